@@ -0,0 +1,15 @@
+package main
+
+import "common/config"
+
+// Settings of the Alert service, loaded by config.Load
+type Config struct {
+	Port           int    `env:"LOCAL_PORT" default:"5002" validate:"port"`
+	Storage        string `env:"STORAGE" default:"mysql" validate:"oneof=mysql memory"`
+	DB             config.Database
+	JWTSecret      config.Secret `env:"JWT_SECRET" required:"true"`
+	TrustedProxies string        `env:"TRUSTED_PROXIES" default:"127.0.0.1,::1"` // Proxies whose X-Real-IP header is believed
+	Services       config.Services
+}
+
+var cfg Config
@@ -3,10 +3,15 @@ module alert_service
 go 1.23.2
 
 require (
-	github.com/go-sql-driver/mysql v1.8.1
+	common v0.0.0
 	github.com/gorilla/mux v1.8.1
-	github.com/joho/godotenv v1.5.1
-	github.com/rs/cors v1.11.1
 )
 
-require filippo.io/edwards25519 v1.1.0 // indirect
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/go-sql-driver/mysql v1.8.1 // indirect
+	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/rs/cors v1.11.1 // indirect
+)
+
+replace common => ../Common
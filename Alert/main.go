@@ -1,8 +1,8 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,86 +10,85 @@ import (
 	"strconv"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"common/audit"
+	"common/auth"
+	"common/config"
+	"common/migrate"
+	"common/rbac"
+	"common/respond"
+	"common/server"
+
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
-	"github.com/rs/cors"
 )
 
-// Database connection function
-func connectDB(dbUser, dbPassword, dbHost, dbName string) (*sql.DB, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", dbUser, dbPassword, dbHost, dbName)
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
-	}
-	return db, db.Ping()
-}
-
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading .env file")
-	}
+	flag.Parse()
 
-	// Get database connection details from environment variables
-	dbUser, dbPassword, dbHost, dbName := os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_HOST"), os.Getenv("DB_NAME")
-	if dbUser == "" || dbPassword == "" || dbHost == "" || dbName == "" {
-		log.Fatal("Database credentials not fully set in environment variables")
+	// Load settings from the environment and .env files, listing everything missing at once
+	config.MustLoad(&cfg)
+
+	// `go run . migrate up|down|status|seed|baseline` manages the schema instead of serving
+	if flag.Arg(0) == "migrate" {
+		if err := migrate.Run(cfg.DB, schemaFiles, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
 	}
 
-	// Get local port from environment variables
-	//localPort := os.Getenv("LOCAL_PORT")
-	localPort := "5002"
-	if localPort == "" {
-		log.Fatal("LOCAL_PORT environment variable is not set")
+	auth.SetSecret(cfg.JWTSecret.Value())
+	if err := auth.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
 	}
 
-	// Connect to the database
-	db, err := connectDB(dbUser, dbPassword, dbHost, dbName)
+	// Connect to the database, or keep everything in memory with STORAGE=memory
+	store, err := openStore()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Failed to open storage: %v", err)
 	}
-	defer db.Close()
+	defer store.Close()
 
 	// Initialize the router
 	router := mux.NewRouter()
+	router.Use(auth.Middleware)
 
 	// API Routes
-	router.HandleFunc("/api/getNotifications", func(w http.ResponseWriter, r *http.Request) {
-		notificationHandler(w, r, db)
-	}).Methods("POST")
-	router.HandleFunc("/api/postNotifications", func(w http.ResponseWriter, r *http.Request) {
-		postHandler(w, r, db)
-	}).Methods("POST")
+	router.HandleFunc("/api/getNotifications", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		notificationHandler(w, r, store)
+	}, rbac.PermNotificationRead)).Methods("POST")
+	router.HandleFunc("/api/postNotifications", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		postHandler(w, r, store)
+	}, rbac.PermNotificationSend)).Methods("POST")
 
 	// Alerts are for Doctors
-	router.HandleFunc("/api/getAlerts", func(w http.ResponseWriter, r *http.Request) {
-		doctorNotificationHandler(w, r, db)
-	}).Methods("GET")
-	router.HandleFunc("/api/postAlerts", func(w http.ResponseWriter, r *http.Request) {
-		doctorPostHandler(w, r, db)
-	}).Methods("POST")
-	router.HandleFunc("/api/resolveAlerts/{assessment_id}", func(w http.ResponseWriter, r *http.Request) {
-		doctorResolveHandler(w, r, db)
-	}).Methods("DELETE")
-
-	// CORS Configuration
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "DELETE"},
-		AllowedHeaders:   []string{"Content-Type"},
-		AllowCredentials: true,
-	})
-	handler := c.Handler(router)
-
-	log.Printf("Starting server on :%s", localPort)
-	if err := http.ListenAndServe(":"+localPort, handler); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	router.HandleFunc("/api/getAlerts", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		doctorNotificationHandler(w, r, store)
+	}, rbac.PermAlertRead)).Methods("GET")
+	router.HandleFunc("/api/postAlerts", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		doctorPostHandler(w, r, store)
+	}, rbac.PermAlertRaise)).Methods("POST")
+	router.HandleFunc("/api/resolveAlerts/{assessment_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		doctorResolveHandler(w, r, store)
+	}, rbac.PermAlertResolve)).Methods("DELETE")
+	router.HandleFunc("/api/audit", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.Audit.SearchHandler(w, r)
+	}, rbac.PermAuditRead)).Methods("GET")
+	router.HandleFunc("/api/audit/verify", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.Audit.VerifyHandler(w, r)
+	}, rbac.PermAuditRead)).Methods("GET")
+	router.HandleFunc("/api/personalData/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		exportPersonalDataHandler(w, r, store)
+	}, rbac.PermDataExport)).Methods("GET")
+	router.HandleFunc("/api/personalData/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		erasePersonalDataHandler(w, r, store)
+	}, rbac.PermDataErase)).Methods("DELETE")
+
+	// Serve until stopped, letting requests in progress finish
+	if err := server.Run("Notifications", cfg.Port, server.CORS(router, "GET", "POST", "DELETE")); err != nil {
+		log.Fatalf("Server failed: %v", err)
 	}
 }
 
-func notificationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func notificationHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	type Request struct {
 		UserID int `json:"user_id"`
 	}
@@ -109,50 +108,38 @@ func notificationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Query database for user notifications
-	query := `SELECT NotificationID, Message, SentAt FROM Notifications WHERE UserID = ? ORDER BY SentAt DESC`
-	rows, err := db.Query(query, req.UserID)
+	// Seniors may only read their own notifications
+	if !auth.AuthorizeUser(w, r, req.UserID) {
+		return
+	}
+
+	// Fetch the user's notifications
+	stored, err := store.Notifications.ListByUser(req.UserID)
 	if err != nil {
 		log.Println("Database query error:", err)
 		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	var notifications []map[string]interface{}
-
-	// Iterate over rows
-	for rows.Next() {
-		var notificationID int
-		var message string
-		var sentAt time.Time
-
-		if err := rows.Scan(&notificationID, &message, &sentAt); err != nil {
-			log.Println("Error scanning row:", err)
-			http.Error(w, "Failed to process data", http.StatusInternalServerError)
-			return
-		}
 
+	// An empty JSON array [] if there are none
+	notifications := []map[string]interface{}{}
+	for _, n := range stored {
 		notifications = append(notifications, map[string]interface{}{
-			"notification_id": notificationID,
-			"message":         message,
-			"sent_at":         sentAt.Format("2006-01-02 15:04:05"),
+			"notification_id": n.NotificationID,
+			"message":         n.Message,
+			"sent_at":         n.SentAt.Format("2006-01-02 15:04:05"),
 		})
 	}
 
-	// If no notifications are found, return an empty JSON array []
-	if len(notifications) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode([]interface{}{})
+	if !store.Audit.Access(w, r, "notification", "", req.UserID) {
 		return
 	}
 
 	// Return response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(notifications)
+	respond.JSON(w, notifications)
 }
 
-func postHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func postHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	type Request struct {
 		UserID  int    `json:"user_id"`
 		Message string `json:"message"`
@@ -173,69 +160,114 @@ func postHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Insert into database
-	query := `INSERT INTO Notifications (UserID, Message) VALUES (?, ?)`
-	_, err := db.Exec(query, req.UserID, req.Message)
+	// Seniors may only post notifications to themselves
+	if !auth.AuthorizeUser(w, r, req.UserID) {
+		return
+	}
+
+	// Store the notification
+	notificationID, err := store.Notifications.Create(req.UserID, req.Message)
 	if err != nil {
 		log.Println("Database insert error:", err)
 		http.Error(w, "Failed to store notification", http.StatusInternalServerError)
 		return
 	}
+	store.Audit.Change(r, audit.Create, "notification", strconv.FormatInt(notificationID, 10), req.UserID)
 
 	// Return success response
 	response := map[string]string{"message": "Notification sent successfully!"}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	respond.JSON(w, response)
 }
 
-func doctorNotificationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	// Query database for alerts, including the `type` column
-	query := `SELECT AlertID, AssessmentID, Type, SentAt FROM Alerts WHERE SentAt >= NOW() - INTERVAL 3 DAY ORDER BY SentAt DESC`
-	rows, err := db.Query(query)
+// Client for calls to other services, so a slow service cannot hold a request open
+var serviceClient = &http.Client{Timeout: 10 * time.Second}
+
+// Fetch the user IDs of the calling doctor's current patients from the Doctor service
+func fetchPatientIDs(authHeader string) ([]int, error) {
+	req, err := http.NewRequest("GET", cfg.Services.Doctor+"/api/assignments", nil)
 	if err != nil {
-		log.Println("Database query error:", err)
-		http.Error(w, "Failed to fetch alerts", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-	defer rows.Close()
+	req.Header.Set("Authorization", authHeader)
 
-	var alerts []map[string]interface{}
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	// Iterate over rows
-	for rows.Next() {
-		var alertID, assessmentID int
-		var alertType string
-		var sentAt time.Time
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doctor service returned %s", resp.Status)
+	}
 
-		if err := rows.Scan(&alertID, &assessmentID, &alertType, &sentAt); err != nil {
-			log.Println("Error scanning row:", err)
-			http.Error(w, "Failed to process data", http.StatusInternalServerError)
-			return
-		}
+	var assignments []struct {
+		UserID int `json:"user_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&assignments); err != nil {
+		return nil, err
+	}
+
+	patientIDs := make([]int, 0, len(assignments))
+	for _, a := range assignments {
+		patientIDs = append(patientIDs, a.UserID)
+	}
+	return patientIDs, nil
+}
+
+// Alerts are shown for this long after they are raised
+const alertPeriod = 3 * 24 * time.Hour
+
+// The calling doctor's patients, whose alerts they may see.
+// Returns ok=false after writing an error response.
+func doctorPatients(w http.ResponseWriter, r *http.Request) ([]int, bool) {
+	patientIDs, err := fetchPatientIDs(r.Header.Get("Authorization"))
+	if err != nil {
+		log.Println("Failed to fetch doctor's patients:", err)
+		http.Error(w, "Failed to fetch patients", http.StatusBadGateway)
+		return nil, false
+	}
+	return patientIDs, true
+}
+
+func doctorNotificationHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	// Doctors only see alerts for their own patients
+	patientIDs, ok := doctorPatients(w, r)
+	if !ok {
+		return
+	}
 
+	stored, err := store.Alerts.ListRecent(patientIDs, alertPeriod)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Failed to fetch alerts", http.StatusInternalServerError)
+		return
+	}
+
+	// An empty JSON array [] if there are none
+	alerts := []map[string]interface{}{}
+	for _, a := range stored {
 		alerts = append(alerts, map[string]interface{}{
-			"alert_id":      alertID,
-			"assessment_id": assessmentID,
-			"type":          alertType, // Includes the type column
-			"sent_at":       sentAt.Format("2006-01-02 15:04:05"),
+			"alert_id":      a.AlertID,
+			"assessment_id": a.AssessmentID,
+			"user_id":       a.UserID,
+			"type":          a.Type,
+			"sent_at":       a.SentAt.Format("2006-01-02 15:04:05"),
 		})
 	}
 
-	// If no alerts found, return empty JSON array []
-	if len(alerts) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode([]interface{}{})
+	// One entry for the whole list, each alert names its own patient
+	if !store.Audit.Access(w, r, "alert", "", 0) {
 		return
 	}
 
 	// Return response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(alerts)
+	respond.JSON(w, alerts)
 }
 
-func doctorPostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func doctorPostHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	type Request struct {
 		AssessmentID int    `json:"assessment_id"`
+		UserID       int    `json:"user_id"`
 		Type         string `json:"type"` // New field for type
 	}
 	var req Request
@@ -254,23 +286,33 @@ func doctorPostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Insert into database with type
-	query := `INSERT INTO Alerts (AssessmentID, Type) VALUES (?, ?)`
-	_, err := db.Exec(query, req.AssessmentID, req.Type)
+	if req.UserID <= 0 {
+		log.Println("Invalid input: UserID missing")
+		http.Error(w, "Invalid input: UserID missing", http.StatusBadRequest)
+		return
+	}
+
+	// Seniors may only raise alerts about themselves
+	if !auth.AuthorizeUser(w, r, req.UserID) {
+		return
+	}
+
+	// Store the alert with its type
+	alertID, err := store.Alerts.Create(req.AssessmentID, req.UserID, req.Type)
 	if err != nil {
 		log.Println("Database insert error:", err)
 		http.Error(w, "Failed to store alert", http.StatusInternalServerError)
 		return
 	}
+	store.Audit.Change(r, audit.Create, "alert", strconv.FormatInt(alertID, 10), req.UserID)
 
 	// Return success response
 	response := map[string]string{"message": "Alert sent successfully!"}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	respond.JSON(w, response)
 }
 
 // Resolve Alert - Removes Alert from DB
-func doctorResolveHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func doctorResolveHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	// Parse assessment_id from URL query parameters
 	vars := mux.Vars(r)
 	assessmentIDStr := vars["assessment_id"]
@@ -290,24 +332,26 @@ func doctorResolveHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Delete the alert from the database
-	query := `DELETE FROM Alerts WHERE AssessmentID = ?`
-	result, err := db.Exec(query, assessmentID)
+	// Doctors may only resolve alerts for their own patients
+	patientIDs, ok := doctorPatients(w, r)
+	if !ok {
+		return
+	}
+
+	// Delete the alert
+	resolved, err := store.Alerts.Resolve(assessmentID, patientIDs)
 	if err != nil {
 		log.Println("Database delete error:", err)
 		http.Error(w, "Failed to resolve alert", http.StatusInternalServerError)
 		return
 	}
-
-	// Check if any row was deleted
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	if resolved == 0 {
 		http.Error(w, "No alert found for the given assessment ID", http.StatusNotFound)
 		return
 	}
+	store.Audit.Change(r, audit.Delete, "alert", "assessment:"+assessmentIDStr, 0)
 
 	// Return success response
 	response := map[string]string{"message": "Alert resolved successfully!"}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	respond.JSON(w, response)
 }
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS AuditLog;
+DROP TABLE IF EXISTS Alerts;
+DROP TABLE IF EXISTS Notifications;
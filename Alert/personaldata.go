@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"common/audit"
+	"common/auth"
+	"common/respond"
+)
+
+// Every notification and alert held about the user, collected by the User service's data export
+func exportPersonalDataHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	userID, ok := auth.DataSubjectFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	storedNotifications, err := store.Notifications.ListByUser(userID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	storedAlerts, err := store.Alerts.ListByUser(userID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Exported oldest first
+	notifications := []map[string]interface{}{}
+	for _, n := range slices.Backward(storedNotifications) {
+		notifications = append(notifications, map[string]interface{}{
+			"notification_id": n.NotificationID,
+			"message":         n.Message,
+			"sent_at":         n.SentAt,
+		})
+	}
+	alerts := []map[string]interface{}{}
+	for _, a := range slices.Backward(storedAlerts) {
+		alerts = append(alerts, map[string]interface{}{
+			"alert_id":      a.AlertID,
+			"assessment_id": a.AssessmentID,
+			"type":          a.Type,
+			"sent_at":       a.SentAt,
+		})
+	}
+
+	if !store.Audit.Access(w, r, "personal_data", strconv.Itoa(userID), userID) {
+		return
+	}
+
+	respond.JSON(w, map[string]interface{}{
+		"notifications": notifications,
+		"alerts":        alerts,
+	})
+}
+
+// Delete every notification and alert held about the user and report how many rows were erased
+func erasePersonalDataHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	userID, ok := auth.DataSubjectFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := store.PersonalData.Erase(userID)
+	if err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	store.Audit.Change(r, audit.Delete, "personal_data", strconv.Itoa(userID), userID)
+
+	log.Printf("Erased notifications and alerts for user %d\n", userID)
+	respond.JSON(w, map[string]interface{}{"deleted": deleted})
+}
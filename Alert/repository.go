@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"common/audit"
+)
+
+// A message shown to a senior
+type Notification struct {
+	NotificationID int
+	UserID         int
+	Message        string
+	SentAt         time.Time
+}
+
+// A senior's assessment result flagged for their doctors
+type Alert struct {
+	AlertID      int
+	AssessmentID int
+	UserID       int
+	Type         string // HealthAssessment or VisionAssessment
+	SentAt       time.Time
+}
+
+type NotificationRepository interface {
+	Create(userID int, message string) (int64, error)
+	// The user's notifications, newest first
+	ListByUser(userID int) ([]Notification, error)
+}
+
+type AlertRepository interface {
+	Create(assessmentID, userID int, alertType string) (int64, error)
+	// Alerts about any of the users sent in the last period, newest first
+	ListRecent(userIDs []int, period time.Duration) ([]Alert, error)
+	// Every alert about the user, newest first
+	ListByUser(userID int) ([]Alert, error)
+	// Delete the alerts raised for an assessment of any of the users, returning how many there were
+	Resolve(assessmentID int, userIDs []int) (int64, error)
+}
+
+// Personal data spread over several repositories
+type PersonalDataRepository interface {
+	// Delete everything held about the user at once, returning how many records of each kind went
+	Erase(userID int) (map[string]int64, error)
+}
+
+func newMySQLStore(db *sql.DB) *Store {
+	return &Store{
+		Notifications: mysqlNotificationRepository{db},
+		Alerts:        mysqlAlertRepository{db},
+		PersonalData:  mysqlPersonalDataRepository{db},
+		Audit:         audit.New(auditServiceName, audit.MySQLRepository{DB: db}),
+		db:            db,
+	}
+}
+
+// Placeholders and arguments for an IN list
+func inList(ids []int) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return "(?" + strings.Repeat(", ?", len(ids)-1) + ")", args
+}
+
+type mysqlNotificationRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlNotificationRepository) Create(userID int, message string) (int64, error) {
+	result, err := m.db.Exec(`INSERT INTO Notifications (UserID, Message) VALUES (?, ?)`, userID, message)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (m mysqlNotificationRepository) ListByUser(userID int) ([]Notification, error) {
+	rows, err := m.db.Query(`SELECT NotificationID, UserID, Message, SentAt FROM Notifications WHERE UserID = ? ORDER BY SentAt DESC, NotificationID DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.NotificationID, &n.UserID, &n.Message, &n.SentAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+type mysqlAlertRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlAlertRepository) Create(assessmentID, userID int, alertType string) (int64, error) {
+	result, err := m.db.Exec(`INSERT INTO Alerts (AssessmentID, UserID, Type) VALUES (?, ?, ?)`, assessmentID, userID, alertType)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (m mysqlAlertRepository) ListRecent(userIDs []int, period time.Duration) ([]Alert, error) {
+	// No users means no alerts
+	if len(userIDs) == 0 {
+		return []Alert{}, nil
+	}
+	// The period is measured with MySQL's clock, which SentAt is set by
+	in, args := inList(userIDs)
+	query := `SELECT AlertID, AssessmentID, UserID, Type, SentAt FROM Alerts
+              WHERE SentAt >= NOW() - INTERVAL ? SECOND AND UserID IN ` + in + ` ORDER BY SentAt DESC, AlertID DESC`
+	return m.query(query, append([]interface{}{int(period.Seconds())}, args...)...)
+}
+
+func (m mysqlAlertRepository) ListByUser(userID int) ([]Alert, error) {
+	return m.query(`SELECT AlertID, AssessmentID, UserID, Type, SentAt FROM Alerts WHERE UserID = ? ORDER BY SentAt DESC, AlertID DESC`, userID)
+}
+
+func (m mysqlAlertRepository) Resolve(assessmentID int, userIDs []int) (int64, error) {
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+	in, args := inList(userIDs)
+	result, err := m.db.Exec(`DELETE FROM Alerts WHERE AssessmentID = ? AND UserID IN `+in, append([]interface{}{assessmentID}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (m mysqlAlertRepository) query(query string, args ...interface{}) ([]Alert, error) {
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var a Alert
+		if err := rows.Scan(&a.AlertID, &a.AssessmentID, &a.UserID, &a.Type, &a.SentAt); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+type mysqlPersonalDataRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlPersonalDataRepository) Erase(userID int) (map[string]int64, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deleted := map[string]int64{}
+	for _, table := range []string{"Notifications", "Alerts"} {
+		result, err := tx.Exec("DELETE FROM "+table+" WHERE UserID = ?", userID)
+		if err != nil {
+			return nil, err
+		}
+		deleted[table], _ = result.RowsAffected()
+	}
+	return deleted, tx.Commit()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"common/audit"
+)
+
+// Notifications and alerts kept in memory, behind one lock so erasing a user is all or nothing
+type memoryData struct {
+	mu                 sync.Mutex
+	notifications      []Notification
+	alerts             []Alert
+	nextNotificationID int
+	nextAlertID        int
+}
+
+func newMemoryStore() *Store {
+	data := &memoryData{nextNotificationID: 1, nextAlertID: 1}
+	return &Store{
+		Notifications: memoryNotificationRepository{data},
+		Alerts:        memoryAlertRepository{data},
+		PersonalData:  memoryPersonalDataRepository{data},
+		Audit:         audit.New(auditServiceName, &audit.MemoryRepository{}),
+	}
+}
+
+type memoryNotificationRepository struct {
+	data *memoryData
+}
+
+func (m memoryNotificationRepository) Create(userID int, message string) (int64, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	n := Notification{NotificationID: m.data.nextNotificationID, UserID: userID, Message: message, SentAt: time.Now()}
+	m.data.nextNotificationID++
+	m.data.notifications = append(m.data.notifications, n)
+	return int64(n.NotificationID), nil
+}
+
+func (m memoryNotificationRepository) ListByUser(userID int) ([]Notification, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	notifications := []Notification{}
+	for i := len(m.data.notifications) - 1; i >= 0; i-- {
+		if m.data.notifications[i].UserID == userID {
+			notifications = append(notifications, m.data.notifications[i])
+		}
+	}
+	return notifications, nil
+}
+
+type memoryAlertRepository struct {
+	data *memoryData
+}
+
+func (m memoryAlertRepository) Create(assessmentID, userID int, alertType string) (int64, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	a := Alert{AlertID: m.data.nextAlertID, AssessmentID: assessmentID, UserID: userID, Type: alertType, SentAt: time.Now()}
+	m.data.nextAlertID++
+	m.data.alerts = append(m.data.alerts, a)
+	return int64(a.AlertID), nil
+}
+
+func (m memoryAlertRepository) ListRecent(userIDs []int, period time.Duration) ([]Alert, error) {
+	since := time.Now().Add(-period)
+	return m.list(func(a Alert) bool {
+		return slices.Contains(userIDs, a.UserID) && !a.SentAt.Before(since)
+	}), nil
+}
+
+func (m memoryAlertRepository) ListByUser(userID int) ([]Alert, error) {
+	return m.list(func(a Alert) bool { return a.UserID == userID }), nil
+}
+
+func (m memoryAlertRepository) Resolve(assessmentID int, userIDs []int) (int64, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	before := len(m.data.alerts)
+	m.data.alerts = slices.DeleteFunc(m.data.alerts, func(a Alert) bool {
+		return a.AssessmentID == assessmentID && slices.Contains(userIDs, a.UserID)
+	})
+	return int64(before - len(m.data.alerts)), nil
+}
+
+// Alerts matching keep, newest first
+func (m memoryAlertRepository) list(keep func(Alert) bool) []Alert {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	alerts := []Alert{}
+	for _, a := range m.data.alerts {
+		if keep(a) {
+			alerts = append(alerts, a)
+		}
+	}
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].AlertID > alerts[j].AlertID })
+	return alerts
+}
+
+type memoryPersonalDataRepository struct {
+	data *memoryData
+}
+
+func (m memoryPersonalDataRepository) Erase(userID int) (map[string]int64, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	notifications, alerts := len(m.data.notifications), len(m.data.alerts)
+	m.data.notifications = slices.DeleteFunc(m.data.notifications, func(n Notification) bool { return n.UserID == userID })
+	m.data.alerts = slices.DeleteFunc(m.data.alerts, func(a Alert) bool { return a.UserID == userID })
+	return map[string]int64{
+		"Notifications": int64(notifications - len(m.data.notifications)),
+		"Alerts":        int64(alerts - len(m.data.alerts)),
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build mysql
+
+package main
+
+import (
+	"testing"
+
+	"common/dbtest"
+)
+
+func TestMySQLRepositories(t *testing.T) {
+	testRepositories(t, func(t *testing.T) *Store { return newMySQLStore(dbtest.Open(t, schemaFiles)) })
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// The behaviour every notification, alert and personal data repository must have, run
+// against a fresh store per case
+func testRepositories(t *testing.T, newStore func(t *testing.T) *Store) {
+	notify := func(t *testing.T, store *Store, userID int, message string) int64 {
+		t.Helper()
+		id, err := store.Notifications.Create(userID, message)
+		if err != nil {
+			t.Fatalf("Notifications.Create: %v", err)
+		}
+		return id
+	}
+	raise := func(t *testing.T, store *Store, assessmentID, userID int) int64 {
+		t.Helper()
+		id, err := store.Alerts.Create(assessmentID, userID, "HealthAssessment")
+		if err != nil {
+			t.Fatalf("Alerts.Create: %v", err)
+		}
+		return id
+	}
+	alertIDs := func(alerts []Alert) []int {
+		ids := []int{}
+		for _, a := range alerts {
+			ids = append(ids, a.AlertID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store *Store)
+	}{
+		{"notifications are listed per user, newest first", func(t *testing.T, store *Store) {
+			first := notify(t, store, 7, "First")
+			notify(t, store, 8, "Someone else's")
+			second := notify(t, store, 7, "Second")
+			got, err := store.Notifications.ListByUser(7)
+			if err != nil {
+				t.Fatalf("ListByUser: %v", err)
+			}
+			if len(got) != 2 || int64(got[0].NotificationID) != second || int64(got[1].NotificationID) != first {
+				t.Fatalf("got %+v, want notifications %d then %d", got, second, first)
+			}
+			if got[0].Message != "Second" || got[0].UserID != 7 || got[0].SentAt.IsZero() {
+				t.Fatalf("notification not stored as sent: %+v", got[0])
+			}
+		}},
+		{"no notifications is an empty list", func(t *testing.T, store *Store) {
+			got, err := store.Notifications.ListByUser(7)
+			if err != nil || got == nil || len(got) != 0 {
+				t.Fatalf("got %#v, %v, want an empty list", got, err)
+			}
+		}},
+		{"alerts are listed per user, newest first", func(t *testing.T, store *Store) {
+			first := raise(t, store, 100, 7)
+			raise(t, store, 101, 8)
+			second := raise(t, store, 102, 7)
+			got, err := store.Alerts.ListByUser(7)
+			if err != nil {
+				t.Fatalf("ListByUser: %v", err)
+			}
+			if ids := alertIDs(got); len(ids) != 2 || int64(ids[0]) != second || int64(ids[1]) != first {
+				t.Fatalf("got alerts %v, want %d then %d", ids, second, first)
+			}
+			if got[0].AssessmentID != 102 || got[0].Type != "HealthAssessment" || got[0].SentAt.IsZero() {
+				t.Fatalf("alert not stored as raised: %+v", got[0])
+			}
+		}},
+		{"recent alerts are only about the users given", func(t *testing.T, store *Store) {
+			seven := raise(t, store, 100, 7)
+			eight := raise(t, store, 101, 8)
+			raise(t, store, 102, 9)
+			got, err := store.Alerts.ListRecent([]int{7, 8}, time.Hour)
+			if err != nil {
+				t.Fatalf("ListRecent: %v", err)
+			}
+			if ids := alertIDs(got); len(ids) != 2 || int64(ids[0]) != eight || int64(ids[1]) != seven {
+				t.Fatalf("got alerts %v, want %d then %d", ids, eight, seven)
+			}
+		}},
+		{"recent alerts for no users", func(t *testing.T, store *Store) {
+			raise(t, store, 100, 7)
+			got, err := store.Alerts.ListRecent(nil, time.Hour)
+			if err != nil || got == nil || len(got) != 0 {
+				t.Fatalf("got %#v, %v, want an empty list", got, err)
+			}
+		}},
+		{"resolve deletes the assessment's alerts for the users given", func(t *testing.T, store *Store) {
+			raise(t, store, 100, 7)
+			raise(t, store, 100, 7)
+			kept := raise(t, store, 101, 7)
+			resolved, err := store.Alerts.Resolve(100, []int{7})
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			if resolved != 2 {
+				t.Fatalf("resolved %d, want 2", resolved)
+			}
+			if ids := alertIDs(mustAlerts(t, store, 7)); len(ids) != 1 || int64(ids[0]) != kept {
+				t.Fatalf("alerts left %v, want only %d", ids, kept)
+			}
+		}},
+		{"resolve leaves other users' alerts", func(t *testing.T, store *Store) {
+			raise(t, store, 100, 7)
+			for _, users := range [][]int{{8}, nil} {
+				if resolved, err := store.Alerts.Resolve(100, users); err != nil || resolved != 0 {
+					t.Fatalf("Resolve for %v: resolved %d, %v, want none", users, resolved, err)
+				}
+			}
+			if got := mustAlerts(t, store, 7); len(got) != 1 {
+				t.Fatalf("%d alerts left, want 1", len(got))
+			}
+		}},
+		{"erase deletes everything about the user only", func(t *testing.T, store *Store) {
+			notify(t, store, 7, "First")
+			notify(t, store, 7, "Second")
+			raise(t, store, 100, 7)
+			notify(t, store, 8, "Someone else's")
+			raise(t, store, 101, 8)
+
+			deleted, err := store.PersonalData.Erase(7)
+			if err != nil {
+				t.Fatalf("Erase: %v", err)
+			}
+			if deleted["Notifications"] != 2 || deleted["Alerts"] != 1 {
+				t.Fatalf("deleted %v, want 2 notifications and 1 alert", deleted)
+			}
+			if got, _ := store.Notifications.ListByUser(7); len(got) != 0 {
+				t.Fatalf("%d notifications left", len(got))
+			}
+			if got := mustAlerts(t, store, 7); len(got) != 0 {
+				t.Fatalf("%d alerts left", len(got))
+			}
+			if got, _ := store.Notifications.ListByUser(8); len(got) != 1 {
+				t.Fatalf("other user has %d notifications, want 1", len(got))
+			}
+			if got := mustAlerts(t, store, 8); len(got) != 1 {
+				t.Fatalf("other user has %d alerts, want 1", len(got))
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+}
+
+func mustAlerts(t *testing.T, store *Store, userID int) []Alert {
+	t.Helper()
+	alerts, err := store.Alerts.ListByUser(userID)
+	if err != nil {
+		t.Fatalf("ListByUser: %v", err)
+	}
+	return alerts
+}
+
+func TestMemoryRepositories(t *testing.T) {
+	testRepositories(t, func(t *testing.T) *Store { return newMemoryStore() })
+}
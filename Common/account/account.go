@@ -0,0 +1,85 @@
+// Package account holds the login protection, sessions and password handling shared by the
+// services that keep accounts: seniors and caregivers in the User service, doctors and staff in
+// the Doctor service.
+package account
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"common/auth"
+)
+
+// Returned when an account, session or one-time token does not exist
+var (
+	ErrAccountNotFound = errors.New("account not found")
+	ErrTokenNotFound   = errors.New("token not found")
+)
+
+// Account is what the shared handlers need to know about an account
+type Account struct {
+	ID           int
+	Role         string
+	PasswordHash string
+	Active       bool // Whether the account may go on refreshing its sessions
+}
+
+// Accounts gives the shared handlers access to one service's accounts
+type Accounts interface {
+	// Look up an account, returning ErrAccountNotFound if there is none
+	Account(accountID int) (Account, error)
+	// Seniors to list in the account's access token: a caregiver's linked seniors or a doctor's patients
+	TokenSeniors(accountID int, role string) ([]int, error)
+	SetPassword(accountID int, passwordHash []byte) error
+	// Add one to the consecutive failed logins in a single update and return the new count
+	IncrementFailedLogins(accountID int) (int, error)
+	// Lock the account for the lockout, unless it is already locked for longer
+	LockFor(accountID int, lockout time.Duration) error
+	// Clear the failed logins and any lockout
+	ResetFailedLogins(accountID int) error
+}
+
+// Session is a device an account is logged in on, identified by its refresh token
+type Session struct {
+	SessionID int
+	AccountID int
+	UserAgent string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+type SessionRepository interface {
+	Create(accountID int, tokenHash, userAgent string, expiresAt time.Time) error
+	// Look up a session, returning ErrTokenNotFound if there is none
+	GetByToken(tokenHash string) (Session, error)
+	// Revoke one of the account's sessions, reporting false if there is none or it already was
+	Revoke(sessionID, accountID int) (bool, error)
+	RevokeByToken(tokenHash string) error
+	RevokeAll(accountID int) error
+	// The account's sessions that are neither revoked nor expired, newest first
+	ListActive(accountID int) ([]Session, error)
+}
+
+// LoginFailureRepository counts failed logins per client IP address
+type LoginFailureRepository interface {
+	Record(ip string) error
+	// How many failures came from the address within the window, and how long ago the oldest was
+	Recent(ip string, window time.Duration) (int, time.Duration, error)
+}
+
+// Service ties the shared handlers to the accounts of one service
+type Service struct {
+	Kind          string // "user" or "doctor", used in log messages, errors and response fields
+	Accounts      Accounts
+	Sessions      SessionRepository
+	LoginFailures LoginFailureRepository
+	// Whether the caller is logged in with one of this service's accounts
+	Owns func(claims *auth.Claims) bool
+}
+
+// Kind with a capital, to start a message
+func (s *Service) title() string {
+	return strings.ToUpper(s.Kind[:1]) + s.Kind[1:]
+}
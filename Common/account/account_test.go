@@ -0,0 +1,326 @@
+package account
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"common/auth"
+	"common/rbac"
+)
+
+func init() {
+	auth.SetSecret("account-tests")
+}
+
+// Accounts kept in a map, recording the lockouts they are given
+type fakeAccounts struct {
+	accounts     map[int]Account
+	failedLogins map[int]int
+	lockouts     map[int]time.Duration
+}
+
+func newFakeAccounts(accounts ...Account) *fakeAccounts {
+	f := &fakeAccounts{accounts: map[int]Account{}, failedLogins: map[int]int{}, lockouts: map[int]time.Duration{}}
+	for _, a := range accounts {
+		f.accounts[a.ID] = a
+	}
+	return f
+}
+
+func (f *fakeAccounts) Account(accountID int) (Account, error) {
+	a, ok := f.accounts[accountID]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return a, nil
+}
+
+func (f *fakeAccounts) TokenSeniors(accountID int, role string) ([]int, error) { return nil, nil }
+
+func (f *fakeAccounts) SetPassword(accountID int, passwordHash []byte) error { return nil }
+
+func (f *fakeAccounts) IncrementFailedLogins(accountID int) (int, error) {
+	f.failedLogins[accountID]++
+	return f.failedLogins[accountID], nil
+}
+
+func (f *fakeAccounts) LockFor(accountID int, lockout time.Duration) error {
+	f.lockouts[accountID] = lockout
+	return nil
+}
+
+func (f *fakeAccounts) ResetFailedLogins(accountID int) error {
+	delete(f.failedLogins, accountID)
+	delete(f.lockouts, accountID)
+	return nil
+}
+
+// Sessions kept in a slice, indexed by session ID - 1
+type fakeSessions struct {
+	sessions []Session
+	hashes   []string
+}
+
+func (f *fakeSessions) Create(accountID int, tokenHash, userAgent string, expiresAt time.Time) error {
+	f.sessions = append(f.sessions, Session{SessionID: len(f.sessions) + 1, AccountID: accountID, UserAgent: userAgent, ExpiresAt: expiresAt})
+	f.hashes = append(f.hashes, tokenHash)
+	return nil
+}
+
+func (f *fakeSessions) GetByToken(tokenHash string) (Session, error) {
+	for i, hash := range f.hashes {
+		if hash == tokenHash {
+			return f.sessions[i], nil
+		}
+	}
+	return Session{}, ErrTokenNotFound
+}
+
+func (f *fakeSessions) Revoke(sessionID, accountID int) (bool, error) {
+	s := &f.sessions[sessionID-1]
+	if s.AccountID != accountID || s.Revoked {
+		return false, nil
+	}
+	s.Revoked = true
+	return true, nil
+}
+
+func (f *fakeSessions) RevokeByToken(tokenHash string) error {
+	for i, hash := range f.hashes {
+		if hash == tokenHash {
+			f.sessions[i].Revoked = true
+		}
+	}
+	return nil
+}
+
+func (f *fakeSessions) RevokeAll(accountID int) error {
+	for i := range f.sessions {
+		if f.sessions[i].AccountID == accountID {
+			f.sessions[i].Revoked = true
+		}
+	}
+	return nil
+}
+
+func (f *fakeSessions) ListActive(accountID int) ([]Session, error) {
+	active := []Session{}
+	for _, s := range f.sessions {
+		if s.AccountID == accountID && !s.Revoked && time.Now().Before(s.ExpiresAt) {
+			active = append(active, s)
+		}
+	}
+	return active, nil
+}
+
+// Failures per address, each recorded at the time given by now
+type fakeLoginFailures struct {
+	failures map[string][]time.Time
+	now      time.Time
+}
+
+func (f *fakeLoginFailures) Record(ip string) error {
+	f.failures[ip] = append(f.failures[ip], f.now)
+	return nil
+}
+
+func (f *fakeLoginFailures) Recent(ip string, window time.Duration) (int, time.Duration, error) {
+	count := 0
+	var oldest time.Time
+	for _, at := range f.failures[ip] {
+		if f.now.Sub(at) < window {
+			if count == 0 {
+				oldest = at
+			}
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0, nil
+	}
+	return count, f.now.Sub(oldest), nil
+}
+
+func newTestService(accounts ...Account) (*Service, *fakeAccounts, *fakeSessions, *fakeLoginFailures) {
+	fakeAccounts := newFakeAccounts(accounts...)
+	sessions := &fakeSessions{}
+	failures := &fakeLoginFailures{failures: map[string][]time.Time{}, now: time.Now()}
+	return &Service{Kind: "user", Accounts: fakeAccounts, Sessions: sessions, LoginFailures: failures}, fakeAccounts, sessions, failures
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		failedLogins int
+		want         time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{10, 32 * time.Minute},
+		{11, time.Hour},
+		{12, time.Hour},
+		{100, time.Hour},
+		{10000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := lockoutDuration(tt.failedLogins); got != tt.want {
+			t.Errorf("lockoutDuration(%d) = %v, want %v", tt.failedLogins, got, tt.want)
+		}
+	}
+}
+
+func TestRecordFailedLoginLocksAfterTheLimit(t *testing.T) {
+	s, accounts, _, failures := newTestService(Account{ID: 7, Role: rbac.RoleSenior, Active: true})
+
+	for i := 1; i <= maxFailedLogins+1; i++ {
+		s.RecordFailedLogin("203.0.113.5", 7)
+		want := lockoutDuration(i)
+		if got := accounts.lockouts[7]; got != want {
+			t.Fatalf("after %d failures locked for %v, want %v", i, got, want)
+		}
+	}
+	if accounts.lockouts[7] != 2*baseLockout {
+		t.Fatalf("locked for %v after %d failures, want the doubled lockout", accounts.lockouts[7], maxFailedLogins+1)
+	}
+
+	// Unknown accounts only count against the address
+	s.RecordFailedLogin("203.0.113.5", 0)
+	if len(failures.failures["203.0.113.5"]) != maxFailedLogins+2 || len(accounts.failedLogins) != 1 {
+		t.Fatalf("failures %v, account failures %v", failures.failures, accounts.failedLogins)
+	}
+}
+
+func TestIPRetryAfter(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		age       time.Duration
+		wantRetry int
+	}{
+		{"under the limit", ipFailureLimit - 1, 0, 0},
+		{"at the limit", ipFailureLimit, 0, int(ipFailureWindow.Seconds())},
+		{"at the limit, oldest 5 minutes ago", ipFailureLimit, 5 * time.Minute, int((ipFailureWindow - 5*time.Minute).Seconds())},
+		{"older failures have left the window", ipFailureLimit, ipFailureWindow, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _, failures := newTestService()
+			now := failures.now
+			failures.now = now.Add(-tt.age)
+			for i := 0; i < tt.failures; i++ {
+				s.LoginFailures.Record("203.0.113.5")
+			}
+			failures.now = now
+
+			retry, err := s.IPRetryAfter("203.0.113.5")
+			if err != nil || retry != tt.wantRetry {
+				t.Fatalf("IPRetryAfter = %d, %v, want %d", retry, err, tt.wantRetry)
+			}
+			if other, _ := s.IPRetryAfter("198.51.100.1"); other != 0 {
+				t.Fatalf("another address throttled for %d seconds", other)
+			}
+		})
+	}
+}
+
+// Exchange a refresh token, returning the response
+func refresh(t *testing.T, s *Service, refreshToken string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"refresh_token": refreshToken})
+	rec := httptest.NewRecorder()
+	s.RefreshHandler(rec, httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewReader(body)))
+	return rec
+}
+
+func TestRefreshRotatesTheToken(t *testing.T) {
+	s, _, sessions, _ := newTestService(Account{ID: 7, Role: rbac.RoleSenior, Active: true})
+	_, first, err := s.CreateSession(7, rbac.RoleSenior, "test-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := refresh(t, s, first)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var response struct {
+		UserID       int    `json:"user_id"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.UserID != 7 || response.RefreshToken == "" || response.RefreshToken == first {
+		t.Fatalf("response %+v", response)
+	}
+	if claims, err := auth.ParseToken(response.Token); err != nil || claims.Subject != 7 || claims.Role != rbac.RoleSenior {
+		t.Fatalf("access token claims %+v, %v", claims, err)
+	}
+	if active, _ := sessions.ListActive(7); len(active) != 1 || active[0].SessionID != 2 {
+		t.Fatalf("active sessions %+v, want only the new one", active)
+	}
+}
+
+func TestRefreshTokenReuseRevokesEverySession(t *testing.T) {
+	s, _, sessions, _ := newTestService(Account{ID: 7, Role: rbac.RoleSenior, Active: true}, Account{ID: 8, Role: rbac.RoleSenior, Active: true})
+	_, stolen, _ := s.CreateSession(7, rbac.RoleSenior, "phone")
+	_, laptop, _ := s.CreateSession(7, rbac.RoleSenior, "laptop")
+	s.CreateSession(8, rbac.RoleSenior, "other account")
+
+	// The thief refreshes first, then the owner presents the same token
+	if rec := refresh(t, s, stolen); rec.Code != http.StatusOK {
+		t.Fatalf("first refresh: %d", rec.Code)
+	}
+	if rec := refresh(t, s, stolen); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("reused token: status = %d, want 401", rec.Code)
+	}
+
+	if active, _ := sessions.ListActive(7); len(active) != 0 {
+		t.Fatalf("%d sessions still active after reuse", len(active))
+	}
+	if rec := refresh(t, s, laptop); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("other device: status = %d, want 401", rec.Code)
+	}
+	if active, _ := sessions.ListActive(8); len(active) != 1 {
+		t.Fatalf("another account's sessions were revoked")
+	}
+}
+
+func TestRefreshRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		account Account
+		expires time.Duration
+		token   string
+		want    int
+	}{
+		{"unknown token", Account{ID: 7, Active: true}, time.Hour, "unknown", http.StatusUnauthorized},
+		{"expired", Account{ID: 7, Active: true}, -time.Second, "", http.StatusUnauthorized},
+		{"deactivated account", Account{ID: 7, Active: false}, time.Hour, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, sessions, _ := newTestService(tt.account)
+			sessions.Create(7, HashToken("token"), "test-agent", time.Now().Add(tt.expires))
+			token := tt.token
+			if token == "" {
+				token = "token"
+			}
+			if rec := refresh(t, s, token); rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	s, _, _, _ := newTestService()
+	if rec := refresh(t, s, ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("missing token: status = %d, want 400", rec.Code)
+	}
+}
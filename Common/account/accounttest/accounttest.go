@@ -0,0 +1,156 @@
+// Package accounttest checks that a service's session and login failure repositories behave
+// as the shared account handlers expect. Each service runs the same cases against its memory
+// and MySQL repositories.
+package accounttest
+
+import (
+	"testing"
+	"time"
+
+	"common/account"
+)
+
+// Sessions runs the SessionRepository contract. newSessions returns an empty repository and
+// two accounts that sessions can belong to.
+func Sessions(t *testing.T, newSessions func(t *testing.T) (account.SessionRepository, int, int)) {
+	create := func(t *testing.T, sessions account.SessionRepository, accountID int, tokenHash string, expiresAt time.Time) account.Session {
+		t.Helper()
+		if err := sessions.Create(accountID, tokenHash, "test-agent", expiresAt); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		session, err := sessions.GetByToken(tokenHash)
+		if err != nil {
+			t.Fatalf("GetByToken: %v", err)
+		}
+		return session
+	}
+	active := func(t *testing.T, sessions account.SessionRepository, accountID int) []int {
+		t.Helper()
+		list, err := sessions.ListActive(accountID)
+		if err != nil {
+			t.Fatalf("ListActive: %v", err)
+		}
+		if list == nil {
+			t.Fatal("ListActive returned nil, want an empty list")
+		}
+		ids := []int{}
+		for _, s := range list {
+			ids = append(ids, s.SessionID)
+		}
+		return ids
+	}
+	later := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, sessions account.SessionRepository, accountID, otherID int)
+	}{
+		{"a created session is found by its token", func(t *testing.T, sessions account.SessionRepository, accountID, otherID int) {
+			session := create(t, sessions, accountID, "hash-1", later)
+			if session.SessionID <= 0 || session.AccountID != accountID || session.UserAgent != "test-agent" || session.Revoked {
+				t.Fatalf("got %+v", session)
+			}
+			if diff := session.ExpiresAt.Sub(later); diff < -time.Second || diff > time.Second {
+				t.Fatalf("ExpiresAt %v, want %v", session.ExpiresAt, later)
+			}
+		}},
+		{"an unknown token", func(t *testing.T, sessions account.SessionRepository, accountID, otherID int) {
+			if _, err := sessions.GetByToken("unknown"); err != account.ErrTokenNotFound {
+				t.Fatalf("err = %v, want ErrTokenNotFound", err)
+			}
+		}},
+		{"active sessions are the account's live ones, newest first", func(t *testing.T, sessions account.SessionRepository, accountID, otherID int) {
+			first := create(t, sessions, accountID, "hash-1", later)
+			create(t, sessions, accountID, "hash-expired", time.Now().Add(-time.Minute))
+			revoked := create(t, sessions, accountID, "hash-revoked", later)
+			create(t, sessions, otherID, "hash-other", later)
+			second := create(t, sessions, accountID, "hash-2", later)
+			if err := sessions.RevokeByToken("hash-revoked"); err != nil {
+				t.Fatalf("RevokeByToken: %v", err)
+			}
+
+			got := active(t, sessions, accountID)
+			if len(got) != 2 || got[0] != second.SessionID || got[1] != first.SessionID {
+				t.Fatalf("got sessions %v, want %d then %d, without %d", got, second.SessionID, first.SessionID, revoked.SessionID)
+			}
+		}},
+		{"revoke only the account's own session, once", func(t *testing.T, sessions account.SessionRepository, accountID, otherID int) {
+			session := create(t, sessions, accountID, "hash-1", later)
+			if revoked, err := sessions.Revoke(session.SessionID, otherID); err != nil || revoked {
+				t.Fatalf("revoking another account's session: %v, %v", revoked, err)
+			}
+			if revoked, err := sessions.Revoke(session.SessionID, accountID); err != nil || !revoked {
+				t.Fatalf("revoking own session: %v, %v", revoked, err)
+			}
+			if revoked, err := sessions.Revoke(session.SessionID, accountID); err != nil || revoked {
+				t.Fatalf("revoking it again: %v, %v", revoked, err)
+			}
+			if got, _ := sessions.GetByToken("hash-1"); !got.Revoked {
+				t.Fatal("session not marked revoked")
+			}
+		}},
+		{"revoke all leaves other accounts signed in", func(t *testing.T, sessions account.SessionRepository, accountID, otherID int) {
+			create(t, sessions, accountID, "hash-1", later)
+			create(t, sessions, accountID, "hash-2", later)
+			other := create(t, sessions, otherID, "hash-other", later)
+			if err := sessions.RevokeAll(accountID); err != nil {
+				t.Fatalf("RevokeAll: %v", err)
+			}
+			if got := active(t, sessions, accountID); len(got) != 0 {
+				t.Fatalf("sessions %v still active", got)
+			}
+			if got := active(t, sessions, otherID); len(got) != 1 || got[0] != other.SessionID {
+				t.Fatalf("other account has sessions %v, want %d", got, other.SessionID)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions, accountID, otherID := newSessions(t)
+			tt.run(t, sessions, accountID, otherID)
+		})
+	}
+}
+
+// LoginFailures runs the LoginFailureRepository contract against a fresh repository per case
+func LoginFailures(t *testing.T, newLoginFailures func(t *testing.T) account.LoginFailureRepository) {
+	record := func(t *testing.T, failures account.LoginFailureRepository, ip string, times int) {
+		t.Helper()
+		for i := 0; i < times; i++ {
+			if err := failures.Record(ip); err != nil {
+				t.Fatalf("Record: %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, failures account.LoginFailureRepository)
+	}{
+		{"no failures", func(t *testing.T, failures account.LoginFailureRepository) {
+			count, sinceOldest, err := failures.Recent("192.0.2.1", 15*time.Minute)
+			if err != nil || count != 0 || sinceOldest != 0 {
+				t.Fatalf("got %d, %v, %v, want none", count, sinceOldest, err)
+			}
+		}},
+		{"failures are counted per address", func(t *testing.T, failures account.LoginFailureRepository) {
+			record(t, failures, "192.0.2.1", 3)
+			record(t, failures, "192.0.2.2", 1)
+			count, sinceOldest, err := failures.Recent("192.0.2.1", 15*time.Minute)
+			if err != nil {
+				t.Fatalf("Recent: %v", err)
+			}
+			if count != 3 {
+				t.Fatalf("counted %d failures, want 3", count)
+			}
+			if sinceOldest < 0 || sinceOldest > time.Minute {
+				t.Fatalf("oldest failure was %v ago, want just now", sinceOldest)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newLoginFailures(t))
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package account
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"common/auth"
+	"common/respond"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Brute-force protection settings
+const (
+	maxFailedLogins = 5                // Failures allowed before the account is locked
+	baseLockout     = time.Minute      // First lockout, doubled on every further failure
+	maxLockout      = time.Hour        // Longest an account can be locked
+	ipFailureLimit  = 20               // Failures allowed from one IP address within ipFailureWindow
+	ipFailureWindow = 15 * time.Minute // Sliding window for per-IP failures
+)
+
+// InvalidCredentialsMessage is the generic message for every failed login, so responses do
+// not reveal which emails are registered
+const InvalidCredentialsMessage = "Invalid email or password"
+
+// DummyPasswordHash is compared against when the email is unknown, so response times do not
+// reveal which emails are registered
+var DummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password-for-timing"), bcrypt.DefaultCost)
+
+// How long an account stays locked after the given number of consecutive failures
+func lockoutDuration(failedLogins int) time.Duration {
+	if failedLogins < maxFailedLogins {
+		return 0
+	}
+	lockout := baseLockout * time.Duration(math.Pow(2, float64(failedLogins-maxFailedLogins)))
+	if lockout > maxLockout || lockout <= 0 {
+		return maxLockout
+	}
+	return lockout
+}
+
+// IPRetryAfter returns the seconds until the IP address may try again, or 0 if it is not throttled
+func (s *Service) IPRetryAfter(ip string) (int, error) {
+	failures, sinceOldest, err := s.LoginFailures.Recent(ip, ipFailureWindow)
+	if err != nil || failures < ipFailureLimit {
+		return 0, err
+	}
+	return int((ipFailureWindow - sinceOldest).Seconds()), nil
+}
+
+// RecordFailedLogin records a failed login from the IP address and, for a known account,
+// advances its lockout. The lockout follows the count the increment returned, so concurrent
+// failures cannot overwrite each other.
+func (s *Service) RecordFailedLogin(ip string, accountID int) {
+	if err := s.LoginFailures.Record(ip); err != nil {
+		log.Println("Database insert error:", err)
+	}
+	if accountID <= 0 {
+		return
+	}
+
+	failedLogins, err := s.Accounts.IncrementFailedLogins(accountID)
+	if err != nil {
+		log.Println("Database update error:", err)
+		return
+	}
+	lockout := lockoutDuration(failedLogins)
+	if lockout <= 0 {
+		return
+	}
+	log.Printf("Locking %s %d for %s after %d failed logins\n", s.Kind, accountID, lockout, failedLogins)
+	if err := s.Accounts.LockFor(accountID, lockout); err != nil {
+		log.Println("Database update error:", err)
+	}
+}
+
+// RefuseLogin answers a login for an unknown or locked account exactly as a wrong password,
+// after spending the time of a password check, so neither can be told apart from outside
+func (s *Service) RefuseLogin(w http.ResponseWriter, ip string, password string) {
+	bcrypt.CompareHashAndPassword(DummyPasswordHash, []byte(password))
+	s.RecordFailedLogin(ip, 0)
+	http.Error(w, InvalidCredentialsMessage, http.StatusUnauthorized)
+}
+
+// TooManyAttempts replies with 429 and a Retry-After header. It is only for addresses making
+// too many attempts, as it would reveal that a locked account exists.
+func TooManyAttempts(w http.ResponseWriter, retryAfter int) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, "Too many failed login attempts. Please try again later.", http.StatusTooManyRequests)
+}
+
+// UnlockHandler lets an admin unlock an account before the lockout expires. The account is
+// named by the {<kind>_id} path variable.
+func (s *Service) UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	accountID, err := strconv.Atoi(mux.Vars(r)[s.Kind+"_id"])
+	if err != nil || accountID <= 0 {
+		http.Error(w, fmt.Sprintf("Invalid %s ID", s.Kind), http.StatusBadRequest)
+		return
+	}
+
+	_, err = s.Accounts.Account(accountID)
+	if err == ErrAccountNotFound {
+		http.Error(w, s.title()+" not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Clear the failure counter before the lockout expires
+	if err := s.Accounts.ResetFailedLogins(accountID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("%s %d unlocked by admin %d\n", s.title(), accountID, auth.ClaimsFromRequest(r).Subject)
+	respond.JSON(w, map[string]string{"message": "Account unlocked successfully"})
+}
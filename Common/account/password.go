@@ -0,0 +1,134 @@
+package account
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"common/auth"
+	"common/respond"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// Set of common and breached passwords, lower-cased
+var commonPasswords = loadCommonPasswords(commonPasswordsFile)
+
+func loadCommonPasswords(list string) map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = true
+	}
+	return passwords
+}
+
+// ValidatePassword applies the password policy to a new password, returning what is wrong
+// with it or "" if it is acceptable
+func ValidatePassword(password string) string {
+	if password == "" || len(password) < 8 {
+		return "Password must be at least 8 characters long"
+	}
+	// bcrypt ignores anything past 72 bytes
+	if len(password) > 72 {
+		return "Password must be at most 72 characters long"
+	}
+	if commonPasswords[strings.ToLower(password)] {
+		return "Password is too common, please choose a different one"
+	}
+	return ""
+}
+
+// ChangePasswordHandler changes the logged in account's password after verifying the current
+// one. Every other session is revoked and the caller receives fresh tokens.
+func (s *Service) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromRequest(r)
+	if !s.Owns(claims) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Verify the current password
+	account, err := s.Accounts.Account(claims.Subject)
+	if err == ErrAccountNotFound {
+		http.Error(w, s.title()+" not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(request.CurrentPassword)); err != nil {
+		http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+		return
+	}
+
+	// Validate the new password
+	msg := ValidatePassword(request.NewPassword)
+	if msg == "" && request.NewPassword == request.CurrentPassword {
+		msg = "New password must be different from the current password"
+	}
+	if msg != "" {
+		respond.JSONStatus(w, http.StatusBadRequest, map[string]string{"new_password": msg})
+		return
+	}
+
+	// Hash the password before storing it
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Password hashing error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.Accounts.SetPassword(claims.Subject, hashedPassword); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Log out every device, then start a new session for this one
+	if err := s.Sessions.RevokeAll(claims.Subject); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	token, refreshToken, err := s.CreateSession(claims.Subject, claims.Role, r.UserAgent())
+	if err != nil {
+		log.Println("Session creation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":       "Password changed successfully",
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.TokenTTL.Seconds()),
+	}
+	respond.JSON(w, response)
+}
@@ -0,0 +1,238 @@
+package account
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"common/auth"
+	"common/respond"
+
+	"github.com/gorilla/mux"
+)
+
+// How long a refresh token stays valid before the account holder must log in again
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+// HashToken hashes an opaque token before it is stored or looked up, so a leaked table cannot be replayed
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateOpaqueToken generates a random opaque token for refresh and one-time links
+func GenerateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateSession issues a new access token and stores a new refresh token for the account.
+// Caregiver tokens list the seniors who have approved them, and doctor tokens their current patients.
+func (s *Service) CreateSession(accountID int, role, userAgent string) (string, string, error) {
+	seniors, err := s.Accounts.TokenSeniors(accountID, role)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := auth.IssueToken(accountID, role, seniors)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	if err := s.Sessions.Create(accountID, HashToken(refreshToken), userAgent, time.Now().Add(refreshTokenTTL)); err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a rotated refresh token
+func (s *Service) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Look up the stored token
+	session, err := s.Sessions.GetByToken(HashToken(request.RefreshToken))
+	if err == ErrTokenNotFound {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	accountID := session.AccountID
+
+	// A revoked token being replayed means it was stolen, so end every session of the account
+	if session.Revoked {
+		log.Printf("Revoked refresh token reused for %s %d, revoking all sessions\n", s.Kind, accountID)
+		if err := s.Sessions.RevokeAll(accountID); err != nil {
+			log.Println("Database update error:", err)
+		}
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		http.Error(w, "Refresh token has expired", http.StatusUnauthorized)
+		return
+	}
+
+	account, err := s.Accounts.Account(accountID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !account.Active {
+		http.Error(w, "Account has been deactivated", http.StatusUnauthorized)
+		return
+	}
+
+	// Revoke the old token, guarding against two concurrent refreshes of the same token
+	revoked, err := s.Sessions.Revoke(session.SessionID, accountID)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, refreshToken, err := s.CreateSession(accountID, account.Role, r.UserAgent())
+	if err != nil {
+		log.Println("Session creation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		s.Kind + "_id":  accountID,
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.TokenTTL.Seconds()),
+	}
+	respond.JSON(w, response)
+}
+
+// LogoutHandler revokes the refresh token of the current device
+func (s *Service) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.Sessions.RevokeByToken(HashToken(request.RefreshToken)); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Logged out successfully"})
+}
+
+// LogoutAllHandler revokes every refresh token of the logged in account, logging out all devices
+func (s *Service) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromRequest(r)
+	if !s.Owns(claims) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := s.Sessions.RevokeAll(claims.Subject); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Logged out of all devices"})
+}
+
+// ListSessionsHandler lists the active sessions of the logged in account
+func (s *Service) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromRequest(r)
+	if !s.Owns(claims) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	active, err := s.Sessions.ListActive(claims.Subject)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	sessions := []map[string]interface{}{}
+	for _, session := range active {
+		sessions = append(sessions, map[string]interface{}{
+			"session_id": session.SessionID,
+			"user_agent": session.UserAgent,
+			"created_at": session.CreatedAt.Format("2006-01-02 15:04:05"),
+			"expires_at": session.ExpiresAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	respond.JSON(w, sessions)
+}
+
+// RevokeSessionHandler revokes a single session, e.g. one left logged in on a lost device
+func (s *Service) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromRequest(r)
+	if !s.Owns(claims) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	sessionID, err := strconv.Atoi(mux.Vars(r)["session_id"])
+	if err != nil || sessionID <= 0 {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := s.Sessions.Revoke(sessionID, claims.Subject)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !revoked {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Session revoked successfully"})
+}
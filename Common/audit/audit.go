@@ -0,0 +1,253 @@
+// Package audit keeps each service's append-only, hash-chained log of who read or changed
+// personal and health data, and serves it to compliance officers.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"common/auth"
+	"common/respond"
+)
+
+// Actions recorded in the log
+const (
+	Read   = "read"
+	Create = "create"
+	Update = "update"
+	Delete = "delete"
+)
+
+// Entry is one entry in the log. Each entry's hash covers the previous entry's hash, so
+// changing or removing a row breaks the chain from that row on.
+type Entry struct {
+	AuditID      int64     `json:"audit_id"`
+	Service      string    `json:"service"`
+	ActorID      int       `json:"actor_id"`
+	ActorRole    string    `json:"actor_role"`
+	Action       string    `json:"action"`
+	ResourceType string    `json:"resource_type"`
+	ResourceID   string    `json:"resource_id"`
+	SubjectID    int       `json:"subject_id"` // User whose health or profile data was touched, 0 if several
+	SourceIP     string    `json:"source_ip"`
+	CreatedAt    time.Time `json:"created_at"`
+	PrevHash     string    `json:"prev_hash"`
+	Hash         string    `json:"hash"`
+}
+
+// Filter is a search of the log. Unset fields match every entry.
+type Filter struct {
+	ActorID, SubjectID                          *int
+	ActorRole, Action, ResourceType, ResourceID string
+	From, To                                    time.Time // To is exclusive
+	Limit                                       int
+}
+
+// Matches reports whether an entry is one the filter searches for
+func (f Filter) Matches(e Entry) bool {
+	return (f.ActorID == nil || e.ActorID == *f.ActorID) &&
+		(f.SubjectID == nil || e.SubjectID == *f.SubjectID) &&
+		(f.ActorRole == "" || e.ActorRole == f.ActorRole) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.ResourceType == "" || e.ResourceType == f.ResourceType) &&
+		(f.ResourceID == "" || e.ResourceID == f.ResourceID) &&
+		(f.From.IsZero() || !e.CreatedAt.Before(f.From)) &&
+		(f.To.IsZero() || e.CreatedAt.Before(f.To))
+}
+
+// Repository is where the log is kept
+type Repository interface {
+	// Chain an entry onto the end of the log, filling in its time and hashes
+	Append(entry Entry) error
+	// Entries matching the filter, newest first
+	Search(filter Filter) ([]Entry, error)
+	// The whole log, oldest first
+	All() ([]Entry, error)
+}
+
+// Hash is the SHA-256 over the previous hash and every recorded field
+func Hash(e Entry) string {
+	fields, _ := json.Marshal([]interface{}{
+		e.PrevHash, e.Service, e.ActorID, e.ActorRole, e.Action, e.ResourceType, e.ResourceID,
+		e.SubjectID, e.SourceIP, e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// Log is the audit log of one service
+type Log struct {
+	service string
+	repo    Repository
+}
+
+// New returns the log of the named service, kept in repo
+func New(service string, repo Repository) *Log {
+	return &Log{service: service, repo: repo}
+}
+
+// Service is the name recorded on every entry
+func (l *Log) Service() string {
+	return l.service
+}
+
+// Append chains an entry onto the log under the service's name
+func (l *Log) Append(entry Entry) error {
+	entry.Service = l.service
+	return l.repo.Append(entry)
+}
+
+// Search returns the entries matching the filter, newest first
+func (l *Log) Search(filter Filter) ([]Entry, error) {
+	return l.repo.Search(filter)
+}
+
+// All returns the whole log, oldest first
+func (l *Log) All() ([]Entry, error) {
+	return l.repo.All()
+}
+
+// Entry for the logged in caller
+func newEntry(r *http.Request, action, resourceType, resourceID string, subjectID int) Entry {
+	entry := Entry{
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		SubjectID:    subjectID,
+		SourceIP:     auth.ClientIP(r),
+	}
+	if claims := auth.ClaimsFromRequest(r); claims != nil {
+		entry.ActorID = claims.Subject
+		entry.ActorRole = claims.Role
+	}
+	return entry
+}
+
+// Access records a read before the data is returned. If it cannot be recorded nothing is returned.
+func (l *Log) Access(w http.ResponseWriter, r *http.Request, resourceType, resourceID string, subjectID int) bool {
+	if err := l.Append(newEntry(r, Read, resourceType, resourceID, subjectID)); err != nil {
+		log.Println("Audit log error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// Change records a change once it has been made. The change already stands, so a failure is only logged.
+func (l *Log) Change(r *http.Request, action, resourceType, resourceID string, subjectID int) {
+	if err := l.Append(newEntry(r, action, resourceType, resourceID, subjectID)); err != nil {
+		log.Printf("Audit log error (%s %s %s): %v\n", action, resourceType, resourceID, err)
+	}
+}
+
+// ParseFilter builds a search of the log from the query string. Filters are optional and combined.
+func ParseFilter(params url.Values) (Filter, error) {
+	var filter Filter
+	for _, f := range []struct {
+		Param string
+		Value **int
+	}{{"actor_id", &filter.ActorID}, {"subject_id", &filter.SubjectID}} {
+		if value := params.Get(f.Param); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return filter, respond.Errorf(http.StatusBadRequest, "Invalid %s", f.Param)
+			}
+			*f.Value = &id
+		}
+	}
+	filter.ActorRole = params.Get("actor_role")
+	filter.Action = params.Get("action")
+	filter.ResourceType = params.Get("resource_type")
+	filter.ResourceID = params.Get("resource_id")
+	for _, f := range []struct {
+		Param string
+		Value *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if value := params.Get(f.Param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, respond.Errorf(http.StatusBadRequest, "Invalid %s, expected an RFC 3339 time", f.Param)
+			}
+			*f.Value = t
+		}
+	}
+
+	// 100 entries unless the caller asks for up to 1000
+	filter.Limit = 100
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 1000 {
+			return filter, respond.Errorf(http.StatusBadRequest, "Limit must be between 1 and 1000")
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// SearchHandler searches the log for compliance officers
+func (l *Log) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := ParseFilter(r.URL.Query())
+	if err != nil {
+		respond.Fail(w, err)
+		return
+	}
+
+	// Searches of the log are themselves recorded
+	if !l.Access(w, r, "audit_log", "", 0) {
+		return
+	}
+
+	entries, err := l.Search(filter)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]interface{}{
+		"service": l.service,
+		"entries": entries,
+	})
+}
+
+// VerifyHandler walks the whole chain and reports the first entry that no longer matches its hash
+func (l *Log) VerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := l.All()
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{"service": l.service, "valid": true}
+	previous := ""
+	checked := 0
+	for _, e := range entries {
+		if e.PrevHash != previous || Hash(e) != e.Hash {
+			response["valid"] = false
+			response["broken_at"] = e.AuditID
+			break
+		}
+		previous = e.Hash
+		checked++
+	}
+	response["entries_checked"] = checked
+
+	respond.JSON(w, response)
+}
@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// A log of five entries kept in memory
+func newTestLog(t *testing.T) (*Log, *MemoryRepository) {
+	t.Helper()
+	repo := &MemoryRepository{}
+	l := New("test", repo)
+	for i, action := range []string{Create, Read, Update, Read, Delete} {
+		if err := l.Append(Entry{ActorID: 7, ActorRole: "senior", Action: action, ResourceType: "assessment", ResourceID: "1", SubjectID: 7, SourceIP: "203.0.113.5"}); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+	}
+	return l, repo
+}
+
+type verifyResponse struct {
+	Valid          bool  `json:"valid"`
+	BrokenAt       int64 `json:"broken_at"`
+	EntriesChecked int   `json:"entries_checked"`
+}
+
+func verify(t *testing.T, l *Log) verifyResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	l.VerifyHandler(rec, httptest.NewRequest(http.MethodGet, "/audit/verify", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var response verifyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestAppendChainsEntries(t *testing.T) {
+	l, repo := newTestLog(t)
+	previous := ""
+	for _, e := range repo.entries {
+		if e.Service != "test" || e.PrevHash != previous || e.Hash != Hash(e) {
+			t.Fatalf("entry %d not chained: %+v", e.AuditID, e)
+		}
+		previous = e.Hash
+	}
+	if response := verify(t, l); !response.Valid || response.EntriesChecked != 5 {
+		t.Fatalf("untouched log: %+v", response)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(entries []Entry) []Entry
+		brokenAt int64
+		checked  int
+	}{
+		{"field changed", func(entries []Entry) []Entry {
+			entries[2].ActorID = 8
+			return entries
+		}, 3, 2},
+		{"field changed and rehashed", func(entries []Entry) []Entry {
+			entries[2].Action = Read
+			entries[2].Hash = Hash(entries[2])
+			return entries
+		}, 4, 3},
+		{"time changed", func(entries []Entry) []Entry {
+			entries[0].CreatedAt = entries[0].CreatedAt.Add(-1)
+			return entries
+		}, 1, 0},
+		{"entry removed", func(entries []Entry) []Entry {
+			return slices.Delete(entries, 1, 2)
+		}, 3, 1},
+		{"entries swapped", func(entries []Entry) []Entry {
+			entries[3], entries[4] = entries[4], entries[3]
+			return entries
+		}, 5, 3},
+		// Truncating the end leaves a valid chain, so it can only be spotted by the entry count
+		{"last entry removed", func(entries []Entry) []Entry {
+			return entries[:4]
+		}, 0, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, repo := newTestLog(t)
+			repo.entries = tt.tamper(repo.entries)
+			response := verify(t, l)
+			if response.BrokenAt != tt.brokenAt || response.Valid != (tt.brokenAt == 0) || response.EntriesChecked != tt.checked {
+				t.Fatalf("%+v, want broken at %d after %d entries", response, tt.brokenAt, tt.checked)
+			}
+		})
+	}
+}
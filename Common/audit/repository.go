@@ -0,0 +1,147 @@
+package audit
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entries are chained one at a time
+var appendMutex sync.Mutex
+
+// MySQLRepository keeps the log in the service's AuditLog table
+type MySQLRepository struct {
+	DB *sql.DB
+}
+
+func (m MySQLRepository) Append(entry Entry) error {
+	appendMutex.Lock()
+	defer appendMutex.Unlock()
+
+	// Stored to the microsecond, so hash exactly what will be read back
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the last entry keeps other instances from chaining onto it at the same time
+	err = tx.QueryRow("SELECT Hash FROM AuditLog ORDER BY AuditID DESC LIMIT 1 FOR UPDATE").Scan(&entry.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	entry.Hash = Hash(entry)
+
+	query := `INSERT INTO AuditLog (Service, ActorID, ActorRole, Action, ResourceType, ResourceID, SubjectID, SourceIP, CreatedAt, PrevHash, Hash)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(query, entry.Service, entry.ActorID, entry.ActorRole, entry.Action, entry.ResourceType, entry.ResourceID,
+		entry.SubjectID, entry.SourceIP, entry.CreatedAt, entry.PrevHash, entry.Hash)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Columns read back from the log, in the order query scans them
+const columns = `SELECT AuditID, Service, ActorID, ActorRole, Action, ResourceType, ResourceID, SubjectID, SourceIP, CreatedAt, PrevHash, Hash
+              FROM AuditLog`
+
+func (m MySQLRepository) Search(filter Filter) ([]Entry, error) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	for _, c := range []struct {
+		Column string
+		Value  *int
+	}{{"ActorID", filter.ActorID}, {"SubjectID", filter.SubjectID}} {
+		if c.Value != nil {
+			conditions = append(conditions, c.Column+" = ?")
+			args = append(args, *c.Value)
+		}
+	}
+	for _, c := range []struct{ Column, Value string }{
+		{"ActorRole", filter.ActorRole}, {"Action", filter.Action}, {"ResourceType", filter.ResourceType}, {"ResourceID", filter.ResourceID},
+	} {
+		if c.Value != "" {
+			conditions = append(conditions, c.Column+" = ?")
+			args = append(args, c.Value)
+		}
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "CreatedAt >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "CreatedAt < ?")
+		args = append(args, filter.To.UTC())
+	}
+	args = append(args, filter.Limit)
+	return m.query(columns+" WHERE "+strings.Join(conditions, " AND ")+" ORDER BY AuditID DESC LIMIT ?", args...)
+}
+
+func (m MySQLRepository) All() ([]Entry, error) {
+	return m.query(columns + " ORDER BY AuditID")
+}
+
+// Run a query selecting columns
+func (m MySQLRepository) query(query string, args ...interface{}) ([]Entry, error) {
+	rows, err := m.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.AuditID, &e.Service, &e.ActorID, &e.ActorRole, &e.Action, &e.ResourceType, &e.ResourceID,
+			&e.SubjectID, &e.SourceIP, &e.CreatedAt, &e.PrevHash, &e.Hash); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// MemoryRepository keeps the log in memory, chained the same way as in MySQL
+type MemoryRepository struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+func (m *MemoryRepository) Append(entry Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.AuditID = int64(len(m.entries) + 1)
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if len(m.entries) > 0 {
+		entry.PrevHash = m.entries[len(m.entries)-1].Hash
+	}
+	entry.Hash = Hash(entry)
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func (m *MemoryRepository) Search(filter Filter) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []Entry{}
+	for i := len(m.entries) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		if filter.Matches(m.entries[i]) {
+			entries = append(entries, m.entries[i])
+		}
+	}
+	return entries, nil
+}
+
+func (m *MemoryRepository) All() ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.entries), nil
+}
@@ -0,0 +1,272 @@
+// Package auth issues and checks the signed session tokens every service trusts, and decides
+// whose records a caller may see.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/rbac"
+
+	"github.com/gorilla/mux"
+)
+
+// TokenTTL is how long an access token stays valid, kept short so revoked sessions expire quickly
+const TokenTTL = 15 * time.Minute
+
+// Secret used to sign and verify session tokens, set from JWT_SECRET with SetSecret
+var secret []byte
+
+// SetSecret sets the shared secret tokens are signed with
+func SetSecret(s string) {
+	secret = []byte(s)
+}
+
+// Claims stored inside a session token
+type Claims struct {
+	Subject   int    `json:"sub"`
+	Role      string `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Seniors   []int  `json:"seniors,omitempty"` // Seniors a caregiver is linked to, or a doctor is currently assigned
+}
+
+type claimsContextKey struct{}
+
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Sign a payload with the shared secret
+func sign(unsigned string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IssueToken creates a signed HS256 JWT for the given subject and role, listing the seniors a
+// caregiver or doctor may see
+func IssueToken(subject int, role string, seniors []int) (string, error) {
+	now := time.Now()
+	payload, err := json.Marshal(Claims{
+		Subject:   subject,
+		Role:      role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(TokenTTL).Unix(),
+		Seniors:   seniors,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + sign(unsigned), nil
+}
+
+// ServiceSubject is the subject of tokens a service issues for itself
+const ServiceSubject = 1
+
+// IssueServiceToken creates a token for a service to call another on its own behalf, when no
+// user's request is being served
+func IssueServiceToken() (string, error) {
+	return IssueToken(ServiceSubject, rbac.RoleService, nil)
+}
+
+// ParseToken verifies the signature and expiry of a token and returns its claims
+func ParseToken(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, errors.New("malformed token")
+	}
+
+	expected := sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Subject <= 0 || claims.Role == "" {
+		return nil, errors.New("token is missing claims")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.New("token has expired")
+	}
+	return &claims, nil
+}
+
+// Middleware rejects requests without a valid bearer token
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Let CORS preflight requests through
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		authHeader := r.Header.Get("Authorization")
+		token, found := strings.CutPrefix(authHeader, "Bearer ")
+		if !found || token == "" {
+			http.Error(w, "Missing authorization token", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := ParseToken(token)
+		if err != nil {
+			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsContextKey{}, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClaimsFromRequest retrieves the claims attached by Middleware
+func ClaimsFromRequest(r *http.Request) *Claims {
+	claims, _ := r.Context().Value(claimsContextKey{}).(*Claims)
+	return claims
+}
+
+// RequirePermission restricts a handler to callers whose role is granted the permission
+func RequirePermission(next http.HandlerFunc, permission string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Let CORS preflight requests through
+		if r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+
+		claims := ClaimsFromRequest(r)
+		if claims == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !rbac.HasPermission(claims.Role, permission) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// AuthorizeUser lets seniors access only their own records, caregivers the records of the
+// seniors who linked them, and doctors the records of the patients they are currently assigned
+func AuthorizeUser(w http.ResponseWriter, r *http.Request, userID int) bool {
+	claims := ClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if claims.Role == rbac.RoleSenior && claims.Subject == userID {
+		return true
+	}
+	if (claims.Role == rbac.RoleCaregiver || claims.Role == rbac.RoleDoctor) && slices.Contains(claims.Seniors, userID) {
+		return true
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// AuthorizeDataSubject lets seniors and caregivers export or erase their own personal data,
+// and system admins anyone's when handling a request on their behalf
+func AuthorizeDataSubject(w http.ResponseWriter, r *http.Request, userID int) bool {
+	claims := ClaimsFromRequest(r)
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if claims.Role == rbac.RoleSystemAdmin || ((claims.Role == rbac.RoleSenior || claims.Role == rbac.RoleCaregiver) && claims.Subject == userID) {
+		return true
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// DataSubjectFromPath parses the user_id path variable and checks the caller may act on that
+// user's personal data with AuthorizeDataSubject
+func DataSubjectFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, AuthorizeDataSubject(w, r, userID)
+}
+
+// Peers whose X-Real-IP header is believed, set from TRUSTED_PROXIES with SetTrustedProxies
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the comma-separated addresses or CIDR ranges of the reverse proxies
+// in front of the service. Only requests arriving from one of them may name the client with
+// X-Real-IP.
+func SetTrustedProxies(list string) error {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("trusted proxy %q is not an IP address or CIDR range", entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("trusted proxy %q is not an IP address or CIDR range", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	trustedProxies = proxies
+	return nil
+}
+
+// ClientIP is the client address as seen by nginx when the request came through a trusted
+// proxy, and otherwise the direct peer, so clients cannot pick their own address
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := r.Header.Get("X-Real-IP"); ip != "" && isTrustedProxy(host) {
+		return ip
+	}
+	return host
+}
+
+func isTrustedProxy(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
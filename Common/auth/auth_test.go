@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"common/rbac"
+
+	"github.com/gorilla/mux"
+)
+
+func init() {
+	SetSecret("auth-tests")
+}
+
+// Build a token by hand, so tests can get the header, claims and signature wrong
+func forge(t *testing.T, header string, claims Claims, key string) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseToken(t *testing.T) {
+	const hs256 = `{"alg":"HS256","typ":"JWT"}`
+	now := time.Now()
+	valid := Claims{Subject: 7, Role: rbac.RoleSenior, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+	expired := valid
+	expired.ExpiresAt = now.Add(-time.Second).Unix()
+	noRole := valid
+	noRole.Role = ""
+	noSubject := valid
+	noSubject.Subject = 0
+
+	issued, err := IssueToken(7, rbac.RoleDoctor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"issued token", issued, false},
+		{"forged with the secret", forge(t, hs256, valid, "auth-tests"), false},
+		{"expired", forge(t, hs256, expired, "auth-tests"), true},
+		{"signed with another secret", forge(t, hs256, valid, "other"), true},
+		{"alg none", forge(t, `{"alg":"none","typ":"JWT"}`, valid, "auth-tests"), true},
+		{"alg HS512", forge(t, `{"alg":"HS512","typ":"JWT"}`, valid, "auth-tests"), true},
+		{"no role", forge(t, hs256, noRole, "auth-tests"), true},
+		{"no subject", forge(t, hs256, noSubject, "auth-tests"), true},
+		{"unsigned", tokenHeader + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":7}`)) + ".", true},
+		{"two parts", "a.b", true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ParseToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && claims.Subject != 7 {
+				t.Fatalf("subject = %d, want 7", claims.Subject)
+			}
+		})
+	}
+
+	claims, _ := ParseToken(issued)
+	if claims.Role != rbac.RoleDoctor || claims.ExpiresAt-claims.IssuedAt != int64(TokenTTL/time.Second) {
+		t.Fatalf("issued claims %+v", claims)
+	}
+}
+
+// A request carrying the claims Middleware would attach, or none if claims is nil
+func withClaims(claims *Claims) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if claims == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims))
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *Claims
+		want   int
+	}{
+		{"senior, own records", &Claims{Subject: 7, Role: rbac.RoleSenior}, http.StatusOK},
+		{"senior, another's", &Claims{Subject: 8, Role: rbac.RoleSenior}, http.StatusForbidden},
+		{"senior listing seniors", &Claims{Subject: 8, Role: rbac.RoleSenior, Seniors: []int{7}}, http.StatusForbidden},
+		{"linked caregiver", &Claims{Subject: 20, Role: rbac.RoleCaregiver, Seniors: []int{6, 7}}, http.StatusOK},
+		{"unlinked caregiver", &Claims{Subject: 20, Role: rbac.RoleCaregiver, Seniors: []int{6}}, http.StatusForbidden},
+		{"caregiver with own ID", &Claims{Subject: 7, Role: rbac.RoleCaregiver}, http.StatusForbidden},
+		{"assigned doctor", &Claims{Subject: 1, Role: rbac.RoleDoctor, Seniors: []int{7}}, http.StatusOK},
+		{"unassigned doctor", &Claims{Subject: 1, Role: rbac.RoleDoctor}, http.StatusForbidden},
+		{"clinic admin", &Claims{Subject: 2, Role: rbac.RoleAdmin, Seniors: []int{7}}, http.StatusForbidden},
+		{"system admin", &Claims{Subject: 3, Role: rbac.RoleSystemAdmin}, http.StatusForbidden},
+		{"compliance officer", &Claims{Subject: 4, Role: rbac.RoleCompliance}, http.StatusForbidden},
+		{"service", &Claims{Subject: ServiceSubject, Role: rbac.RoleService}, http.StatusForbidden},
+		{"no claims", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ok := AuthorizeUser(rec, withClaims(tt.claims), 7)
+			if ok != (tt.want == http.StatusOK) || rec.Code != tt.want {
+				t.Fatalf("ok = %v, status = %d, want %d", ok, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeDataSubject(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *Claims
+		want   int
+	}{
+		{"senior, own data", &Claims{Subject: 7, Role: rbac.RoleSenior}, http.StatusOK},
+		{"senior, another's", &Claims{Subject: 8, Role: rbac.RoleSenior}, http.StatusForbidden},
+		{"caregiver, own data", &Claims{Subject: 7, Role: rbac.RoleCaregiver}, http.StatusOK},
+		{"caregiver, linked senior's", &Claims{Subject: 20, Role: rbac.RoleCaregiver, Seniors: []int{7}}, http.StatusForbidden},
+		{"assigned doctor", &Claims{Subject: 1, Role: rbac.RoleDoctor, Seniors: []int{7}}, http.StatusForbidden},
+		{"doctor with the same ID", &Claims{Subject: 7, Role: rbac.RoleDoctor}, http.StatusForbidden},
+		{"clinic admin", &Claims{Subject: 2, Role: rbac.RoleAdmin}, http.StatusForbidden},
+		{"system admin", &Claims{Subject: 3, Role: rbac.RoleSystemAdmin}, http.StatusOK},
+		{"compliance officer", &Claims{Subject: 4, Role: rbac.RoleCompliance}, http.StatusForbidden},
+		{"no claims", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ok := AuthorizeDataSubject(rec, withClaims(tt.claims), 7)
+			if ok != (tt.want == http.StatusOK) || rec.Code != tt.want {
+				t.Fatalf("ok = %v, status = %d, want %d", ok, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDataSubjectFromPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		want   int
+	}{
+		{"own data", "7", http.StatusOK},
+		{"another's", "8", http.StatusForbidden},
+		{"not a number", "seven", http.StatusBadRequest},
+		{"zero", "0", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.SetURLVars(withClaims(&Claims{Subject: 7, Role: rbac.RoleSenior}), map[string]string{"user_id": tt.userID})
+			rec := httptest.NewRecorder()
+			userID, ok := DataSubjectFromPath(rec, r)
+			if ok != (tt.want == http.StatusOK) || rec.Code != tt.want {
+				t.Fatalf("ok = %v, status = %d, want %d", ok, rec.Code, tt.want)
+			}
+			if ok && userID != 7 {
+				t.Fatalf("user ID = %d, want 7", userID)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	handler := RequirePermission(func(w http.ResponseWriter, r *http.Request) {}, rbac.PermRosterRead)
+
+	tests := []struct {
+		name   string
+		claims *Claims
+		want   int
+	}{
+		{"doctor", &Claims{Subject: 1, Role: rbac.RoleDoctor}, http.StatusOK},
+		{"senior", &Claims{Subject: 7, Role: rbac.RoleSenior}, http.StatusForbidden},
+		{"unknown role", &Claims{Subject: 7, Role: "root"}, http.StatusForbidden},
+		{"no claims", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, withClaims(tt.claims))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	token, err := IssueToken(7, rbac.RoleSenior, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims := ClaimsFromRequest(r); claims == nil || claims.Subject != 7 {
+			t.Errorf("claims %+v", claims)
+		}
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"bearer token", "Bearer " + token, http.StatusOK},
+		{"no header", "", http.StatusUnauthorized},
+		{"no bearer prefix", token, http.StatusUnauthorized},
+		{"invalid token", "Bearer " + token + "x", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxies    string
+		remoteAddr string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "", "203.0.113.5:4000", "", "203.0.113.5"},
+		{"header ignored without trusted proxies", "", "127.0.0.1:4000", "198.51.100.1", "127.0.0.1"},
+		{"default proxies", "127.0.0.1,::1", "127.0.0.1:4000", "198.51.100.1", "198.51.100.1"},
+		{"IPv6 loopback proxy", "127.0.0.1,::1", "[::1]:4000", "198.51.100.1", "198.51.100.1"},
+		{"untrusted peer cannot pick its address", "127.0.0.1,::1", "203.0.113.5:4000", "198.51.100.1", "203.0.113.5"},
+		{"CIDR range", "10.0.0.0/8", "10.1.2.3:4000", "198.51.100.1", "198.51.100.1"},
+		{"outside the CIDR range", "10.0.0.0/8", "11.1.2.3:4000", "198.51.100.1", "11.1.2.3"},
+		{"trusted proxy without the header", "127.0.0.1", "127.0.0.1:4000", "", "127.0.0.1"},
+		{"address without a port", "", "203.0.113.5", "", "203.0.113.5"},
+	}
+	t.Cleanup(func() { trustedProxies = nil })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetTrustedProxies(tt.proxies); err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := ClientIP(r); got != tt.want {
+				t.Fatalf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxiesRejectsInvalidEntries(t *testing.T) {
+	t.Cleanup(func() { trustedProxies = nil })
+	for _, list := range []string{"localhost", "127.0.0.1,nginx", "10.0.0.0/33"} {
+		if err := SetTrustedProxies(list); err == nil {
+			t.Errorf("SetTrustedProxies(%q) accepted", list)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Command fieldkeys prints a new field encryption key file for a service:
+//
+//	go run ./cmd/fieldkeys > "../User/field_keys.json"
+//
+// or, with -rotate, the service's key file with a new current master key and index key added,
+// keeping the older master keys so stored values can be re-encrypted:
+//
+//	go run ./cmd/fieldkeys -rotate "../User/field_keys.json" > new_keys.json
+//
+// Each service needs its own file. Key files are never committed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"common/fieldcrypt"
+)
+
+func main() {
+	id := flag.String("id", time.Now().Format("2006-01"), "ID of the new master key")
+	rotate := flag.String("rotate", "", "Key file to add the new keys to")
+	flag.Parse()
+
+	var keyFile []byte
+	var err error
+	if *rotate != "" {
+		var existing []byte
+		existing, err = os.ReadFile(*rotate)
+		if err != nil {
+			log.Fatalf("Failed to read key file: %v", err)
+		}
+		keyFile, err = fieldcrypt.RotateKeyFile(existing, *id)
+	} else {
+		keyFile, err = fieldcrypt.GenerateKeyFile(*id)
+	}
+	if err != nil {
+		log.Fatalf("Failed to generate keys: %v", err)
+	}
+	fmt.Println(string(keyFile))
+}
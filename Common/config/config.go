@@ -0,0 +1,348 @@
+// Package config loads a service's settings into a typed struct, so every service reads,
+// validates and logs its configuration the same way.
+//
+// Each field of the settings struct names its environment variable with an env tag, and may
+// add a default, mark itself required or ask for validation:
+//
+//	Port    int    `env:"LOCAL_PORT" default:"5001" validate:"port"`
+//	Storage string `env:"STORAGE" default:"mysql" validate:"oneof=mysql memory"`
+//
+// Values are taken from the first place they are found: the process environment, the
+// profile's .env.<profile> file, the .env file, then the default. A variable can also be given
+// as NAME_FILE holding the path of a file to read it from, e.g. a mounted secret, in any of
+// those places.
+package config
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Profiles, chosen with APP_ENV
+const (
+	Development = "development"
+	Test        = "test"
+	Production  = "production"
+)
+
+var profiles = []string{Development, Test, Production}
+
+// Shown in place of a secret's value
+const redacted = "[redacted]"
+
+// Shortest secret accepted in production
+const minSecretLength = 16
+
+// Secrets from the development .env files and other well-known defaults, refused in production
+var devSecrets = []string{"befrienders-dev-secret-change-me", "root", "password", "secret", "changeme", "change-me"}
+
+// A setting that must never appear in logs. Printing it shows a placeholder, and the real
+// value is only available through Value.
+type Secret string
+
+func (s Secret) Value() string { return string(s) }
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string { return s.String() }
+
+func (s Secret) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// Where a service keeps its data, chosen with STORAGE. In memory, nothing is kept once the
+// service stops, but no database server is needed.
+const (
+	StorageMySQL  = "mysql"
+	StorageMemory = "memory"
+)
+
+// MySQL connection settings. The pool limits keep a busy service from opening more
+// connections than MySQL allows, while keeping enough open to absorb bursts.
+type Database struct {
+	User            string        `env:"DB_USER" required:"true"`
+	Password        Secret        `env:"DB_PASSWORD" required:"true"`
+	Host            string        `env:"DB_HOST" default:"127.0.0.1:3306"`
+	Name            string        `env:"DB_NAME" required:"true"`
+	MaxOpenConns    int           `env:"DB_MAX_OPEN_CONNS" default:"25" validate:"positive"`
+	MaxIdleConns    int           `env:"DB_MAX_IDLE_CONNS" default:"25" validate:"positive"`
+	ConnMaxLifetime time.Duration `env:"DB_CONN_MAX_LIFETIME" default:"5m" validate:"positive"`
+}
+
+// Data source name for the MySQL driver, without parameters
+func (d Database) DSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s", d.User, d.Password.Value(), d.Host, d.Name)
+}
+
+// Base URLs of the services and the front end. The defaults match a local development setup.
+type Services struct {
+	User             string `env:"USER_SERVICE_URL" default:"http://localhost:5001" validate:"url"`
+	SelfAssessment   string `env:"SELF_ASSESSMENT_SERVICE_URL" default:"http://localhost:5000" validate:"url"`
+	RiskAssessment   string `env:"RISK_ASSESSMENT_SERVICE_URL" default:"http://localhost:8080" validate:"url"`
+	VisionAssessment string `env:"VISION_ASSESSMENT_SERVICE_URL" default:"http://localhost:8088" validate:"url"`
+	Notifications    string `env:"NOTIFICATIONS_SERVICE_URL" default:"http://localhost:5002" validate:"url"`
+	Doctor           string `env:"DOCTOR_SERVICE_URL" default:"http://localhost:5004" validate:"url"`
+	Email            string `env:"EMAIL_SERVICE_URL" default:"http://localhost:8090" validate:"url"`
+	FrontEnd         string `env:"FRONT_END_URL" default:"http://localhost:5500" validate:"url"`
+}
+
+// Outgoing mail server. Mail is sent from the account it logs in with.
+type SMTP struct {
+	Host     string `env:"SMTP_HOST" default:"smtp.gmail.com"`
+	Port     int    `env:"SMTP_PORT" default:"587" validate:"port"`
+	Username string `env:"SMTP_USERNAME" required:"true"`
+	Password Secret `env:"SMTP_PASSWORD" required:"true"`
+}
+
+// Every setting that is missing or invalid, reported together so they can all be fixed at once
+type Error struct {
+	Profile string
+	Missing []string
+	Invalid []string
+}
+
+func (e *Error) Error() string {
+	var problems []string
+	if len(e.Missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		problems = append(problems, "invalid "+strings.Join(e.Invalid, ", "))
+	}
+	return fmt.Sprintf("%s configuration: %s", e.Profile, strings.Join(problems, "; "))
+}
+
+// Load the settings struct cfg points to and return the profile it was loaded for
+func Load(cfg interface{}) (string, error) {
+	target := reflect.ValueOf(cfg)
+	if target.Kind() != reflect.Pointer || target.Elem().Kind() != reflect.Struct {
+		return "", fmt.Errorf("config.Load needs a pointer to a struct, got %T", cfg)
+	}
+
+	base, err := readEnvFile(".env")
+	if err != nil {
+		return "", err
+	}
+
+	profile := os.Getenv("APP_ENV")
+	if profile == "" {
+		profile = base["APP_ENV"]
+	}
+	if profile == "" {
+		profile = Development
+	}
+	if !slices.Contains(profiles, profile) {
+		return "", fmt.Errorf("unknown APP_ENV %q, expected one of %s", profile, strings.Join(profiles, ", "))
+	}
+
+	overrides, err := readEnvFile(".env." + profile)
+	if err != nil {
+		return profile, err
+	}
+
+	l := &loader{files: []map[string]string{overrides, base}, err: &Error{Profile: profile}}
+	l.fill(target.Elem())
+	if profile == Production {
+		l.checkSecrets(target.Elem())
+	}
+	if len(l.err.Missing) > 0 || len(l.err.Invalid) > 0 {
+		return profile, l.err
+	}
+	return profile, nil
+}
+
+// MustLoad loads the settings and logs them, or exits listing every setting that is missing or invalid
+func MustLoad(cfg interface{}) {
+	profile, err := Load(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	log.Printf("Loaded %s configuration: %s", profile, Describe(cfg))
+}
+
+// Describe the loaded settings for the startup log, with secrets redacted
+func Describe(cfg interface{}) string {
+	var settings []string
+	walk(reflect.Indirect(reflect.ValueOf(cfg)), func(field reflect.StructField, value reflect.Value) {
+		settings = append(settings, fmt.Sprintf("%s=%v", field.Tag.Get("env"), value.Interface()))
+	})
+	return strings.Join(settings, " ")
+}
+
+// Read a .env file, treating a missing one as empty
+func readEnvFile(path string) (map[string]string, error) {
+	values, err := godotenv.Read(path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return values, nil
+}
+
+// Call fn for every field with an env tag, descending into nested settings structs
+func walk(v reflect.Value, fn func(reflect.StructField, reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		field, value := v.Type().Field(i), v.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Tag.Get("env") == "" {
+			if value.Kind() == reflect.Struct {
+				walk(value, fn)
+			}
+			continue
+		}
+		fn(field, value)
+	}
+}
+
+type loader struct {
+	files []map[string]string // .env.<profile> then .env
+	err   *Error
+}
+
+// Look a variable up in the environment, then in the .env files. At each place NAME_FILE is
+// read instead if NAME is not set.
+func (l *loader) lookup(name string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+	if path, ok := os.LookupEnv(name + "_FILE"); ok {
+		return readValueFile(path)
+	}
+	for _, file := range l.files {
+		if value, ok := file[name]; ok {
+			return value, true, nil
+		}
+		if path, ok := file[name+"_FILE"]; ok {
+			return readValueFile(path)
+		}
+	}
+	return "", false, nil
+}
+
+func readValueFile(path string) (string, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+func (l *loader) fill(v reflect.Value) {
+	walk(v, func(field reflect.StructField, value reflect.Value) {
+		name := field.Tag.Get("env")
+		raw, found, err := l.lookup(name)
+		if err != nil {
+			l.err.Invalid = append(l.err.Invalid, fmt.Sprintf("%s (%v)", name, err))
+			return
+		}
+		if !found || raw == "" {
+			raw, found = field.Tag.Lookup("default")
+		}
+		if !found || raw == "" {
+			if field.Tag.Get("required") == "true" {
+				l.err.Missing = append(l.err.Missing, name)
+			}
+			return
+		}
+
+		if err := set(value, raw); err != nil {
+			l.err.Invalid = append(l.err.Invalid, fmt.Sprintf("%s (%v)", name, err))
+			return
+		}
+		if err := validate(field.Tag.Get("validate"), value); err != nil {
+			l.err.Invalid = append(l.err.Invalid, fmt.Sprintf("%s (%v)", name, err))
+		}
+	})
+}
+
+// Refuse secrets left at a development value or too short to be safe
+func (l *loader) checkSecrets(v reflect.Value) {
+	walk(v, func(field reflect.StructField, value reflect.Value) {
+		secret, ok := value.Interface().(Secret)
+		if !ok || secret == "" {
+			return
+		}
+		name := field.Tag.Get("env")
+		if slices.Contains(devSecrets, strings.ToLower(secret.Value())) {
+			l.err.Invalid = append(l.err.Invalid, fmt.Sprintf("%s (a development value, not for production)", name))
+		} else if len(secret.Value()) < minSecretLength {
+			l.err.Invalid = append(l.err.Invalid, fmt.Sprintf("%s (must be at least %d characters in production)", name, minSecretLength))
+		}
+	})
+}
+
+// Parse raw into the field according to its type
+func set(value reflect.Value, raw string) error {
+	switch {
+	case value.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("not a duration")
+		}
+		value.SetInt(int64(d))
+	case value.Kind() == reflect.String:
+		value.SetString(raw)
+	case value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("not a number")
+		}
+		value.SetInt(int64(n))
+	case value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("not true or false")
+		}
+		value.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", value.Type())
+	}
+	return nil
+}
+
+func validate(rule string, value reflect.Value) error {
+	if allowed, ok := strings.CutPrefix(rule, "oneof="); ok {
+		if !slices.Contains(strings.Fields(allowed), value.String()) {
+			return fmt.Errorf("must be one of %s", strings.Join(strings.Fields(allowed), ", "))
+		}
+		return nil
+	}
+
+	switch rule {
+	case "":
+	case "positive":
+		if value.Int() < 1 {
+			return fmt.Errorf("must be greater than 0")
+		}
+	case "port":
+		if port := value.Int(); port < 1 || port > 65535 {
+			return fmt.Errorf("must be between 1 and 65535")
+		}
+	case "url":
+		u, err := url.Parse(value.String())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("not an http or https URL")
+		}
+		// Paths are appended directly to base URLs
+		value.SetString(strings.TrimRight(value.String(), "/"))
+	default:
+		return fmt.Errorf("unknown validation %q", rule)
+	}
+	return nil
+}
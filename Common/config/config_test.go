@@ -0,0 +1,150 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testSettings struct {
+	Port     int    `env:"TEST_PORT" default:"5001" validate:"port"`
+	Storage  string `env:"TEST_STORAGE" default:"mysql" validate:"oneof=mysql memory"`
+	Secret   Secret `env:"TEST_SECRET" required:"true"`
+	Services struct {
+		User string `env:"TEST_USER_URL" default:"http://localhost:5001/" validate:"url"`
+	}
+}
+
+// Run the test in an empty directory, with the given .env files and no test variables set
+func inDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	for _, name := range []string{"APP_ENV", "TEST_PORT", "TEST_STORAGE", "TEST_SECRET", "TEST_SECRET_FILE", "TEST_USER_URL"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	inDir(t, map[string]string{
+		".env":      "TEST_SECRET=from-the-env-file\nTEST_STORAGE=memory\n",
+		".env.test": "TEST_PORT=6000\n",
+	})
+	t.Setenv("APP_ENV", Test)
+	t.Setenv("TEST_STORAGE", "mysql")
+
+	var cfg testSettings
+	profile, err := Load(&cfg)
+	if err != nil || profile != Test {
+		t.Fatalf("Load = %q, %v", profile, err)
+	}
+	if cfg.Port != 6000 || cfg.Storage != "mysql" || cfg.Secret.Value() != "from-the-env-file" || cfg.Services.User != "http://localhost:5001" {
+		t.Fatalf("loaded %+v", cfg)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	inDir(t, nil)
+	t.Setenv("TEST_PORT", "70000")
+	t.Setenv("TEST_STORAGE", "postgres")
+
+	var cfg testSettings
+	_, err := Load(&cfg)
+	var configErr *Error
+	if !errors.As(err, &configErr) {
+		t.Fatalf("err = %v", err)
+	}
+	if len(configErr.Missing) != 1 || configErr.Missing[0] != "TEST_SECRET" || len(configErr.Invalid) != 2 {
+		t.Fatalf("missing %v, invalid %v", configErr.Missing, configErr.Invalid)
+	}
+}
+
+func TestProductionRefusesDevelopmentSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		profile string
+		wantErr bool
+	}{
+		{"development .env secret", "befrienders-dev-secret-change-me", Production, true},
+		{"well-known default", "password", Production, true},
+		{"well-known default, any case", "ChangeMe", Production, true},
+		{"too short", "k3Qz9pLm", Production, true},
+		{"strong secret", "k3Qz9pLm2vXw8rTy", Production, false},
+		{"development secret outside production", "befrienders-dev-secret-change-me", Development, false},
+		{"short secret outside production", "root", Test, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inDir(t, nil)
+			t.Setenv("APP_ENV", tt.profile)
+			t.Setenv("TEST_SECRET", tt.secret)
+
+			var cfg testSettings
+			_, err := Load(&cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), tt.secret) {
+				t.Fatalf("error shows the secret: %v", err)
+			}
+		})
+	}
+}
+
+func TestSecretFromFile(t *testing.T) {
+	dir := inDir(t, nil)
+	path := filepath.Join(dir, "secret")
+	os.WriteFile(path, []byte("k3Qz9pLm2vXw8rTy\n"), 0o600)
+	t.Setenv("APP_ENV", Production)
+	t.Setenv("TEST_SECRET_FILE", path)
+
+	var cfg testSettings
+	if _, err := Load(&cfg); err != nil || cfg.Secret.Value() != "k3Qz9pLm2vXw8rTy" {
+		t.Fatalf("secret %q, %v", cfg.Secret.Value(), err)
+	}
+}
+
+func TestSecretIsRedacted(t *testing.T) {
+	const value = "k3Qz9pLm2vXw8rTy"
+	cfg := testSettings{Port: 5001, Secret: value}
+	marshalled, _ := json.Marshal(cfg)
+
+	for name, shown := range map[string]string{
+		"Describe": Describe(&cfg),
+		"%v":       fmt.Sprintf("%v", cfg),
+		"%+v":      fmt.Sprintf("%+v", cfg),
+		"%#v":      fmt.Sprintf("%#v", cfg),
+		"%s":       fmt.Sprintf("%s", cfg.Secret),
+		"JSON":     string(marshalled),
+	} {
+		if strings.Contains(shown, value) || !strings.Contains(shown, redacted) {
+			t.Errorf("%s shows %s", name, shown)
+		}
+	}
+	if cfg.Secret.Value() != value {
+		t.Fatalf("Value = %q", cfg.Secret.Value())
+	}
+	if Secret("").String() != "" {
+		t.Fatal("an unset secret is shown as redacted")
+	}
+}
@@ -0,0 +1,58 @@
+// Package consent asks the User service what a senior has agreed to share.
+package consent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Purposes a senior can consent to, as recorded by the User service
+const (
+	ShareDoctor    = "share_with_doctor"    // Results emailed and alerted to their doctors
+	ShareCaregiver = "share_with_caregiver" // Results emailed to their approved caregivers
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Granted asks the User service at userServiceURL whether the senior currently consents to
+// the purpose, forwarding the caller's Authorization header. Anything short of a clear yes,
+// including a failed lookup, counts as no.
+func Granted(userServiceURL string, userID int, purpose string, authHeader string) bool {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/consents/%d", userServiceURL, userID), nil)
+	if err != nil {
+		log.Println("Failed to create consent request:", err)
+		return false
+	}
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Println("Failed to check consent:", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Println("User service returned", resp.Status, "for consent check")
+		return false
+	}
+
+	var consents []struct {
+		Purpose string `json:"purpose"`
+		Granted bool   `json:"granted"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&consents); err != nil {
+		log.Println("Failed to decode consents:", err)
+		return false
+	}
+
+	for _, consent := range consents {
+		if consent.Purpose == purpose {
+			return consent.Granted
+		}
+	}
+	return false
+}
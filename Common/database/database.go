@@ -0,0 +1,52 @@
+// Package database opens the MySQL connection used by a service's handlers.
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"common/config"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// Connect opens the connection pool and checks the database can be reached. The pool is
+// meant to be opened once and shared by every request. DATE and TIMESTAMP columns scan into
+// time.Time.
+func Connect(settings config.Database) (*sql.DB, error) {
+	db, err := sql.Open("mysql", settings.DSN()+"?parseTime=true")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(settings.MaxOpenConns)
+	db.SetMaxIdleConns(settings.MaxIdleConns)
+	// Recycle connections before MySQL or anything in between drops them for being idle
+	db.SetConnMaxLifetime(settings.ConnMaxLifetime)
+	return db, db.Ping()
+}
+
+// Create creates the database named in settings unless it already exists
+func Create(settings config.Database) error {
+	name := settings.Name
+	settings.Name = ""
+	db, err := sql.Open("mysql", settings.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("CREATE DATABASE IF NOT EXISTS `" + strings.ReplaceAll(name, "`", "``") + "`")
+	return err
+}
+
+// Drop deletes the database named in settings if it exists
+func Drop(settings config.Database) error {
+	name := settings.Name
+	settings.Name = ""
+	db, err := sql.Open("mysql", settings.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("DROP DATABASE IF EXISTS `" + strings.ReplaceAll(name, "`", "``") + "`")
+	return err
+}
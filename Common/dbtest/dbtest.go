@@ -0,0 +1,48 @@
+// Package dbtest gives repository tests an empty, fully migrated MySQL database. The tests
+// using it are built with the mysql tag, and reach the server with the service's DB_*
+// settings:
+//
+//	go test -tags mysql ./...
+package dbtest
+
+import (
+	"database/sql"
+	"io"
+	"io/fs"
+	"testing"
+
+	"common/config"
+	"common/database"
+	"common/migrate"
+)
+
+// Open creates <DB_NAME>_test afresh with every migration in files applied, and drops it
+// again when the test ends
+func Open(t testing.TB, files fs.FS) *sql.DB {
+	t.Helper()
+	var settings struct {
+		DB config.Database
+	}
+	if _, err := config.Load(&settings); err != nil {
+		t.Fatalf("Loading database settings: %v", err)
+	}
+	settings.DB.Name += "_test"
+
+	if err := database.Drop(settings.DB); err != nil {
+		t.Fatalf("Dropping %s: %v", settings.DB.Name, err)
+	}
+	if err := migrate.Run(settings.DB, files, []string{"up"}, io.Discard); err != nil {
+		t.Fatalf("Migrating %s: %v", settings.DB.Name, err)
+	}
+	db, err := database.Connect(settings.DB)
+	if err != nil {
+		t.Fatalf("Connecting to %s: %v", settings.DB.Name, err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := database.Drop(settings.DB); err != nil {
+			t.Errorf("Dropping %s: %v", settings.DB.Name, err)
+		}
+	})
+	return db
+}
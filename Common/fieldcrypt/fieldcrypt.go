@@ -0,0 +1,313 @@
+// Package fieldcrypt encrypts personal and health data before it is stored. Every value gets
+// its own random data key, which is in turn encrypted ("wrapped") with a master key from the key
+// file. The key file stands in for a KMS, so moving to one only means changing wrapKey and unwrapKey.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Start of an encrypted value, followed by <master key ID>:<wrapped data key>:<ciphertext>
+const encryptedPrefix = "enc:v1:"
+
+// Master keys loaded from the key file
+type keyring struct {
+	current  string            // Key new values are wrapped with
+	keys     map[string][]byte // Every key still needed to read older values, by ID
+	indexKey []byte            // Key for blind indexes, kept apart from the encryption keys
+}
+
+var masterKeys *keyring
+
+// The key file. Keys are 32 bytes, base64 encoded.
+type keyFile struct {
+	Current  string            `json:"current"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key,omitempty"`
+}
+
+// LoadKeys loads the master keys from the contents of a key file:
+//
+//	{"current": "2025-01", "keys": {"2025-01": "..."}, "index_key": "..."}
+//
+// To rotate, add a new key, make it current and run the service once with -reencrypt.
+// The old key can be removed once that has finished.
+func LoadKeys(data []byte) error {
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid key file: %v", err)
+	}
+
+	k := &keyring{current: file.Current, keys: map[string][]byte{}}
+	for id, encoded := range file.Keys {
+		if id == "" || strings.Contains(id, ":") {
+			return fmt.Errorf("invalid key ID %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("key %q must be 32 bytes, base64 encoded", id)
+		}
+		k.keys[id] = key
+	}
+	if _, ok := k.keys[k.current]; !ok {
+		return fmt.Errorf("current key %q is not in the key file", k.current)
+	}
+
+	// Only needed by services that look values up by blind index
+	if file.IndexKey != "" {
+		var err error
+		k.indexKey, err = base64.StdEncoding.DecodeString(file.IndexKey)
+		if err != nil || len(k.indexKey) != 32 {
+			return fmt.Errorf("index_key must be 32 bytes, base64 encoded")
+		}
+	}
+
+	masterKeys = k
+	return nil
+}
+
+// GenerateKeyFile creates a key file with one random master key, made current under the
+// given ID, and a random index key
+func GenerateKeyFile(id string) ([]byte, error) {
+	if id == "" || strings.Contains(id, ":") {
+		return nil, fmt.Errorf("invalid key ID %q", id)
+	}
+	key, indexKey := make([]byte, 32), make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(indexKey); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(keyFile{
+		Current:  id,
+		Keys:     map[string]string{id: base64.StdEncoding.EncodeToString(key)},
+		IndexKey: base64.StdEncoding.EncodeToString(indexKey),
+	}, "", "    ")
+}
+
+// RotateKeyFile adds a random master key to a key file and makes it current under the given ID,
+// keeping the older keys so values stored under them can still be read. The index key is
+// replaced too, so blind indexes must be rebuilt before the file is used.
+func RotateKeyFile(data []byte, id string) ([]byte, error) {
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid key file: %v", err)
+	}
+	if _, ok := file.Keys[id]; ok {
+		return nil, fmt.Errorf("key %q is already in the key file", id)
+	}
+
+	generated, err := GenerateKeyFile(id)
+	if err != nil {
+		return nil, err
+	}
+	var fresh keyFile
+	if err := json.Unmarshal(generated, &fresh); err != nil {
+		return nil, err
+	}
+
+	if file.Keys == nil {
+		file.Keys = map[string]string{}
+	}
+	file.Current = id
+	file.Keys[id] = fresh.Keys[id]
+	file.IndexKey = fresh.IndexKey
+	return json.MarshalIndent(file, "", "    ")
+}
+
+// HasIndexKey reports whether the key file has a key for blind indexes
+func HasIndexKey() bool {
+	return masterKeys != nil && len(masterKeys.indexKey) > 0
+}
+
+// AES-256-GCM with a random nonce stored in front of the ciphertext
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+}
+
+// Wrap a data key with the current master key
+func (k *keyring) wrapKey(dataKey []byte) (string, []byte, error) {
+	wrapped, err := sealAESGCM(k.keys[k.current], dataKey, []byte(k.current))
+	return k.current, wrapped, err
+}
+
+// Unwrap a data key with the master key it was wrapped with
+func (k *keyring) unwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %q is not in the key file", keyID)
+	}
+	return openAESGCM(key, wrapped, []byte(keyID))
+}
+
+// Encrypt encrypts a value for a column, named as Table.Column. The column name is bound to the
+// ciphertext, so a value copied into another column will not decrypt. Empty values stay empty.
+func Encrypt(column, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	ciphertext, err := sealAESGCM(dataKey, []byte(value), []byte(column))
+	if err != nil {
+		return "", err
+	}
+	keyID, wrapped, err := masterKeys.wrapKey(dataKey)
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + keyID + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts a value read from a column. Values stored before encryption was introduced are
+// returned as they are until -reencrypt has been run.
+func Decrypt(column, stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		return stored, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(stored, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted value in %s", column)
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value in %s", column)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value in %s", column)
+	}
+
+	dataKey, err := masterKeys.unwrapKey(parts[0], wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openAESGCM(dataKey, ciphertext, []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt %s: %v", column, err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencryption reports whether a stored value is still in plaintext or wrapped with an older master key
+func NeedsReencryption(stored string) bool {
+	if stored == "" {
+		return false
+	}
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		return true
+	}
+	keyID, _, _ := strings.Cut(strings.TrimPrefix(stored, encryptedPrefix), ":")
+	return keyID != masterKeys.current
+}
+
+// BlindIndex is a keyed hash of a value, so rows can be found by an exact match without storing the value in
+// the clear. Case and surrounding spaces are ignored, as they were by the plaintext column.
+func BlindIndex(column, value string) string {
+	mac := hmac.New(sha256.New, masterKeys.indexKey)
+	mac.Write([]byte(column + ":" + strings.ToLower(strings.TrimSpace(value))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Column is an encrypted column, with the primary key used to write re-encrypted values back
+type Column struct {
+	Table, Key, Column string
+}
+
+// Re-encrypt every value in a column that is still in plaintext or under an older master key.
+// A row changed in the meantime is left alone, as it was written under the current key.
+func reencryptColumn(db *sql.DB, c Column) (int, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IS NOT NULL", c.Key, c.Column, c.Table, c.Column))
+	if err != nil {
+		return 0, err
+	}
+	stale := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var stored string
+		if err := rows.Scan(&id, &stored); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if NeedsReencryption(stored) {
+			stale[id] = stored
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	column := c.Table + "." + c.Column
+	update := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ? AND %s = ?", c.Table, c.Column, c.Key, c.Column)
+	for id, stored := range stale {
+		value, err := Decrypt(column, stored)
+		if err != nil {
+			return 0, fmt.Errorf("row %d: %v", id, err)
+		}
+		encrypted, err := Encrypt(column, value)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := db.Exec(update, encrypted, id, stored); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), nil
+}
+
+// ReencryptColumns re-encrypts every encrypted column under the current master key
+func ReencryptColumns(db *sql.DB, columns []Column) error {
+	for _, c := range columns {
+		count, err := reencryptColumn(db, c)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", c.Table, c.Column, err)
+		}
+		log.Printf("Re-encrypted %d values in %s.%s\n", count, c.Table, c.Column)
+	}
+	return nil
+}
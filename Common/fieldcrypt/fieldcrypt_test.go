@@ -0,0 +1,185 @@
+package fieldcrypt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Generate a key file and load it, returning its contents
+func loadNewKeys(t *testing.T, id string) []byte {
+	t.Helper()
+	data, err := GenerateKeyFile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadKeys(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	loadNewKeys(t, "test-1")
+	for _, value := range []string{"", "Tan Ah Kow", "1 Jalan Besar, #02-03", "血压 140/90", strings.Repeat("x", 10000)} {
+		stored, err := Encrypt("Users.address", value)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", value, err)
+		}
+		if value != "" && (!strings.HasPrefix(stored, encryptedPrefix+"test-1:") || strings.Contains(stored, value)) {
+			t.Fatalf("Encrypt(%q) stored %q", value, stored)
+		}
+		if got, err := Decrypt("Users.address", stored); err != nil || got != value {
+			t.Fatalf("Decrypt = %q, %v, want %q", got, err, value)
+		}
+		if NeedsReencryption(stored) {
+			t.Fatalf("%q needs re-encryption under the current key", stored)
+		}
+	}
+
+	// Each value has its own data key and nonce
+	a, _ := Encrypt("Users.address", "same")
+	b, _ := Encrypt("Users.address", "same")
+	if a == b {
+		t.Fatal("the same value encrypted twice gave the same ciphertext")
+	}
+}
+
+func TestDecryptRefused(t *testing.T) {
+	loadNewKeys(t, "test-1")
+	stored, err := Encrypt("Users.address", "Tan Ah Kow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(stored, encryptedPrefix), ":")
+	ciphertext, _ := base64.RawStdEncoding.DecodeString(parts[2])
+	ciphertext[len(ciphertext)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		column string
+		stored string
+	}{
+		{"another column", "Users.phone", stored},
+		{"unknown master key", "Users.address", encryptedPrefix + "test-2:" + parts[1] + ":" + parts[2]},
+		{"ciphertext changed", "Users.address", encryptedPrefix + "test-1:" + parts[1] + ":" + base64.RawStdEncoding.EncodeToString(ciphertext)},
+		{"wrapped key changed", "Users.address", encryptedPrefix + "test-1:" + parts[2] + ":" + parts[2]},
+		{"missing part", "Users.address", encryptedPrefix + "test-1:" + parts[1]},
+		{"not base64", "Users.address", encryptedPrefix + "test-1:!!:" + parts[2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Decrypt(tt.column, tt.stored); err == nil {
+				t.Fatalf("decrypted to %q", got)
+			}
+		})
+	}
+
+	// The same key ID with a different key
+	loadNewKeys(t, "test-1")
+	if got, err := Decrypt("Users.address", stored); err == nil {
+		t.Fatalf("decrypted with the wrong key to %q", got)
+	}
+}
+
+func TestPlaintextIsReturnedUntilReencrypted(t *testing.T) {
+	loadNewKeys(t, "test-1")
+	if got, err := Decrypt("Users.address", "1 Jalan Besar"); err != nil || got != "1 Jalan Besar" {
+		t.Fatalf("Decrypt = %q, %v", got, err)
+	}
+	if !NeedsReencryption("1 Jalan Besar") || NeedsReencryption("") {
+		t.Fatal("plaintext values must be re-encrypted, empty ones left alone")
+	}
+}
+
+func TestRotateKeyFile(t *testing.T) {
+	original := loadNewKeys(t, "test-1")
+	old, err := Encrypt("Users.address", "Tan Ah Kow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldIndex := BlindIndex("Users.email", "tan@example.com")
+
+	rotated, err := RotateKeyFile(original, "test-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadKeys(rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	// Older values still decrypt but are due for re-encryption under the new key
+	if got, err := Decrypt("Users.address", old); err != nil || got != "Tan Ah Kow" {
+		t.Fatalf("old value: %q, %v", got, err)
+	}
+	if !NeedsReencryption(old) {
+		t.Fatal("value under the old key does not need re-encryption")
+	}
+	stored, _ := Encrypt("Users.address", "Tan Ah Kow")
+	if !strings.HasPrefix(stored, encryptedPrefix+"test-2:") || NeedsReencryption(stored) {
+		t.Fatalf("new value stored as %q", stored)
+	}
+	if BlindIndex("Users.email", "tan@example.com") == oldIndex {
+		t.Fatal("index key was not replaced")
+	}
+
+	var file keyFile
+	json.Unmarshal(rotated, &file)
+	if file.Current != "test-2" || len(file.Keys) != 2 {
+		t.Fatalf("rotated key file %+v", file)
+	}
+	if _, err := RotateKeyFile(rotated, "test-1"); err == nil {
+		t.Fatal("rotated to a key ID already in the file")
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	data := loadNewKeys(t, "test-1")
+	index := BlindIndex("Users.email", "tan@example.com")
+
+	tests := []struct {
+		name   string
+		column string
+		value  string
+		same   bool
+	}{
+		{"same value", "Users.email", "tan@example.com", true},
+		{"case and spaces ignored", "Users.email", "  Tan@Example.COM ", true},
+		{"another value", "Users.email", "lim@example.com", false},
+		{"another column", "Caregivers.email", "tan@example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BlindIndex(tt.column, tt.value); (got == index) != tt.same {
+				t.Fatalf("BlindIndex(%q, %q) = %s, same as the original: %v", tt.column, tt.value, got, !tt.same)
+			}
+		})
+	}
+
+	// Stable across restarts with the same key file
+	if err := LoadKeys(data); err != nil {
+		t.Fatal(err)
+	}
+	if BlindIndex("Users.email", "tan@example.com") != index {
+		t.Fatal("index changed after reloading the same keys")
+	}
+}
+
+func TestLoadKeysRefused(t *testing.T) {
+	const key = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	for _, data := range []string{
+		`not json`,
+		`{"current": "a", "keys": {}}`,
+		`{"current": "a", "keys": {"a": "c2hvcnQ="}}`,
+		`{"current": "a:b", "keys": {"a:b": "` + key + `"}}`,
+		`{"current": "a", "keys": {"a": "` + key + `"}, "index_key": "c2hvcnQ="}`,
+	} {
+		if err := LoadKeys([]byte(data)); err == nil {
+			t.Errorf("LoadKeys(%s) accepted", data)
+		}
+	}
+	if err := LoadKeys([]byte(`{"current": "a", "keys": {"a": "` + key + `"}}`)); err != nil || HasIndexKey() {
+		t.Fatalf("key file without an index key: %v", err)
+	}
+}
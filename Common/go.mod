@@ -0,0 +1,13 @@
+module common
+
+go 1.23.2
+
+require (
+	github.com/go-sql-driver/mysql v1.8.1
+	github.com/gorilla/mux v1.8.1
+	github.com/joho/godotenv v1.5.1
+	github.com/rs/cors v1.11.1
+	golang.org/x/crypto v0.33.0
+)
+
+require filippo.io/edwards25519 v1.1.0 // indirect
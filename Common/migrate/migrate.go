@@ -0,0 +1,459 @@
+// Package migrate upgrades a service's schema with numbered migrations and records which ones
+// have run in a schema_version table, so the database never has to be dropped and recreated.
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"common/config"
+	"common/database"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Migration is one schema change, read from migrations/<version>_<name>.up.sql and the
+// matching .down.sql that undoes it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// State is whether a migration has been applied to the database
+type State struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Dirty     bool // Started but never finished, so the schema needs repairing by hand
+	Unknown   bool // Applied, but not part of this build
+}
+
+const usage = "usage: migrate up|down|status|seed|baseline"
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations directory of files, ordered by version
+func Load(files fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations/%s is not named <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		if version == 0 {
+			return nil, fmt.Errorf("migrations/%s: versions start at 1", entry.Name())
+		}
+		script, err := fs.ReadFile(files, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %04d_%s share a version", migration, version, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %s needs both an .up.sql and a .down.sql file", migration)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Status lists every migration of this build, and any the database has that this build lacks
+func Status(db *sql.DB, migrations []Migration) ([]State, error) {
+	rows, err := db.Query(`SELECT Version, Name, Dirty, AppliedAt FROM schema_version`)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1146 {
+		// No table yet, so nothing has been applied
+		rows, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int]State{}
+	if rows != nil {
+		defer rows.Close()
+		for rows.Next() {
+			var state State
+			if err := rows.Scan(&state.Version, &state.Name, &state.Dirty, &state.AppliedAt); err != nil {
+				return nil, err
+			}
+			state.Applied = true
+			applied[state.Version] = state
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	states := make([]State, 0, len(migrations))
+	for _, migration := range migrations {
+		state := applied[migration.Version]
+		state.Migration = migration
+		states = append(states, state)
+		delete(applied, migration.Version)
+	}
+	for _, state := range applied {
+		state.Unknown = true
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+// Refuse to touch a schema that a failed migration or a newer build has left behind
+func usable(states []State) error {
+	for _, state := range states {
+		if state.Dirty {
+			return fmt.Errorf("migration %s did not finish. Repair the schema by hand, then set its Dirty flag in schema_version to FALSE if it is now applied, or delete its row if it is not", state.Migration)
+		}
+		if state.Unknown {
+			return fmt.Errorf("the database has migration %s, which this build does not include. Run a build that has it", state.Migration)
+		}
+	}
+	return nil
+}
+
+// Check returns an error unless the database has exactly the migrations of this build, so a
+// service never starts against a schema it was not written for.
+func Check(db *sql.DB, files fs.FS) error {
+	migrations, err := Load(files)
+	if err != nil {
+		return err
+	}
+	states, err := Status(db, migrations)
+	if err != nil {
+		return err
+	}
+	if err := usable(states); err != nil {
+		return err
+	}
+	pending := 0
+	for _, state := range states {
+		if !state.Applied {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migration(s) not applied yet, run `go run . migrate up`", pending)
+	}
+	return nil
+}
+
+func createTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		Version INT PRIMARY KEY,
+		Name VARCHAR(100) NOT NULL,
+		Dirty BOOLEAN NOT NULL DEFAULT TRUE,
+		AppliedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+// Up applies every migration not applied yet, oldest first
+func Up(db *sql.DB, migrations []Migration, out io.Writer) error {
+	if err := createTable(db); err != nil {
+		return err
+	}
+	states, err := Status(db, migrations)
+	if err != nil {
+		return err
+	}
+	if err := usable(states); err != nil {
+		return err
+	}
+
+	applied := 0
+	for _, state := range states {
+		if state.Applied {
+			continue
+		}
+		// MySQL commits every schema change at once, so the row stays dirty if a statement fails
+		if _, err := db.Exec(`INSERT INTO schema_version (Version, Name, Dirty) VALUES (?, ?, TRUE)`, state.Version, state.Name); err != nil {
+			return err
+		}
+		if err := run(db, state.Up); err != nil {
+			return fmt.Errorf("migration %s: %w", state.Migration, err)
+		}
+		if _, err := db.Exec(`UPDATE schema_version SET Dirty = FALSE, AppliedAt = CURRENT_TIMESTAMP WHERE Version = ?`, state.Version); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Applied %s\n", state.Migration)
+		applied++
+	}
+	if applied == 0 {
+		fmt.Fprintln(out, "Schema is up to date")
+	}
+	return nil
+}
+
+// Down undoes the newest applied migration
+func Down(db *sql.DB, migrations []Migration, out io.Writer) error {
+	states, err := Status(db, migrations)
+	if err != nil {
+		return err
+	}
+	if err := usable(states); err != nil {
+		return err
+	}
+
+	for i := len(states) - 1; i >= 0; i-- {
+		state := states[i]
+		if !state.Applied {
+			continue
+		}
+		if _, err := db.Exec(`UPDATE schema_version SET Dirty = TRUE WHERE Version = ?`, state.Version); err != nil {
+			return err
+		}
+		if err := run(db, state.Down); err != nil {
+			if refused(err) {
+				// Nothing was changed, so the migration is still applied
+				if _, err := db.Exec(`UPDATE schema_version SET Dirty = FALSE WHERE Version = ?`, state.Version); err != nil {
+					return err
+				}
+				return fmt.Errorf("migration %s cannot be undone: %w", state.Migration, err)
+			}
+			return fmt.Errorf("undoing migration %s: %w", state.Migration, err)
+		}
+		if _, err := db.Exec(`DELETE FROM schema_version WHERE Version = ?`, state.Version); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Undid %s\n", state.Migration)
+		return nil
+	}
+	fmt.Fprintln(out, "No migrations to undo")
+	return nil
+}
+
+// Whether a script stopped itself with SIGNAL, which a down migration does before changing
+// anything when undoing it would lose data
+func refused(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1644
+}
+
+// Baseline records the first migration as applied without running it, for a database created
+// by the old scripts that already has the initial schema.
+func Baseline(db *sql.DB, migrations []Migration, out io.Writer) error {
+	if len(migrations) == 0 {
+		return errors.New("there are no migrations")
+	}
+	if err := createTable(db); err != nil {
+		return err
+	}
+	states, err := Status(db, migrations)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		if state.Applied {
+			return errors.New("migrations have already been recorded, baseline is only for a database without any")
+		}
+	}
+	first := migrations[0]
+	if _, err := db.Exec(`INSERT INTO schema_version (Version, Name, Dirty) VALUES (?, ?, FALSE)`, first.Version, first.Name); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Recorded %s as applied\n", first)
+	return nil
+}
+
+// Seed runs the scripts in the seeds directory of files in name order. Seeds add test accounts
+// and sample records under fixed IDs with INSERT IGNORE, so running them again changes nothing.
+func Seed(db *sql.DB, files fs.FS, out io.Writer) error {
+	if err := Check(db, files); err != nil {
+		return err
+	}
+	entries, err := fs.ReadDir(files, "seeds")
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintln(out, "No seeds to run")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		script, err := fs.ReadFile(files, path.Join("seeds", entry.Name()))
+		if err != nil {
+			return err
+		}
+		if err := run(db, string(script)); err != nil {
+			return fmt.Errorf("seed %s: %w", entry.Name(), err)
+		}
+		fmt.Fprintf(out, "Seeded %s\n", entry.Name())
+	}
+	return nil
+}
+
+// Run carries out a `migrate` command line against the database in settings
+func Run(settings config.Database, files fs.FS, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+	migrations, err := Load(files)
+	if err != nil {
+		return err
+	}
+	// A fresh server has no database for the first migration to go into
+	if args[0] == "up" || args[0] == "baseline" {
+		if err := database.Create(settings); err != nil {
+			return err
+		}
+	}
+	db, err := database.Connect(settings)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		return Up(db, migrations, out)
+	case "down":
+		return Down(db, migrations, out)
+	case "status":
+		states, err := Status(db, migrations)
+		if err != nil {
+			return err
+		}
+		printStatus(out, states)
+		return nil
+	case "seed":
+		return Seed(db, files, out)
+	case "baseline":
+		return Baseline(db, migrations, out)
+	}
+	return errors.New(usage)
+}
+
+func printStatus(out io.Writer, states []State) {
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "MIGRATION\tSTATUS")
+	for _, state := range states {
+		status := "pending"
+		switch {
+		case state.Dirty:
+			status = "dirty, needs repairing by hand"
+		case state.Unknown:
+			status = "applied " + state.AppliedAt.Format(time.DateTime) + ", not in this build"
+		case state.Applied:
+			status = "applied " + state.AppliedAt.Format(time.DateTime)
+		}
+		fmt.Fprintf(table, "%s\t%s\n", state.Migration, status)
+	}
+	table.Flush()
+}
+
+// Split a script into statements at the semicolons outside quotes and comments, since the
+// driver runs one statement at a time. As in the mysql client, a DELIMITER line changes what
+// ends a statement, so stored programs with semicolons in their body can be created.
+func split(script string) []string {
+	var statements []string
+	var statement strings.Builder
+	flush := func() {
+		if text := strings.TrimSpace(statement.String()); text != "" {
+			statements = append(statements, text)
+		}
+		statement.Reset()
+	}
+
+	delimiter := ";"
+	var quote byte // Quote character of the string being read, or 0
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		lineStart := i == 0 || script[i-1] == '\n'
+		switch {
+		case quote != 0:
+			statement.WriteByte(c)
+			if c == '\\' && quote != '`' && i+1 < len(script) {
+				i++
+				statement.WriteByte(script[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case lineStart && len(script) > i+10 && strings.EqualFold(script[i:i+10], "DELIMITER "):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			flush()
+			if d := strings.TrimSpace(script[i+10 : i+end]); d != "" {
+				delimiter = d
+			}
+			i += end
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			statement.WriteByte(c)
+		case c == '#' || strings.HasPrefix(script[i:], "-- ") || strings.HasPrefix(script[i:], "--\n"):
+			// Comment to the end of the line
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end - 1
+			}
+		case strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+		case strings.HasPrefix(script[i:], delimiter):
+			flush()
+			i += len(delimiter) - 1
+		default:
+			statement.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
+
+// Run each statement of a script in turn
+func run(db *sql.DB, script string) error {
+	for i, statement := range split(script) {
+		if _, err := db.Exec(statement); err != nil {
+			return fmt.Errorf("statement %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package migrate
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"statements", "CREATE TABLE A (ID INT);\nDROP TABLE B;", []string{"CREATE TABLE A (ID INT)", "DROP TABLE B"}},
+		{"semicolons in strings and comments", "INSERT INTO A VALUES ('a;b', \"c;d\"); -- e;f\n/* g; */ DROP TABLE B; # h;",
+			[]string{"INSERT INTO A VALUES ('a;b', \"c;d\")", "DROP TABLE B"}},
+		{"escaped quotes", `INSERT INTO A VALUES ('it\'s;'); DROP TABLE B`, []string{`INSERT INTO A VALUES ('it\'s;')`, "DROP TABLE B"}},
+		{"delimiter", "DELIMITER //\nCREATE PROCEDURE P()\nBEGIN\n    SELECT 1;\n    SELECT 2;\nEND//\nDELIMITER ;\nCALL P();\nDROP PROCEDURE P;",
+			[]string{"CREATE PROCEDURE P()\nBEGIN\n    SELECT 1;\n    SELECT 2;\nEND", "CALL P()", "DROP PROCEDURE P"}},
+		{"delimiter word inside a statement", "UPDATE A SET Note = 'x'\nDELIMITERS;", []string{"UPDATE A SET Note = 'x'\nDELIMITERS"}},
+		{"empty delimiter is ignored", "DELIMITER \nSELECT 1;", []string{"SELECT 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := split(tt.script); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Package rbac holds the roles carried in session tokens and the permissions each one is granted,
+// so a role means the same thing in every service.
+package rbac
+
+import "slices"
+
+// Roles carried in session tokens
+const (
+	RoleSenior      = "senior"
+	RoleCaregiver   = "caregiver"
+	RoleDoctor      = "doctor"
+	RoleAdmin       = "admin"      // Clinic admin
+	RoleSystemAdmin = "sysadmin"   // System admin, also manages clinic admins
+	RoleCompliance  = "compliance" // Compliance officer, reviews the audit log
+	RoleService     = "service"    // A service calling another on its own behalf, never a person
+)
+
+// Permissions checked in front of every protected route. Ownership of individual
+// records (e.g. a senior's own results) is still checked by auth.AuthorizeUser.
+const (
+	PermAccountSelf       = "account:self" // Own password, sessions and two-factor settings
+	PermProfileRead       = "profile:read"
+	PermProfileWrite      = "profile:write"
+	PermCaregiverApprove  = "caregiver:approve"
+	PermCaregiverRequest  = "caregiver:request"
+	PermCaregiverUnlink   = "caregiver:unlink"
+	PermQuestionnaireRead = "questionnaire:read"
+	PermAssessmentRead    = "assessment:read"
+	PermAssessmentSubmit  = "assessment:submit"
+	PermRiskAnalyze       = "risk:analyze"
+	PermRiskClinical      = "risk:clinical" // Score and store a clinician-administered instrument for a patient
+	PermVisionRead        = "vision:read"
+	PermVisionSubmit      = "vision:submit"
+	PermNotificationRead  = "notification:read"
+	PermNotificationSend  = "notification:send"
+	PermAlertRead         = "alert:read"
+	PermAlertRaise        = "alert:raise"
+	PermAlertResolve      = "alert:resolve"
+	PermDoctorRead        = "doctor:read"
+	PermCareTeamRead      = "careteam:read"
+	PermAssignmentRead    = "assignment:read"
+	PermRosterRead        = "roster:read"
+	PermAccountUnlock     = "account:unlock"
+	PermDoctorManage      = "doctor:manage"
+	PermAssignmentManage  = "assignment:manage"
+	PermSettingsManage    = "settings:manage"
+	PermAdminManage       = "admin:manage"
+	PermDataExport        = "data:export" // Personal data export (right of access)
+	PermDataErase         = "data:erase"  // Personal data erasure
+	PermConsentRead       = "consent:read"
+	PermConsentManage     = "consent:manage"
+	PermAuditRead         = "audit:read"
+)
+
+// Permissions shared by clinic and system admins
+var adminPermissions = []string{
+	PermAccountSelf, PermDoctorRead, PermAccountUnlock, PermDoctorManage, PermAssignmentManage, PermSettingsManage,
+}
+
+// Permissions granted to each role
+var rolePermissions = map[string][]string{
+	RoleSenior: {
+		PermAccountSelf, PermProfileRead, PermProfileWrite, PermCaregiverApprove, PermCaregiverUnlink,
+		PermQuestionnaireRead, PermAssessmentRead, PermAssessmentSubmit, PermRiskAnalyze,
+		PermVisionRead, PermVisionSubmit, PermNotificationRead, PermNotificationSend, PermAlertRaise, PermCareTeamRead,
+		PermDataExport, PermDataErase, PermConsentRead, PermConsentManage,
+	},
+	RoleCaregiver: {
+		PermAccountSelf, PermProfileRead, PermProfileWrite, PermCaregiverRequest, PermCaregiverUnlink,
+		PermQuestionnaireRead, PermAssessmentRead, PermVisionRead, PermNotificationRead, PermCareTeamRead,
+		PermDataExport, PermDataErase, PermConsentRead,
+	},
+	RoleDoctor: {
+		PermAccountSelf, PermDoctorRead, PermProfileRead, PermQuestionnaireRead, PermAssessmentRead, PermRiskClinical, PermVisionRead,
+		PermNotificationRead, PermNotificationSend, PermAlertRead, PermAlertRaise, PermAlertResolve,
+		PermCareTeamRead, PermAssignmentRead, PermRosterRead, PermConsentRead,
+	},
+	RoleAdmin:       adminPermissions,
+	RoleSystemAdmin: append(slices.Clone(adminPermissions), PermAdminManage, PermDataExport, PermDataErase),
+	RoleCompliance:  {PermAccountSelf, PermAuditRead},
+	RoleService:     {PermQuestionnaireRead},
+}
+
+// HasPermission reports whether the role is granted the permission
+func HasPermission(role, permission string) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
@@ -0,0 +1,76 @@
+package rbac
+
+import "testing"
+
+// Every role against every permission, so a change to the matrix has to be made here too
+func TestPermissionMatrix(t *testing.T) {
+	roles := []string{RoleSenior, RoleCaregiver, RoleDoctor, RoleAdmin, RoleSystemAdmin, RoleCompliance, RoleService}
+	matrix := []struct {
+		permission string
+		granted    []string
+	}{
+		{PermAccountSelf, []string{RoleSenior, RoleCaregiver, RoleDoctor, RoleAdmin, RoleSystemAdmin, RoleCompliance}},
+		{PermProfileRead, []string{RoleSenior, RoleCaregiver, RoleDoctor}},
+		{PermProfileWrite, []string{RoleSenior, RoleCaregiver}},
+		{PermCaregiverApprove, []string{RoleSenior}},
+		{PermCaregiverRequest, []string{RoleCaregiver}},
+		{PermCaregiverUnlink, []string{RoleSenior, RoleCaregiver}},
+		{PermQuestionnaireRead, []string{RoleSenior, RoleCaregiver, RoleDoctor, RoleService}},
+		{PermAssessmentRead, []string{RoleSenior, RoleCaregiver, RoleDoctor}},
+		{PermAssessmentSubmit, []string{RoleSenior}},
+		{PermRiskAnalyze, []string{RoleSenior}},
+		{PermRiskClinical, []string{RoleDoctor}},
+		{PermVisionRead, []string{RoleSenior, RoleCaregiver, RoleDoctor}},
+		{PermVisionSubmit, []string{RoleSenior}},
+		{PermNotificationRead, []string{RoleSenior, RoleCaregiver, RoleDoctor}},
+		{PermNotificationSend, []string{RoleSenior, RoleDoctor}},
+		{PermAlertRead, []string{RoleDoctor}},
+		{PermAlertRaise, []string{RoleSenior, RoleDoctor}},
+		{PermAlertResolve, []string{RoleDoctor}},
+		{PermDoctorRead, []string{RoleDoctor, RoleAdmin, RoleSystemAdmin}},
+		{PermCareTeamRead, []string{RoleSenior, RoleCaregiver, RoleDoctor}},
+		{PermAssignmentRead, []string{RoleDoctor}},
+		{PermRosterRead, []string{RoleDoctor}},
+		{PermAccountUnlock, []string{RoleAdmin, RoleSystemAdmin}},
+		{PermDoctorManage, []string{RoleAdmin, RoleSystemAdmin}},
+		{PermAssignmentManage, []string{RoleAdmin, RoleSystemAdmin}},
+		{PermSettingsManage, []string{RoleAdmin, RoleSystemAdmin}},
+		{PermAdminManage, []string{RoleSystemAdmin}},
+		{PermDataExport, []string{RoleSenior, RoleCaregiver, RoleSystemAdmin}},
+		{PermDataErase, []string{RoleSenior, RoleCaregiver, RoleSystemAdmin}},
+		{PermConsentRead, []string{RoleSenior, RoleCaregiver, RoleDoctor}},
+		{PermConsentManage, []string{RoleSenior}},
+		{PermAuditRead, []string{RoleCompliance}},
+	}
+
+	listed := map[string]int{}
+	for _, row := range matrix {
+		for _, role := range roles {
+			want := false
+			for _, granted := range row.granted {
+				want = want || granted == role
+			}
+			if got := HasPermission(role, row.permission); got != want {
+				t.Errorf("HasPermission(%q, %q) = %v, want %v", role, row.permission, got, want)
+			}
+			if want {
+				listed[role]++
+			}
+		}
+	}
+
+	// A permission granted to a role but missing from the matrix above
+	for _, role := range roles {
+		if len(rolePermissions[role]) != listed[role] {
+			t.Errorf("%s is granted %d permissions, the matrix lists %d", role, len(rolePermissions[role]), listed[role])
+		}
+	}
+}
+
+func TestUnknownRoleHasNoPermissions(t *testing.T) {
+	for _, role := range []string{"", "root", "Senior"} {
+		if HasPermission(role, PermAccountSelf) {
+			t.Errorf("role %q is granted %s", role, PermAccountSelf)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Package respond writes JSON responses and errors the same way in every service.
+package respond
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// JSON writes v as the JSON response body
+func JSON(w http.ResponseWriter, v interface{}) {
+	JSONStatus(w, http.StatusOK, v)
+}
+
+// JSONStatus writes v as the JSON response body with the given status
+func JSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("JSON encoding error:", err)
+	}
+}
+
+// Message writes {"message": message} with the given status, for callers that expect
+// JSON even when a request fails
+func Message(w http.ResponseWriter, status int, message string) {
+	JSONStatus(w, status, map[string]string{"message": message})
+}
+
+// Error is an error to report to the caller with its status and message
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf creates an Error with a formatted message
+func Errorf(status int, format string, args ...interface{}) *Error {
+	return &Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// Fail reports err to the caller. An Error is sent with its own status and message. Anything
+// else is logged and reported as an internal server error, so details never leak to the caller.
+func Fail(w http.ResponseWriter, err error) {
+	var e *Error
+	if errors.As(err, &e) {
+		http.Error(w, e.Message, e.Status)
+		return
+	}
+	log.Println("Internal error:", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
@@ -0,0 +1,60 @@
+// Package server starts a service's HTTP server the same way in every service.
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"common/middleware"
+
+	"github.com/rs/cors"
+)
+
+// How long requests in progress get to finish when the service is stopped
+const shutdownTimeout = 15 * time.Second
+
+// CORS lets the front end, served from another origin, call the given methods with a session token
+func CORS(handler http.Handler, methods ...string) http.Handler {
+	return cors.New(cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   methods,
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+	}).Handler(handler)
+}
+
+// Run serves handler on the port, behind the middleware every service shares, until the
+// process is interrupted or terminated. Requests in progress are then given time to finish.
+func Run(name string, port int, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           middleware.Recover(middleware.SecureHeaders(handler)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	log.Printf("%s service running on port %d", name, port)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %v, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
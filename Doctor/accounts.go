@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+
+	"common/account"
+	"common/auth"
+	"common/rbac"
+)
+
+// Doctor service accounts are doctors, clinic admins, system admins and compliance officers
+func isDoctorAccount(claims *auth.Claims) bool {
+	return claims != nil && (claims.Role == rbac.RoleDoctor || claims.Role == rbac.RoleCompliance || isAdminRole(claims.Role))
+}
+
+// The shared login protection, session and password handling, run against this service's doctors
+func (s *Store) accounts() *account.Service {
+	return &account.Service{
+		Kind:          "doctor",
+		Accounts:      doctorAccounts{s.Doctors, s.Assignments},
+		Sessions:      s.Sessions,
+		LoginFailures: s.LoginFailures,
+		Owns:          isDoctorAccount,
+	}
+}
+
+// Doctors as the shared handlers see them
+type doctorAccounts struct {
+	DoctorRepository
+	assignments AssignmentRepository
+}
+
+// Deactivated doctors cannot refresh their sessions
+func (d doctorAccounts) Account(doctorID int) (account.Account, error) {
+	doctor, err := d.Get(doctorID)
+	if err == errDoctorNotFound {
+		return account.Account{}, account.ErrAccountNotFound
+	} else if err != nil {
+		return account.Account{}, err
+	}
+	return account.Account{ID: doctor.DoctorID, Role: doctor.Role, PasswordHash: doctor.PasswordHash, Active: doctor.Status == statusActive}, nil
+}
+
+// Doctor tokens list the patients they are assigned today, whose records they may read
+func (d doctorAccounts) TokenSeniors(doctorID int, role string) ([]int, error) {
+	if role != rbac.RoleDoctor {
+		return nil, nil
+	}
+	assignments, err := d.assignments.List(AssignmentFilter{DoctorID: doctorID, Active: true})
+	if err != nil {
+		return nil, err
+	}
+	patients := []int{}
+	for _, a := range assignments {
+		if !slices.Contains(patients, a.UserID) {
+			patients = append(patients, a.UserID)
+		}
+	}
+	return patients, nil
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/account"
+	"common/audit"
+	"common/auth"
+	"common/rbac"
+	"common/respond"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Doctor account states
+const (
+	statusInvited     = "Invited"     // Created by an admin, waiting for the doctor to set a password
+	statusActive      = "Active"      // May log in
+	statusDeactivated = "Deactivated" // Left the clinic, login and refresh are refused
+)
+
+// How long an emailed invitation link stays valid
+const invitationTTL = 7 * 24 * time.Hour
+
+var emailPattern = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+// Validate the fields an admin sets on a doctor account
+func validateDoctorInput(d Doctor) map[string]string {
+	errors := make(map[string]string)
+
+	if strings.TrimSpace(d.Name) == "" {
+		errors["name"] = "Name is required"
+	}
+
+	if d.Email == "" {
+		errors["email"] = "Email is required"
+	} else if !emailPattern.MatchString(d.Email) {
+		errors["email"] = "Invalid email format"
+	}
+
+	if len(d.Specialty) > 100 {
+		errors["specialty"] = "Specialty must be at most 100 characters"
+	}
+	if len(d.Clinic) > 255 {
+		errors["clinic"] = "Clinic must be at most 255 characters"
+	}
+	if len(d.LicenseNumber) > 50 {
+		errors["license_number"] = "License number must be at most 50 characters"
+	}
+
+	if d.Role != "" && d.Role != rbac.RoleDoctor && d.Role != rbac.RoleCompliance && !isAdminRole(d.Role) {
+		errors["role"] = "Role must be doctor, admin, sysadmin or compliance"
+	}
+
+	// Accounts created with a password skip the invitation
+	if d.Password != "" {
+		if msg := account.ValidatePassword(d.Password); msg != "" {
+			errors["password"] = msg
+		}
+	}
+
+	return errors
+}
+
+// Add errors for an email or license number already used by another doctor
+func checkDoctorUnique(doctors DoctorRepository, d Doctor, errors map[string]string) error {
+	inUse, err := doctors.EmailInUse(d.Email, d.DoctorID)
+	if err != nil {
+		return err
+	} else if inUse {
+		errors["email"] = "Email address already in use"
+	}
+
+	if d.LicenseNumber == "" {
+		return nil
+	}
+	inUse, err = doctors.LicenseInUse(d.LicenseNumber, d.DoctorID)
+	if err != nil {
+		return err
+	} else if inUse {
+		errors["license_number"] = "License number already in use"
+	}
+	return nil
+}
+
+// Ask the Email service to send the invitation link to the doctor
+func sendInvitationEmail(email, name, token string) {
+	requestBody, _ := json.Marshal(map[string]string{
+		"email": email,
+		"name":  name,
+		"token": token,
+	})
+
+	resp, err := http.Post(cfg.Services.Email+"/sendDoctorInvitation", "application/json", bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Println("Error sending invitation to email microservice:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	log.Println("Invitation email requested for doctor", email)
+}
+
+// Create a single-use invitation link for the doctor and email it, replacing any earlier link
+func createInvitation(invitations InvitationRepository, doctorID int, email, name string) error {
+	token, err := account.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	if err := invitations.Create(doctorID, account.HashToken(token), time.Now().Add(invitationTTL)); err != nil {
+		return err
+	}
+
+	go sendInvitationEmail(email, name, token)
+	return nil
+}
+
+// Parse the doctor_id path variable
+func doctorIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	doctorID, err := strconv.Atoi(mux.Vars(r)["doctor_id"])
+	if err != nil || doctorID <= 0 {
+		http.Error(w, "Invalid Doctor ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return doctorID, true
+}
+
+// Clinic and system admins both count as admins
+func isAdminRole(role string) bool {
+	return role == rbac.RoleAdmin || role == rbac.RoleSystemAdmin
+}
+
+// Clinic admins manage doctors. Only system admins may grant, change or remove the admin
+// and compliance roles, so a clinic admin cannot review or appoint their own auditors.
+func canManageRoles(w http.ResponseWriter, r *http.Request, roles ...string) bool {
+	if rbac.HasPermission(auth.ClaimsFromRequest(r).Role, rbac.PermAdminManage) {
+		return true
+	}
+	for _, role := range roles {
+		if isAdminRole(role) || role == rbac.RoleCompliance {
+			http.Error(w, "Only system admins can manage admin and compliance accounts", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// Look up the doctor's current role and check the caller may manage it
+func canManageDoctor(w http.ResponseWriter, r *http.Request, doctors DoctorRepository, doctorID int) bool {
+	doctor, err := doctors.Get(doctorID)
+	if err == errDoctorNotFound {
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+		return false
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return canManageRoles(w, r, doctor.Role)
+}
+
+// List every doctor account, optionally filtered by status
+func listDoctorsHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != statusInvited && status != statusActive && status != statusDeactivated {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	doctors, err := store.Doctors.List(status)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !store.Audit.Access(w, r, "doctor_profile", "", 0) {
+		return
+	}
+
+	respond.JSON(w, doctors)
+}
+
+// Create a doctor account. Without a password the doctor is emailed an invitation to set one.
+func createDoctorHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	var d Doctor
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	d.DoctorID = 0
+	d.Email = strings.TrimSpace(d.Email)
+	d.LicenseNumber = strings.TrimSpace(d.LicenseNumber)
+	if d.Role == "" {
+		d.Role = rbac.RoleDoctor
+	}
+	if !canManageRoles(w, r, d.Role) {
+		return
+	}
+
+	validationErrors := validateDoctorInput(d)
+	if err := checkDoctorUnique(store.Doctors, d, validationErrors); err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(validationErrors) > 0 {
+		respond.JSONStatus(w, http.StatusBadRequest, validationErrors)
+		return
+	}
+
+	// Invited accounts have no usable password until the invitation is accepted
+	passwordHash := []byte{}
+	d.Status = statusInvited
+	if d.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(d.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Println("Password hashing error:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		passwordHash = hashedPassword
+		d.Status = statusActive
+	}
+
+	d.Password = ""
+	if err := store.Doctors.Create(&d, passwordHash); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	store.Audit.Change(r, audit.Create, "doctor_profile", strconv.Itoa(d.DoctorID), 0)
+
+	if d.Status == statusInvited {
+		if err := createInvitation(store.Invitations, d.DoctorID, d.Email, d.Name); err != nil {
+			log.Println("Invitation error:", err)
+			http.Error(w, "Doctor created but the invitation could not be sent", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Printf("Doctor %d created by admin %d\n", d.DoctorID, auth.ClaimsFromRequest(r).Subject)
+	respond.JSONStatus(w, http.StatusCreated, d)
+}
+
+// Update a doctor's profile and role
+func updateDoctorHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	doctorID, ok := doctorIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var d Doctor
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	d.DoctorID = doctorID
+	d.Email = strings.TrimSpace(d.Email)
+	d.LicenseNumber = strings.TrimSpace(d.LicenseNumber)
+	d.Password = "" // Passwords are only changed by their owner
+
+	current, err := store.Doctors.Get(doctorID)
+	if err == errDoctorNotFound {
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	currentRole := current.Role
+	if d.Role == "" {
+		d.Role = currentRole
+	}
+	if !canManageRoles(w, r, currentRole, d.Role) {
+		return
+	}
+
+	// Admins cannot change their own role and lock themselves out
+	if doctorID == auth.ClaimsFromRequest(r).Subject && d.Role != currentRole {
+		http.Error(w, "You cannot change your own role", http.StatusBadRequest)
+		return
+	}
+
+	validationErrors := validateDoctorInput(d)
+	if err := checkDoctorUnique(store.Doctors, d, validationErrors); err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(validationErrors) > 0 {
+		respond.JSONStatus(w, http.StatusBadRequest, validationErrors)
+		return
+	}
+
+	if err := store.Doctors.Update(d); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	store.Audit.Change(r, audit.Update, "doctor_profile", strconv.Itoa(doctorID), 0)
+
+	// A role change only takes effect in new sessions, so end the current ones
+	if d.Role != currentRole {
+		if err := store.Sessions.RevokeAll(doctorID); err != nil {
+			log.Println("Database update error:", err)
+		}
+	}
+
+	respond.JSON(w, map[string]string{"message": "Doctor updated successfully"})
+}
+
+// Send a new invitation link to a doctor who has not set a password yet
+func resendInvitationHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	doctorID, ok := doctorIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !canManageDoctor(w, r, store.Doctors, doctorID) {
+		return
+	}
+
+	doctor, err := store.Doctors.Get(doctorID)
+	if err == errDoctorNotFound {
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if doctor.Status != statusInvited {
+		http.Error(w, "Doctor has already accepted the invitation", http.StatusConflict)
+		return
+	}
+
+	if err := createInvitation(store.Invitations, doctorID, doctor.Email, doctor.Name); err != nil {
+		log.Println("Invitation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Invitation sent successfully"})
+}
+
+// Deactivate a doctor's account and end all of their sessions
+func deactivateDoctorHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	doctorID, ok := doctorIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if doctorID == auth.ClaimsFromRequest(r).Subject {
+		http.Error(w, "You cannot deactivate your own account", http.StatusBadRequest)
+		return
+	}
+	if !canManageDoctor(w, r, store.Doctors, doctorID) {
+		return
+	}
+
+	deactivated, err := store.Doctors.Deactivate(doctorID)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deactivated {
+		http.Error(w, "Doctor not found or already deactivated", http.StatusNotFound)
+		return
+	}
+	store.Audit.Change(r, audit.Update, "doctor_profile", strconv.Itoa(doctorID), 0)
+
+	if err := store.Sessions.RevokeAll(doctorID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Doctor %d deactivated by admin %d\n", doctorID, auth.ClaimsFromRequest(r).Subject)
+	respond.JSON(w, map[string]string{"message": "Doctor deactivated successfully"})
+}
+
+// Reactivate a deactivated doctor. Doctors who never set a password go back to Invited.
+func reactivateDoctorHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	doctorID, ok := doctorIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if !canManageDoctor(w, r, store.Doctors, doctorID) {
+		return
+	}
+
+	reactivated, err := store.Doctors.Reactivate(doctorID)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !reactivated {
+		http.Error(w, "Doctor not found or not deactivated", http.StatusNotFound)
+		return
+	}
+	store.Audit.Change(r, audit.Update, "doctor_profile", strconv.Itoa(doctorID), 0)
+
+	log.Printf("Doctor %d reactivated by admin %d\n", doctorID, auth.ClaimsFromRequest(r).Subject)
+	respond.JSON(w, map[string]string{"message": "Doctor reactivated successfully"})
+}
+
+// Set the first password from an emailed invitation link and activate the account
+func acceptInvitationHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	var request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if request.Token == "" {
+		http.Error(w, "Invitation token is required", http.StatusBadRequest)
+		return
+	}
+
+	if msg := account.ValidatePassword(request.Password); msg != "" {
+		respond.JSONStatus(w, http.StatusBadRequest, map[string]string{"password": msg})
+		return
+	}
+
+	// Look up the invitation
+	invitation, err := store.Invitations.GetByToken(account.HashToken(request.Token))
+	if err == errTokenNotFound || (err == nil && (invitation.Used || time.Now().After(invitation.ExpiresAt))) {
+		http.Error(w, "Invalid or expired invitation link", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Password hashing error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Consume the token and set the password together. Deactivated accounts stay deactivated.
+	accepted, err := store.Invitations.Accept(invitation.ID, invitation.DoctorID, hashedPassword)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !accepted {
+		http.Error(w, "Invalid or expired invitation link", http.StatusBadRequest)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Your password has been set. You can now log in."})
+}
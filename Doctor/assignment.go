@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"common/audit"
+	"common/auth"
+	"common/respond"
+
+	"github.com/gorilla/mux"
+)
+
+// A doctor's role in a patient's care
+const (
+	assignmentPrimary   = "primary"
+	assignmentSecondary = "secondary"
+)
+
+// Layout of StartDate and EndDate in requests and responses
+const dateLayout = "2006-01-02"
+
+// Open-ended assignments are compared as if they ended on this date
+const openEndDate = "9999-12-31"
+
+// Link between a doctor and a senior (user) they care for
+type Assignment struct {
+	AssignmentID int    `json:"assignment_id"`
+	DoctorID     int    `json:"doctor_id"`
+	DoctorName   string `json:"doctor_name,omitempty"`
+	UserID       int    `json:"user_id"`
+	Role         string `json:"role"`
+	StartDate    string `json:"start_date"`
+	EndDate      string `json:"end_date,omitempty"`
+}
+
+// Validate an assignment and normalise its dates, returning field errors
+func validateAssignment(store *Store, a *Assignment) (map[string]string, error) {
+	errors := make(map[string]string)
+
+	if a.UserID <= 0 {
+		errors["user_id"] = "Invalid user ID"
+	}
+	if a.Role != assignmentPrimary && a.Role != assignmentSecondary {
+		errors["role"] = "Role must be primary or secondary"
+	}
+
+	if a.StartDate == "" {
+		a.StartDate = time.Now().Format(dateLayout)
+	}
+	start, err := time.Parse(dateLayout, a.StartDate)
+	if err != nil {
+		errors["start_date"] = "Start date must be in YYYY-MM-DD format"
+	}
+	if a.EndDate != "" {
+		end, err := time.Parse(dateLayout, a.EndDate)
+		if err != nil {
+			errors["end_date"] = "End date must be in YYYY-MM-DD format"
+		} else if end.Before(start) {
+			errors["end_date"] = "End date cannot be before the start date"
+		}
+	}
+
+	// Only active doctor accounts can take on patients
+	doctor, err := store.Doctors.Get(a.DoctorID)
+	if err == errDoctorNotFound || (err == nil && doctor.Status == statusDeactivated) {
+		errors["doctor_id"] = "Doctor not found or deactivated"
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(errors) > 0 {
+		return errors, nil
+	}
+
+	// Assignments overlapping this one's dates, excluding itself when updating
+	overlapping, err := store.Assignments.Overlapping(*a)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range overlapping {
+		if other.DoctorID == a.DoctorID {
+			errors["doctor_id"] = "Doctor is already assigned to this patient for these dates"
+		}
+		// A patient has at most one primary doctor at a time
+		if a.Role == assignmentPrimary && other.Role == assignmentPrimary {
+			errors["role"] = "Patient already has a primary doctor for these dates"
+		}
+	}
+
+	return errors, nil
+}
+
+// List assignments, optionally filtered by doctor, patient or whether they are in effect today
+func listAssignmentsHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	filter := AssignmentFilter{Active: r.URL.Query().Get("active") == "true"}
+	for _, param := range []struct {
+		Name  string
+		Value *int
+	}{{"doctor_id", &filter.DoctorID}, {"user_id", &filter.UserID}} {
+		value := r.URL.Query().Get(param.Name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			http.Error(w, "Invalid "+param.Name, http.StatusBadRequest)
+			return
+		}
+		*param.Value = id
+	}
+
+	assignments, err := store.Assignments.List(filter)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !store.Audit.Access(w, r, "assignment", "", filter.UserID) {
+		return
+	}
+
+	respond.JSON(w, assignments)
+}
+
+// Assign a doctor to a patient
+func createAssignmentHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	var a Assignment
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	a.AssignmentID = 0
+	validationErrors, err := validateAssignment(store, &a)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(validationErrors) > 0 {
+		respond.JSONStatus(w, http.StatusBadRequest, validationErrors)
+		return
+	}
+
+	if err := store.Assignments.Create(&a); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	store.Audit.Change(r, audit.Create, "assignment", strconv.Itoa(a.AssignmentID), a.UserID)
+
+	respond.JSONStatus(w, http.StatusCreated, a)
+}
+
+// Change an assignment's role or dates, e.g. setting an end date when care is handed over
+func updateAssignmentHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	assignmentID, err := strconv.Atoi(mux.Vars(r)["assignment_id"])
+	if err != nil || assignmentID <= 0 {
+		http.Error(w, "Invalid assignment ID", http.StatusBadRequest)
+		return
+	}
+
+	var request Assignment
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// The doctor and patient of an assignment never change, only its role and dates
+	stored, err := store.Assignments.Get(assignmentID)
+	if err == errAssignmentNotFound {
+		http.Error(w, "Assignment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	a := Assignment{AssignmentID: stored.AssignmentID, DoctorID: stored.DoctorID, UserID: stored.UserID}
+	a.Role, a.StartDate, a.EndDate = request.Role, request.StartDate, request.EndDate
+
+	validationErrors, err := validateAssignment(store, &a)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(validationErrors) > 0 {
+		respond.JSONStatus(w, http.StatusBadRequest, validationErrors)
+		return
+	}
+
+	if err := store.Assignments.Update(a); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	store.Audit.Change(r, audit.Update, "assignment", strconv.Itoa(assignmentID), a.UserID)
+
+	respond.JSON(w, a)
+}
+
+// Delete an assignment created by mistake. Ended assignments should be given an end date instead.
+func deleteAssignmentHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	assignmentID, err := strconv.Atoi(mux.Vars(r)["assignment_id"])
+	if err != nil || assignmentID <= 0 {
+		http.Error(w, "Invalid assignment ID", http.StatusBadRequest)
+		return
+	}
+
+	a, err := store.Assignments.Get(assignmentID)
+	if err == errAssignmentNotFound {
+		http.Error(w, "Assignment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	deleted, err := store.Assignments.Delete(assignmentID)
+	if err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Assignment not found", http.StatusNotFound)
+		return
+	}
+	store.Audit.Change(r, audit.Delete, "assignment", strconv.Itoa(assignmentID), a.UserID)
+
+	respond.JSON(w, map[string]string{"message": "Assignment deleted successfully"})
+}
+
+// List the logged in doctor's current assignments. Other services use this to scope data to a doctor's patients.
+func myAssignmentsHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+	if !isDoctorAccount(claims) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	assignments, err := store.Assignments.List(AssignmentFilter{DoctorID: claims.Subject, Active: true})
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !store.Audit.Access(w, r, "assignment", "", 0) {
+		return
+	}
+
+	respond.JSON(w, assignments)
+}
+
+// List the doctors currently caring for a patient, primary doctor first
+func careTeamHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Seniors may only see their own care team
+	if !auth.AuthorizeUser(w, r, userID) {
+		return
+	}
+
+	members, err := store.Assignments.CareTeam(userID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !store.Audit.Access(w, r, "care_team", "", userID) {
+		return
+	}
+
+	careTeam := []map[string]interface{}{}
+	for _, m := range members {
+		careTeam = append(careTeam, map[string]interface{}{
+			"doctor_id": m.DoctorID,
+			"name":      m.Name,
+			"email":     m.Email,
+			"specialty": m.Specialty,
+			"role":      m.Role,
+		})
+	}
+
+	respond.JSON(w, careTeam)
+}
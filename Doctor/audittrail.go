@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"common/audit"
+	"common/respond"
+)
+
+// Audit logs kept by the other services, searched together with this one's
+func auditLogServices() []struct{ Name, URL string } {
+	return []struct{ Name, URL string }{
+		{"user", cfg.Services.User + "/api/audit"},
+		{"self_assessment", cfg.Services.SelfAssessment + "/api/audit"},
+		{"vision_assessment", cfg.Services.VisionAssessment + "/audit"},
+		{"notifications", cfg.Services.Notifications + "/api/audit"},
+	}
+}
+
+// Search every service's audit log at once, e.g. for everyone who viewed one patient's records.
+// Takes the same filters as /api/audit and merges the results newest first. Services that
+// cannot be reached are listed so an incomplete trail is never mistaken for a complete one.
+func auditTrailHandler(w http.ResponseWriter, r *http.Request, auditLog *audit.Log) {
+	filter, err := audit.ParseFilter(r.URL.Query())
+	if err != nil {
+		respond.Fail(w, err)
+		return
+	}
+
+	if !auditLog.Access(w, r, "audit_log", "", 0) {
+		return
+	}
+
+	entries, err := auditLog.Search(filter)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	unavailable := []string{}
+	for _, service := range auditLogServices() {
+		var reply struct {
+			Entries []audit.Entry `json:"entries"`
+		}
+		status, err := callService("GET", service.URL+"?"+r.URL.RawQuery, nil, r.Header.Get("Authorization"), &reply)
+		if err == nil && status != http.StatusOK {
+			err = fmt.Errorf("returned status %d", status)
+		}
+		if err != nil {
+			log.Printf("Audit log of %s service unavailable: %v\n", service.Name, err)
+			unavailable = append(unavailable, service.Name)
+			continue
+		}
+		entries = append(entries, reply.Entries...)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	if len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	respond.JSON(w, map[string]interface{}{
+		"entries":     entries,
+		"unavailable": unavailable,
+	})
+}
@@ -1,91 +1,188 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
-	"fmt"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
+
+	"common/account"
+	"common/auth"
+	"common/config"
+	"common/migrate"
+	"common/rbac"
+	"common/respond"
+	"common/server"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
-	"github.com/rs/cors"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Database connection function
-func connectDB(dbUser, dbPassword, dbHost, dbName string) (*sql.DB, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", dbUser, dbPassword, dbHost, dbName)
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
-	}
-	return db, db.Ping()
-}
-
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading .env file")
-	}
+	flag.Parse()
 
-	// Get database connection details from environment variables
-	dbUser, dbPassword, dbHost, dbName := os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_HOST"), os.Getenv("DB_NAME")
-	if dbUser == "" || dbPassword == "" || dbHost == "" || dbName == "" {
-		log.Fatal("Database credentials not fully set in environment variables")
+	// Load settings from the environment and .env files, listing everything missing at once
+	config.MustLoad(&cfg)
+
+	// `go run . migrate up|down|status|seed|baseline` manages the schema instead of serving
+	if flag.Arg(0) == "migrate" {
+		if err := migrate.Run(cfg.DB, schemaFiles, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
 	}
 
-	// Get local port from environment variables
-	//localPort := os.Getenv("LOCAL_PORT")
-	localPort := "5004"
-	if localPort == "" {
-		log.Fatal("LOCAL_PORT environment variable is not set")
+	auth.SetSecret(cfg.JWTSecret.Value())
+	if err := auth.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
 	}
 
-	// Connect to the database
-	db, err := connectDB(dbUser, dbPassword, dbHost, dbName)
+	// Connect to the database, or keep everything in memory with STORAGE=memory
+	store, err := openStore()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Failed to open storage: %v", err)
 	}
-	defer db.Close()
+	defer store.Close()
 
 	// Initialize the router
 	router := mux.NewRouter()
 
 	// API Routes
 	router.HandleFunc("/api/authenticate", func(w http.ResponseWriter, r *http.Request) {
-		authenticationHandler(w, r, db)
+		authenticationHandler(w, r, store)
+	}).Methods("POST")
+	router.HandleFunc("/api/authenticate/2fa", func(w http.ResponseWriter, r *http.Request) {
+		loginTwoFactorHandler(w, r, store)
+	}).Methods("POST")
+	router.HandleFunc("/api/authenticate/2fa/setup", func(w http.ResponseWriter, r *http.Request) {
+		loginTwoFactorSetupHandler(w, r, store)
+	}).Methods("POST")
+	router.HandleFunc("/api/acceptInvitation", func(w http.ResponseWriter, r *http.Request) {
+		acceptInvitationHandler(w, r, store)
+	}).Methods("POST")
+	router.HandleFunc("/api/refresh", func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().RefreshHandler(w, r)
 	}).Methods("POST")
-	router.HandleFunc("/api/getDoctorDetails", func(w http.ResponseWriter, r *http.Request) {
-		getDoctorDetailsHandler(w, r, db)
+	router.HandleFunc("/api/logout", func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().LogoutHandler(w, r)
 	}).Methods("POST")
 
-	// CORS Configuration
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT"},
-		AllowedHeaders:   []string{"Content-Type"},
-		AllowCredentials: true,
-	})
-	handler := c.Handler(router)
+	// Routes below require a valid session token
+	protected := router.NewRoute().Subrouter()
+	protected.Use(auth.Middleware)
+	protected.HandleFunc("/api/getDoctorDetails", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		getDoctorDetailsHandler(w, r, store)
+	}, rbac.PermDoctorRead)).Methods("POST")
+	protected.HandleFunc("/api/changePassword", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().ChangePasswordHandler(w, r)
+	}, rbac.PermAccountSelf)).Methods("PUT")
+	protected.HandleFunc("/api/admin/unlock/{doctor_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().UnlockHandler(w, r)
+	}, rbac.PermAccountUnlock)).Methods("POST")
+	protected.HandleFunc("/api/2fa/setup", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		setupTwoFactorHandler(w, r, store)
+	}, rbac.PermAccountSelf)).Methods("POST")
+	protected.HandleFunc("/api/2fa/confirm", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		confirmTwoFactorHandler(w, r, store)
+	}, rbac.PermAccountSelf)).Methods("POST")
+	protected.HandleFunc("/api/2fa/disable", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		disableTwoFactorHandler(w, r, store)
+	}, rbac.PermAccountSelf)).Methods("POST")
+	protected.HandleFunc("/api/2fa/recoveryCodes", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		regenerateRecoveryCodesHandler(w, r, store)
+	}, rbac.PermAccountSelf)).Methods("POST")
+	protected.HandleFunc("/api/admin/doctors", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		listDoctorsHandler(w, r, store)
+	}, rbac.PermDoctorManage)).Methods("GET")
+	protected.HandleFunc("/api/admin/doctors", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		createDoctorHandler(w, r, store)
+	}, rbac.PermDoctorManage)).Methods("POST")
+	protected.HandleFunc("/api/admin/doctors/{doctor_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		updateDoctorHandler(w, r, store)
+	}, rbac.PermDoctorManage)).Methods("PUT")
+	protected.HandleFunc("/api/admin/doctors/{doctor_id}/invite", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		resendInvitationHandler(w, r, store)
+	}, rbac.PermDoctorManage)).Methods("POST")
+	protected.HandleFunc("/api/admin/doctors/{doctor_id}/deactivate", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		deactivateDoctorHandler(w, r, store)
+	}, rbac.PermDoctorManage)).Methods("POST")
+	protected.HandleFunc("/api/admin/doctors/{doctor_id}/reactivate", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		reactivateDoctorHandler(w, r, store)
+	}, rbac.PermDoctorManage)).Methods("POST")
+	protected.HandleFunc("/api/admin/assignments", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		listAssignmentsHandler(w, r, store)
+	}, rbac.PermAssignmentManage)).Methods("GET")
+	protected.HandleFunc("/api/admin/assignments", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		createAssignmentHandler(w, r, store)
+	}, rbac.PermAssignmentManage)).Methods("POST")
+	protected.HandleFunc("/api/admin/assignments/{assignment_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		updateAssignmentHandler(w, r, store)
+	}, rbac.PermAssignmentManage)).Methods("PUT")
+	protected.HandleFunc("/api/admin/assignments/{assignment_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		deleteAssignmentHandler(w, r, store)
+	}, rbac.PermAssignmentManage)).Methods("DELETE")
+	protected.HandleFunc("/api/assignments", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		myAssignmentsHandler(w, r, store)
+	}, rbac.PermAssignmentRead)).Methods("GET")
+	protected.HandleFunc("/api/patients", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		rosterHandler(w, r, store)
+	}, rbac.PermRosterRead)).Methods("GET")
+	protected.HandleFunc("/api/careTeam/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		careTeamHandler(w, r, store)
+	}, rbac.PermCareTeamRead)).Methods("GET")
+	protected.HandleFunc("/api/audit", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.Audit.SearchHandler(w, r)
+	}, rbac.PermAuditRead)).Methods("GET")
+	protected.HandleFunc("/api/audit/verify", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.Audit.VerifyHandler(w, r)
+	}, rbac.PermAuditRead)).Methods("GET")
+	protected.HandleFunc("/api/auditTrail", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		auditTrailHandler(w, r, store.Audit)
+	}, rbac.PermAuditRead)).Methods("GET")
+	protected.HandleFunc("/api/personalData/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		exportPersonalDataHandler(w, r, store)
+	}, rbac.PermDataExport)).Methods("GET")
+	protected.HandleFunc("/api/personalData/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		erasePersonalDataHandler(w, r, store)
+	}, rbac.PermDataErase)).Methods("DELETE")
+	protected.HandleFunc("/api/admin/settings/twoFactor", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		getTwoFactorPolicyHandler(w, r, store)
+	}, rbac.PermSettingsManage)).Methods("GET")
+	protected.HandleFunc("/api/admin/settings/twoFactor", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		updateTwoFactorPolicyHandler(w, r, store)
+	}, rbac.PermSettingsManage)).Methods("PUT")
+	protected.HandleFunc("/api/logoutAll", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().LogoutAllHandler(w, r)
+	}, rbac.PermAccountSelf)).Methods("POST")
+	protected.HandleFunc("/api/sessions", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().ListSessionsHandler(w, r)
+	}, rbac.PermAccountSelf)).Methods("GET")
+	protected.HandleFunc("/api/sessions/{session_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().RevokeSessionHandler(w, r)
+	}, rbac.PermAccountSelf)).Methods("DELETE")
 
-	log.Printf("Starting server on :%s", localPort)
-	if err := http.ListenAndServe(":"+localPort, handler); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	// Serve until stopped, letting requests in progress finish
+	if err := server.Run("Doctor", cfg.Port, server.CORS(router, "GET", "POST", "PUT", "DELETE")); err != nil {
+		log.Fatalf("Server failed: %v", err)
 	}
 }
 
 type Doctor struct {
-	DoctorID int    `json:"id,omitempty"`
-	Name     string `json:"name,omitempty"`
-	Email    string `json:"email,omitempty"`
-	Password string `json:"password,omitempty"`
+	DoctorID      int    `json:"id,omitempty"`
+	Name          string `json:"name,omitempty"`
+	Email         string `json:"email,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Role          string `json:"role,omitempty"`
+	Specialty     string `json:"specialty"`
+	Clinic        string `json:"clinic"`
+	LicenseNumber string `json:"license_number"`
+	Status        string `json:"status,omitempty"`
 }
 
-func authenticationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func authenticationHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	// Parse request body
 	var credentials struct {
 		Email    string `json:"email"`
@@ -106,13 +203,23 @@ func authenticationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Query the database for the doctor with this email
-	var doctor Doctor
-	query := `SELECT DoctorID, PasswordHash FROM Doctors WHERE Email = ?`
-	err := db.QueryRow(query, credentials.Email).Scan(&doctor.DoctorID, &doctor.Password)
+	// Reject addresses with too many recent failures
+	ip := auth.ClientIP(r)
+	retryAfter, err := store.accounts().IPRetryAfter(ip)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+		log.Println("Database error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	} else if retryAfter > 0 {
+		account.TooManyAttempts(w, retryAfter)
+		return
+	}
+
+	// Look up the doctor with this email
+	doctor, err := store.Doctors.GetByEmail(credentials.Email)
+	if err != nil {
+		if err == errDoctorNotFound {
+			store.accounts().RefuseLogin(w, ip, credentials.Password)
 		} else {
 			log.Println("Database error:", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -120,23 +227,79 @@ func authenticationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Locked accounts are refused even with the right password, the same way as unknown emails
+	if doctor.LockedFor > 0 {
+		store.accounts().RefuseLogin(w, ip, credentials.Password)
+		return
+	}
+
 	// Verify password with bcrypt
-	if err := bcrypt.CompareHashAndPassword([]byte(doctor.Password), []byte(credentials.Password)); err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+	if err := bcrypt.CompareHashAndPassword([]byte(doctor.PasswordHash), []byte(credentials.Password)); err != nil {
+		store.accounts().RecordFailedLogin(ip, doctor.DoctorID)
+		http.Error(w, account.InvalidCredentialsMessage, http.StatusUnauthorized)
+		return
+	}
+
+	// Only reveal the account state once the password has been proven
+	if doctor.Status != statusActive {
+		http.Error(w, "This account has been deactivated. Please contact your clinic admin.", http.StatusForbidden)
+		return
+	}
+
+	// Doctors with two-factor enabled, or required by the clinic, must complete a second step.
+	// The failure counter is only reset once that step succeeds.
+	required, err := twoFactorRequired(store.Settings)
+	if err != nil {
+		log.Println("Database error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if doctor.TOTPEnabled || required {
+		mfaToken, err := startLoginChallenge(store.LoginChallenges, doctor.DoctorID)
+		if err != nil {
+			log.Println("Database error:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		respond.JSON(w, map[string]interface{}{
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"enrollment_required": !doctor.TOTPEnabled,
+			"mfa_token":           mfaToken,
+			"expires_in":          int(loginChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	if doctor.FailedLoginCount > 0 {
+		if err := store.Doctors.ResetFailedLogins(doctor.DoctorID); err != nil {
+			log.Println("Database error:", err)
+		}
+	}
+
+	// Issue a signed access token and a refresh token for this device
+	token, refreshToken, err := store.accounts().CreateSession(doctor.DoctorID, doctor.Role, r.UserAgent())
+	if err != nil {
+		log.Println("Session creation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	// Authentication successful - Send doctor details (excluding password)
 	response := map[string]interface{}{
-		"message":   "Authentication successful",
-		"doctor_id": doctor.DoctorID,
+		"message":       "Authentication successful",
+		"doctor_id":     doctor.DoctorID,
+		"role":          doctor.Role,
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.TokenTTL.Seconds()),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	respond.JSON(w, response)
 }
 
-func getDoctorDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func getDoctorDetailsHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	// Parse request body
 	var request struct {
 		DoctorID int `json:"doctor_id"`
@@ -155,12 +318,16 @@ func getDoctorDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB)
 		return
 	}
 
-	// Query doctor details from the database
-	var doctor Doctor
-	query := "SELECT DoctorID, Name, Email FROM Doctors WHERE DoctorID = ?"
-	err := db.QueryRow(query, request.DoctorID).Scan(&doctor.DoctorID, &doctor.Name, &doctor.Email)
+	// Doctors may only view their own details, clinic admins may view any doctor
+	if claims := auth.ClaimsFromRequest(r); !isDoctorAccount(claims) || (!rbac.HasPermission(claims.Role, rbac.PermDoctorManage) && claims.Subject != request.DoctorID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Look up the doctor's details
+	account, err := store.Doctors.Get(request.DoctorID)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == errDoctorNotFound {
 			http.Error(w, "Doctor not found", http.StatusNotFound)
 		} else {
 			log.Println("Database query error:", err)
@@ -169,15 +336,10 @@ func getDoctorDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB)
 		return
 	}
 
-	// Set secure response headers
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set("X-Frame-Options", "DENY")
-	w.Header().Set("X-XSS-Protection", "1; mode=block")
+	if !store.Audit.Access(w, r, "doctor_profile", strconv.Itoa(request.DoctorID), 0) {
+		return
+	}
 
 	// Send response
-	if err := json.NewEncoder(w).Encode(doctor); err != nil {
-		log.Println("JSON encoding error:", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-	}
+	respond.JSON(w, account.Doctor)
 }
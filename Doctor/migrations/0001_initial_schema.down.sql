@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS AuditLog;
+DROP TABLE IF EXISTS ClinicSettings;
+DROP TABLE IF EXISTS LoginChallenges;
+DROP TABLE IF EXISTS RecoveryCodes;
+DROP TABLE IF EXISTS PatientAssignments;
+DROP TABLE IF EXISTS LoginFailures;
+DROP TABLE IF EXISTS RefreshTokens;
+DROP TABLE IF EXISTS DoctorInvitations;
+DROP TABLE IF EXISTS Doctors;
@@ -0,0 +1,123 @@
+-- Table for storing doctor information
+CREATE TABLE Doctors (
+    DoctorID INT AUTO_INCREMENT PRIMARY KEY,
+    Name VARCHAR(255) NOT NULL,
+    Email VARCHAR(100) UNIQUE NOT NULL,
+    PasswordHash VARCHAR(255) NOT NULL, -- Changed to store hashed passwords
+    Role ENUM('doctor', 'admin', 'sysadmin', 'compliance') NOT NULL DEFAULT 'doctor', -- Clinic admins manage doctor accounts, system admins also manage admins and compliance officers
+    Specialty VARCHAR(100) NOT NULL DEFAULT '',
+    Clinic VARCHAR(255) NOT NULL DEFAULT '',
+    LicenseNumber VARCHAR(50) UNIQUE NULL DEFAULT NULL, -- Medical council registration number
+    Status ENUM('Invited', 'Active', 'Deactivated') NOT NULL DEFAULT 'Active', -- Invited accounts have no password yet
+    FailedLoginCount INT NOT NULL DEFAULT 0, -- Consecutive failed logins, reset on success
+    LockedUntil TIMESTAMP NULL DEFAULT NULL, -- Set once FailedLoginCount reaches the lockout threshold
+    TOTPSecret VARCHAR(64) NULL DEFAULT NULL, -- Base32 TOTP secret, set when two-factor setup starts
+    TOTPEnabled BOOLEAN NOT NULL DEFAULT FALSE, -- Set once the first code is confirmed
+    TOTPLastStep BIGINT NOT NULL DEFAULT 0, -- Last accepted TOTP time step, so a code cannot be replayed
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- Emailed set-password links for doctors invited by a clinic admin (only the SHA-256 hash is stored)
+CREATE TABLE DoctorInvitations (
+    InvitationID INT AUTO_INCREMENT PRIMARY KEY,
+    DoctorID INT NOT NULL,
+    TokenHash CHAR(64) UNIQUE NOT NULL,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP NOT NULL,
+    UsedAt TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (DoctorID) REFERENCES Doctors(DoctorID) ON DELETE CASCADE
+);
+
+-- Refresh tokens, one row per device session (only the SHA-256 hash is stored)
+CREATE TABLE RefreshTokens (
+    TokenID INT AUTO_INCREMENT PRIMARY KEY,
+    DoctorID INT NOT NULL,
+    TokenHash CHAR(64) UNIQUE NOT NULL,
+    UserAgent VARCHAR(255) NOT NULL DEFAULT '',
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP NOT NULL,
+    RevokedAt TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (DoctorID) REFERENCES Doctors(DoctorID) ON DELETE CASCADE
+);
+
+-- Failed logins per client IP, used to throttle password guessing across accounts
+CREATE TABLE LoginFailures (
+    FailureID INT AUTO_INCREMENT PRIMARY KEY,
+    IPAddress VARCHAR(45) NOT NULL,
+    AttemptedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (IPAddress, AttemptedAt)
+);
+
+-- Doctors caring for each senior. UserID refers to Users in user_db.
+CREATE TABLE PatientAssignments (
+    AssignmentID INT AUTO_INCREMENT PRIMARY KEY,
+    DoctorID INT NOT NULL,
+    UserID INT NOT NULL,
+    Role ENUM('primary', 'secondary') NOT NULL DEFAULT 'primary',
+    StartDate DATE NOT NULL,
+    EndDate DATE NULL DEFAULT NULL, -- NULL while the assignment is ongoing
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (UserID),
+    INDEX (DoctorID),
+    FOREIGN KEY (DoctorID) REFERENCES Doctors(DoctorID) ON DELETE CASCADE
+);
+
+-- Single-use two-factor recovery codes (only the SHA-256 hash is stored)
+CREATE TABLE RecoveryCodes (
+    CodeID INT AUTO_INCREMENT PRIMARY KEY,
+    DoctorID INT NOT NULL,
+    CodeHash CHAR(64) NOT NULL,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UsedAt TIMESTAMP NULL DEFAULT NULL,
+    INDEX (DoctorID, CodeHash),
+    FOREIGN KEY (DoctorID) REFERENCES Doctors(DoctorID) ON DELETE CASCADE
+);
+
+-- Pending second login steps, created once the password has been verified
+CREATE TABLE LoginChallenges (
+    ChallengeID INT AUTO_INCREMENT PRIMARY KEY,
+    DoctorID INT NOT NULL,
+    TokenHash CHAR(64) UNIQUE NOT NULL,
+    Attempts INT NOT NULL DEFAULT 0,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP NOT NULL,
+    UsedAt TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (DoctorID) REFERENCES Doctors(DoctorID) ON DELETE CASCADE
+);
+
+-- Clinic-wide settings managed by clinic admins
+CREATE TABLE ClinicSettings (
+    SettingKey VARCHAR(50) PRIMARY KEY,
+    SettingValue VARCHAR(255) NOT NULL,
+    UpdatedBy INT NULL,
+    UpdatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+INSERT INTO ClinicSettings (SettingKey, SettingValue) VALUES ('require_two_factor', 'false');
+
+-- Append-only audit log of every read and change of health or profile data. Each row's Hash
+-- covers the previous row's Hash, so any edit or removal shows up when the chain is verified.
+CREATE TABLE AuditLog (
+    AuditID BIGINT AUTO_INCREMENT PRIMARY KEY,
+    Service VARCHAR(30) NOT NULL,
+    ActorID INT NOT NULL,
+    ActorRole VARCHAR(20) NOT NULL,
+    Action VARCHAR(10) NOT NULL,
+    ResourceType VARCHAR(30) NOT NULL,
+    ResourceID VARCHAR(64) NOT NULL DEFAULT '',
+    SubjectID INT NOT NULL DEFAULT 0,
+    SourceIP VARCHAR(45) NOT NULL DEFAULT '',
+    CreatedAt DATETIME(6) NOT NULL,
+    PrevHash CHAR(64) NOT NULL DEFAULT '',
+    Hash CHAR(64) NOT NULL,
+    INDEX (ActorID),
+    INDEX (SubjectID),
+    INDEX (CreatedAt)
+);
+
+CREATE TRIGGER AuditLogNoUpdate BEFORE UPDATE ON AuditLog
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only';
+
+CREATE TRIGGER AuditLogNoDelete BEFORE DELETE ON AuditLog
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only';
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"common/audit"
+	"common/auth"
+	"common/respond"
+)
+
+// Every doctor assignment held about the user, collected by the User service's data export
+func exportPersonalDataHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	userID, ok := auth.DataSubjectFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	assignments, err := store.Assignments.List(AssignmentFilter{UserID: userID})
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Earliest first
+	slices.Reverse(assignments)
+
+	if !store.Audit.Access(w, r, "personal_data", strconv.Itoa(userID), userID) {
+		return
+	}
+
+	respond.JSON(w, map[string]interface{}{"assignments": assignments})
+}
+
+// Delete every doctor assignment held about the user and report how many rows were erased
+func erasePersonalDataHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	userID, ok := auth.DataSubjectFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := store.Assignments.DeleteByUser(userID)
+	if err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	store.Audit.Change(r, audit.Delete, "personal_data", strconv.Itoa(userID), userID)
+
+	log.Printf("Erased %d assignments for user %d\n", deleted, userID)
+	respond.JSON(w, map[string]interface{}{
+		"deleted": map[string]int64{"PatientAssignments": deleted},
+	})
+}
@@ -0,0 +1,657 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"common/account"
+	"common/audit"
+)
+
+// Returned when nothing matches the lookup
+var (
+	errDoctorNotFound     = errors.New("doctor not found")
+	errAssignmentNotFound = errors.New("assignment not found")
+	errTokenNotFound      = account.ErrTokenNotFound
+)
+
+// A doctor account with the fields that never leave the service
+type DoctorAccount struct {
+	Doctor
+	PasswordHash     string // Empty until an invited doctor sets a password
+	FailedLoginCount int
+	LockedFor        time.Duration // Time left on a lockout, 0 when not locked
+	TOTPSecret       string        // Empty until two-factor setup starts
+	TOTPEnabled      bool
+	TOTPLastStep     int64
+}
+
+type DoctorRepository interface {
+	// Store a new account, filling in its ID. Without a password hash the doctor must accept an invitation.
+	Create(d *Doctor, passwordHash []byte) error
+	Get(doctorID int) (DoctorAccount, error)
+	GetByEmail(email string) (DoctorAccount, error)
+	// Every account, or only those with the status, by name
+	List(status string) ([]Doctor, error)
+	// Whether an account other than exceptID already uses the email or license number
+	EmailInUse(email string, exceptID int) (bool, error)
+	LicenseInUse(license string, exceptID int) (bool, error)
+	// Save the name, email, role, specialty, clinic and license number
+	Update(d Doctor) error
+	SetPassword(doctorID int, passwordHash []byte) error
+	// Deactivate an account, reporting false if there is none or it already was
+	Deactivate(doctorID int) (bool, error)
+	// Reactivate a deactivated account, back to Invited if it never had a password
+	Reactivate(doctorID int) (bool, error)
+	// Add one to the consecutive failed logins in a single update and return the new count
+	IncrementFailedLogins(doctorID int) (int, error)
+	// Lock the account for the lockout, unless it is already locked for longer
+	LockFor(doctorID int, lockout time.Duration) error
+	// Clear the failed logins and any lockout
+	ResetFailedLogins(doctorID int) error
+}
+
+// Two-factor secrets and recovery codes. Codes are only ever stored hashed.
+type TwoFactorRepository interface {
+	// Store a new pending secret, unless two-factor is already enabled
+	SetSecret(doctorID int, secret string) error
+	// Record the time step of an accepted code, reporting false if it is not newer than the last one
+	AdvanceStep(doctorID int, step int64) (bool, error)
+	// Turn two-factor on and replace the recovery codes at once
+	Enable(doctorID int, codeHashes []string) error
+	// Turn two-factor off, removing the secret and recovery codes
+	Disable(doctorID int) error
+	ReplaceRecoveryCodes(doctorID int, codeHashes []string) error
+	// Use up an unused recovery code, reporting whether there was one
+	UseRecoveryCode(doctorID int, codeHash string) (bool, error)
+}
+
+// An emailed set-password link, or a pending second login step
+type OneTimeToken struct {
+	ID        int
+	DoctorID  int
+	Attempts  int
+	ExpiresAt time.Time
+	Used      bool
+}
+
+type InvitationRepository interface {
+	// Store a new invitation, using up any earlier one the doctor has not accepted
+	Create(doctorID int, tokenHash string, expiresAt time.Time) error
+	GetByToken(tokenHash string) (OneTimeToken, error)
+	// Use up the invitation and set the invited doctor's password at once, reporting false
+	// if the invitation was already used or the doctor is no longer invited
+	Accept(invitationID, doctorID int, passwordHash []byte) (bool, error)
+}
+
+type LoginChallengeRepository interface {
+	Create(doctorID int, tokenHash string, expiresAt time.Time) error
+	GetByToken(tokenHash string) (OneTimeToken, error)
+	AddAttempt(challengeID int) error
+	// Use up the challenge, reporting false if it already was
+	Use(challengeID int) (bool, error)
+}
+
+// Clinic-wide settings
+type SettingsRepository interface {
+	// The setting's value, or "" if it was never set
+	Get(key string) (string, error)
+	Set(key, value string, updatedBy int) error
+}
+
+// Which assignments to list. Zero fields match every assignment.
+type AssignmentFilter struct {
+	DoctorID int
+	UserID   int
+	Active   bool // Only assignments in effect today
+}
+
+// A doctor currently caring for a patient
+type CareTeamMember struct {
+	DoctorID  int
+	Name      string
+	Email     string
+	Specialty string
+	Role      string
+}
+
+type AssignmentRepository interface {
+	// Assignments matching the filter, by patient then latest start first
+	List(filter AssignmentFilter) ([]Assignment, error)
+	Get(assignmentID int) (Assignment, error)
+	// The patient's other assignments whose dates overlap the assignment's
+	Overlapping(a Assignment) ([]Assignment, error)
+	// Store a new assignment, filling in its ID
+	Create(a *Assignment) error
+	// Save the role and dates
+	Update(a Assignment) error
+	Delete(assignmentID int) (bool, error)
+	// Delete every assignment of the patient, returning how many there were
+	DeleteByUser(userID int) (int64, error)
+	// Active doctors currently assigned to the patient, primary doctor first
+	CareTeam(userID int) ([]CareTeamMember, error)
+}
+
+func newMySQLStore(db *sql.DB) *Store {
+	return &Store{
+		Doctors:         mysqlDoctorRepository{db},
+		TwoFactor:       mysqlTwoFactorRepository{db},
+		Sessions:        mysqlSessionRepository{db},
+		LoginFailures:   mysqlLoginFailureRepository{db},
+		Invitations:     mysqlInvitationRepository{db},
+		LoginChallenges: mysqlLoginChallengeRepository{db},
+		Settings:        mysqlSettingsRepository{db},
+		Assignments:     mysqlAssignmentRepository{db},
+		Audit:           audit.New(auditServiceName, audit.MySQLRepository{DB: db}),
+		db:              db,
+	}
+}
+
+// Scans a single row or each of several
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Whether an UPDATE or DELETE touched any row
+func affected(result sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+type mysqlDoctorRepository struct {
+	db *sql.DB
+}
+
+// Lockouts are measured with MySQL's clock, which LockedUntil is set by
+const doctorColumns = `SELECT DoctorID, Name, Email, Role, Specialty, Clinic, COALESCE(LicenseNumber, ''), Status,
+              PasswordHash, FailedLoginCount, GREATEST(COALESCE(TIMESTAMPDIFF(SECOND, NOW(), LockedUntil), 0), 0),
+              COALESCE(TOTPSecret, ''), TOTPEnabled, TOTPLastStep FROM Doctors`
+
+func scanDoctor(row scanner) (DoctorAccount, error) {
+	var d DoctorAccount
+	var lockedFor int64
+	err := row.Scan(&d.DoctorID, &d.Name, &d.Email, &d.Role, &d.Specialty, &d.Clinic, &d.LicenseNumber, &d.Status,
+		&d.PasswordHash, &d.FailedLoginCount, &lockedFor, &d.TOTPSecret, &d.TOTPEnabled, &d.TOTPLastStep)
+	d.LockedFor = time.Duration(lockedFor) * time.Second
+	return d, err
+}
+
+func (m mysqlDoctorRepository) Create(d *Doctor, passwordHash []byte) error {
+	query := `INSERT INTO Doctors (Name, Email, PasswordHash, Role, Specialty, Clinic, LicenseNumber, Status)
+              VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?)`
+	result, err := m.db.Exec(query, d.Name, d.Email, passwordHash, d.Role, d.Specialty, d.Clinic, d.LicenseNumber, d.Status)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	d.DoctorID = int(id)
+	return err
+}
+
+func (m mysqlDoctorRepository) Get(doctorID int) (DoctorAccount, error) {
+	return m.queryOne(doctorColumns+" WHERE DoctorID = ?", doctorID)
+}
+
+func (m mysqlDoctorRepository) GetByEmail(email string) (DoctorAccount, error) {
+	return m.queryOne(doctorColumns+" WHERE Email = ?", email)
+}
+
+func (m mysqlDoctorRepository) queryOne(query string, args ...interface{}) (DoctorAccount, error) {
+	d, err := scanDoctor(m.db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return DoctorAccount{}, errDoctorNotFound
+	}
+	return d, err
+}
+
+func (m mysqlDoctorRepository) List(status string) ([]Doctor, error) {
+	query := doctorColumns
+	args := []interface{}{}
+	if status != "" {
+		query += " WHERE Status = ?"
+		args = append(args, status)
+	}
+	rows, err := m.db.Query(query+" ORDER BY Name", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	doctors := []Doctor{}
+	for rows.Next() {
+		d, err := scanDoctor(rows)
+		if err != nil {
+			return nil, err
+		}
+		doctors = append(doctors, d.Doctor)
+	}
+	return doctors, rows.Err()
+}
+
+func (m mysqlDoctorRepository) EmailInUse(email string, exceptID int) (bool, error) {
+	return m.exists("SELECT DoctorID FROM Doctors WHERE Email = ? AND DoctorID <> ?", email, exceptID)
+}
+
+func (m mysqlDoctorRepository) LicenseInUse(license string, exceptID int) (bool, error) {
+	return m.exists("SELECT DoctorID FROM Doctors WHERE LicenseNumber = ? AND DoctorID <> ?", license, exceptID)
+}
+
+func (m mysqlDoctorRepository) exists(query string, args ...interface{}) (bool, error) {
+	var id int
+	err := m.db.QueryRow(query, args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m mysqlDoctorRepository) Update(d Doctor) error {
+	query := `UPDATE Doctors SET Name = ?, Email = ?, Role = ?, Specialty = ?, Clinic = ?, LicenseNumber = NULLIF(?, '')
+              WHERE DoctorID = ?`
+	_, err := m.db.Exec(query, d.Name, d.Email, d.Role, d.Specialty, d.Clinic, d.LicenseNumber, d.DoctorID)
+	return err
+}
+
+func (m mysqlDoctorRepository) SetPassword(doctorID int, passwordHash []byte) error {
+	_, err := m.db.Exec("UPDATE Doctors SET PasswordHash = ? WHERE DoctorID = ?", passwordHash, doctorID)
+	return err
+}
+
+func (m mysqlDoctorRepository) Deactivate(doctorID int) (bool, error) {
+	return affected(m.db.Exec("UPDATE Doctors SET Status = ? WHERE DoctorID = ? AND Status <> ?", statusDeactivated, doctorID, statusDeactivated))
+}
+
+func (m mysqlDoctorRepository) Reactivate(doctorID int) (bool, error) {
+	query := "UPDATE Doctors SET Status = IF(PasswordHash = '', ?, ?) WHERE DoctorID = ? AND Status = ?"
+	return affected(m.db.Exec(query, statusInvited, statusActive, doctorID, statusDeactivated))
+}
+
+// LAST_INSERT_ID(expr) hands back the incremented count from the same statement, so
+// concurrent failures each see their own count
+func (m mysqlDoctorRepository) IncrementFailedLogins(doctorID int) (int, error) {
+	result, err := m.db.Exec("UPDATE Doctors SET FailedLoginCount = LAST_INSERT_ID(FailedLoginCount + 1) WHERE DoctorID = ?", doctorID)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return 0, err
+	}
+	failedLogins, err := result.LastInsertId()
+	return int(failedLogins), err
+}
+
+func (m mysqlDoctorRepository) LockFor(doctorID int, lockout time.Duration) error {
+	_, err := m.db.Exec("UPDATE Doctors SET LockedUntil = GREATEST(COALESCE(LockedUntil, NOW()), NOW() + INTERVAL ? SECOND) WHERE DoctorID = ?", int(lockout.Seconds()), doctorID)
+	return err
+}
+
+func (m mysqlDoctorRepository) ResetFailedLogins(doctorID int) error {
+	_, err := m.db.Exec("UPDATE Doctors SET FailedLoginCount = 0, LockedUntil = NULL WHERE DoctorID = ?", doctorID)
+	return err
+}
+
+type mysqlTwoFactorRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlTwoFactorRepository) SetSecret(doctorID int, secret string) error {
+	_, err := m.db.Exec("UPDATE Doctors SET TOTPSecret = ?, TOTPLastStep = 0 WHERE DoctorID = ? AND TOTPEnabled = FALSE", secret, doctorID)
+	return err
+}
+
+// The step is only recorded if it is newer, guarding against the same code being submitted twice concurrently
+func (m mysqlTwoFactorRepository) AdvanceStep(doctorID int, step int64) (bool, error) {
+	return affected(m.db.Exec("UPDATE Doctors SET TOTPLastStep = ? WHERE DoctorID = ? AND TOTPLastStep < ?", step, doctorID, step))
+}
+
+func (m mysqlTwoFactorRepository) Enable(doctorID int, codeHashes []string) error {
+	return m.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("UPDATE Doctors SET TOTPEnabled = TRUE WHERE DoctorID = ?", doctorID); err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(tx, doctorID, codeHashes)
+	})
+}
+
+func (m mysqlTwoFactorRepository) Disable(doctorID int) error {
+	return m.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("UPDATE Doctors SET TOTPEnabled = FALSE, TOTPSecret = NULL WHERE DoctorID = ?", doctorID); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM RecoveryCodes WHERE DoctorID = ?", doctorID)
+		return err
+	})
+}
+
+func (m mysqlTwoFactorRepository) ReplaceRecoveryCodes(doctorID int, codeHashes []string) error {
+	return m.inTx(func(tx *sql.Tx) error {
+		return replaceRecoveryCodes(tx, doctorID, codeHashes)
+	})
+}
+
+func (m mysqlTwoFactorRepository) UseRecoveryCode(doctorID int, codeHash string) (bool, error) {
+	return affected(m.db.Exec("UPDATE RecoveryCodes SET UsedAt = NOW() WHERE DoctorID = ? AND CodeHash = ? AND UsedAt IS NULL", doctorID, codeHash))
+}
+
+// Run the changes in one transaction, committing only if they all succeed
+func (m mysqlTwoFactorRepository) inTx(changes func(tx *sql.Tx) error) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := changes(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, doctorID int, codeHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM RecoveryCodes WHERE DoctorID = ?", doctorID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO RecoveryCodes (DoctorID, CodeHash) VALUES (?, ?)", doctorID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mysqlSessionRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlSessionRepository) Create(doctorID int, tokenHash, userAgent string, expiresAt time.Time) error {
+	query := "INSERT INTO RefreshTokens (DoctorID, TokenHash, UserAgent, ExpiresAt) VALUES (?, ?, ?, ?)"
+	_, err := m.db.Exec(query, doctorID, tokenHash, userAgent, expiresAt)
+	return err
+}
+
+const sessionColumns = `SELECT TokenID, DoctorID, UserAgent, CreatedAt, ExpiresAt, RevokedAt IS NOT NULL FROM RefreshTokens`
+
+func scanSession(row scanner) (account.Session, error) {
+	var s account.Session
+	err := row.Scan(&s.SessionID, &s.AccountID, &s.UserAgent, &s.CreatedAt, &s.ExpiresAt, &s.Revoked)
+	return s, err
+}
+
+func (m mysqlSessionRepository) GetByToken(tokenHash string) (account.Session, error) {
+	s, err := scanSession(m.db.QueryRow(sessionColumns+" WHERE TokenHash = ?", tokenHash))
+	if err == sql.ErrNoRows {
+		return account.Session{}, errTokenNotFound
+	}
+	return s, err
+}
+
+func (m mysqlSessionRepository) Revoke(sessionID, doctorID int) (bool, error) {
+	return affected(m.db.Exec("UPDATE RefreshTokens SET RevokedAt = NOW() WHERE TokenID = ? AND DoctorID = ? AND RevokedAt IS NULL", sessionID, doctorID))
+}
+
+func (m mysqlSessionRepository) RevokeByToken(tokenHash string) error {
+	_, err := m.db.Exec("UPDATE RefreshTokens SET RevokedAt = NOW() WHERE TokenHash = ? AND RevokedAt IS NULL", tokenHash)
+	return err
+}
+
+func (m mysqlSessionRepository) RevokeAll(doctorID int) error {
+	_, err := m.db.Exec("UPDATE RefreshTokens SET RevokedAt = NOW() WHERE DoctorID = ? AND RevokedAt IS NULL", doctorID)
+	return err
+}
+
+func (m mysqlSessionRepository) ListActive(doctorID int) ([]account.Session, error) {
+	rows, err := m.db.Query(sessionColumns+` WHERE DoctorID = ? AND RevokedAt IS NULL AND ExpiresAt > ? ORDER BY CreatedAt DESC, TokenID DESC`, doctorID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []account.Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+type mysqlLoginFailureRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlLoginFailureRepository) Record(ip string) error {
+	_, err := m.db.Exec("INSERT INTO LoginFailures (IPAddress) VALUES (?)", ip)
+	return err
+}
+
+func (m mysqlLoginFailureRepository) Recent(ip string, window time.Duration) (int, time.Duration, error) {
+	var failures int
+	var secondsSinceOldest sql.NullInt64
+	query := `SELECT COUNT(*), TIMESTAMPDIFF(SECOND, MIN(AttemptedAt), NOW())
+              FROM LoginFailures WHERE IPAddress = ? AND AttemptedAt >= NOW() - INTERVAL ? SECOND`
+	err := m.db.QueryRow(query, ip, int(window.Seconds())).Scan(&failures, &secondsSinceOldest)
+	return failures, time.Duration(secondsSinceOldest.Int64) * time.Second, err
+}
+
+type mysqlInvitationRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlInvitationRepository) Create(doctorID int, tokenHash string, expiresAt time.Time) error {
+	if _, err := m.db.Exec("UPDATE DoctorInvitations SET UsedAt = NOW() WHERE DoctorID = ? AND UsedAt IS NULL", doctorID); err != nil {
+		return err
+	}
+	_, err := m.db.Exec("INSERT INTO DoctorInvitations (DoctorID, TokenHash, ExpiresAt) VALUES (?, ?, ?)", doctorID, tokenHash, expiresAt)
+	return err
+}
+
+func (m mysqlInvitationRepository) GetByToken(tokenHash string) (OneTimeToken, error) {
+	var t OneTimeToken
+	query := "SELECT InvitationID, DoctorID, ExpiresAt, UsedAt IS NOT NULL FROM DoctorInvitations WHERE TokenHash = ?"
+	err := m.db.QueryRow(query, tokenHash).Scan(&t.ID, &t.DoctorID, &t.ExpiresAt, &t.Used)
+	if err == sql.ErrNoRows {
+		return OneTimeToken{}, errTokenNotFound
+	}
+	return t, err
+}
+
+func (m mysqlInvitationRepository) Accept(invitationID, doctorID int, passwordHash []byte) (bool, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Consume the token, guarding against the link being used twice at once
+	ok, err := affected(tx.Exec("UPDATE DoctorInvitations SET UsedAt = NOW() WHERE InvitationID = ? AND UsedAt IS NULL", invitationID))
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// Deactivated accounts stay deactivated
+	ok, err = affected(tx.Exec("UPDATE Doctors SET PasswordHash = ?, Status = ? WHERE DoctorID = ? AND Status = ?", passwordHash, statusActive, doctorID, statusInvited))
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+type mysqlLoginChallengeRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlLoginChallengeRepository) Create(doctorID int, tokenHash string, expiresAt time.Time) error {
+	_, err := m.db.Exec("INSERT INTO LoginChallenges (DoctorID, TokenHash, ExpiresAt) VALUES (?, ?, ?)", doctorID, tokenHash, expiresAt)
+	return err
+}
+
+func (m mysqlLoginChallengeRepository) GetByToken(tokenHash string) (OneTimeToken, error) {
+	var t OneTimeToken
+	query := "SELECT ChallengeID, DoctorID, Attempts, ExpiresAt, UsedAt IS NOT NULL FROM LoginChallenges WHERE TokenHash = ?"
+	err := m.db.QueryRow(query, tokenHash).Scan(&t.ID, &t.DoctorID, &t.Attempts, &t.ExpiresAt, &t.Used)
+	if err == sql.ErrNoRows {
+		return OneTimeToken{}, errTokenNotFound
+	}
+	return t, err
+}
+
+func (m mysqlLoginChallengeRepository) AddAttempt(challengeID int) error {
+	_, err := m.db.Exec("UPDATE LoginChallenges SET Attempts = Attempts + 1 WHERE ChallengeID = ?", challengeID)
+	return err
+}
+
+// Guards against the challenge being completed twice concurrently
+func (m mysqlLoginChallengeRepository) Use(challengeID int) (bool, error) {
+	return affected(m.db.Exec("UPDATE LoginChallenges SET UsedAt = NOW() WHERE ChallengeID = ? AND UsedAt IS NULL", challengeID))
+}
+
+type mysqlSettingsRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlSettingsRepository) Get(key string) (string, error) {
+	var value string
+	err := m.db.QueryRow("SELECT SettingValue FROM ClinicSettings WHERE SettingKey = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (m mysqlSettingsRepository) Set(key, value string, updatedBy int) error {
+	query := `INSERT INTO ClinicSettings (SettingKey, SettingValue, UpdatedBy) VALUES (?, ?, ?)
+              ON DUPLICATE KEY UPDATE SettingValue = VALUES(SettingValue), UpdatedBy = VALUES(UpdatedBy)`
+	_, err := m.db.Exec(query, key, value, updatedBy)
+	return err
+}
+
+type mysqlAssignmentRepository struct {
+	db *sql.DB
+}
+
+// SQL condition for assignments in effect today
+const activeAssignment = "a.StartDate <= CURDATE() AND (a.EndDate IS NULL OR a.EndDate >= CURDATE())"
+
+const assignmentColumns = `SELECT a.AssignmentID, a.DoctorID, d.Name, a.UserID, a.Role, a.StartDate, a.EndDate
+              FROM PatientAssignments a JOIN Doctors d ON d.DoctorID = a.DoctorID`
+
+func scanAssignment(row scanner) (Assignment, error) {
+	var a Assignment
+	var start time.Time
+	var end sql.NullTime
+	if err := row.Scan(&a.AssignmentID, &a.DoctorID, &a.DoctorName, &a.UserID, &a.Role, &start, &end); err != nil {
+		return Assignment{}, err
+	}
+	a.StartDate = start.Format(dateLayout)
+	if end.Valid {
+		a.EndDate = end.Time.Format(dateLayout)
+	}
+	return a, nil
+}
+
+func (m mysqlAssignmentRepository) query(query string, args ...interface{}) ([]Assignment, error) {
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []Assignment{}
+	for rows.Next() {
+		a, err := scanAssignment(rows)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+func (m mysqlAssignmentRepository) List(filter AssignmentFilter) ([]Assignment, error) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	if filter.DoctorID > 0 {
+		conditions = append(conditions, "a.DoctorID = ?")
+		args = append(args, filter.DoctorID)
+	}
+	if filter.UserID > 0 {
+		conditions = append(conditions, "a.UserID = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.Active {
+		conditions = append(conditions, activeAssignment)
+	}
+	return m.query(assignmentColumns+" WHERE "+strings.Join(conditions, " AND ")+" ORDER BY a.UserID, a.StartDate DESC", args...)
+}
+
+func (m mysqlAssignmentRepository) Get(assignmentID int) (Assignment, error) {
+	a, err := scanAssignment(m.db.QueryRow(assignmentColumns+" WHERE a.AssignmentID = ?", assignmentID))
+	if err == sql.ErrNoRows {
+		return Assignment{}, errAssignmentNotFound
+	}
+	return a, err
+}
+
+func (m mysqlAssignmentRepository) Overlapping(a Assignment) ([]Assignment, error) {
+	query := assignmentColumns + ` WHERE a.UserID = ? AND a.AssignmentID <> ?
+              AND a.StartDate <= COALESCE(NULLIF(?, ''), ?) AND COALESCE(a.EndDate, ?) >= ?`
+	return m.query(query, a.UserID, a.AssignmentID, a.EndDate, openEndDate, openEndDate, a.StartDate)
+}
+
+func (m mysqlAssignmentRepository) Create(a *Assignment) error {
+	query := "INSERT INTO PatientAssignments (DoctorID, UserID, Role, StartDate, EndDate) VALUES (?, ?, ?, ?, NULLIF(?, ''))"
+	result, err := m.db.Exec(query, a.DoctorID, a.UserID, a.Role, a.StartDate, a.EndDate)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	a.AssignmentID = int(id)
+	return err
+}
+
+func (m mysqlAssignmentRepository) Update(a Assignment) error {
+	query := "UPDATE PatientAssignments SET Role = ?, StartDate = ?, EndDate = NULLIF(?, '') WHERE AssignmentID = ?"
+	_, err := m.db.Exec(query, a.Role, a.StartDate, a.EndDate, a.AssignmentID)
+	return err
+}
+
+func (m mysqlAssignmentRepository) Delete(assignmentID int) (bool, error) {
+	return affected(m.db.Exec("DELETE FROM PatientAssignments WHERE AssignmentID = ?", assignmentID))
+}
+
+func (m mysqlAssignmentRepository) DeleteByUser(userID int) (int64, error) {
+	result, err := m.db.Exec("DELETE FROM PatientAssignments WHERE UserID = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (m mysqlAssignmentRepository) CareTeam(userID int) ([]CareTeamMember, error) {
+	query := `SELECT d.DoctorID, d.Name, d.Email, d.Specialty, a.Role FROM PatientAssignments a
+              JOIN Doctors d ON d.DoctorID = a.DoctorID
+              WHERE a.UserID = ? AND d.Status = ? AND ` + activeAssignment + `
+              ORDER BY a.Role = ? DESC, d.Name`
+	rows, err := m.db.Query(query, userID, statusActive, assignmentPrimary)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	careTeam := []CareTeamMember{}
+	for rows.Next() {
+		var c CareTeamMember
+		if err := rows.Scan(&c.DoctorID, &c.Name, &c.Email, &c.Specialty, &c.Role); err != nil {
+			return nil, err
+		}
+		careTeam = append(careTeam, c)
+	}
+	return careTeam, rows.Err()
+}
@@ -0,0 +1,665 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"common/account"
+	"common/audit"
+	"common/rbac"
+)
+
+// Accounts seeded like seeds/test_accounts.sql, so the clinic can be administered from the start
+var memoryDoctors = []DoctorAccount{
+	{Doctor: Doctor{Name: "Dr. John Doe", Email: "johndoe7@gmail.com", Role: rbac.RoleDoctor, Specialty: "Geriatric Medicine", Clinic: "Bukit Merah Clinic", LicenseNumber: "M12345A"},
+		PasswordHash: "$2a$10$.Nl5tbg7enAt9PUEG.DBe.DHj0vRrldExrcaLN9e9I6dZ.bMPy/ha"},
+	{Doctor: Doctor{Name: "Clinic Admin", Email: "admin@befrienders.sg", Role: rbac.RoleAdmin},
+		PasswordHash: "$2a$10$8jSTPBbAODr9tw4RfDjmaO80iKMw0Glk1KoxREsAs94R3XcXqvxum"},
+	{Doctor: Doctor{Name: "System Admin", Email: "sysadmin@befrienders.sg", Role: rbac.RoleSystemAdmin},
+		PasswordHash: "$2a$10$6xPJAHhyycvriGIixBEWKeAgCFCKpaL3dGVCJ/JEMBkdM34/Nydw."},
+}
+
+// Every table kept in memory, behind one lock so changes spanning tables are all or nothing
+type memoryData struct {
+	mu            sync.Mutex
+	doctors       []memoryDoctor
+	recoveryCodes []memoryRecoveryCode
+	sessions      []memorySession
+	loginFailures []memoryLoginFailure
+	invitations   []memoryToken
+	challenges    []memoryToken
+	settings      map[string]string
+	assignments   []Assignment
+	lastID        map[string]int // Last ID handed out per table
+}
+
+type memoryDoctor struct {
+	DoctorAccount
+	lockedUntil time.Time
+}
+
+type memoryRecoveryCode struct {
+	doctorID int
+	hash     string
+	used     bool
+}
+
+type memorySession struct {
+	account.Session
+	tokenHash string
+}
+
+type memoryLoginFailure struct {
+	ip          string
+	attemptedAt time.Time
+}
+
+type memoryToken struct {
+	OneTimeToken
+	tokenHash string
+}
+
+func newMemoryStore() *Store {
+	data := &memoryData{
+		settings: map[string]string{requireTwoFactorSetting: "false"},
+		lastID:   map[string]int{},
+	}
+	for _, d := range memoryDoctors {
+		d.DoctorID = data.nextID("doctors")
+		d.Status = statusActive
+		data.doctors = append(data.doctors, memoryDoctor{DoctorAccount: d})
+	}
+
+	return &Store{
+		Doctors:         memoryDoctorRepository{data},
+		TwoFactor:       memoryTwoFactorRepository{data},
+		Sessions:        memorySessionRepository{data},
+		LoginFailures:   memoryLoginFailureRepository{data},
+		Invitations:     memoryInvitationRepository{data},
+		LoginChallenges: memoryLoginChallengeRepository{data},
+		Settings:        memorySettingsRepository{data},
+		Assignments:     memoryAssignmentRepository{data},
+		Audit:           audit.New(auditServiceName, &audit.MemoryRepository{}),
+	}
+}
+
+// Auto-increment ID for a new row. Called with the lock held.
+func (d *memoryData) nextID(table string) int {
+	d.lastID[table]++
+	return d.lastID[table]
+}
+
+// The doctor's row, or nil. Called with the lock held.
+func (d *memoryData) doctor(doctorID int) *memoryDoctor {
+	for i := range d.doctors {
+		if d.doctors[i].DoctorID == doctorID {
+			return &d.doctors[i]
+		}
+	}
+	return nil
+}
+
+// The row as MySQL would return it, with the time left on its lockout
+func (m memoryDoctor) account() DoctorAccount {
+	account := m.DoctorAccount
+	if lockedFor := time.Until(m.lockedUntil).Truncate(time.Second); lockedFor > 0 {
+		account.LockedFor = lockedFor
+	}
+	return account
+}
+
+type memoryDoctorRepository struct {
+	data *memoryData
+}
+
+func (m memoryDoctorRepository) Create(d *Doctor, passwordHash []byte) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	d.DoctorID = m.data.nextID("doctors")
+	account := DoctorAccount{Doctor: *d, PasswordHash: string(passwordHash)}
+	account.Password = ""
+	m.data.doctors = append(m.data.doctors, memoryDoctor{DoctorAccount: account})
+	return nil
+}
+
+func (m memoryDoctorRepository) Get(doctorID int) (DoctorAccount, error) {
+	return m.find(func(d memoryDoctor) bool { return d.DoctorID == doctorID })
+}
+
+func (m memoryDoctorRepository) GetByEmail(email string) (DoctorAccount, error) {
+	return m.find(func(d memoryDoctor) bool { return strings.EqualFold(d.Email, email) })
+}
+
+func (m memoryDoctorRepository) find(match func(d memoryDoctor) bool) (DoctorAccount, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for _, d := range m.data.doctors {
+		if match(d) {
+			return d.account(), nil
+		}
+	}
+	return DoctorAccount{}, errDoctorNotFound
+}
+
+func (m memoryDoctorRepository) List(status string) ([]Doctor, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	doctors := []Doctor{}
+	for _, d := range m.data.doctors {
+		if status == "" || d.Status == status {
+			doctors = append(doctors, d.Doctor)
+		}
+	}
+	sort.SliceStable(doctors, func(i, j int) bool { return doctors[i].Name < doctors[j].Name })
+	return doctors, nil
+}
+
+func (m memoryDoctorRepository) EmailInUse(email string, exceptID int) (bool, error) {
+	_, err := m.find(func(d memoryDoctor) bool { return strings.EqualFold(d.Email, email) && d.DoctorID != exceptID })
+	return err == nil, nil
+}
+
+func (m memoryDoctorRepository) LicenseInUse(license string, exceptID int) (bool, error) {
+	_, err := m.find(func(d memoryDoctor) bool { return d.LicenseNumber == license && d.DoctorID != exceptID })
+	return err == nil, nil
+}
+
+// Apply the change to the doctor's row, reporting whether there was one
+func (m memoryDoctorRepository) update(doctorID int, change func(d *memoryDoctor) bool) bool {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	d := m.data.doctor(doctorID)
+	return d != nil && change(d)
+}
+
+func (m memoryDoctorRepository) Update(doctor Doctor) error {
+	m.update(doctor.DoctorID, func(d *memoryDoctor) bool {
+		d.Name, d.Email, d.Role = doctor.Name, doctor.Email, doctor.Role
+		d.Specialty, d.Clinic, d.LicenseNumber = doctor.Specialty, doctor.Clinic, doctor.LicenseNumber
+		return true
+	})
+	return nil
+}
+
+func (m memoryDoctorRepository) SetPassword(doctorID int, passwordHash []byte) error {
+	m.update(doctorID, func(d *memoryDoctor) bool {
+		d.PasswordHash = string(passwordHash)
+		return true
+	})
+	return nil
+}
+
+func (m memoryDoctorRepository) Deactivate(doctorID int) (bool, error) {
+	return m.update(doctorID, func(d *memoryDoctor) bool {
+		if d.Status == statusDeactivated {
+			return false
+		}
+		d.Status = statusDeactivated
+		return true
+	}), nil
+}
+
+func (m memoryDoctorRepository) Reactivate(doctorID int) (bool, error) {
+	return m.update(doctorID, func(d *memoryDoctor) bool {
+		if d.Status != statusDeactivated {
+			return false
+		}
+		d.Status = statusActive
+		if d.PasswordHash == "" {
+			d.Status = statusInvited
+		}
+		return true
+	}), nil
+}
+
+func (m memoryDoctorRepository) IncrementFailedLogins(doctorID int) (int, error) {
+	failedLogins := 0
+	m.update(doctorID, func(d *memoryDoctor) bool {
+		d.FailedLoginCount++
+		failedLogins = d.FailedLoginCount
+		return true
+	})
+	return failedLogins, nil
+}
+
+func (m memoryDoctorRepository) LockFor(doctorID int, lockout time.Duration) error {
+	m.update(doctorID, func(d *memoryDoctor) bool {
+		if until := time.Now().Add(lockout); until.After(d.lockedUntil) {
+			d.lockedUntil = until
+		}
+		return true
+	})
+	return nil
+}
+
+func (m memoryDoctorRepository) ResetFailedLogins(doctorID int) error {
+	m.update(doctorID, func(d *memoryDoctor) bool {
+		d.FailedLoginCount, d.lockedUntil = 0, time.Time{}
+		return true
+	})
+	return nil
+}
+
+type memoryTwoFactorRepository struct {
+	data *memoryData
+}
+
+func (m memoryTwoFactorRepository) SetSecret(doctorID int, secret string) error {
+	memoryDoctorRepository(m).update(doctorID, func(d *memoryDoctor) bool {
+		if !d.TOTPEnabled {
+			d.TOTPSecret, d.TOTPLastStep = secret, 0
+		}
+		return true
+	})
+	return nil
+}
+
+func (m memoryTwoFactorRepository) AdvanceStep(doctorID int, step int64) (bool, error) {
+	return memoryDoctorRepository(m).update(doctorID, func(d *memoryDoctor) bool {
+		if d.TOTPLastStep >= step {
+			return false
+		}
+		d.TOTPLastStep = step
+		return true
+	}), nil
+}
+
+func (m memoryTwoFactorRepository) Enable(doctorID int, codeHashes []string) error {
+	memoryDoctorRepository(m).update(doctorID, func(d *memoryDoctor) bool {
+		d.TOTPEnabled = true
+		m.replace(doctorID, codeHashes)
+		return true
+	})
+	return nil
+}
+
+func (m memoryTwoFactorRepository) Disable(doctorID int) error {
+	memoryDoctorRepository(m).update(doctorID, func(d *memoryDoctor) bool {
+		d.TOTPEnabled, d.TOTPSecret = false, ""
+		m.replace(doctorID, nil)
+		return true
+	})
+	return nil
+}
+
+func (m memoryTwoFactorRepository) ReplaceRecoveryCodes(doctorID int, codeHashes []string) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	m.replace(doctorID, codeHashes)
+	return nil
+}
+
+// Replace the doctor's recovery codes. Called with the lock held.
+func (m memoryTwoFactorRepository) replace(doctorID int, codeHashes []string) {
+	m.data.recoveryCodes = slices.DeleteFunc(m.data.recoveryCodes, func(c memoryRecoveryCode) bool { return c.doctorID == doctorID })
+	for _, hash := range codeHashes {
+		m.data.recoveryCodes = append(m.data.recoveryCodes, memoryRecoveryCode{doctorID: doctorID, hash: hash})
+	}
+}
+
+func (m memoryTwoFactorRepository) UseRecoveryCode(doctorID int, codeHash string) (bool, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for i, c := range m.data.recoveryCodes {
+		if c.doctorID == doctorID && c.hash == codeHash && !c.used {
+			m.data.recoveryCodes[i].used = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type memorySessionRepository struct {
+	data *memoryData
+}
+
+func (m memorySessionRepository) Create(doctorID int, tokenHash, userAgent string, expiresAt time.Time) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	s := account.Session{SessionID: m.data.nextID("sessions"), AccountID: doctorID, UserAgent: userAgent, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	m.data.sessions = append(m.data.sessions, memorySession{Session: s, tokenHash: tokenHash})
+	return nil
+}
+
+func (m memorySessionRepository) GetByToken(tokenHash string) (account.Session, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for _, s := range m.data.sessions {
+		if s.tokenHash == tokenHash {
+			return s.Session, nil
+		}
+	}
+	return account.Session{}, errTokenNotFound
+}
+
+// Revoke the active sessions matching, returning how many there were
+func (m memorySessionRepository) revoke(match func(s memorySession) bool) int {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	revoked := 0
+	for i, s := range m.data.sessions {
+		if !s.Revoked && match(s) {
+			m.data.sessions[i].Revoked = true
+			revoked++
+		}
+	}
+	return revoked
+}
+
+func (m memorySessionRepository) Revoke(sessionID, doctorID int) (bool, error) {
+	return m.revoke(func(s memorySession) bool { return s.SessionID == sessionID && s.AccountID == doctorID }) > 0, nil
+}
+
+func (m memorySessionRepository) RevokeByToken(tokenHash string) error {
+	m.revoke(func(s memorySession) bool { return s.tokenHash == tokenHash })
+	return nil
+}
+
+func (m memorySessionRepository) RevokeAll(doctorID int) error {
+	m.revoke(func(s memorySession) bool { return s.AccountID == doctorID })
+	return nil
+}
+
+func (m memorySessionRepository) ListActive(doctorID int) ([]account.Session, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	sessions := []account.Session{}
+	for i := len(m.data.sessions) - 1; i >= 0; i-- {
+		s := m.data.sessions[i].Session
+		if s.AccountID == doctorID && !s.Revoked && time.Now().Before(s.ExpiresAt) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+type memoryLoginFailureRepository struct {
+	data *memoryData
+}
+
+func (m memoryLoginFailureRepository) Record(ip string) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	m.data.loginFailures = append(m.data.loginFailures, memoryLoginFailure{ip: ip, attemptedAt: time.Now()})
+	return nil
+}
+
+func (m memoryLoginFailureRepository) Recent(ip string, window time.Duration) (int, time.Duration, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	// Failures from before the window are no longer needed
+	since := time.Now().Add(-window)
+	m.data.loginFailures = slices.DeleteFunc(m.data.loginFailures, func(f memoryLoginFailure) bool {
+		return f.attemptedAt.Before(since)
+	})
+
+	failures := 0
+	var oldest time.Time
+	for _, f := range m.data.loginFailures {
+		if f.ip == ip {
+			if failures == 0 {
+				oldest = f.attemptedAt
+			}
+			failures++
+		}
+	}
+	if failures == 0 {
+		return 0, 0, nil
+	}
+	return failures, time.Since(oldest).Truncate(time.Second), nil
+}
+
+// Find a token by its hash. Called with the lock held.
+func findToken(tokens []memoryToken, tokenHash string) (OneTimeToken, error) {
+	for _, t := range tokens {
+		if t.tokenHash == tokenHash {
+			return t.OneTimeToken, nil
+		}
+	}
+	return OneTimeToken{}, errTokenNotFound
+}
+
+// Mark the unused token used, reporting whether it was. Called with the lock held.
+func useToken(tokens []memoryToken, id int) bool {
+	for i, t := range tokens {
+		if t.ID == id && !t.Used {
+			tokens[i].Used = true
+			return true
+		}
+	}
+	return false
+}
+
+type memoryInvitationRepository struct {
+	data *memoryData
+}
+
+func (m memoryInvitationRepository) Create(doctorID int, tokenHash string, expiresAt time.Time) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for i := range m.data.invitations {
+		if m.data.invitations[i].DoctorID == doctorID {
+			m.data.invitations[i].Used = true
+		}
+	}
+	t := OneTimeToken{ID: m.data.nextID("invitations"), DoctorID: doctorID, ExpiresAt: expiresAt}
+	m.data.invitations = append(m.data.invitations, memoryToken{OneTimeToken: t, tokenHash: tokenHash})
+	return nil
+}
+
+func (m memoryInvitationRepository) GetByToken(tokenHash string) (OneTimeToken, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	return findToken(m.data.invitations, tokenHash)
+}
+
+func (m memoryInvitationRepository) Accept(invitationID, doctorID int, passwordHash []byte) (bool, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	d := m.data.doctor(doctorID)
+	if d == nil || d.Status != statusInvited || !useToken(m.data.invitations, invitationID) {
+		return false, nil
+	}
+	d.PasswordHash, d.Status = string(passwordHash), statusActive
+	return true, nil
+}
+
+type memoryLoginChallengeRepository struct {
+	data *memoryData
+}
+
+func (m memoryLoginChallengeRepository) Create(doctorID int, tokenHash string, expiresAt time.Time) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	t := OneTimeToken{ID: m.data.nextID("challenges"), DoctorID: doctorID, ExpiresAt: expiresAt}
+	m.data.challenges = append(m.data.challenges, memoryToken{OneTimeToken: t, tokenHash: tokenHash})
+	return nil
+}
+
+func (m memoryLoginChallengeRepository) GetByToken(tokenHash string) (OneTimeToken, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	return findToken(m.data.challenges, tokenHash)
+}
+
+func (m memoryLoginChallengeRepository) AddAttempt(challengeID int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for i := range m.data.challenges {
+		if m.data.challenges[i].ID == challengeID {
+			m.data.challenges[i].Attempts++
+		}
+	}
+	return nil
+}
+
+func (m memoryLoginChallengeRepository) Use(challengeID int) (bool, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	return useToken(m.data.challenges, challengeID), nil
+}
+
+type memorySettingsRepository struct {
+	data *memoryData
+}
+
+func (m memorySettingsRepository) Get(key string) (string, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	return m.data.settings[key], nil
+}
+
+func (m memorySettingsRepository) Set(key, value string, updatedBy int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	m.data.settings[key] = value
+	return nil
+}
+
+type memoryAssignmentRepository struct {
+	data *memoryData
+}
+
+// Assignments compare their dates as YYYY-MM-DD strings, open-ended ones as ending on openEndDate
+func assignmentEnd(a Assignment) string {
+	if a.EndDate == "" {
+		return openEndDate
+	}
+	return a.EndDate
+}
+
+func assignmentActive(a Assignment) bool {
+	today := time.Now().Format(dateLayout)
+	return a.StartDate <= today && assignmentEnd(a) >= today
+}
+
+// Assignments matching, with their doctor's current name, by patient then latest start first
+func (m memoryAssignmentRepository) list(match func(a Assignment) bool) []Assignment {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	assignments := []Assignment{}
+	for _, a := range m.data.assignments {
+		if match(a) {
+			if d := m.data.doctor(a.DoctorID); d != nil {
+				a.DoctorName = d.Name
+			}
+			assignments = append(assignments, a)
+		}
+	}
+	sort.SliceStable(assignments, func(i, j int) bool {
+		if assignments[i].UserID != assignments[j].UserID {
+			return assignments[i].UserID < assignments[j].UserID
+		}
+		return assignments[i].StartDate > assignments[j].StartDate
+	})
+	return assignments
+}
+
+func (m memoryAssignmentRepository) List(filter AssignmentFilter) ([]Assignment, error) {
+	return m.list(func(a Assignment) bool {
+		return (filter.DoctorID == 0 || a.DoctorID == filter.DoctorID) &&
+			(filter.UserID == 0 || a.UserID == filter.UserID) &&
+			(!filter.Active || assignmentActive(a))
+	}), nil
+}
+
+func (m memoryAssignmentRepository) Get(assignmentID int) (Assignment, error) {
+	assignments := m.list(func(a Assignment) bool { return a.AssignmentID == assignmentID })
+	if len(assignments) == 0 {
+		return Assignment{}, errAssignmentNotFound
+	}
+	return assignments[0], nil
+}
+
+func (m memoryAssignmentRepository) Overlapping(assignment Assignment) ([]Assignment, error) {
+	return m.list(func(a Assignment) bool {
+		return a.UserID == assignment.UserID && a.AssignmentID != assignment.AssignmentID &&
+			a.StartDate <= assignmentEnd(assignment) && assignmentEnd(a) >= assignment.StartDate
+	}), nil
+}
+
+func (m memoryAssignmentRepository) Create(a *Assignment) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	a.AssignmentID = m.data.nextID("assignments")
+	stored := *a
+	stored.DoctorName = ""
+	m.data.assignments = append(m.data.assignments, stored)
+	return nil
+}
+
+func (m memoryAssignmentRepository) Update(assignment Assignment) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for i, a := range m.data.assignments {
+		if a.AssignmentID == assignment.AssignmentID {
+			m.data.assignments[i].Role, m.data.assignments[i].StartDate, m.data.assignments[i].EndDate = assignment.Role, assignment.StartDate, assignment.EndDate
+		}
+	}
+	return nil
+}
+
+// Delete the assignments matching, returning how many there were
+func (m memoryAssignmentRepository) delete(match func(a Assignment) bool) int64 {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	before := len(m.data.assignments)
+	m.data.assignments = slices.DeleteFunc(m.data.assignments, match)
+	return int64(before - len(m.data.assignments))
+}
+
+func (m memoryAssignmentRepository) Delete(assignmentID int) (bool, error) {
+	return m.delete(func(a Assignment) bool { return a.AssignmentID == assignmentID }) > 0, nil
+}
+
+func (m memoryAssignmentRepository) DeleteByUser(userID int) (int64, error) {
+	return m.delete(func(a Assignment) bool { return a.UserID == userID }), nil
+}
+
+func (m memoryAssignmentRepository) CareTeam(userID int) ([]CareTeamMember, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	careTeam := []CareTeamMember{}
+	for _, a := range m.data.assignments {
+		d := m.data.doctor(a.DoctorID)
+		if a.UserID == userID && assignmentActive(a) && d != nil && d.Status == statusActive {
+			careTeam = append(careTeam, CareTeamMember{DoctorID: d.DoctorID, Name: d.Name, Email: d.Email, Specialty: d.Specialty, Role: a.Role})
+		}
+	}
+	sort.SliceStable(careTeam, func(i, j int) bool {
+		if (careTeam[i].Role == assignmentPrimary) != (careTeam[j].Role == assignmentPrimary) {
+			return careTeam[i].Role == assignmentPrimary
+		}
+		return careTeam[i].Name < careTeam[j].Name
+	})
+	return careTeam, nil
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"common/account"
+	"common/account/accounttest"
+	"common/rbac"
+)
+
+// Store a new account directly in the repository, active unless no password hash is given
+func createDoctor(t *testing.T, store *Store, name, email, license string, passwordHash []byte) int {
+	t.Helper()
+	d := Doctor{Name: name, Email: email, Role: rbac.RoleDoctor, Specialty: "Geriatrics", Clinic: "Test Clinic", LicenseNumber: license, Status: statusActive}
+	if passwordHash == nil {
+		d.Status = statusInvited
+	}
+	if err := store.Doctors.Create(&d, passwordHash); err != nil {
+		t.Fatalf("Doctors.Create: %v", err)
+	}
+	return d.DoctorID
+}
+
+// Store a new assignment directly in the repository
+func createAssignment(t *testing.T, store *Store, doctorID, userID int, role, start, end string) int {
+	t.Helper()
+	a := Assignment{DoctorID: doctorID, UserID: userID, Role: role, StartDate: start, EndDate: end}
+	if err := store.Assignments.Create(&a); err != nil {
+		t.Fatalf("Assignments.Create: %v", err)
+	}
+	return a.AssignmentID
+}
+
+// A date the given number of days from today
+func day(days int) string {
+	return time.Now().AddDate(0, 0, days).Format(dateLayout)
+}
+
+// The behaviour every repository of the service must have, run against a fresh store per case
+func testRepositories(t *testing.T, newStore func(t *testing.T) *Store) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store *Store)
+	}{
+		{"a created doctor is found by ID and email", func(t *testing.T, store *Store) {
+			id := createDoctor(t, store, "Dr Tan", "tan@example.com", "T10001X", []byte("hash"))
+			d, err := store.Doctors.Get(id)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if d.DoctorID != id || d.Name != "Dr Tan" || d.Email != "tan@example.com" || d.Role != rbac.RoleDoctor ||
+				d.Specialty != "Geriatrics" || d.Clinic != "Test Clinic" || d.LicenseNumber != "T10001X" ||
+				d.Status != statusActive || d.PasswordHash != "hash" || d.Password != "" || d.TOTPEnabled {
+				t.Fatalf("got %+v", d)
+			}
+			if d, err := store.Doctors.GetByEmail("Tan@Example.com"); err != nil || d.DoctorID != id {
+				t.Fatalf("GetByEmail: %d, %v, want %d", d.DoctorID, err, id)
+			}
+		}},
+		{"unknown doctors", func(t *testing.T, store *Store) {
+			if _, err := store.Doctors.Get(999); err != errDoctorNotFound {
+				t.Fatalf("Get: err = %v, want errDoctorNotFound", err)
+			}
+			if _, err := store.Doctors.GetByEmail("nobody@example.com"); err != errDoctorNotFound {
+				t.Fatalf("GetByEmail: err = %v, want errDoctorNotFound", err)
+			}
+		}},
+		{"list filters by status and sorts by name", func(t *testing.T, store *Store) {
+			zed := createDoctor(t, store, "Zed Ong", "zed@example.com", "", []byte("hash"))
+			amy := createDoctor(t, store, "Amy Lim", "amy@example.com", "", nil)
+			all, err := store.Doctors.List("")
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			found := map[int]bool{}
+			for i, d := range all {
+				found[d.DoctorID] = true
+				if i > 0 && all[i-1].Name > d.Name {
+					t.Fatalf("%q listed before %q", all[i-1].Name, d.Name)
+				}
+			}
+			if !found[zed] || !found[amy] {
+				t.Fatalf("List missed a created doctor: %+v", all)
+			}
+			invited, _ := store.Doctors.List(statusInvited)
+			if len(invited) != 1 || invited[0].DoctorID != amy {
+				t.Fatalf("invited %+v, want only %d", invited, amy)
+			}
+		}},
+		{"email and license checks skip the doctor themselves", func(t *testing.T, store *Store) {
+			id := createDoctor(t, store, "Dr Tan", "tan@example.com", "T10001X", []byte("hash"))
+			checks := []struct {
+				name     string
+				inUse    func(value string, exceptID int) (bool, error)
+				value    string
+				exceptID int
+				want     bool
+			}{
+				{"email", store.Doctors.EmailInUse, "tan@example.com", 0, true},
+				{"email of the same doctor", store.Doctors.EmailInUse, "tan@example.com", id, false},
+				{"unused email", store.Doctors.EmailInUse, "other@example.com", 0, false},
+				{"license", store.Doctors.LicenseInUse, "T10001X", 0, true},
+				{"license of the same doctor", store.Doctors.LicenseInUse, "T10001X", id, false},
+				{"unused license", store.Doctors.LicenseInUse, "T99999Z", 0, false},
+			}
+			for _, c := range checks {
+				if got, err := c.inUse(c.value, c.exceptID); err != nil || got != c.want {
+					t.Fatalf("%s: %v, %v, want %v", c.name, got, err, c.want)
+				}
+			}
+		}},
+		{"update and set password", func(t *testing.T, store *Store) {
+			id := createDoctor(t, store, "Dr Tan", "tan@example.com", "T10001X", []byte("hash"))
+			changed := Doctor{DoctorID: id, Name: "Dr Tan Wei", Email: "wei@example.com", Role: rbac.RoleAdmin, Specialty: "Ophthalmology", Clinic: "Eye Clinic", LicenseNumber: "T10002Y"}
+			if err := store.Doctors.Update(changed); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if err := store.Doctors.SetPassword(id, []byte("new-hash")); err != nil {
+				t.Fatalf("SetPassword: %v", err)
+			}
+			d, _ := store.Doctors.Get(id)
+			if d.Name != changed.Name || d.Email != changed.Email || d.Role != changed.Role || d.Specialty != changed.Specialty ||
+				d.Clinic != changed.Clinic || d.LicenseNumber != changed.LicenseNumber || d.PasswordHash != "new-hash" || d.Status != statusActive {
+				t.Fatalf("got %+v", d)
+			}
+		}},
+		{"deactivate and reactivate", func(t *testing.T, store *Store) {
+			active := createDoctor(t, store, "Dr Tan", "tan@example.com", "", []byte("hash"))
+			invited := createDoctor(t, store, "Dr Lim", "lim@example.com", "", nil)
+			for _, id := range []int{active, invited} {
+				if ok, err := store.Doctors.Deactivate(id); err != nil || !ok {
+					t.Fatalf("Deactivate(%d): %v, %v", id, ok, err)
+				}
+				if ok, _ := store.Doctors.Deactivate(id); ok {
+					t.Fatalf("Deactivate(%d) twice succeeded", id)
+				}
+				if d, _ := store.Doctors.Get(id); d.Status != statusDeactivated {
+					t.Fatalf("status %q, want %q", d.Status, statusDeactivated)
+				}
+			}
+			// A doctor who never set a password goes back to waiting for the invitation
+			for id, want := range map[int]string{active: statusActive, invited: statusInvited} {
+				if ok, err := store.Doctors.Reactivate(id); err != nil || !ok {
+					t.Fatalf("Reactivate(%d): %v, %v", id, ok, err)
+				}
+				if d, _ := store.Doctors.Get(id); d.Status != want {
+					t.Fatalf("status %q, want %q", d.Status, want)
+				}
+				if ok, _ := store.Doctors.Reactivate(id); ok {
+					t.Fatalf("Reactivate(%d) of an account that is not deactivated succeeded", id)
+				}
+			}
+			if ok, _ := store.Doctors.Deactivate(999); ok {
+				t.Fatal("Deactivate of an unknown doctor succeeded")
+			}
+		}},
+		{"failed logins count up and lock the account", func(t *testing.T, store *Store) {
+			id := createDoctor(t, store, "Dr Tan", "tan@example.com", "", []byte("hash"))
+			for want := 1; want <= 3; want++ {
+				if got, err := store.Doctors.IncrementFailedLogins(id); err != nil || got != want {
+					t.Fatalf("IncrementFailedLogins: %d, %v, want %d", got, err, want)
+				}
+			}
+			if err := store.Doctors.LockFor(id, 10*time.Minute); err != nil {
+				t.Fatalf("LockFor: %v", err)
+			}
+			// A shorter lockout does not cut the current one short
+			if err := store.Doctors.LockFor(id, time.Minute); err != nil {
+				t.Fatalf("LockFor: %v", err)
+			}
+			d, _ := store.Doctors.Get(id)
+			if d.FailedLoginCount != 3 || d.LockedFor < 9*time.Minute || d.LockedFor > 10*time.Minute {
+				t.Fatalf("%d failed logins, locked for %v, want 3 and about 10m", d.FailedLoginCount, d.LockedFor)
+			}
+
+			if err := store.Doctors.ResetFailedLogins(id); err != nil {
+				t.Fatalf("ResetFailedLogins: %v", err)
+			}
+			if d, _ := store.Doctors.Get(id); d.FailedLoginCount != 0 || d.LockedFor != 0 {
+				t.Fatalf("%d failed logins, locked for %v after reset", d.FailedLoginCount, d.LockedFor)
+			}
+			if got, err := store.Doctors.IncrementFailedLogins(999); err != nil || got != 0 {
+				t.Fatalf("IncrementFailedLogins of an unknown doctor: %d, %v", got, err)
+			}
+		}},
+		{"two-factor setup, steps and recovery codes", func(t *testing.T, store *Store) {
+			id := createDoctor(t, store, "Dr Tan", "tan@example.com", "", []byte("hash"))
+			if err := store.TwoFactor.SetSecret(id, "first-secret"); err != nil {
+				t.Fatalf("SetSecret: %v", err)
+			}
+			if err := store.TwoFactor.Enable(id, []string{"code-1", "code-2"}); err != nil {
+				t.Fatalf("Enable: %v", err)
+			}
+			// Once enabled, a new setup cannot replace the secret
+			store.TwoFactor.SetSecret(id, "second-secret")
+			if d, _ := store.Doctors.Get(id); !d.TOTPEnabled || d.TOTPSecret != "first-secret" {
+				t.Fatalf("enabled %v with secret %q, want the first secret", d.TOTPEnabled, d.TOTPSecret)
+			}
+
+			steps := []struct {
+				step int64
+				want bool
+			}{{100, true}, {100, false}, {99, false}, {101, true}}
+			for _, s := range steps {
+				if got, err := store.TwoFactor.AdvanceStep(id, s.step); err != nil || got != s.want {
+					t.Fatalf("AdvanceStep(%d): %v, %v, want %v", s.step, got, err, s.want)
+				}
+			}
+
+			if ok, _ := store.TwoFactor.UseRecoveryCode(id, "code-1"); !ok {
+				t.Fatal("UseRecoveryCode of an unused code failed")
+			}
+			if ok, _ := store.TwoFactor.UseRecoveryCode(id, "code-1"); ok {
+				t.Fatal("a recovery code worked twice")
+			}
+			if err := store.TwoFactor.ReplaceRecoveryCodes(id, []string{"code-3"}); err != nil {
+				t.Fatalf("ReplaceRecoveryCodes: %v", err)
+			}
+			if ok, _ := store.TwoFactor.UseRecoveryCode(id, "code-2"); ok {
+				t.Fatal("a replaced recovery code still works")
+			}
+
+			if err := store.TwoFactor.Disable(id); err != nil {
+				t.Fatalf("Disable: %v", err)
+			}
+			if d, _ := store.Doctors.Get(id); d.TOTPEnabled || d.TOTPSecret != "" {
+				t.Fatalf("enabled %v with secret %q after disabling", d.TOTPEnabled, d.TOTPSecret)
+			}
+			if ok, _ := store.TwoFactor.UseRecoveryCode(id, "code-3"); ok {
+				t.Fatal("a recovery code works after disabling")
+			}
+		}},
+		{"an invitation uses up earlier ones and is accepted once", func(t *testing.T, store *Store) {
+			id := createDoctor(t, store, "Dr Tan", "tan@example.com", "", nil)
+			for _, hash := range []string{"invite-1", "invite-2"} {
+				if err := store.Invitations.Create(id, hash, time.Now().Add(time.Hour)); err != nil {
+					t.Fatalf("Create: %v", err)
+				}
+			}
+			if first, _ := store.Invitations.GetByToken("invite-1"); !first.Used {
+				t.Fatal("an earlier invitation was not used up")
+			}
+			invitation, err := store.Invitations.GetByToken("invite-2")
+			if err != nil || invitation.DoctorID != id || invitation.Used {
+				t.Fatalf("GetByToken: %+v, %v", invitation, err)
+			}
+			if ok, err := store.Invitations.Accept(invitation.ID, id, []byte("hash")); err != nil || !ok {
+				t.Fatalf("Accept: %v, %v", ok, err)
+			}
+			if d, _ := store.Doctors.Get(id); d.Status != statusActive || d.PasswordHash != "hash" {
+				t.Fatalf("status %q with hash %q after accepting", d.Status, d.PasswordHash)
+			}
+			if ok, _ := store.Invitations.Accept(invitation.ID, id, []byte("other")); ok {
+				t.Fatal("an invitation was accepted twice")
+			}
+			if _, err := store.Invitations.GetByToken("unknown"); err != errTokenNotFound {
+				t.Fatalf("GetByToken: err = %v, want errTokenNotFound", err)
+			}
+		}},
+		{"an invitation is refused once the doctor is deactivated", func(t *testing.T, store *Store) {
+			id := createDoctor(t, store, "Dr Tan", "tan@example.com", "", nil)
+			store.Invitations.Create(id, "invite", time.Now().Add(time.Hour))
+			invitation, _ := store.Invitations.GetByToken("invite")
+			store.Doctors.Deactivate(id)
+			if ok, _ := store.Invitations.Accept(invitation.ID, id, []byte("hash")); ok {
+				t.Fatal("a deactivated doctor accepted an invitation")
+			}
+			if d, _ := store.Doctors.Get(id); d.Status != statusDeactivated || d.PasswordHash != "" {
+				t.Fatalf("status %q with hash %q", d.Status, d.PasswordHash)
+			}
+		}},
+		{"login challenges count attempts and are used once", func(t *testing.T, store *Store) {
+			id := createDoctor(t, store, "Dr Tan", "tan@example.com", "", []byte("hash"))
+			if err := store.LoginChallenges.Create(id, "challenge", time.Now().Add(5*time.Minute)); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			challenge, err := store.LoginChallenges.GetByToken("challenge")
+			if err != nil || challenge.DoctorID != id || challenge.Attempts != 0 || challenge.Used {
+				t.Fatalf("GetByToken: %+v, %v", challenge, err)
+			}
+			for range 2 {
+				if err := store.LoginChallenges.AddAttempt(challenge.ID); err != nil {
+					t.Fatalf("AddAttempt: %v", err)
+				}
+			}
+			if ok, err := store.LoginChallenges.Use(challenge.ID); err != nil || !ok {
+				t.Fatalf("Use: %v, %v", ok, err)
+			}
+			if ok, _ := store.LoginChallenges.Use(challenge.ID); ok {
+				t.Fatal("a challenge was used twice")
+			}
+			if challenge, _ := store.LoginChallenges.GetByToken("challenge"); challenge.Attempts != 2 || !challenge.Used {
+				t.Fatalf("got %+v, want 2 attempts and used", challenge)
+			}
+		}},
+		{"settings", func(t *testing.T, store *Store) {
+			if value, err := store.Settings.Get("unset"); err != nil || value != "" {
+				t.Fatalf("Get of an unset key: %q, %v", value, err)
+			}
+			for _, value := range []string{"true", "false"} {
+				if err := store.Settings.Set(requireTwoFactorSetting, value, 1); err != nil {
+					t.Fatalf("Set: %v", err)
+				}
+				if got, _ := store.Settings.Get(requireTwoFactorSetting); got != value {
+					t.Fatalf("got %q, want %q", got, value)
+				}
+			}
+		}},
+		{"assignments list by patient then latest start, with the doctor's name", func(t *testing.T, store *Store) {
+			tan := createDoctor(t, store, "Dr Tan", "tan@example.com", "", []byte("hash"))
+			lim := createDoctor(t, store, "Dr Lim", "lim@example.com", "", []byte("hash"))
+			older := createAssignment(t, store, tan, 7, assignmentPrimary, day(-30), day(-10))
+			current := createAssignment(t, store, lim, 7, assignmentPrimary, day(-9), "")
+			other := createAssignment(t, store, tan, 5, assignmentSecondary, day(-1), day(30))
+
+			filters := []struct {
+				name   string
+				filter AssignmentFilter
+				want   []int
+			}{
+				{"all", AssignmentFilter{}, []int{other, current, older}},
+				{"by doctor", AssignmentFilter{DoctorID: tan}, []int{other, older}},
+				{"by patient", AssignmentFilter{UserID: 7}, []int{current, older}},
+				{"in effect today", AssignmentFilter{Active: true}, []int{other, current}},
+			}
+			for _, f := range filters {
+				assignments, err := store.Assignments.List(f.filter)
+				if err != nil {
+					t.Fatalf("%s: %v", f.name, err)
+				}
+				if len(assignments) != len(f.want) {
+					t.Fatalf("%s: got %+v, want IDs %v", f.name, assignments, f.want)
+				}
+				for i, a := range assignments {
+					if a.AssignmentID != f.want[i] {
+						t.Fatalf("%s: got %+v, want IDs %v", f.name, assignments, f.want)
+					}
+				}
+			}
+
+			a, err := store.Assignments.Get(current)
+			if err != nil || a.DoctorID != lim || a.DoctorName != "Dr Lim" || a.UserID != 7 ||
+				a.Role != assignmentPrimary || a.StartDate != day(-9) || a.EndDate != "" {
+				t.Fatalf("Get: %+v, %v", a, err)
+			}
+			if _, err := store.Assignments.Get(999); err != errAssignmentNotFound {
+				t.Fatalf("Get: err = %v, want errAssignmentNotFound", err)
+			}
+		}},
+		{"overlapping assignments of the same patient", func(t *testing.T, store *Store) {
+			tan := createDoctor(t, store, "Dr Tan", "tan@example.com", "", []byte("hash"))
+			ended := createAssignment(t, store, tan, 7, assignmentPrimary, day(-30), day(-10))
+			open := createAssignment(t, store, tan, 7, assignmentPrimary, day(-9), "")
+			createAssignment(t, store, tan, 5, assignmentPrimary, day(-30), "")
+
+			checks := []struct {
+				name string
+				a    Assignment
+				want []int
+			}{
+				{"in the past", Assignment{UserID: 7, StartDate: day(-20), EndDate: day(-15)}, []int{ended}},
+				{"open-ended", Assignment{UserID: 7, StartDate: day(10)}, []int{open}},
+				{"spanning both", Assignment{UserID: 7, StartDate: day(-40), EndDate: day(-5)}, []int{open, ended}},
+				{"itself", Assignment{AssignmentID: open, UserID: 7, StartDate: day(-9)}, []int{}},
+				{"before any", Assignment{UserID: 7, StartDate: day(-60), EndDate: day(-31)}, []int{}},
+			}
+			for _, c := range checks {
+				overlapping, err := store.Assignments.Overlapping(c.a)
+				if err != nil {
+					t.Fatalf("%s: %v", c.name, err)
+				}
+				if len(overlapping) != len(c.want) {
+					t.Fatalf("%s: got %+v, want IDs %v", c.name, overlapping, c.want)
+				}
+				for i, a := range overlapping {
+					if a.AssignmentID != c.want[i] {
+						t.Fatalf("%s: got %+v, want IDs %v", c.name, overlapping, c.want)
+					}
+				}
+			}
+		}},
+		{"update and delete assignments", func(t *testing.T, store *Store) {
+			tan := createDoctor(t, store, "Dr Tan", "tan@example.com", "", []byte("hash"))
+			id := createAssignment(t, store, tan, 7, assignmentPrimary, day(-9), "")
+			createAssignment(t, store, tan, 7, assignmentSecondary, day(-30), day(-10))
+			createAssignment(t, store, tan, 5, assignmentPrimary, day(-9), "")
+
+			changed := Assignment{AssignmentID: id, Role: assignmentSecondary, StartDate: day(-5), EndDate: day(5)}
+			if err := store.Assignments.Update(changed); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if a, _ := store.Assignments.Get(id); a.Role != changed.Role || a.StartDate != changed.StartDate || a.EndDate != changed.EndDate || a.UserID != 7 {
+				t.Fatalf("got %+v", a)
+			}
+
+			if ok, err := store.Assignments.Delete(id); err != nil || !ok {
+				t.Fatalf("Delete: %v, %v", ok, err)
+			}
+			if ok, _ := store.Assignments.Delete(id); ok {
+				t.Fatal("an assignment was deleted twice")
+			}
+			if n, err := store.Assignments.DeleteByUser(7); err != nil || n != 1 {
+				t.Fatalf("DeleteByUser: %d, %v, want 1", n, err)
+			}
+			if remaining, _ := store.Assignments.List(AssignmentFilter{}); len(remaining) != 1 || remaining[0].UserID != 5 {
+				t.Fatalf("remaining %+v, want only patient 5's", remaining)
+			}
+		}},
+		{"the care team is the active doctors assigned today, primary first", func(t *testing.T, store *Store) {
+			primary := createDoctor(t, store, "Dr Zed", "zed@example.com", "", []byte("hash"))
+			secondary := createDoctor(t, store, "Dr Amy", "amy@example.com", "", []byte("hash"))
+			former := createDoctor(t, store, "Dr Former", "former@example.com", "", []byte("hash"))
+			left := createDoctor(t, store, "Dr Left", "left@example.com", "", []byte("hash"))
+			createAssignment(t, store, secondary, 7, assignmentSecondary, day(-5), "")
+			createAssignment(t, store, primary, 7, assignmentPrimary, day(-5), day(5))
+			createAssignment(t, store, former, 7, assignmentPrimary, day(-30), day(-6))
+			createAssignment(t, store, left, 7, assignmentSecondary, day(-5), "")
+			createAssignment(t, store, primary, 5, assignmentPrimary, day(-5), "")
+			store.Doctors.Deactivate(left)
+
+			team, err := store.Assignments.CareTeam(7)
+			if err != nil {
+				t.Fatalf("CareTeam: %v", err)
+			}
+			want := []CareTeamMember{
+				{DoctorID: primary, Name: "Dr Zed", Email: "zed@example.com", Specialty: "Geriatrics", Role: assignmentPrimary},
+				{DoctorID: secondary, Name: "Dr Amy", Email: "amy@example.com", Specialty: "Geriatrics", Role: assignmentSecondary},
+			}
+			if len(team) != len(want) || team[0] != want[0] || team[1] != want[1] {
+				t.Fatalf("got %+v, want %+v", team, want)
+			}
+			if team, _ := store.Assignments.CareTeam(9); len(team) != 0 {
+				t.Fatalf("a patient without assignments has care team %+v", team)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+
+	t.Run("sessions", func(t *testing.T) {
+		accounttest.Sessions(t, func(t *testing.T) (account.SessionRepository, int, int) {
+			store := newStore(t)
+			return store.Sessions, createDoctor(t, store, "First", "first@example.com", "", []byte("hash")),
+				createDoctor(t, store, "Second", "second@example.com", "", []byte("hash"))
+		})
+	})
+	t.Run("login failures", func(t *testing.T) {
+		accounttest.LoginFailures(t, func(t *testing.T) account.LoginFailureRepository { return newStore(t).LoginFailures })
+	})
+}
+
+func TestMemoryRepositories(t *testing.T) {
+	testRepositories(t, func(t *testing.T) *Store { return newMemoryStore() })
+}
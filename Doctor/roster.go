@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"common/auth"
+	"common/respond"
+)
+
+// Client for calls to other services, so a slow service cannot hold a request open
+var serviceClient = &http.Client{Timeout: 10 * time.Second}
+
+// Patients whose details are fetched at once when building a roster
+const rosterConcurrency = 8
+
+// Call another service on behalf of the caller and decode its JSON reply.
+// Returns the status code so callers can tell "no data yet" (404) apart from failures.
+func callService(method, url string, body interface{}, authHeader string, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		requestBody, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewBuffer(requestBody)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Latest risk assessment as returned by the Self Assessment service
+type latestRisk struct {
+	AssessmentID int    `json:"id"`
+	Instrument   string `json:"instrument"`
+	TotalScore   int    `json:"totalScore"`
+	RiskLevel    string `json:"riskLevel"`
+}
+
+// Latest vision result as returned by the Vision Assessment service
+type latestVision struct {
+	ID            int    `json:"ID"`
+	LeftEyeScore  int    `json:"LeftEyeScore"`
+	RightEyeScore int    `json:"RightEyeScore"`
+	CreatedAt     string `json:"CreatedAt"`
+}
+
+// One patient on a doctor's roster
+type RosterEntry struct {
+	UserID       int           `json:"user_id"`
+	Name         string        `json:"name"`
+	Role         string        `json:"role"`
+	StartDate    string        `json:"start_date"`
+	LatestRisk   *latestRisk   `json:"latest_risk"`
+	LatestVision *latestVision `json:"latest_vision"`
+}
+
+// Patients with the highest risk are listed first
+var riskRank = map[string]int{"High": 0, "Moderate": 1, "Low": 2}
+
+func rosterRank(entry RosterEntry) int {
+	if entry.LatestRisk == nil {
+		return len(riskRank)
+	}
+	if rank, ok := riskRank[entry.LatestRisk.RiskLevel]; ok {
+		return rank
+	}
+	return len(riskRank)
+}
+
+// Fill in a patient's name and latest results from the User, Self Assessment and Vision services
+func loadRosterEntry(entry *RosterEntry, authHeader string) {
+	var user struct {
+		Name string `json:"name"`
+	}
+	if _, err := callService("POST", cfg.Services.User+"/api/getUserDetails", map[string]int{"user_id": entry.UserID}, authHeader, &user); err != nil {
+		log.Println("Failed to fetch user details:", err)
+	}
+	entry.Name = user.Name
+
+	var risk latestRisk
+	status, err := callService("POST", cfg.Services.SelfAssessment+"/api/getLastAssessment", map[string]int{"user_id": entry.UserID}, authHeader, &risk)
+	if err != nil {
+		log.Println("Failed to fetch latest assessment:", err)
+	} else if status == http.StatusOK {
+		entry.LatestRisk = &risk
+	}
+
+	var vision latestVision
+	status, err = callService("GET", fmt.Sprintf("%s/getLatestResult?userID=%d", cfg.Services.VisionAssessment, entry.UserID), nil, authHeader, &vision)
+	if err != nil {
+		log.Println("Failed to fetch latest vision result:", err)
+	} else if status == http.StatusOK {
+		entry.LatestVision = &vision
+	}
+}
+
+// List the logged in doctor's current patients with their latest risk level and vision scores
+func rosterHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+
+	assignments, err := store.Assignments.List(AssignmentFilter{DoctorID: claims.Subject, Active: true})
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Each patient's details are also recorded by the service they are read from
+	if !store.Audit.Access(w, r, "patient_roster", "", 0) {
+		return
+	}
+
+	// Patients are filled in side by side, a few at a time
+	roster := make([]RosterEntry, len(assignments))
+	slots := make(chan struct{}, rosterConcurrency)
+	var wg sync.WaitGroup
+	for i, a := range assignments {
+		roster[i] = RosterEntry{UserID: a.UserID, Role: a.Role, StartDate: a.StartDate}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(entry *RosterEntry) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			loadRosterEntry(entry, r.Header.Get("Authorization"))
+		}(&roster[i])
+	}
+	wg.Wait()
+
+	sort.SliceStable(roster, func(i, j int) bool {
+		return rosterRank(roster[i]) < rosterRank(roster[j])
+	})
+
+	respond.JSON(w, roster)
+}
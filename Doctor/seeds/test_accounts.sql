@@ -0,0 +1,13 @@
+-- Test accounts for development, under fixed IDs so running the seed again changes nothing
+
+-- Test doctor with a hashed password
+INSERT IGNORE INTO Doctors (DoctorID, Name, Email, PasswordHash, Specialty, Clinic, LicenseNumber) VALUES
+(1, 'Dr. John Doe', 'johndoe7@gmail.com', '$2a$10$.Nl5tbg7enAt9PUEG.DBe.DHj0vRrldExrcaLN9e9I6dZ.bMPy/ha', 'Geriatric Medicine', 'Bukit Merah Clinic', 'M12345A');
+
+-- Test clinic admin (password: ClinicAdmin#2025, change it after the first login)
+INSERT IGNORE INTO Doctors (DoctorID, Name, Email, PasswordHash, Role) VALUES
+(2, 'Clinic Admin', 'admin@befrienders.sg', '$2a$10$8jSTPBbAODr9tw4RfDjmaO80iKMw0Glk1KoxREsAs94R3XcXqvxum', 'admin');
+
+-- Test system admin (password: SystemAdmin#2025, change it after the first login)
+INSERT IGNORE INTO Doctors (DoctorID, Name, Email, PasswordHash, Role) VALUES
+(3, 'System Admin', 'sysadmin@befrienders.sg', '$2a$10$6xPJAHhyycvriGIixBEWKeAgCFCKpaL3dGVCJ/JEMBkdM34/Nydw.', 'sysadmin');
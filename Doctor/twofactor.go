@@ -0,0 +1,579 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"common/account"
+	"common/auth"
+	"common/respond"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTP (RFC 6238) settings, matching the defaults of common authenticator apps
+const (
+	totpIssuer = "Befrienders"
+	totpPeriod = 30 // Seconds per code
+	totpDigits = 6
+	totpSkew   = 1 // Codes from one step either side are accepted to allow for clock drift
+)
+
+// Second login step settings
+const (
+	recoveryCodeCount    = 10
+	loginChallengeTTL    = 5 * time.Minute
+	maxChallengeAttempts = 5
+)
+
+// ClinicSettings key for the clinic-wide two-factor requirement
+const requireTwoFactorSetting = "require_two_factor"
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generate a random 160-bit TOTP secret, base32 encoded for authenticator apps
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// Compute the code for a time step (RFC 4226 HOTP with HMAC-SHA1)
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+// Return the time step the code belongs to, if it matches the secret at the given time
+func matchTOTP(secret string, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// otpauth:// URI that authenticator apps import from a QR code
+func totpURI(secret string, email string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+email) + "?" + query.Encode()
+}
+
+// Check a TOTP code for the doctor, refusing a code whose time step was already used
+func verifyTOTP(store *Store, doctorID int, code string) (bool, error) {
+	doctor, err := store.Doctors.Get(doctorID)
+	if err != nil || doctor.TOTPSecret == "" {
+		return false, err
+	}
+
+	step, ok := matchTOTP(doctor.TOTPSecret, strings.TrimSpace(code), time.Now())
+	if !ok || step <= doctor.TOTPLastStep {
+		return false, nil
+	}
+
+	// Record the step, guarding against the same code being submitted twice concurrently
+	return store.TwoFactor.AdvanceStep(doctorID, step)
+}
+
+// Recovery codes are compared without dashes, spaces or case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	return strings.ToLower(code)
+}
+
+// Generate a new set of recovery codes, returning the plain codes to show once and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b)) // 8 characters
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, account.HashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// Turn on two-factor for the doctor and issue their first recovery codes
+func enableTwoFactor(twoFactor TwoFactorRepository, doctorID int) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	return codes, twoFactor.Enable(doctorID, hashes)
+}
+
+// Whether clinic admins have made two-factor mandatory for every doctor account
+func twoFactorRequired(settings SettingsRepository) (bool, error) {
+	value, err := settings.Get(requireTwoFactorSetting)
+	return value == "true", err
+}
+
+// Store a fresh pending secret for the doctor and return what the authenticator app needs
+func beginTwoFactorSetup(store *Store, doctorID int) (map[string]string, error) {
+	doctor, err := store.Doctors.Get(doctorID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := store.TwoFactor.SetSecret(doctorID, secret); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"secret":      secret,
+		"otpauth_uri": totpURI(secret, doctor.Email),
+	}, nil
+}
+
+// Start the second login step and return the token the client presents with its code
+func startLoginChallenge(challenges LoginChallengeRepository, doctorID int) (string, error) {
+	token, err := account.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	return token, challenges.Create(doctorID, account.HashToken(token), time.Now().Add(loginChallengeTTL))
+}
+
+// Look up an unused, unexpired login challenge, returning 0 if it is not valid
+func lookupLoginChallenge(challenges LoginChallengeRepository, token string) (int, int, error) {
+	challenge, err := challenges.GetByToken(account.HashToken(token))
+	if err == errTokenNotFound {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	if challenge.Used || challenge.Attempts >= maxChallengeAttempts || time.Now().After(challenge.ExpiresAt) {
+		return 0, 0, nil
+	}
+	return challenge.ID, challenge.DoctorID, nil
+}
+
+// Begin two-factor enrollment for the logged in doctor
+func setupTwoFactorHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+	if !isDoctorAccount(claims) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	doctor, err := store.Doctors.Get(claims.Subject)
+	if err == errDoctorNotFound {
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if doctor.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	response, err := beginTwoFactorSetup(store, claims.Subject)
+	if err != nil {
+		log.Println("Two-factor setup error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, response)
+}
+
+// Finish enrollment by proving the authenticator app produces valid codes
+func confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+	if !isDoctorAccount(claims) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	doctor, err := store.Doctors.Get(claims.Subject)
+	if err == errDoctorNotFound {
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if doctor.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if doctor.TOTPSecret == "" {
+		http.Error(w, "Two-factor setup has not been started", http.StatusBadRequest)
+		return
+	}
+
+	valid, err := verifyTOTP(store, claims.Subject, request.Code)
+	if err != nil {
+		log.Println("Database error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid verification code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, err := enableTwoFactor(store.TwoFactor, claims.Subject)
+	if err != nil {
+		log.Println("Database error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]interface{}{
+		"message":        "Two-factor authentication enabled. Store your recovery codes somewhere safe.",
+		"recovery_codes": codes,
+	})
+}
+
+// Turn two-factor off, unless the clinic requires it
+func disableTwoFactorHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+	if !isDoctorAccount(claims) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Password == "" || request.Code == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	required, err := twoFactorRequired(store.Settings)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if required {
+		http.Error(w, "Two-factor authentication is required by your clinic", http.StatusForbidden)
+		return
+	}
+
+	// Require both the password and a current code
+	doctor, err := store.Doctors.Get(claims.Subject)
+	if err == errDoctorNotFound {
+		http.Error(w, "Doctor not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(doctor.PasswordHash), []byte(request.Password)) != nil {
+		http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+		return
+	}
+
+	valid, err := verifyTOTP(store, claims.Subject, request.Code)
+	if err != nil {
+		log.Println("Database error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid verification code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := store.TwoFactor.Disable(claims.Subject); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// Issue a new set of recovery codes, invalidating the old ones
+func regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+	if !isDoctorAccount(claims) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var request struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Code == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	valid, err := verifyTOTP(store, claims.Subject, request.Code)
+	if err != nil {
+		log.Println("Database error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid verification code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = store.TwoFactor.ReplaceRecoveryCodes(claims.Subject, hashes)
+	}
+	if err != nil {
+		log.Println("Database error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]interface{}{"recovery_codes": codes})
+}
+
+// Start enrollment during login, for doctors the clinic requires to use two-factor
+func loginTwoFactorSetupHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	var request struct {
+		MFAToken string `json:"mfa_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.MFAToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	challengeID, doctorID, err := lookupLoginChallenge(store.LoginChallenges, request.MFAToken)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if challengeID == 0 {
+		http.Error(w, "Login has expired, please log in again", http.StatusUnauthorized)
+		return
+	}
+
+	doctor, err := store.Doctors.Get(doctorID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if doctor.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	response, err := beginTwoFactorSetup(store, doctorID)
+	if err != nil {
+		log.Println("Two-factor setup error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, response)
+}
+
+// Complete login with a TOTP code or a recovery code. For a doctor enrolling during
+// login the first valid code also turns two-factor on.
+func loginTwoFactorHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	var request struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.MFAToken == "" || (request.Code == "" && request.RecoveryCode == "") {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	challengeID, doctorID, err := lookupLoginChallenge(store.LoginChallenges, request.MFAToken)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if challengeID == 0 {
+		http.Error(w, "Login has expired, please log in again", http.StatusUnauthorized)
+		return
+	}
+
+	doctor, err := store.Doctors.Get(doctorID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// A lockout reads as a wrong code, so it does not show which accounts exist
+	if doctor.LockedFor > 0 {
+		store.accounts().RecordFailedLogin(auth.ClientIP(r), 0)
+		http.Error(w, "Invalid verification code", http.StatusUnauthorized)
+		return
+	}
+
+	// Recovery codes only exist once two-factor is enabled
+	var valid bool
+	if request.RecoveryCode != "" && doctor.TOTPEnabled {
+		valid, err = store.TwoFactor.UseRecoveryCode(doctorID, account.HashToken(normalizeRecoveryCode(request.RecoveryCode)))
+	} else if request.Code != "" {
+		valid, err = verifyTOTP(store, doctorID, request.Code)
+	}
+	if err != nil {
+		log.Println("Database error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Wrong codes count towards both the challenge limit and the account lockout
+	if !valid {
+		if err := store.LoginChallenges.AddAttempt(challengeID); err != nil {
+			log.Println("Database update error:", err)
+		}
+		store.accounts().RecordFailedLogin(auth.ClientIP(r), doctorID)
+		http.Error(w, "Invalid verification code", http.StatusUnauthorized)
+		return
+	}
+
+	// Consume the challenge, guarding against it being completed twice concurrently
+	used, err := store.LoginChallenges.Use(challengeID)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !used {
+		http.Error(w, "Login has expired, please log in again", http.StatusUnauthorized)
+		return
+	}
+
+	if doctor.FailedLoginCount > 0 {
+		if err := store.Doctors.ResetFailedLogins(doctorID); err != nil {
+			log.Println("Database error:", err)
+		}
+	}
+
+	response := map[string]interface{}{
+		"message":   "Authentication successful",
+		"doctor_id": doctorID,
+		"role":      doctor.Role,
+	}
+
+	if !doctor.TOTPEnabled {
+		codes, err := enableTwoFactor(store.TwoFactor, doctorID)
+		if err != nil {
+			log.Println("Database error:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		response["recovery_codes"] = codes
+	}
+
+	token, refreshToken, err := store.accounts().CreateSession(doctorID, doctor.Role, r.UserAgent())
+	if err != nil {
+		log.Println("Session creation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	response["token"] = token
+	response["refresh_token"] = refreshToken
+	response["expires_in"] = int(auth.TokenTTL.Seconds())
+
+	respond.JSON(w, response)
+}
+
+// Show whether two-factor is mandatory for every doctor
+func getTwoFactorPolicyHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	required, err := twoFactorRequired(store.Settings)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]bool{"required": required})
+}
+
+// Let a clinic admin make two-factor mandatory (or optional) for every doctor
+func updateTwoFactorPolicyHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	var request struct {
+		Required *bool `json:"required"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Required == nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := store.Settings.Set(requireTwoFactorSetting, fmt.Sprint(*request.Required), auth.ClaimsFromRequest(r).Subject); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Two-factor requirement set to %t by admin %d\n", *request.Required, auth.ClaimsFromRequest(r).Subject)
+	respond.JSON(w, map[string]bool{"required": *request.Required})
+}
@@ -0,0 +1,13 @@
+package main
+
+import "common/config"
+
+// Settings of the Email service, loaded by config.Load
+type Config struct {
+	Port        int `env:"LOCAL_PORT" default:"8090" validate:"port"`
+	SMTP        config.SMTP
+	Services    config.Services
+	ClinicInbox string `env:"CLINIC_INBOX" default:"s10247445@connect.np.edu.sg"` // Receives alerts for patients without an assigned doctor
+}
+
+var cfg Config
@@ -2,6 +2,15 @@ module email_service
 
 go 1.23.2
 
-require gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
+require (
+	common v0.0.0
+	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
+)
 
-require gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+require (
+	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/rs/cors v1.11.1 // indirect
+	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+)
+
+replace common => ../Common
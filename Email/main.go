@@ -1,13 +1,20 @@
 package main
 
 import (
-	"crypto/tls"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 
+	"common/config"
+	"common/consent"
+	"common/respond"
+	"common/server"
+
 	"gopkg.in/gomail.v2"
 )
 
@@ -19,16 +26,107 @@ type VisionResult struct {
 	Comments      string `json:"Comments"`
 }
 
-// Function to send email using Gmail SMTP
-// Function to send email using Gmail SMTP
-func sendEmailToDoctor(result VisionResult) error {
+// Look up the email addresses of the doctors currently assigned to the user
+func fetchCareTeamEmails(userID int, authHeader string) ([]string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/careTeam/%d", cfg.Services.Doctor, userID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doctor service returned %s", resp.Status)
+	}
+
+	var careTeam []struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&careTeam); err != nil {
+		return nil, err
+	}
+
+	emails := make([]string, 0, len(careTeam))
+	for _, doctor := range careTeam {
+		emails = append(emails, doctor.Email)
+	}
+	return emails, nil
+}
+
+// Look up the senior's name and the email addresses of the caregivers they have approved
+func fetchCaregivers(userID int, authHeader string) (string, []string, error) {
+	req, err := http.NewRequest("GET", cfg.Services.User+"/api/caregivers", nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("user service returned %s", resp.Status)
+	}
+
+	var caregivers []struct {
+		Email  string `json:"email"`
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&caregivers); err != nil {
+		return "", nil, err
+	}
+
+	emails := []string{}
+	for _, caregiver := range caregivers {
+		if caregiver.Status == "Active" {
+			emails = append(emails, caregiver.Email)
+		}
+	}
+	if len(emails) == 0 {
+		return "", emails, nil
+	}
+
+	// Name the senior so caregivers looking after several know who the email is about
+	detailsBody, _ := json.Marshal(map[string]int{"user_id": userID})
+	req, err = http.NewRequest("POST", cfg.Services.User+"/api/getUserDetails", bytes.NewBuffer(detailsBody))
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authHeader)
+
+	detailsResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer detailsResp.Body.Close()
+
+	var senior struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(detailsResp.Body).Decode(&senior); err != nil {
+		return "", nil, err
+	}
+	return senior.Name, emails, nil
+}
+
+// Function to send email through the configured SMTP server
+func sendEmailToDoctor(result VisionResult, recipients []string) error {
 	m := gomail.NewMessage()
-	m.SetHeader("From", "newuploadedvideo@gmail.com") // Sender's email
-	m.SetHeader("To", "s10247445@connect.np.edu.sg")  // Doctor's email
+	m.SetHeader("From", cfg.SMTP.Username) // Sender's email
+	m.SetHeader("To", recipients...)       // The user's doctors
 	m.SetHeader("Subject", "Urgent: Vision Test Report for User ID "+strconv.Itoa(result.UserID))
 
 	// Construct the correct report URL using visionAssessment_id
-	reportURL := fmt.Sprintf("http://localhost:5500/report.html?visionAssessment_id=%d", result.ID)
+	reportURL := fmt.Sprintf("%s/report.html?visionAssessment_id=%d", cfg.Services.FrontEnd, result.ID)
 
 	// Email body with correct report link
 	body := fmt.Sprintf(`
@@ -43,9 +141,13 @@ func sendEmailToDoctor(result VisionResult) error {
 
 	m.SetBody("text/html", body)
 
-	// Configure Gmail SMTP settings
-	d := gomail.NewDialer("smtp.gmail.com", 587, "newuploadedvideo@gmail.com", "agof rvwb lreo tups")
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true} // Needed for Gmail
+	return dialAndSend(m)
+}
+
+// Send a message through the configured SMTP server
+func dialAndSend(m *gomail.Message) error {
+	// Configure SMTP settings. STARTTLS checks the server's certificate against cfg.SMTP.Host.
+	d := gomail.NewDialer(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password.Value())
 
 	// Send email
 	if err := d.DialAndSend(m); err != nil {
@@ -54,6 +156,96 @@ func sendEmailToDoctor(result VisionResult) error {
 	return nil
 }
 
+// Email a risk notification to the senior's caregivers. They are sent one blind-copied email
+// so they do not see each other's addresses.
+func sendCaregiverNotificationEmail(seniorName, message string, recipients []string) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", cfg.SMTP.Username)
+	m.SetHeader("To", cfg.SMTP.Username)
+	m.SetHeader("Bcc", recipients...)
+	m.SetHeader("Subject", "Befrienders: Assessment Notification for "+seniorName)
+
+	body := fmt.Sprintf(`
+		<h2>Assessment Notification</h2>
+		<p>%s has just completed a fall risk assessment.</p>
+		<p>%s</p>
+		<p><a href="%s/caregiver.html" style="color: #007bff; font-weight: bold;">View Their History</a></p>
+	`, html.EscapeString(seniorName), html.EscapeString(message), cfg.Services.FrontEnd)
+
+	m.SetBody("text/html", body)
+
+	return dialAndSend(m)
+}
+
+// Function to send a password reset link to a user
+func sendPasswordResetEmail(email, name, token string) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", cfg.SMTP.Username)
+	m.SetHeader("To", email)
+	m.SetHeader("Subject", "Reset your Befrienders password")
+
+	// The link is built here so callers cannot inject arbitrary URLs
+	resetURL := fmt.Sprintf("%s/resetPassword.html?token=%s", cfg.Services.FrontEnd, url.QueryEscape(token))
+
+	body := fmt.Sprintf(`
+		<h2>Password Reset</h2>
+		<p>Hello %s,</p>
+		<p>We received a request to reset your password. This link expires in 1 hour and can only be used once.</p>
+		<p><a href="%s" style="color: #007bff; font-weight: bold;">Reset your password</a></p>
+		<p>If you did not request this, you can ignore this email.</p>
+	`, html.EscapeString(name), resetURL)
+
+	m.SetBody("text/html", body)
+
+	return dialAndSend(m)
+}
+
+// Function to send an email address verification link to a newly registered user
+func sendVerificationEmail(email, name, token string) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", cfg.SMTP.Username)
+	m.SetHeader("To", email)
+	m.SetHeader("Subject", "Verify your Befrienders email address")
+
+	// The link is built here so callers cannot inject arbitrary URLs
+	verifyURL := fmt.Sprintf("%s/verifyEmail.html?token=%s", cfg.Services.FrontEnd, url.QueryEscape(token))
+
+	body := fmt.Sprintf(`
+		<h2>Verify Your Email</h2>
+		<p>Hello %s,</p>
+		<p>Please confirm that this is your email address so that your doctors and caregivers can reach you. This link expires in 24 hours.</p>
+		<p><a href="%s" style="color: #007bff; font-weight: bold;">Verify my email address</a></p>
+		<p>If you did not create a Befrienders account, you can ignore this email.</p>
+	`, html.EscapeString(name), verifyURL)
+
+	m.SetBody("text/html", body)
+
+	return dialAndSend(m)
+}
+
+// Function to send an account invitation to a doctor created by a clinic admin
+func sendDoctorInvitationEmail(email, name, token string) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", cfg.SMTP.Username)
+	m.SetHeader("To", email)
+	m.SetHeader("Subject", "You have been invited to Befrienders")
+
+	// The link is built here so callers cannot inject arbitrary URLs
+	inviteURL := fmt.Sprintf("%s/doctorSetPassword.html?token=%s", cfg.Services.FrontEnd, url.QueryEscape(token))
+
+	body := fmt.Sprintf(`
+		<h2>Welcome to Befrienders</h2>
+		<p>Hello %s,</p>
+		<p>Your clinic has created a doctor account for you. Please set your password to start reviewing your patients' fall risk assessments. This link expires in 7 days and can only be used once.</p>
+		<p><a href="%s" style="color: #007bff; font-weight: bold;">Set your password</a></p>
+		<p>If you were not expecting this invitation, you can ignore this email.</p>
+	`, html.EscapeString(name), inviteURL)
+
+	m.SetBody("text/html", body)
+
+	return dialAndSend(m)
+}
+
 // API Endpoint to receive and send emails
 func handleSendReportToDoctor(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -68,8 +260,23 @@ func handleSendReportToDoctor(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Results only go out with the senior's consent, checked here too as this endpoint can be called directly
+	if !consent.Granted(cfg.Services.User, result.UserID, consent.ShareDoctor, r.Header.Get("Authorization")) {
+		http.Error(w, "The user has not consented to sharing results with their doctors", http.StatusForbidden)
+		return
+	}
+
+	// Send to the user's doctors, using the caller's token to look them up
+	recipients, err := fetchCareTeamEmails(result.UserID, r.Header.Get("Authorization"))
+	if err != nil {
+		log.Println("Failed to fetch care team:", err)
+	}
+	if len(recipients) == 0 {
+		recipients = []string{cfg.ClinicInbox}
+	}
+
 	// Send email
-	err = sendEmailToDoctor(result)
+	err = sendEmailToDoctor(result, recipients)
 	if err != nil {
 		log.Println("Failed to send email:", err)
 		http.Error(w, "Failed to send report to doctor", http.StatusInternalServerError)
@@ -77,12 +284,143 @@ func handleSendReportToDoctor(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Success response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Report sent successfully to doctor"})
+	respond.JSON(w, map[string]string{"message": "Report sent successfully to doctor"})
+}
+
+// API Endpoint to notify a senior's approved caregivers of a risk assessment result
+func handleSendCaregiverNotification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		UserID  int    `json:"user_id"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.UserID <= 0 || request.Message == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Checked here too as this endpoint can be called directly
+	if !consent.Granted(cfg.Services.User, request.UserID, consent.ShareCaregiver, r.Header.Get("Authorization")) {
+		http.Error(w, "The user has not consented to sharing results with their caregivers", http.StatusForbidden)
+		return
+	}
+
+	// Look the caregivers up with the caller's token
+	seniorName, recipients, err := fetchCaregivers(request.UserID, r.Header.Get("Authorization"))
+	if err != nil {
+		log.Println("Failed to fetch caregivers:", err)
+		http.Error(w, "Failed to look up caregivers", http.StatusBadGateway)
+		return
+	}
+	if len(recipients) == 0 {
+		respond.JSON(w, map[string]string{"message": "No approved caregivers to notify"})
+		return
+	}
+
+	if err := sendCaregiverNotificationEmail(seniorName, request.Message, recipients); err != nil {
+		log.Println("Failed to send email:", err)
+		http.Error(w, "Failed to send caregiver notification", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Caregiver notification sent to %d caregiver(s)\n", len(recipients))
+	respond.JSON(w, map[string]string{"message": "Caregiver notification sent successfully"})
+}
+
+// API Endpoint to send a password reset link
+func handleSendPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" || request.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := sendPasswordResetEmail(request.Email, request.Name, request.Token); err != nil {
+		log.Println("Failed to send email:", err)
+		http.Error(w, "Failed to send password reset email", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Password reset email sent successfully"})
+}
+
+// API Endpoint to send an email verification link
+func handleSendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" || request.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := sendVerificationEmail(request.Email, request.Name, request.Token); err != nil {
+		log.Println("Failed to send email:", err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Verification email sent successfully"})
+}
+
+// API Endpoint to send a doctor account invitation
+func handleSendDoctorInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Email == "" || request.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := sendDoctorInvitationEmail(request.Email, request.Name, request.Token); err != nil {
+		log.Println("Failed to send email:", err)
+		http.Error(w, "Failed to send invitation email", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Invitation email sent successfully"})
 }
 
 func main() {
+	// Load settings from the environment and .env files, listing everything missing at once
+	config.MustLoad(&cfg)
+
 	http.HandleFunc("/sendReportToDoctor", handleSendReportToDoctor)
-	log.Println("Email microservice running on port 8090")
-	log.Fatal(http.ListenAndServe(":8090", nil)) // Running on port 8090
+	http.HandleFunc("/sendCaregiverNotification", handleSendCaregiverNotification)
+	http.HandleFunc("/sendPasswordReset", handleSendPasswordReset)
+	http.HandleFunc("/sendVerificationEmail", handleSendVerificationEmail)
+	http.HandleFunc("/sendDoctorInvitation", handleSendDoctorInvitation)
+
+	// Serve until stopped, letting requests in progress finish
+	if err := server.Run("Email", cfg.Port, http.DefaultServeMux); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
 }
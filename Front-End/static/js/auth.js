@@ -0,0 +1,75 @@
+// Session helpers shared by every page that calls a protected API
+
+// Login service that issued the current session (Doctor or User service)
+function authServiceUrl() {
+    return localStorage.getItem("doctor_id") ? "http://localhost:5004" : "http://localhost:5001";
+}
+
+// Store the tokens returned by authenticate/refresh
+function saveSession(data) {
+    localStorage.setItem("token", data.token);
+    localStorage.setItem("refresh_token", data.refresh_token);
+}
+
+// Remove every trace of the session from this device
+function clearSession() {
+    localStorage.removeItem("user_id");
+    localStorage.removeItem("doctor_id");
+    localStorage.removeItem("token");
+    localStorage.removeItem("refresh_token");
+}
+
+// Exchange the refresh token for a new access token.
+// Concurrent callers share one request, since each refresh token can only be used once.
+let refreshInFlight = null;
+function refreshSession() {
+    if (!refreshInFlight) {
+        refreshInFlight = (async () => {
+            const refreshToken = localStorage.getItem("refresh_token");
+            if (!refreshToken) {
+                return false;
+            }
+
+            const response = await fetch(authServiceUrl() + "/api/refresh", {
+                method: "POST",
+                headers: { "Content-Type": "application/json" },
+                body: JSON.stringify({ refresh_token: refreshToken })
+            });
+            if (!response.ok) {
+                return false;
+            }
+
+            saveSession(await response.json());
+            return true;
+        })().finally(() => { refreshInFlight = null; });
+    }
+    return refreshInFlight;
+}
+
+// fetch() wrapper that sends the access token and retries once after refreshing an expired one
+async function authFetch(url, options = {}) {
+    const send = () => fetch(url, {
+        ...options,
+        headers: { ...(options.headers || {}), "Authorization": "Bearer " + localStorage.getItem("token") }
+    });
+
+    let response = await send();
+    if (response.status === 401 && await refreshSession()) {
+        response = await send();
+    }
+    return response;
+}
+
+// Revoke this device's refresh token on the server and clear the local session
+function endSession() {
+    const refreshToken = localStorage.getItem("refresh_token");
+    if (refreshToken) {
+        fetch(authServiceUrl() + "/api/logout", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ refresh_token: refreshToken }),
+            keepalive: true // Let the request finish while the page navigates away
+        }).catch(error => console.error("Logout error:", error));
+    }
+    clearSession();
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// How the questionnaire result, contrast vision and age are combined into one fall risk, read
+// from the file named by RISK_COMPOSITE_FILE. Each component gives points from 0 (no added
+// risk) to 100, and the combined score is their weighted average.
+type CompositeModel struct {
+	Version          int                       `json:"version"`
+	Description      string                    `json:"description"`
+	Weights          CompositeWeights          `json:"weights"`
+	Questionnaire    map[string]map[string]int `json:"questionnaire"`       // Points by instrument, then risk level of the latest assessment
+	Vision           []ScoreStep               `json:"vision"`              // Points by the weaker eye's contrast score
+	VisionMaxAgeDays int                       `json:"vision_max_age_days"` // Older vision results are left out
+	Age              []ScoreStep               `json:"age"`                 // Points by age in years
+	Bands            []RiskBand                `json:"bands"`
+}
+
+// Percentages adding up to 100
+type CompositeWeights struct {
+	Questionnaire int `json:"questionnaire"`
+	Vision        int `json:"vision"`
+	Age           int `json:"age"`
+}
+
+// Points for a measured value: the first step whose max the value does not exceed. The last
+// step has no max.
+type ScoreStep struct {
+	Max    *int `json:"max"`
+	Points int  `json:"points"`
+}
+
+// What one component added to the combined score. A component without data is left out and
+// the weights of the others are scaled up to make up for it.
+type CompositeComponent struct {
+	Component    string      `json:"component"`
+	Available    bool        `json:"available"`
+	Input        interface{} `json:"input,omitempty"`      // Risk level, weaker eye's score or age
+	Instrument   string      `json:"instrument,omitempty"` // Questionnaire the risk level comes from
+	RecordID     int         `json:"record_id,omitempty"`  // Assessment or vision result used
+	Stale        bool        `json:"stale,omitempty"`      // Left out because the vision result is too old
+	Points       int         `json:"points"`
+	Weight       float64     `json:"weight"`       // Percentage after scaling
+	Contribution float64     `json:"contribution"` // Points times weight
+}
+
+// The components, in the order they are listed
+const (
+	componentQuestionnaire = "questionnaire"
+	componentVision        = "vision"
+	componentAge           = "age"
+)
+
+// Returned when the user has no questionnaire result to combine
+var errNoAssessment = errors.New("no assessment to combine")
+
+// How the Vision Assessment service sends CreatedAt, in the database's time zone
+const visionCreatedAtLayout = "2006-01-02 15:04:05"
+
+// Check the model is complete and consistent
+func (m *CompositeModel) validate() error {
+	if m.Version <= 0 {
+		return errors.New("version must be positive")
+	}
+	weights := []int{m.Weights.Questionnaire, m.Weights.Vision, m.Weights.Age}
+	if slices.Min(weights) < 0 || m.Weights.Questionnaire+m.Weights.Vision+m.Weights.Age != 100 {
+		return errors.New("weights must not be negative and must add up to 100")
+	}
+	if m.Questionnaire[defaultInstrument] == nil {
+		return fmt.Errorf("questionnaire needs points for %s", defaultInstrument)
+	}
+	// An instrument only needs points for the risk levels its bands use
+	for instrument, levels := range m.Questionnaire {
+		if len(levels) == 0 {
+			return fmt.Errorf("questionnaire has no points for %s", instrument)
+		}
+		for level, points := range levels {
+			if !slices.Contains(riskLevels, level) || points < 0 || points > 100 {
+				return fmt.Errorf("questionnaire: %s %s must be one of %v with points from 0 to 100", instrument, level, riskLevels)
+			}
+		}
+	}
+	if m.VisionMaxAgeDays <= 0 {
+		return errors.New("vision_max_age_days must be positive")
+	}
+	for name, steps := range map[string][]ScoreStep{componentVision: m.Vision, componentAge: m.Age} {
+		if err := validateSteps(steps); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return validateBands(m.Bands)
+}
+
+func validateSteps(steps []ScoreStep) error {
+	if len(steps) == 0 {
+		return errors.New("no steps")
+	}
+	for i, step := range steps {
+		if step.Points < 0 || step.Points > 100 {
+			return fmt.Errorf("step %d: points must be from 0 to 100", i+1)
+		}
+		last := i == len(steps)-1
+		if last != (step.Max == nil) {
+			return fmt.Errorf("step %d: every step but the last needs a max, and the last must have none", i+1)
+		}
+		if !last && i > 0 && *step.Max <= *steps[i-1].Max {
+			return fmt.Errorf("step %d: max must be higher than the step before", i+1)
+		}
+	}
+	return nil
+}
+
+func pointsFor(steps []ScoreStep, value int) int {
+	for _, step := range steps {
+		if step.Max == nil || value <= *step.Max {
+			return step.Points
+		}
+	}
+	return steps[len(steps)-1].Points
+}
+
+// Weigh the available components, filling in their weight and contribution, and find the
+// band the combined score falls in
+func (m *CompositeModel) combine(components []CompositeComponent) (float64, RiskBand) {
+	weights := map[string]int{
+		componentQuestionnaire: m.Weights.Questionnaire,
+		componentVision:        m.Weights.Vision,
+		componentAge:           m.Weights.Age,
+	}
+	available := 0
+	for _, c := range components {
+		if c.Available {
+			available += weights[c.Component]
+		}
+	}
+
+	total := 0.0
+	for i, c := range components {
+		if !c.Available || available == 0 {
+			continue
+		}
+		weight := 100 * float64(weights[c.Component]) / float64(available)
+		components[i].Weight = roundTenth(weight)
+		components[i].Contribution = roundTenth(float64(c.Points) * weight / 100)
+		total += float64(c.Points) * weight / 100
+	}
+	total = roundTenth(total)
+	return total, bandFor(m.Bands, total)
+}
+
+func roundTenth(x float64) float64 {
+	return math.Round(x*10) / 10
+}
+
+// Read and check the composite model file
+func loadCompositeModel(path string) (*CompositeModel, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	var model CompositeModel
+	if err := decoder.Decode(&model); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := model.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &model, nil
+}
+
+// The composite model in force
+type compositeRegistry struct {
+	mu        sync.Mutex
+	current   *CompositeModel
+	signature string
+}
+
+var compositeModel compositeRegistry
+
+// Load the composite model file if it changed, keeping the current model if it cannot be loaded
+func (c *compositeRegistry) reload(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	signature := fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+	c.mu.Lock()
+	unchanged := signature == c.signature
+	c.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	model, err := loadCompositeModel(path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.signature = signature
+	if err != nil {
+		return err
+	}
+	c.current = model
+	log.Printf("Loaded composite risk model v%d from %s", model.Version, path)
+	return nil
+}
+
+func (c *compositeRegistry) get() *CompositeModel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+// Gather each component for the user from the Self-Assessment, Vision Assessment and User
+// services, on behalf of the caller. The questionnaire result is the latest one taken with the
+// instrument, which must have points in the model.
+func fetchComponents(m *CompositeModel, userID int, instrument string, authHeader string) ([]CompositeComponent, error) {
+	var assessment struct {
+		AssessmentID int    `json:"id"`
+		Instrument   string `json:"instrument"`
+		RiskLevel    string `json:"riskLevel"`
+	}
+	status, err := callService("POST", cfg.Services.SelfAssessment+"/api/getLastAssessment",
+		map[string]interface{}{"user_id": userID, "instrument": instrument}, authHeader, &assessment)
+	if err != nil {
+		return nil, fmt.Errorf("latest assessment: %w", err)
+	}
+	if status == http.StatusNotFound {
+		return nil, errNoAssessment
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("latest assessment: self-assessment service returned %d", status)
+	}
+	points, ok := m.Questionnaire[instrument][assessment.RiskLevel]
+	if !ok {
+		return nil, fmt.Errorf("composite model v%d has no points for %s risk level %q", m.Version, instrument, assessment.RiskLevel)
+	}
+	questionnaire := CompositeComponent{
+		Component:  componentQuestionnaire,
+		Available:  true,
+		Input:      assessment.RiskLevel,
+		Instrument: assessment.Instrument,
+		RecordID:   assessment.AssessmentID,
+		Points:     points,
+	}
+
+	var result struct {
+		ID            int    `json:"ID"`
+		LeftEyeScore  int    `json:"LeftEyeScore"`
+		RightEyeScore int    `json:"RightEyeScore"`
+		CreatedAt     string `json:"CreatedAt"`
+	}
+	vision := CompositeComponent{Component: componentVision}
+	status, err = callService("GET", fmt.Sprintf("%s/getLatestResult?userID=%d", cfg.Services.VisionAssessment, userID), nil, authHeader, &result)
+	if err != nil {
+		return nil, fmt.Errorf("latest vision result: %w", err)
+	}
+	if status == http.StatusOK {
+		takenAt, err := time.ParseInLocation(visionCreatedAtLayout, result.CreatedAt, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("latest vision result: %w", err)
+		}
+		vision.RecordID = result.ID
+		if time.Since(takenAt) > time.Duration(m.VisionMaxAgeDays)*24*time.Hour {
+			vision.Stale = true
+		} else {
+			weaker := min(result.LeftEyeScore, result.RightEyeScore)
+			vision.Available, vision.Input, vision.Points = true, weaker, pointsFor(m.Vision, weaker)
+		}
+	} else if status != http.StatusNotFound {
+		return nil, fmt.Errorf("latest vision result: vision service returned %d", status)
+	}
+
+	var user struct {
+		DateOfBirth *time.Time `json:"date_of_birth"`
+	}
+	age := CompositeComponent{Component: componentAge}
+	status, err = callService("POST", cfg.Services.User+"/api/getUserDetails", map[string]int{"user_id": userID}, authHeader, &user)
+	if err != nil {
+		return nil, fmt.Errorf("user details: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("user details: user service returned %d", status)
+	}
+	if user.DateOfBirth != nil {
+		years := ageOn(*user.DateOfBirth, time.Now())
+		age.Available, age.Input, age.Points = true, years, pointsFor(m.Age, years)
+	}
+
+	return []CompositeComponent{questionnaire, vision, age}, nil
+}
+
+// Age in whole years on the given day
+func ageOn(dateOfBirth time.Time, day time.Time) int {
+	years := day.Year() - dateOfBirth.Year()
+	if day.Month() < dateOfBirth.Month() || (day.Month() == dateOfBirth.Month() && day.Day() < dateOfBirth.Day()) {
+		years--
+	}
+	return years
+}
+
+// Call another service on behalf of the caller and decode its JSON reply.
+// Returns the status code so callers can tell "no data yet" (404) apart from failures.
+func callService(method, url string, body interface{}, authHeader string, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		requestBody, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewBuffer(requestBody)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := serviceClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The model in testdata/composite.json
+func testCompositeModel(t *testing.T) *CompositeModel {
+	t.Helper()
+	model, err := loadCompositeModel(filepath.Join("testdata", "composite.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model
+}
+
+func TestCombine(t *testing.T) {
+	component := func(name string, points int) CompositeComponent {
+		return CompositeComponent{Component: name, Available: true, Points: points}
+	}
+	missing := func(name string) CompositeComponent {
+		return CompositeComponent{Component: name}
+	}
+
+	tests := []struct {
+		name        string
+		components  []CompositeComponent
+		wantTotal   float64
+		wantLevel   string
+		wantWeights []float64
+	}{
+		{"every component", []CompositeComponent{component(componentQuestionnaire, 50), component(componentVision, 100), component(componentAge, 50)},
+			60, "Moderate", []float64{60, 20, 20}},
+		{"vision missing, the rest scaled up", []CompositeComponent{component(componentQuestionnaire, 50), missing(componentVision), component(componentAge, 100)},
+			62.5, "Moderate", []float64{75, 0, 25}},
+		{"age missing", []CompositeComponent{component(componentQuestionnaire, 100), component(componentVision, 40), missing(componentAge)},
+			85, "High", []float64{75, 25, 0}},
+		{"questionnaire alone", []CompositeComponent{component(componentQuestionnaire, 10), missing(componentVision), missing(componentAge)},
+			10, "Low", []float64{100, 0, 0}},
+		{"nothing available", []CompositeComponent{missing(componentQuestionnaire), missing(componentVision), missing(componentAge)},
+			0, "Low", []float64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total, band := testCompositeModel(t).combine(tt.components)
+			if total != tt.wantTotal || band.RiskLevel != tt.wantLevel {
+				t.Fatalf("combine = %v %s, want %v %s", total, band.RiskLevel, tt.wantTotal, tt.wantLevel)
+			}
+			contributions := 0.0
+			for i, c := range tt.components {
+				if c.Weight != tt.wantWeights[i] {
+					t.Fatalf("%s weighted %v, want %v", c.Component, c.Weight, tt.wantWeights[i])
+				}
+				contributions += c.Contribution
+			}
+			if roundTenth(contributions) != total {
+				t.Fatalf("contributions add up to %v, total is %v", contributions, total)
+			}
+		})
+	}
+
+	// Weights that do not divide evenly are rounded for display only
+	model := testCompositeModel(t)
+	model.Weights = CompositeWeights{Questionnaire: 50, Vision: 20, Age: 30}
+	components := []CompositeComponent{component(componentQuestionnaire, 100), component(componentVision, 100), missing(componentAge)}
+	if total, _ := model.combine(components); total != 100 || components[0].Weight != 71.4 || components[1].Weight != 28.6 {
+		t.Fatalf("total %v, weights %v and %v", total, components[0].Weight, components[1].Weight)
+	}
+}
+
+func TestAgeOn(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name        string
+		dateOfBirth time.Time
+		day         time.Time
+		want        int
+	}{
+		{"birthday today", date(1950, time.October, 18), date(2025, time.October, 18), 75},
+		{"day before the birthday", date(1950, time.October, 18), date(2025, time.October, 17), 74},
+		{"earlier month", date(1950, time.October, 18), date(2025, time.March, 30), 74},
+		{"later month", date(1950, time.October, 18), date(2025, time.November, 1), 75},
+		{"29 February, on 29 February", date(1940, time.February, 29), date(2024, time.February, 29), 84},
+		{"29 February, on 28 February of a common year", date(1940, time.February, 29), date(2025, time.February, 28), 84},
+		{"29 February, on 1 March of a common year", date(1940, time.February, 29), date(2025, time.March, 1), 85},
+		{"on 29 February, born on 28 February", date(1940, time.February, 28), date(2024, time.February, 29), 84},
+		{"born late in the day", time.Date(1950, time.October, 18, 23, 0, 0, 0, time.UTC), date(2025, time.October, 18), 75},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ageOn(tt.dateOfBirth, tt.day); got != tt.want {
+				t.Fatalf("ageOn = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// A reply from a stubbed service
+type stubReply struct {
+	status int
+	body   interface{}
+}
+
+// The Self-Assessment, Vision Assessment and User services in one server, giving the replies
+// set for the latest assessment, latest vision result and user details
+type componentStub struct {
+	assessment, vision, user stubReply
+}
+
+func (s *componentStub) serve(t *testing.T) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer caller" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var reply stubReply
+		switch r.URL.Path {
+		case "/api/getLastAssessment":
+			reply = s.assessment
+		case "/getLatestResult":
+			reply = s.vision
+		case "/api/getUserDetails":
+			reply = s.user
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(reply.status)
+		json.NewEncoder(w).Encode(reply.body)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := cfg.Services
+	cfg.Services.SelfAssessment, cfg.Services.VisionAssessment, cfg.Services.User = server.URL, server.URL, server.URL
+	t.Cleanup(func() { cfg.Services = previous })
+}
+
+func TestFetchComponents(t *testing.T) {
+	// Vision results are sent in local time without a zone
+	takenAgo := func(age time.Duration) string {
+		return time.Now().Add(-age).Format(visionCreatedAtLayout)
+	}
+	day := 24 * time.Hour
+	dateOfBirth := time.Now().AddDate(-70, 0, -1).UTC().Truncate(day)
+
+	assessment := stubReply{http.StatusOK, map[string]interface{}{"id": 12, "instrument": "steps", "riskLevel": "High"}}
+	vision := stubReply{http.StatusOK, map[string]interface{}{"ID": 5, "LeftEyeScore": 3, "RightEyeScore": 1, "CreatedAt": takenAgo(day)}}
+	user := stubReply{http.StatusOK, map[string]interface{}{"date_of_birth": dateOfBirth}}
+	notFound := stubReply{http.StatusNotFound, nil}
+
+	questionnaire := CompositeComponent{Component: componentQuestionnaire, Available: true, Input: "High", Instrument: "steps", RecordID: 12, Points: 90}
+	eyes := CompositeComponent{Component: componentVision, Available: true, Input: 1, RecordID: 5, Points: 100}
+	age := CompositeComponent{Component: componentAge, Available: true, Input: 70, Points: 50}
+
+	tests := []struct {
+		name    string
+		stub    componentStub
+		want    []CompositeComponent
+		wantErr string
+	}{
+		{"every component", componentStub{assessment, vision, user}, []CompositeComponent{questionnaire, eyes, age}, ""},
+		{"no vision result", componentStub{assessment, notFound, user},
+			[]CompositeComponent{questionnaire, {Component: componentVision}, age}, ""},
+		{"vision result just inside the cutoff", componentStub{assessment,
+			stubReply{http.StatusOK, map[string]interface{}{"ID": 5, "LeftEyeScore": 3, "RightEyeScore": 1, "CreatedAt": takenAgo(30*day - time.Hour)}}, user},
+			[]CompositeComponent{questionnaire, eyes, age}, ""},
+		{"vision result past the cutoff", componentStub{assessment,
+			stubReply{http.StatusOK, map[string]interface{}{"ID": 5, "LeftEyeScore": 3, "RightEyeScore": 1, "CreatedAt": takenAgo(30*day + time.Hour)}}, user},
+			[]CompositeComponent{questionnaire, {Component: componentVision, RecordID: 5, Stale: true}, age}, ""},
+		{"no date of birth", componentStub{assessment, vision, stubReply{http.StatusOK, map[string]interface{}{"date_of_birth": nil}}},
+			[]CompositeComponent{questionnaire, eyes, {Component: componentAge}}, ""},
+		{"no assessment", componentStub{notFound, vision, user}, nil, errNoAssessment.Error()},
+		{"risk level without points", componentStub{stubReply{http.StatusOK, map[string]interface{}{"id": 12, "instrument": "steps", "riskLevel": "Moderate"}}, vision, user},
+			nil, `no points for steps risk level "Moderate"`},
+		{"vision service failing", componentStub{assessment, stubReply{http.StatusInternalServerError, nil}, user}, nil, "vision service returned 500"},
+		{"unreadable vision time", componentStub{assessment, stubReply{http.StatusOK, map[string]interface{}{"ID": 5, "CreatedAt": "yesterday"}}, user},
+			nil, "latest vision result"},
+		{"user service failing", componentStub{assessment, vision, stubReply{http.StatusForbidden, nil}}, nil, "user service returned 403"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.stub.serve(t)
+			components, err := fetchComponents(testCompositeModel(t), 7, "steps", "Bearer caller")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(components, tt.want) {
+				t.Fatalf("got %+v\nwant %+v", components, tt.want)
+			}
+		})
+	}
+
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"time"
+
+	"common/config"
+)
+
+// Settings of the Risk Assessment service, loaded by config.Load
+type Config struct {
+	Port      int           `env:"LOCAL_PORT" default:"8080" validate:"port"`
+	JWTSecret config.Secret `env:"JWT_SECRET" required:"true"`
+	Services  config.Services
+	// Scoring models, reloaded whenever a file in the directory changes
+	RulesDir            string        `env:"RISK_RULES_DIR" default:"rules"`
+	RulesReloadInterval time.Duration `env:"RISK_RULES_RELOAD_INTERVAL" default:"30s" validate:"positive"`
+	// Weights of the combined questionnaire, vision and age score, reloaded with the rules
+	CompositeFile string `env:"RISK_COMPOSITE_FILE" default:"rules/composite.json"`
+}
+
+var cfg Config
@@ -3,6 +3,13 @@ module risk_assessment_service
 go 1.23.2
 
 require (
+	common v0.0.0
 	github.com/gorilla/mux v1.8.1
-	github.com/rs/cors v1.11.1
 )
+
+require (
+	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/rs/cors v1.11.1 // indirect
+)
+
+replace common => ../Common
@@ -4,57 +4,63 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"slices"
+
+	"common/auth"
+	"common/config"
+	"common/rbac"
+	"common/respond"
+	"common/server"
 
 	"github.com/gorilla/mux"
-	"github.com/rs/cors"
 )
 
 func main() {
-	/*
-		// Get local port from environment variables
-		localPort := os.Getenv("LOCAL_PORT")
-		if localPort == "" {
-			log.Fatal("LOCAL_PORT environment variable is not set")
-		}
-	*/
+	// Load settings from the environment and .env files, listing everything missing at once
+	config.MustLoad(&cfg)
+
+	auth.SetSecret(cfg.JWTSecret.Value())
+
+	// Load the newest scoring model of every instrument and pick up new or edited rule files
+	// while running
+	if err := riskModels.reload(cfg.RulesDir); err != nil {
+		log.Fatalf("Failed to load risk models: %v", err)
+	}
+	if err := compositeModel.reload(cfg.CompositeFile); err != nil {
+		log.Fatalf("Failed to load composite risk model: %v", err)
+	}
+	go watchRiskModels(cfg.RulesDir, cfg.CompositeFile, cfg.RulesReloadInterval)
 
 	// Initialize the router
 	router := mux.NewRouter()
-	router.HandleFunc("/api/analyzeRisk", func(w http.ResponseWriter, r *http.Request) {
-		analyzeRiskHandler(w, r)
-	}).Methods("POST")
-
-	// Enable CORS
-	corsHandler := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders:   []string{"*"},
-		AllowCredentials: true,
-	})
-
-	log.Println("Server running on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", corsHandler.Handler(router)))
-}
-
-// Get recommendations based on risk level
-func getRecommendation(riskLevel string) string {
-	switch riskLevel {
-	case "Low":
-		return "Maintain a healthy lifestyle with balance exercises and check-ups."
-	case "Moderate":
-		return "Consider physical therapy, improve home safety, and monitor medications."
-	case "High":
-		return "Consult a healthcare provider for a fall risk assessment and use mobility aids."
-	default:
-		return "No recommendation available."
+	router.Use(auth.Middleware)
+	router.HandleFunc("/api/analyzeRisk", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		analyzeRiskHandler(w, r, false)
+	}, rbac.PermRiskAnalyze)).Methods("POST")
+	router.HandleFunc("/api/clinician/analyzeRisk", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		analyzeRiskHandler(w, r, true)
+	}, rbac.PermRiskClinical)).Methods("POST")
+	router.HandleFunc("/api/compositeRisk", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		compositeRiskHandler(w, r)
+	}, rbac.PermAssessmentRead)).Methods("POST")
+	router.HandleFunc("/api/instruments", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		instrumentsHandler(w, r)
+	}, rbac.PermQuestionnaireRead)).Methods("GET")
+
+	// Serve until stopped, letting requests in progress finish
+	if err := server.Run("Risk assessment", cfg.Port, server.CORS(router, "GET", "POST", "PUT", "DELETE")); err != nil {
+		log.Fatalf("Server failed: %v", err)
 	}
 }
 
-// Analyze risk
-func analyzeRiskHandler(w http.ResponseWriter, r *http.Request) {
+// Analyze risk. Seniors score the instruments they take themselves, and clinicians the ones
+// they administer to a patient.
+func analyzeRiskHandler(w http.ResponseWriter, r *http.Request, clinician bool) {
 	type Request struct {
-		UserID  int         `json:"user_id"`
-		Answers map[int]int `json:"answers"` // {question_id: selected_option_index (1-based)}
+		UserID     int         `json:"user_id"`
+		Instrument string      `json:"instrument"` // The custom questionnaire if left out
+		Answers    map[int]int `json:"answers"`    // {question_id: selected_option_index (1-based)}
+		Language   string      `json:"language"`   // Questionnaire language the explanation is given in
 	}
 
 	var req Request
@@ -71,61 +77,153 @@ func analyzeRiskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Ensure at least some answers are provided
-	if len(req.Answers) == 0 {
-		http.Error(w, "No answers provided", http.StatusBadRequest)
+	// Seniors may only analyze their own answers, and doctors their patients'
+	if !auth.AuthorizeUser(w, r, req.UserID) {
+		return
+	}
+
+	if req.Language == "" {
+		req.Language = "English"
+	}
+	if !slices.Contains(questionnaireLanguages, req.Language) {
+		http.Error(w, "Unsupported language", http.StatusBadRequest)
+		return
+	}
+	if req.Instrument == "" {
+		req.Instrument = defaultInstrument
+	}
+
+	// Score with the instrument's model in force, which is stamped on the result
+	model, err := riskModels.active(req.Instrument)
+	if err == errUnknownInstrument {
+		http.Error(w, "Unknown instrument", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("No %s risk model available: %v", req.Instrument, err)
+		http.Error(w, "Risk model is not available", http.StatusServiceUnavailable)
+		return
+	}
+	if model.Clinician && !clinician {
+		http.Error(w, "Instrument is scored by a clinician, not self-assessed", http.StatusBadRequest)
+		return
+	}
+	if !model.Clinician && clinician {
+		http.Error(w, "Instrument is self-assessed, not scored by a clinician", http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(model.Languages, req.Language) {
+		http.Error(w, "Instrument is not offered in this language", http.StatusBadRequest)
 		return
 	}
 
-	// Define risk points (Now indexed from 1)
-	riskPoints := map[int]map[int]int{
-		1:  {1: 2, 2: 0},             // Dizziness: Yes = 2, No = 0
-		2:  {1: 1, 2: 2, 3: 3},       // Balance: Good = 1, Moderate = 2, Poor = 3
-		3:  {1: 1, 2: 2, 3: 3},       // Falls: 0 = 1, 1-2 = 2, 3+ = 3
-		4:  {1: 0, 2: 1, 3: 2, 4: 3}, // Mobility aid
-		5:  {1: 0, 2: 1, 3: 2, 4: 3}, // Unsteady Walking
-		6:  {1: 2, 2: 0},             // Recent fall: Yes = 2, No = 0
-		7:  {1: 0, 2: 2},             // Stand without hands
-		8:  {1: 2, 2: 0, 3: 1},       // Medications
-		9:  {1: 0, 2: 2},             // Exercise
-		10: {1: 2, 2: 0, 3: 1},       // Numbness
+	// A partial or malformed submission would score falsely low, so it is not scored at all
+	if problems := model.checkAnswers(req.Answers); len(problems) > 0 {
+		respond.JSONStatus(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"status":   "incomplete",
+			"problems": problems,
+		})
+		return
 	}
+	result := model.score(req.Answers, req.Language)
+
+	// Send JSON response
+	response := map[string]interface{}{
+		"status":         "complete",
+		"instrument":     model.Instrument,
+		"total_score":    result.TotalScore,
+		"risk_level":     result.Band.RiskLevel,
+		"recommendation": result.Band.Recommendation,
+		"model_version":  model.Version,
+		"language":       req.Language,
+		"breakdown":      result.Breakdown,
+		"factors":        result.Factors,
+	}
+
+	respond.JSON(w, response)
+}
+
+// List the instruments risk can be scored with. Clinician-scored instruments are only listed
+// for callers who may score them.
+func instrumentsHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.ClaimsFromRequest(r)
+	clinician := claims != nil && rbac.HasPermission(claims.Role, rbac.PermRiskClinical)
 
-	// Compute total score
-	totalScore := 0
-	for questionID, answerIndex := range req.Answers {
-		if pointsMap, exists := riskPoints[questionID]; exists {
-			if points, valid := pointsMap[answerIndex]; valid {
-				totalScore += points
-			} else {
-				log.Printf("Invalid answer index %d for question %d", answerIndex, questionID)
-			}
-		} else {
-			log.Printf("Invalid question ID: %d", questionID)
+	instruments := []map[string]interface{}{}
+	for _, model := range riskModels.list() {
+		if model.Clinician && !clinician {
+			continue
 		}
+		instruments = append(instruments, map[string]interface{}{
+			"instrument":    model.Instrument,
+			"name":          model.Name,
+			"description":   model.Description,
+			"languages":     model.Languages,
+			"clinician":     model.Clinician,
+			"model_version": model.Version,
+		})
+	}
+
+	respond.JSON(w, instruments)
+}
+
+// Combine the latest questionnaire result, contrast vision result and age into one fall risk
+func compositeRiskHandler(w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		UserID     int    `json:"user_id"`
+		Instrument string `json:"instrument"` // The custom questionnaire if left out
+	}
+
+	var req Request
+
+	// Decode JSON request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
+		return
 	}
 
-	// Determine risk level
-	var riskLevel string
-	switch {
-	case totalScore <= 5:
-		riskLevel = "Low"
-	case totalScore <= 10:
-		riskLevel = "Moderate"
-	default:
-		riskLevel = "High"
+	// Validate User ID
+	if req.UserID <= 0 {
+		http.Error(w, "Invalid or missing user_id", http.StatusBadRequest)
+		return
 	}
 
-	// Generate recommendation
-	recommendation := getRecommendation(riskLevel)
+	// Seniors may only see their own risk, caregivers their linked seniors'
+	if !auth.AuthorizeUser(w, r, req.UserID) {
+		return
+	}
+
+	model := compositeModel.get()
+	if req.Instrument == "" {
+		req.Instrument = defaultInstrument
+	}
+	// Risk levels of different instruments are only combined as the model weighs each one
+	if model.Questionnaire[req.Instrument] == nil {
+		http.Error(w, "Instrument has no points in the composite model", http.StatusBadRequest)
+		return
+	}
+
+	components, err := fetchComponents(model, req.UserID, req.Instrument, r.Header.Get("Authorization"))
+	if err == errNoAssessment {
+		http.Error(w, "No risk assessments found for this user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to gather composite risk components:", err)
+		http.Error(w, "Failed to gather results", http.StatusBadGateway)
+		return
+	}
+	totalScore, band := model.combine(components)
 
 	// Send JSON response
 	response := map[string]interface{}{
-		"total_score":    totalScore,
-		"risk_level":     riskLevel,
-		"recommendation": recommendation,
+		"user_id":           req.UserID,
+		"total_score":       totalScore,
+		"risk_level":        band.RiskLevel,
+		"recommendation":    band.Recommendation,
+		"composite_version": model.Version,
+		"components":        components,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	respond.JSON(w, response)
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"common/auth"
+	"common/rbac"
+)
+
+func TestCheckAnswers(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers map[int]int
+		want    []AnswerProblem
+	}{
+		{"every question answered", map[int]int{1: 2, 2: 3}, []AnswerProblem{}},
+		{"no answers", map[int]int{}, []AnswerProblem{
+			{1, "missing", "Question 1 is not answered"},
+			{2, "missing", "Question 2 is not answered"},
+		}},
+		{"option zero", map[int]int{1: 0, 2: 1}, []AnswerProblem{{1, "invalid_option", "Question 1 has options 1 to 2, not 0"}}},
+		{"option past the last", map[int]int{1: 1, 2: 4}, []AnswerProblem{{2, "invalid_option", "Question 2 has options 1 to 3, not 4"}}},
+		{"unknown question", map[int]int{1: 1, 2: 1, 3: 1}, []AnswerProblem{{3, "unknown_question", "There is no question 3"}}},
+		{"several problems, by question", map[int]int{-1: 1, 2: 0}, []AnswerProblem{
+			{-1, "unknown_question", "There is no question -1"},
+			{1, "missing", "Question 1 is not answered"},
+			{2, "invalid_option", "Question 2 has options 1 to 3, not 0"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stepsModel(t).checkAnswers(tt.answers); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Make the steps model the one in force while the test runs
+func useStepsModel(t *testing.T) {
+	t.Helper()
+	model := stepsModel(t)
+	riskModels.mu.Lock()
+	riskModels.instruments["steps"] = &instrumentModels{current: model, checked: model}
+	riskModels.mu.Unlock()
+	t.Cleanup(func() {
+		riskModels.mu.Lock()
+		delete(riskModels.instruments, "steps")
+		riskModels.mu.Unlock()
+	})
+}
+
+// Ask senior 7's own risk to be analyzed
+func analyze(t *testing.T, answers map[int]int) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := auth.IssueToken(7, rbac.RoleSenior, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{"user_id": 7, "instrument": "steps", "answers": answers})
+	req := httptest.NewRequest(http.MethodPost, "/api/analyzeRisk", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		analyzeRiskHandler(w, r, false)
+	})).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAnalyzeRiskRefusesIncompleteAnswers(t *testing.T) {
+	useStepsModel(t)
+
+	rec := analyze(t, map[int]int{2: 5})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422: %s", rec.Code, rec.Body)
+	}
+	var response struct {
+		Status   string          `json:"status"`
+		Problems []AnswerProblem `json:"problems"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	want := []AnswerProblem{
+		{1, "missing", "Question 1 is not answered"},
+		{2, "invalid_option", "Question 2 has options 1 to 3, not 5"},
+	}
+	if response.Status != "incomplete" || !reflect.DeepEqual(response.Problems, want) {
+		t.Fatalf("response %+v", response)
+	}
+}
+
+func TestAnalyzeRiskScoresCompleteAnswers(t *testing.T) {
+	useStepsModel(t)
+
+	rec := analyze(t, map[int]int{1: 2, 2: 2})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var response struct {
+		Status       string `json:"status"`
+		TotalScore   int    `json:"total_score"`
+		RiskLevel    string `json:"risk_level"`
+		ModelVersion int    `json:"model_version"`
+	}
+	json.NewDecoder(rec.Body).Decode(&response)
+	if response.Status != "complete" || response.TotalScore != 3 || response.RiskLevel != "Moderate" || response.ModelVersion != 1 {
+		t.Fatalf("response %+v", response)
+	}
+}
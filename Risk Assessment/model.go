@@ -0,0 +1,516 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"common/auth"
+)
+
+// A scoring model of an instrument, read from rules/<instrument>_v<version>.json
+type RiskModel struct {
+	Instrument  string         `json:"-"` // From the file name
+	Version     int            `json:"version"`
+	Name        string         `json:"name"` // Shown to people choosing an instrument
+	Description string         `json:"description"`
+	Languages   []string       `json:"languages"` // Languages the questionnaire is offered in
+	Clinician   bool           `json:"clinician"` // Scored by a clinician observing the patient, not self-assessed
+	Questions   []QuestionRule `json:"questions"`
+	Bands       []RiskBand     `json:"bands"`
+}
+
+// Points for each option of a question, in the order the questionnaire lists them, and how to
+// explain the question to someone it counts against, by questionnaire language
+type QuestionRule struct {
+	QuestionID int               `json:"question_id"`
+	Topic      string            `json:"topic"` // For people reading the file
+	Points     []int             `json:"points"`
+	Factor     map[string]string `json:"factor,omitempty"` // The risk factor in plain language
+	Advice     map[string]string `json:"advice,omitempty"` // What to do about it
+}
+
+// A range of total scores and the advice given for it. The last band has no maximum.
+type RiskBand struct {
+	RiskLevel      string `json:"risk_level"`
+	MaxScore       *int   `json:"max_score"`
+	Recommendation string `json:"recommendation"`
+}
+
+// How a total score was reached
+type RiskResult struct {
+	TotalScore int
+	Band       RiskBand
+	Breakdown  []QuestionScore
+	Factors    []RiskFactor
+}
+
+// Points one answer added to the total
+type QuestionScore struct {
+	QuestionID int `json:"question_id"`
+	Option     int `json:"option"`
+	Points     int `json:"points"`
+}
+
+// A question that raised the score, explained in the questionnaire language
+type RiskFactor struct {
+	QuestionID int    `json:"question_id"`
+	Factor     string `json:"factor"`
+	Advice     string `json:"advice"`
+}
+
+// A problem with one answer of a submission
+type AnswerProblem struct {
+	QuestionID int    `json:"question_id"`
+	Code       string `json:"code"` // missing, unknown_question or invalid_option
+	Message    string `json:"message"`
+}
+
+// How many factors a result lists at most
+const topFactorCount = 3
+
+// Risk levels the Self-Assessment service can store
+var riskLevels = []string{"Low", "Moderate", "High"}
+
+// Languages a questionnaire can be offered in
+var questionnaireLanguages = []string{"English", "Chinese", "Malay", "Tamil"}
+
+// The custom questionnaire, scored when no instrument is asked for
+const defaultInstrument = "fall_risk"
+
+var ruleFileName = regexp.MustCompile(`^([a-z]+(?:_[a-z]+)*)_v(\d+)\.json$`)
+
+// Returned when no model matching the questionnaire has been loaded
+var errNoRiskModel = errors.New("no risk model matches the questionnaire")
+
+// Returned for an instrument without rule files
+var errUnknownInstrument = errors.New("unknown instrument")
+
+// Returned when a model cannot be checked because the questionnaire could not be fetched
+var errQuestionnaireUnavailable = errors.New("questionnaire unavailable")
+
+// Check the model is complete and consistent on its own
+func (m *RiskModel) validate() error {
+	if m.Name == "" {
+		return errors.New("no name")
+	}
+	if len(m.Languages) == 0 {
+		return errors.New("no languages")
+	}
+	for i, language := range m.Languages {
+		if !slices.Contains(questionnaireLanguages, language) || slices.Index(m.Languages, language) != i {
+			return fmt.Errorf("language %q is not one of %s or is repeated", language, strings.Join(questionnaireLanguages, ", "))
+		}
+	}
+	if len(m.Questions) == 0 {
+		return errors.New("no questions")
+	}
+	seen := map[int]bool{}
+	for _, q := range m.Questions {
+		if q.QuestionID <= 0 || seen[q.QuestionID] {
+			return fmt.Errorf("question ID %d is invalid or repeated", q.QuestionID)
+		}
+		seen[q.QuestionID] = true
+		if len(q.Points) == 0 {
+			return fmt.Errorf("question %d has no points", q.QuestionID)
+		}
+		if slices.Min(q.Points) < 0 {
+			return fmt.Errorf("question %d has negative points", q.QuestionID)
+		}
+		// Explanations are optional, but must then be complete in every language of the model
+		if q.Factor == nil && q.Advice == nil {
+			continue
+		}
+		for name, texts := range map[string]map[string]string{"factor": q.Factor, "advice": q.Advice} {
+			if len(texts) != len(m.Languages) {
+				return fmt.Errorf("question %d needs its %s in exactly %s", q.QuestionID, name, strings.Join(m.Languages, ", "))
+			}
+			for _, language := range m.Languages {
+				if texts[language] == "" {
+					return fmt.Errorf("question %d has no %s in %s", q.QuestionID, name, language)
+				}
+			}
+		}
+	}
+
+	return validateBands(m.Bands)
+}
+
+// Check the bands cover every score, from the lowest up, with a recommendation each
+func validateBands(bands []RiskBand) error {
+	if len(bands) == 0 {
+		return errors.New("no bands")
+	}
+	levels := map[string]bool{}
+	for i, band := range bands {
+		if !slices.Contains(riskLevels, band.RiskLevel) || levels[band.RiskLevel] {
+			return fmt.Errorf("band %d: risk level %q is not one of %s or is repeated", i+1, band.RiskLevel, strings.Join(riskLevels, ", "))
+		}
+		levels[band.RiskLevel] = true
+		if band.Recommendation == "" {
+			return fmt.Errorf("band %s has no recommendation", band.RiskLevel)
+		}
+		last := i == len(bands)-1
+		if last != (band.MaxScore == nil) {
+			return fmt.Errorf("band %s: every band but the last needs a max_score, and the last must have none", band.RiskLevel)
+		}
+		if !last && i > 0 && *band.MaxScore <= *bands[i-1].MaxScore {
+			return fmt.Errorf("band %s: max_score must be higher than the band before", band.RiskLevel)
+		}
+	}
+	return nil
+}
+
+// The band a total score falls in
+func bandFor(bands []RiskBand, score float64) RiskBand {
+	for _, band := range bands {
+		if band.MaxScore == nil || score <= float64(*band.MaxScore) {
+			return band
+		}
+	}
+	return bands[len(bands)-1]
+}
+
+// Check the model scores exactly the questions and options of its questionnaire, given as
+// the option count of each question per language
+func (m *RiskModel) matches(questionnaire map[string]map[int]int) error {
+	var problems []string
+	for _, language := range m.Languages {
+		options := questionnaire[language]
+		for _, q := range m.Questions {
+			count, ok := options[q.QuestionID]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("question %d is not in the %s questionnaire", q.QuestionID, language))
+			} else if count != len(q.Points) {
+				problems = append(problems, fmt.Sprintf("question %d has %d options in %s but %d points", q.QuestionID, count, language, len(q.Points)))
+			}
+		}
+		for questionID := range options {
+			if !slices.ContainsFunc(m.Questions, func(q QuestionRule) bool { return q.QuestionID == questionID }) {
+				problems = append(problems, fmt.Sprintf("%s question %d has no points", language, questionID))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Check the answers give one of the scored options, as a 1-based index, for every question of
+// the model and for nothing else
+func (m *RiskModel) checkAnswers(answers map[int]int) []AnswerProblem {
+	problems := []AnswerProblem{}
+	for _, q := range m.Questions {
+		answer, answered := answers[q.QuestionID]
+		if !answered {
+			problems = append(problems, AnswerProblem{q.QuestionID, "missing", fmt.Sprintf("Question %d is not answered", q.QuestionID)})
+		} else if answer < 1 || answer > len(q.Points) {
+			problems = append(problems, AnswerProblem{q.QuestionID, "invalid_option",
+				fmt.Sprintf("Question %d has options 1 to %d, not %d", q.QuestionID, len(q.Points), answer)})
+		}
+	}
+	for questionID := range answers {
+		if !slices.ContainsFunc(m.Questions, func(q QuestionRule) bool { return q.QuestionID == questionID }) {
+			problems = append(problems, AnswerProblem{questionID, "unknown_question", fmt.Sprintf("There is no question %d", questionID)})
+		}
+	}
+	slices.SortFunc(problems, func(a, b AnswerProblem) int { return a.QuestionID - b.QuestionID })
+	return problems
+}
+
+// Add up the points of answers that passed checkAnswers, find the band the total falls in, and
+// explain the answers that raised it most in the given language
+func (m *RiskModel) score(answers map[int]int, language string) RiskResult {
+	rules := map[int]QuestionRule{}
+	for _, q := range m.Questions {
+		rules[q.QuestionID] = q
+	}
+
+	result := RiskResult{Breakdown: []QuestionScore{}, Factors: []RiskFactor{}}
+	raised := map[int]int{} // Points above the question's lowest option
+	for questionID, answerIndex := range answers {
+		rule := rules[questionID]
+		points := rule.Points[answerIndex-1]
+		result.TotalScore += points
+		result.Breakdown = append(result.Breakdown, QuestionScore{QuestionID: questionID, Option: answerIndex, Points: points})
+		if extra := points - slices.Min(rule.Points); extra > 0 && rule.Factor != nil {
+			raised[questionID] = extra
+		}
+	}
+	slices.SortFunc(result.Breakdown, func(a, b QuestionScore) int { return a.QuestionID - b.QuestionID })
+
+	// Biggest rises first, then in questionnaire order
+	for _, s := range result.Breakdown {
+		if raised[s.QuestionID] > 0 {
+			rule := rules[s.QuestionID]
+			result.Factors = append(result.Factors, RiskFactor{QuestionID: s.QuestionID, Factor: rule.Factor[language], Advice: rule.Advice[language]})
+		}
+	}
+	slices.SortStableFunc(result.Factors, func(a, b RiskFactor) int { return raised[b.QuestionID] - raised[a.QuestionID] })
+	if len(result.Factors) > topFactorCount {
+		result.Factors = result.Factors[:topFactorCount]
+	}
+
+	result.Band = bandFor(m.Bands, float64(result.TotalScore))
+	return result
+}
+
+// The newest rule file of an instrument, and a signature that changes whenever one of the
+// instrument's rule files is added, removed or edited
+type ruleFile struct {
+	path      string
+	version   int
+	signature string
+}
+
+// Find the newest rule file of every instrument in dir
+func newestRuleFiles(dir string) (map[string]ruleFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]ruleFile{}
+	for _, entry := range entries {
+		match := ruleFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		file := files[match[1]]
+		file.signature += fmt.Sprintf("%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+		if version, _ := strconv.Atoi(match[2]); version > file.version {
+			file.path, file.version = filepath.Join(dir, entry.Name()), version
+		}
+		files[match[1]] = file
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no <instrument>_v<version>.json file in %s", dir)
+	}
+	return files, nil
+}
+
+// Read and check a rule file
+func loadRiskModel(path string) (*RiskModel, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Reject misspelled keys rather than silently scoring without them
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	var model RiskModel
+	if err := decoder.Decode(&model); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	match := ruleFileName.FindStringSubmatch(filepath.Base(path))
+	if match == nil || match[2] != strconv.Itoa(model.Version) {
+		return nil, fmt.Errorf("%s: version %d does not match the file name", path, model.Version)
+	}
+	model.Instrument = match[1]
+	if err := model.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &model, nil
+}
+
+// The models of every instrument found in the rules directory
+type riskModelRegistry struct {
+	mu          sync.Mutex
+	instruments map[string]*instrumentModels
+}
+
+// The model in force for one instrument. A newly loaded model is checked against the
+// questionnaire when it is loaded, and only scored with once it matches.
+type instrumentModels struct {
+	mu        sync.Mutex
+	current   *RiskModel // Newest model loaded from the rules directory
+	checked   *RiskModel // Newest model found to match the questionnaire, the one scored with
+	signature string
+}
+
+var riskModels = riskModelRegistry{instruments: map[string]*instrumentModels{}}
+
+// Load the newest model of every instrument in dir and check it against the questionnaire,
+// keeping an instrument's current model if the new one cannot be loaded or does not match. A
+// model that cannot be checked yet because the Self-Assessment service is unreachable is
+// checked again on the next reload.
+func (r *riskModelRegistry) reload(dir string) error {
+	files, err := newestRuleFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	var failed []error
+	for _, instrument := range slices.Sorted(maps.Keys(files)) {
+		file := files[instrument]
+		r.mu.Lock()
+		models := r.instruments[instrument]
+		if models == nil {
+			models = &instrumentModels{}
+			r.instruments[instrument] = models
+		}
+		r.mu.Unlock()
+
+		models.mu.Lock()
+		unchanged := file.signature == models.signature
+		model := models.current
+		pending := model != models.checked
+		models.mu.Unlock()
+		if unchanged && !pending {
+			continue
+		}
+
+		if !unchanged {
+			model, err = loadRiskModel(file.path)
+			if err != nil {
+				// Not tried again until the instrument's rule files change
+				models.mu.Lock()
+				models.signature = file.signature
+				models.mu.Unlock()
+				failed = append(failed, err)
+				continue
+			}
+			log.Printf("Loaded %s risk model v%d from %s", instrument, model.Version, file.path)
+		}
+
+		// Fetched without holding the lock, so scoring goes on with the checked model meanwhile
+		err = checkAgainstQuestionnaire(model)
+		models.mu.Lock()
+		models.signature = file.signature
+		switch {
+		case errors.Is(err, errQuestionnaireUnavailable):
+			models.current = model
+		case err != nil:
+			models.current = models.checked
+		default:
+			models.current, models.checked = model, model
+		}
+		models.mu.Unlock()
+
+		if errors.Is(err, errQuestionnaireUnavailable) {
+			log.Printf("Cannot check %s risk model v%d yet, trying again on the next reload: %v", instrument, model.Version, err)
+		} else if err != nil {
+			failed = append(failed, fmt.Errorf("%s risk model v%d does not match the questionnaire and is not used: %w", instrument, model.Version, err))
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// Check the model against its questionnaire, fetched with the service's own credential
+func checkAgainstQuestionnaire(model *RiskModel) error {
+	token, err := auth.IssueServiceToken()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errQuestionnaireUnavailable, err)
+	}
+	questionnaire, err := fetchQuestionnaire(model.Instrument, model.Languages, "Bearer "+token)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errQuestionnaireUnavailable, err)
+	}
+	return model.matches(questionnaire)
+}
+
+// Reload the rules directory and composite model file whenever they change, until the
+// service stops
+func watchRiskModels(dir, compositeFile string, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := riskModels.reload(dir); err != nil {
+			log.Printf("Keeping the current risk models: %v", err)
+		}
+		if err := compositeModel.reload(compositeFile); err != nil {
+			log.Printf("Keeping the current composite risk model: %v", err)
+		}
+	}
+}
+
+// The model scored with of every instrument that has one, by instrument
+func (r *riskModelRegistry) list() []*RiskModel {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	models := []*RiskModel{}
+	for _, instrument := range slices.Sorted(maps.Keys(r.instruments)) {
+		m := r.instruments[instrument]
+		m.mu.Lock()
+		if m.checked != nil {
+			models = append(models, m.checked)
+		}
+		m.mu.Unlock()
+	}
+	return models
+}
+
+// The model to score the instrument with, the newest one found to match the questionnaire
+func (r *riskModelRegistry) active(instrument string) (*RiskModel, error) {
+	r.mu.Lock()
+	models := r.instruments[instrument]
+	r.mu.Unlock()
+	if models == nil {
+		return nil, errUnknownInstrument
+	}
+
+	models.mu.Lock()
+	defer models.mu.Unlock()
+	if models.checked == nil {
+		return nil, errNoRiskModel
+	}
+	return models.checked, nil
+}
+
+var serviceClient = &http.Client{Timeout: 10 * time.Second}
+
+// Fetch the option count of every question of the instrument in each language from the
+// Self-Assessment service
+func fetchQuestionnaire(instrument string, languages []string, authHeader string) (map[string]map[int]int, error) {
+	questionnaire := map[string]map[int]int{}
+	for _, language := range languages {
+		query := url.Values{"instrument": {instrument}, "language": {language}}
+		request, err := http.NewRequest("GET", cfg.Services.SelfAssessment+"/api/questionnaire?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", authHeader)
+
+		response, err := serviceClient.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		var questions []struct {
+			QuestionID int      `json:"question_id"`
+			Options    []string `json:"question_options"`
+		}
+		if response.StatusCode != http.StatusOK {
+			err = fmt.Errorf("%s %s questionnaire returned %s", language, instrument, response.Status)
+		} else {
+			err = json.NewDecoder(response.Body).Decode(&questions)
+		}
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		options := map[int]int{}
+		for _, q := range questions {
+			options[q.QuestionID] = len(q.Options)
+		}
+		questionnaire[language] = options
+	}
+	return questionnaire, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"common/auth"
+)
+
+func init() {
+	auth.SetSecret("risk-model-tests")
+}
+
+func maxScore(n int) *int { return &n }
+
+// The model in testdata/steps_v1.json, loaded afresh so each test can change it
+func stepsModel(t *testing.T) *RiskModel {
+	t.Helper()
+	model, err := loadRiskModel(filepath.Join("testdata", "steps_v1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model
+}
+
+func TestLoadRiskModel(t *testing.T) {
+	tests := []struct {
+		file    string
+		wantErr string
+	}{
+		{"steps_v1.json", ""},
+		{"steps_v2.json", ""},
+		{"overlapping_bands_v3.json", "max_score must be higher"},
+		{"misspelled_key_v5.json", `unknown field "advise"`},
+		{"missing_v1.json", "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			model, err := loadRiskModel(filepath.Join("testdata", tt.file))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if model.Instrument != "steps" || len(model.Questions) != 2 {
+					t.Fatalf("loaded %+v", model)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The version in the file must match its name
+	dir := t.TempDir()
+	data, _ := os.ReadFile(filepath.Join("testdata", "steps_v1.json"))
+	os.WriteFile(filepath.Join(dir, "steps_v7.json"), data, 0o644)
+	if _, err := loadRiskModel(filepath.Join(dir, "steps_v7.json")); err == nil || !strings.Contains(err.Error(), "does not match the file name") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	explained := func(m *RiskModel) {
+		m.Languages = []string{"English", "Malay"}
+		m.Questions[0].Factor = map[string]string{"English": "Struggles with stairs", "Malay": "Sukar menaiki tangga"}
+		m.Questions[0].Advice = map[string]string{"English": "Use the handrail.", "Malay": "Gunakan pemegang tangga."}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(m *RiskModel)
+		wantErr string
+	}{
+		{"as loaded", func(m *RiskModel) {}, ""},
+		{"explained in every language", explained, ""},
+		{"no name", func(m *RiskModel) { m.Name = "" }, "no name"},
+		{"no languages", func(m *RiskModel) { m.Languages = nil }, "no languages"},
+		{"unknown language", func(m *RiskModel) { m.Languages = []string{"Klingon"} }, `language "Klingon"`},
+		{"repeated language", func(m *RiskModel) { m.Languages = []string{"English", "English"} }, "or is repeated"},
+		{"no questions", func(m *RiskModel) { m.Questions = nil }, "no questions"},
+		{"repeated question", func(m *RiskModel) { m.Questions[1].QuestionID = 1 }, "question ID 1"},
+		{"question ID zero", func(m *RiskModel) { m.Questions[0].QuestionID = 0 }, "question ID 0"},
+		{"no points", func(m *RiskModel) { m.Questions[0].Points = nil }, "has no points"},
+		{"negative points", func(m *RiskModel) { m.Questions[0].Points = []int{-1, 2} }, "negative points"},
+		{"factor missing a language", func(m *RiskModel) {
+			explained(m)
+			delete(m.Questions[0].Factor, "Malay")
+		}, "needs its factor"},
+		{"advice in a language the model lacks", func(m *RiskModel) {
+			explained(m)
+			delete(m.Questions[0].Advice, "Malay")
+			m.Questions[0].Advice["Tamil"] = "..."
+		}, "no advice in Malay"},
+		{"factor without advice", func(m *RiskModel) {
+			explained(m)
+			m.Questions[0].Advice = nil
+		}, "needs its advice"},
+		{"bands checked too", func(m *RiskModel) { m.Bands = nil }, "no bands"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := stepsModel(t)
+			tt.change(model)
+			err := model.validate()
+			if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateBands(t *testing.T) {
+	low := func(max *int) RiskBand {
+		return RiskBand{RiskLevel: "Low", MaxScore: max, Recommendation: "Keep active."}
+	}
+	moderate := func(max *int) RiskBand {
+		return RiskBand{RiskLevel: "Moderate", MaxScore: max, Recommendation: "Exercise."}
+	}
+	high := RiskBand{RiskLevel: "High", Recommendation: "See a doctor."}
+
+	tests := []struct {
+		name    string
+		bands   []RiskBand
+		wantErr string
+	}{
+		{"three bands", []RiskBand{low(maxScore(5)), moderate(maxScore(10)), high}, ""},
+		{"two bands", []RiskBand{low(maxScore(5)), high}, ""},
+		{"one band for every score", []RiskBand{high}, ""},
+		{"no bands", nil, "no bands"},
+		{"overlapping", []RiskBand{low(maxScore(10)), moderate(maxScore(5)), high}, "max_score must be higher"},
+		{"same maximum twice", []RiskBand{low(maxScore(5)), moderate(maxScore(5)), high}, "max_score must be higher"},
+		{"last band capped", []RiskBand{low(maxScore(5)), moderate(maxScore(10))}, "the last must have none"},
+		{"gap before the last", []RiskBand{low(maxScore(5)), moderate(nil), high}, "every band but the last"},
+		{"unknown level", []RiskBand{low(maxScore(5)), {RiskLevel: "Severe", Recommendation: "Call."}}, `"Severe"`},
+		{"repeated level", []RiskBand{low(maxScore(5)), low(nil)}, "or is repeated"},
+		{"no recommendation", []RiskBand{low(maxScore(5)), {RiskLevel: "High"}}, "no recommendation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBands(tt.bands)
+			if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBandFor(t *testing.T) {
+	bands := []RiskBand{
+		{RiskLevel: "Low", MaxScore: maxScore(5)},
+		{RiskLevel: "Moderate", MaxScore: maxScore(10)},
+		{RiskLevel: "High"},
+	}
+	tests := []struct {
+		score float64
+		want  string
+	}{
+		{0, "Low"},
+		{5, "Low"},
+		{5.1, "Moderate"},
+		{10, "Moderate"},
+		{10.5, "High"},
+		{1000, "High"},
+	}
+	for _, tt := range tests {
+		if got := bandFor(bands, tt.score); got.RiskLevel != tt.want {
+			t.Errorf("bandFor(%v) = %s, want %s", tt.score, got.RiskLevel, tt.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name          string
+		questionnaire map[string]map[int]int
+		wantErr       string
+	}{
+		{"same questions and options", map[string]map[int]int{"English": {1: 2, 2: 3}}, ""},
+		{"question missing", map[string]map[int]int{"English": {1: 2}}, "question 2 is not in the English questionnaire"},
+		{"extra question", map[string]map[int]int{"English": {1: 2, 2: 3, 3: 2}}, "English question 3 has no points"},
+		{"option count differs", map[string]map[int]int{"English": {1: 2, 2: 4}}, "question 2 has 4 options in English but 3 points"},
+		{"language not fetched", map[string]map[int]int{}, "question 1 is not in the English questionnaire; question 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := stepsModel(t).matches(tt.questionnaire)
+			if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// A Self-Assessment service serving the steps questionnaire, with an outage that can be
+// switched on
+type questionnaireStub struct {
+	server *httptest.Server
+	down   atomic.Bool
+}
+
+func newQuestionnaireStub(t *testing.T) *questionnaireStub {
+	t.Helper()
+	stub := &questionnaireStub{}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := auth.ParseToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if err != nil || claims.Subject != auth.ServiceSubject {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if stub.down.Load() || r.URL.Query().Get("instrument") != "steps" {
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"question_id": 1, "question_options": []string{"No", "Yes"}},
+			{"question_id": 2, "question_options": []string{"Always", "Sometimes", "Never"}},
+		})
+	}))
+	t.Cleanup(stub.server.Close)
+
+	previous := cfg.Services.SelfAssessment
+	cfg.Services.SelfAssessment = stub.server.URL
+	t.Cleanup(func() { cfg.Services.SelfAssessment = previous })
+	return stub
+}
+
+func TestReload(t *testing.T) {
+	stub := newQuestionnaireStub(t)
+	dir := t.TempDir()
+	registry := riskModelRegistry{instruments: map[string]*instrumentModels{}}
+
+	// Each step adds a fixture to the rules directory as the next version and reloads
+	steps := []struct {
+		name        string
+		fixture     string
+		down        bool
+		wantErr     string
+		wantVersion int
+	}{
+		{"first version", "steps_v1.json", false, "", 1},
+		{"new version picked up", "steps_v2.json", false, "", 2},
+		{"unchanged directory", "", false, "", 2},
+		{"overlapping bands kept out", "overlapping_bands_v3.json", false, "max_score must be higher", 2},
+		{"broken file not retried", "", false, "", 2},
+		{"model not matching the questionnaire kept out", "extra_option_v4.json", false, "does not match the questionnaire", 2},
+		{"misspelled key kept out", "misspelled_key_v5.json", false, `unknown field "advise"`, 2},
+		{"questionnaire down, new version waits", "steps_v6.json", true, "", 2},
+		{"questionnaire back, waiting version checked", "", false, "", 6},
+	}
+	for i, step := range steps {
+		if step.fixture != "" {
+			data, err := os.ReadFile(filepath.Join("testdata", step.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var version struct{ Version int }
+			json.Unmarshal(data, &version)
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("steps_v%d.json", version.Version)), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stub.down.Store(step.down)
+
+		err := registry.reload(dir)
+		if (err == nil) != (step.wantErr == "") || (err != nil && !strings.Contains(err.Error(), step.wantErr)) {
+			t.Fatalf("step %d, %s: err = %v, want %q", i+1, step.name, err, step.wantErr)
+		}
+		model, err := registry.active("steps")
+		if err != nil || model.Version != step.wantVersion {
+			t.Fatalf("step %d, %s: active model %+v, %v, want version %d", i+1, step.name, model, err, step.wantVersion)
+		}
+	}
+
+	// A rule file edited in place is picked up too
+	path := filepath.Join(dir, "steps_v6.json")
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(data), "Keep active.", "Keep active and wear good shoes.", 1)), 0o644)
+	if err := registry.reload(dir); err != nil {
+		t.Fatal(err)
+	}
+	if model, _ := registry.active("steps"); model.Bands[0].Recommendation != "Keep active and wear good shoes." {
+		t.Fatalf("edited model not reloaded: %+v", model.Bands[0])
+	}
+
+	if _, err := registry.active("unknown"); err != errUnknownInstrument {
+		t.Fatalf("unknown instrument: %v", err)
+	}
+}
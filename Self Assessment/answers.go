@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Why an answer cannot be scored
+const (
+	answerMissing         = "missing"
+	answerUnknownQuestion = "unknown_question"
+	answerInvalidOption   = "invalid_option"
+)
+
+// A problem with one answer of a submission
+type AnswerProblem struct {
+	QuestionID int    `json:"question_id"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+// Status of a submission: scored, or left unscored and unstored because of problems
+const (
+	assessmentComplete   = "complete"
+	assessmentIncomplete = "incomplete"
+)
+
+// Check the answers give one of the stored options, as a 1-based index, for every question of
+// the questionnaire and for nothing else. Every question is required.
+func validateAnswers(questions []Question, answers map[int]int) ([]AnswerProblem, error) {
+	problems := []AnswerProblem{}
+	asked := map[int]bool{}
+	for _, q := range questions {
+		asked[q.QuestionID] = true
+		var options []string
+		if err := json.Unmarshal([]byte(q.Options), &options); err != nil {
+			return nil, fmt.Errorf("options of question %d: %w", q.QuestionID, err)
+		}
+
+		answer, answered := answers[q.QuestionID]
+		if !answered {
+			problems = append(problems, AnswerProblem{q.QuestionID, answerMissing, fmt.Sprintf("Question %d is not answered", q.QuestionID)})
+		} else if answer < 1 || answer > len(options) {
+			problems = append(problems, AnswerProblem{q.QuestionID, answerInvalidOption,
+				fmt.Sprintf("Question %d has options 1 to %d, not %d", q.QuestionID, len(options), answer)})
+		}
+	}
+	for questionID := range answers {
+		if !asked[questionID] {
+			problems = append(problems, AnswerProblem{questionID, answerUnknownQuestion, fmt.Sprintf("There is no question %d", questionID)})
+		}
+	}
+
+	slices.SortFunc(problems, func(a, b AnswerProblem) int { return a.QuestionID - b.QuestionID })
+	return problems, nil
+}
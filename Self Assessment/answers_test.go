@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"common/auth"
+	"common/rbac"
+)
+
+func init() {
+	auth.SetSecret("self-assessment-answer-tests")
+}
+
+func TestValidateAnswers(t *testing.T) {
+	questions := []Question{
+		{1, "Do you experience dizziness?", `["Yes", "No"]`},
+		{2, "How is your balance?", `["Good", "Fair", "Poor"]`},
+		{3, "Have you fallen in the past year?", `["Never", "Once", "More than once"]`},
+	}
+
+	tests := []struct {
+		name    string
+		answers map[int]int
+		want    []AnswerProblem
+	}{
+		{"every question answered", map[int]int{1: 1, 2: 3, 3: 2}, []AnswerProblem{}},
+		{"no answers", nil, []AnswerProblem{
+			{1, answerMissing, "Question 1 is not answered"},
+			{2, answerMissing, "Question 2 is not answered"},
+			{3, answerMissing, "Question 3 is not answered"},
+		}},
+		{"one unanswered", map[int]int{1: 1, 3: 2}, []AnswerProblem{{2, answerMissing, "Question 2 is not answered"}}},
+		{"option zero", map[int]int{1: 0, 2: 1, 3: 1}, []AnswerProblem{{1, answerInvalidOption, "Question 1 has options 1 to 2, not 0"}}},
+		{"option past the last", map[int]int{1: 1, 2: 4, 3: 1}, []AnswerProblem{{2, answerInvalidOption, "Question 2 has options 1 to 3, not 4"}}},
+		{"negative option", map[int]int{1: 1, 2: 1, 3: -1}, []AnswerProblem{{3, answerInvalidOption, "Question 3 has options 1 to 3, not -1"}}},
+		{"unknown question", map[int]int{1: 1, 2: 1, 3: 1, 11: 1}, []AnswerProblem{{11, answerUnknownQuestion, "There is no question 11"}}},
+		{"several problems, by question", map[int]int{0: 1, 2: 9, 3: 1}, []AnswerProblem{
+			{0, answerUnknownQuestion, "There is no question 0"},
+			{1, answerMissing, "Question 1 is not answered"},
+			{2, answerInvalidOption, "Question 2 has options 1 to 3, not 9"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateAnswers(questions, tt.answers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := validateAnswers([]Question{{1, "Broken", `not json`}}, map[int]int{1: 1}); err == nil {
+		t.Fatal("stored options that are not JSON were accepted")
+	}
+}
+
+// A Risk Assessment service answering every analysis with the given status and body, counting
+// the requests it gets
+func newRiskStub(t *testing.T, status int, body interface{}) *atomic.Int32 {
+	t.Helper()
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := cfg.Services.RiskAssessment
+	cfg.Services.RiskAssessment = server.URL
+	t.Cleanup(func() { cfg.Services.RiskAssessment = previous })
+	return &calls
+}
+
+// Submit answers to the custom questionnaire as senior 7
+func submit(t *testing.T, store *Store, answers map[int]int) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := auth.IssueToken(7, rbac.RoleSenior, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{"user_id": 7, "answers": answers})
+	req := httptest.NewRequest(http.MethodPost, "/api/addAssessmentResults", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addAssessmentHandler(w, r, store, false)
+	})).ServeHTTP(rec, req)
+	return rec
+}
+
+type incompleteResponse struct {
+	Status   string          `json:"status"`
+	Problems []AnswerProblem `json:"problems"`
+}
+
+func TestIncompleteAssessmentIsNotScored(t *testing.T) {
+	calls := newRiskStub(t, http.StatusOK, nil)
+	store := newMemoryStore()
+
+	// Question 10 is left out and question 2 has three options
+	answers := map[int]int{1: 1, 2: 4, 3: 1, 4: 1, 5: 1, 6: 1, 7: 1, 8: 1, 9: 1}
+	rec := submit(t, store, answers)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422: %s", rec.Code, rec.Body)
+	}
+	var response incompleteResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	want := incompleteResponse{Status: assessmentIncomplete, Problems: []AnswerProblem{
+		{2, answerInvalidOption, "Question 2 has options 1 to 3, not 4"},
+		{10, answerMissing, "Question 10 is not answered"},
+	}}
+	if !reflect.DeepEqual(response, want) {
+		t.Fatalf("response %+v, want %+v", response, want)
+	}
+
+	if calls.Load() != 0 {
+		t.Fatal("incomplete answers were sent to the Risk Assessment service")
+	}
+	if stored, _ := store.Assessments.ListByUser(7, ""); len(stored) != 0 {
+		t.Fatalf("incomplete assessment stored: %+v", stored)
+	}
+}
+
+func TestRiskAssessmentProblemsArePassedOn(t *testing.T) {
+	// The scoring model disagrees with the stored questionnaire about question 4
+	problems := []AnswerProblem{{4, answerInvalidOption, "Question 4 has options 1 to 3, not 4"}}
+	newRiskStub(t, http.StatusUnprocessableEntity, map[string]interface{}{"status": "incomplete", "problems": problems})
+	store := newMemoryStore()
+
+	rec := submit(t, store, map[int]int{1: 1, 2: 1, 3: 1, 4: 4, 5: 1, 6: 1, 7: 1, 8: 1, 9: 1, 10: 1})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422: %s", rec.Code, rec.Body)
+	}
+	var response incompleteResponse
+	json.NewDecoder(rec.Body).Decode(&response)
+	if response.Status != assessmentIncomplete || !reflect.DeepEqual(response.Problems, problems) {
+		t.Fatalf("response %+v", response)
+	}
+	if stored, _ := store.Assessments.ListByUser(7, ""); len(stored) != 0 {
+		t.Fatalf("assessment stored: %+v", stored)
+	}
+}
+
+func TestCompleteAssessmentIsStored(t *testing.T) {
+	// Low risk, so no emails, notifications or alerts are sent
+	newRiskStub(t, http.StatusOK, map[string]interface{}{
+		"total_score": 3, "risk_level": "Low", "recommendation": "Keep active.", "model_version": 2,
+	})
+	store := newMemoryStore()
+
+	rec := submit(t, store, map[int]int{1: 2, 2: 1, 3: 1, 4: 1, 5: 1, 6: 2, 7: 1, 8: 2, 9: 1, 10: 2})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	stored, err := store.Assessments.ListByUser(7, "")
+	if err != nil || len(stored) != 1 || stored[0].RiskLevel != "Low" || stored[0].ModelVersion != 2 {
+		t.Fatalf("stored %+v, %v", stored, err)
+	}
+}
@@ -2,166 +2,251 @@ package main
 
 import (
 	"bytes"
-	"crypto/tls"
-	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
-	"time"
+	"strings"
+
+	"common/audit"
+	"common/auth"
+	"common/config"
+	"common/consent"
+	"common/fieldcrypt"
+	"common/migrate"
+	"common/rbac"
+	"common/respond"
+	"common/server"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
-	"github.com/rs/cors"
 	"gopkg.in/gomail.v2"
 )
 
-// Database connection function
-func connectDB(dbUser, dbPassword, dbHost, dbName string) (*sql.DB, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", dbUser, dbPassword, dbHost, dbName)
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
-	}
-	return db, db.Ping()
-}
-
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading .env file:", err)
+	reencrypt := flag.Bool("reencrypt", false, "Re-encrypt stored answers under the current key, then exit")
+	flag.Parse()
+
+	// Load settings from the environment and .env files, listing everything missing at once
+	config.MustLoad(&cfg)
+
+	// `go run . migrate up|down|status|seed|baseline` manages the schema instead of serving
+	if flag.Arg(0) == "migrate" {
+		if err := migrate.Run(cfg.DB, schemaFiles, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
 	}
 
-	// Get database connection details from environment variables
-	dbUser, dbPassword, dbHost, dbName := os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_HOST"), os.Getenv("DB_NAME")
-	if dbUser == "" || dbPassword == "" || dbHost == "" || dbName == "" {
-		log.Fatal("Database credentials not fully set in environment variables")
+	auth.SetSecret(cfg.JWTSecret.Value())
+	if err := auth.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
 	}
 
-	// Get local port from environment variables
-	//localPort := os.Getenv("LOCAL_PORT")
-	localPort := "5000"
-	if localPort == "" {
-		log.Fatal("LOCAL_PORT environment variable is not set")
+	// Load the master keys assessment answers are encrypted with
+	if err := fieldcrypt.LoadKeys([]byte(cfg.FieldKeys.Value())); err != nil {
+		log.Fatalf("Failed to load field encryption keys: %v", err)
 	}
 
-	// Connect to the database
-	db, err := connectDB(dbUser, dbPassword, dbHost, dbName)
+	// Connect to the database, or keep everything in memory with STORAGE=memory
+	store, err := openStore()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	defer store.Close()
+
+	if *reencrypt {
+		// Nothing is encrypted in memory
+		if store.db == nil {
+			log.Fatal("Re-encryption needs STORAGE=mysql")
+		}
+		if err := fieldcrypt.ReencryptColumns(store.db, assessmentEncryptedColumns); err != nil {
+			log.Fatalf("Re-encryption failed: %v", err)
+		}
+		log.Println("Re-encryption finished")
+		return
 	}
-	defer db.Close()
 
 	// Initialize the router
 	router := mux.NewRouter()
+	router.Use(auth.Middleware)
 
 	// API Routes
-	router.HandleFunc("/api/questionnaire", func(w http.ResponseWriter, r *http.Request) {
-		questionnaireHandler(w, r, db)
-	}).Methods("GET")
-	router.HandleFunc("/api/addAssessmentResults", func(w http.ResponseWriter, r *http.Request) {
-		addAssessmentHandler(w, r, db)
-	}).Methods("POST")
-	router.HandleFunc("/api/getLastAssessment", func(w http.ResponseWriter, r *http.Request) {
-		getLastAssessmentHandler(w, r, db)
-	}).Methods("POST")
-	router.HandleFunc("/api/getAssessment", func(w http.ResponseWriter, r *http.Request) {
-		getAssessmentHandler(w, r, db)
-	}).Methods("POST")
-	router.HandleFunc("/api/assessmentHistory", func(w http.ResponseWriter, r *http.Request) {
-		assessmentHistoryHandler(w, r, db)
-	}).Methods("POST")
-
-	// CORS Configuration
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST"},
-		AllowedHeaders:   []string{"Content-Type"},
-		AllowCredentials: true,
-	})
-	handler := c.Handler(router)
-
-	log.Printf("Starting server on :%s", localPort)
-	if err := http.ListenAndServe(":"+localPort, handler); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	router.HandleFunc("/api/questionnaire", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		questionnaireHandler(w, r, store)
+	}, rbac.PermQuestionnaireRead)).Methods("GET")
+	router.HandleFunc("/api/instruments", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		instrumentsHandler(w, r)
+	}, rbac.PermQuestionnaireRead)).Methods("GET")
+	router.HandleFunc("/api/addAssessmentResults", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		addAssessmentHandler(w, r, store, false)
+	}, rbac.PermAssessmentSubmit)).Methods("POST")
+	router.HandleFunc("/api/clinician/addAssessmentResults", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		addAssessmentHandler(w, r, store, true)
+	}, rbac.PermRiskClinical)).Methods("POST")
+	router.HandleFunc("/api/getLastAssessment", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		getLastAssessmentHandler(w, r, store)
+	}, rbac.PermAssessmentRead)).Methods("POST")
+	router.HandleFunc("/api/getAssessment", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		getAssessmentHandler(w, r, store)
+	}, rbac.PermAssessmentRead)).Methods("POST")
+	router.HandleFunc("/api/assessmentHistory", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		assessmentHistoryHandler(w, r, store)
+	}, rbac.PermAssessmentRead)).Methods("POST")
+	router.HandleFunc("/api/audit", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.Audit.SearchHandler(w, r)
+	}, rbac.PermAuditRead)).Methods("GET")
+	router.HandleFunc("/api/audit/verify", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.Audit.VerifyHandler(w, r)
+	}, rbac.PermAuditRead)).Methods("GET")
+	router.HandleFunc("/api/personalData/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		exportPersonalDataHandler(w, r, store)
+	}, rbac.PermDataExport)).Methods("GET")
+	router.HandleFunc("/api/personalData/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		erasePersonalDataHandler(w, r, store)
+	}, rbac.PermDataErase)).Methods("DELETE")
+
+	// Serve until stopped, letting requests in progress finish
+	if err := server.Run("Self assessment", cfg.Port, server.CORS(router, "GET", "POST")); err != nil {
+		log.Fatalf("Server failed: %v", err)
 	}
 }
 
+// Columns holding health data, which are stored encrypted
+var assessmentEncryptedColumns = []fieldcrypt.Column{
+	{Table: "Assessments", Key: "AssessmentID", Column: "QuestionResponses"},
+	{Table: "Assessments", Key: "AssessmentID", Column: "Explanation"},
+}
+
 // Structure to handle assessment submissions
 
 type Assessment struct {
-	AssessmentID   int    `json:"id,omitempty"`
-	TotalScore     int    `json:"totalScore"`
-	RiskLevel      string `json:"riskLevel"`
-	Recommendation string `json:"recommendation"`
-	DateCreated    string `json:"dateCreated,omitempty"`
-	UserID         int    `json:"user_id,omitempty"`
+	AssessmentID   int             `json:"id,omitempty"`
+	Instrument     string          `json:"instrument"`
+	TotalScore     int             `json:"totalScore"`
+	RiskLevel      string          `json:"riskLevel"`
+	Recommendation string          `json:"recommendation"`
+	ModelVersion   int             `json:"modelVersion,omitempty"`
+	Explanation    json.RawMessage `json:"explanation,omitempty"`
+	DateCreated    string          `json:"dateCreated,omitempty"`
+	UserID         int             `json:"user_id,omitempty"`
+}
+
+// Each answer's points and the factors that raised the score most, as worked out by the Risk
+// Assessment service in the questionnaire language
+type Explanation struct {
+	Language  string `json:"language"`
+	Breakdown []struct {
+		QuestionID int `json:"question_id"`
+		Option     int `json:"option"`
+		Points     int `json:"points"`
+	} `json:"breakdown"`
+	Factors []struct {
+		QuestionID int    `json:"question_id"`
+		Factor     string `json:"factor"`
+		Advice     string `json:"advice"`
+	} `json:"factors"`
+}
+
+// A stored explanation as JSON, or nothing for assessments scored before explanations
+func explanationJSON(stored string) json.RawMessage {
+	if stored == "" {
+		return nil
+	}
+	return json.RawMessage(stored)
 }
 
-// Handler to retrieve questionnaire questions based on language (GET request with query string)
-func questionnaireHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	// Get language from query parameters
+// Handler to retrieve questionnaire questions based on instrument and language (GET request with query string)
+func questionnaireHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	// Get language and instrument from query parameters
 	language := r.URL.Query().Get("language")
 	if language == "" {
 		language = "English" // Default to English if not specified
 	}
-
-	// Map supported languages to their respective tables
-	languageMap := map[string]string{
-		"English": "QuestionsEn",
-		"Chinese": "QuestionsCn",
-		"Malay":   "QuestionsMy",
-		"Tamil":   "QuestionsTa",
+	instrument := r.URL.Query().Get("instrument")
+	if instrument == "" {
+		instrument = defaultInstrument
 	}
 
-	// Check if language is supported
-	tableName, exists := languageMap[language]
-	if !exists {
+	// Get the instrument's questions in the selected language
+	stored, err := store.Questions.List(instrument, language)
+	if err == errUnsupportedLanguage {
 		http.Error(w, "Unsupported language", http.StatusBadRequest)
 		return
 	}
-
-	// Query the database for questions in the selected language
-	query := fmt.Sprintf("SELECT QuestionID, QuestionContent, QuestionOptions FROM %s", tableName)
-	rows, err := db.Query(query)
+	if err == errInstrumentNotOffered {
+		http.Error(w, "Instrument is not offered in this language", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	// Store retrieved questions
-	var questions []map[string]interface{}
-
-	for rows.Next() {
-		var questionID int
-		var questionContent string
-		var questionOptions string // Stored in JSON format
-
-		if err := rows.Scan(&questionID, &questionContent, &questionOptions); err != nil {
-			log.Println("Data retrieval error: ", err)
-			http.Error(w, "Data retrieval error", http.StatusInternalServerError)
-			return
-		}
 
+	questions := []map[string]interface{}{}
+	for _, q := range stored {
 		questions = append(questions, map[string]interface{}{
-			"question_id":      questionID,
-			"question_content": questionContent,
-			"question_options": json.RawMessage(questionOptions), // Ensure JSON format
+			"question_id":      q.QuestionID,
+			"question_content": q.Content,
+			"question_options": json.RawMessage(q.Options), // Ensure JSON format
 		})
 	}
 
 	// Send the response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(questions)
+	respond.JSON(w, questions)
+}
+
+// An instrument risk can be scored with, as listed by the Risk Assessment service
+type Instrument struct {
+	Instrument   string   `json:"instrument"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Languages    []string `json:"languages"`
+	Clinician    bool     `json:"clinician"` // Scored by a clinician, not offered to seniors
+	ModelVersion int      `json:"model_version"`
+}
+
+// List the instruments that can be taken, as scored by the Risk Assessment service. It only
+// lists clinician-scored instruments for callers who may score them.
+func instrumentsHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := http.NewRequest("GET", cfg.Services.RiskAssessment+"/api/instruments", nil)
+	if err != nil {
+		log.Println("Error creating instruments request:", err)
+		http.Error(w, "Failed to fetch instruments", http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Authorization", r.Header.Get("Authorization"))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Println("Error calling Risk Assessment Service:", err)
+		http.Error(w, "Failed to fetch instruments", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Println("Risk Assessment Service returned status:", resp.Status)
+		http.Error(w, "Failed to fetch instruments", http.StatusBadGateway)
+		return
+	}
+
+	var instruments []Instrument
+	if err := json.NewDecoder(resp.Body).Decode(&instruments); err != nil {
+		log.Println("Error decoding instruments:", err)
+		http.Error(w, "Failed to fetch instruments", http.StatusBadGateway)
+		return
+	}
+
+	respond.JSON(w, instruments)
 }
 
 // Call Alert Service to send user notification
-func sendNotification(userID int, riskLevel string) {
+func sendNotification(userID int, riskLevel string, authHeader string, notifyCaregivers bool) {
 	// Define the notification message
 	var message string
 	if riskLevel == "Moderate" {
@@ -178,8 +263,16 @@ func sendNotification(userID int, riskLevel string) {
 		"message": message,
 	})
 
-	// Send POST request to Alerts Service
-	resp, err := http.Post("http://localhost:5002/api/postNotifications", "application/json", bytes.NewBuffer(notificationBody))
+	// Send POST request to Alerts Service on behalf of the user
+	req, err := http.NewRequest("POST", cfg.Services.Notifications+"/api/postNotifications", bytes.NewBuffer(notificationBody))
+	if err != nil {
+		log.Println("Failed to create notification request:", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Println("Failed to send notification:", err)
 		return
@@ -188,20 +281,73 @@ func sendNotification(userID int, riskLevel string) {
 
 	// Log notification response
 	log.Printf("Notification sent for user %d with risk level %s\n", userID, riskLevel)
+
+	// Let the senior's approved caregivers know as well. They are looked up with the senior's
+	// own token, so not for an assessment a clinician submitted.
+	if notifyCaregivers {
+		sendCaregiverEmail(userID, message, authHeader)
+	}
+}
+
+// Ask the Email service to send a Moderate/High risk notification to the senior's caregivers
+func sendCaregiverEmail(userID int, message string, authHeader string) {
+	if !consent.Granted(cfg.Services.User, userID, consent.ShareCaregiver, authHeader) {
+		log.Printf("User %d has not consented to sharing results with caregivers, caregiver email not sent\n", userID)
+		return
+	}
+
+	requestBody, _ := json.Marshal(map[string]interface{}{
+		"user_id": userID,
+		"message": message,
+	})
+
+	req, err := http.NewRequest("POST", cfg.Services.Email+"/sendCaregiverNotification", bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Println("Error creating caregiver email request:", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Println("Error sending caregiver notification to email microservice:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Println("Email service returned", resp.Status, "for caregiver notification")
+		return
+	}
+	log.Printf("Caregiver notification for user %d sent to email microservice\n", userID)
 }
 
 // Call Alert Service to send doctor alert
-func sendAlertToDoctors(userID int, assessmentID int64) {
+func sendAlertToDoctors(userID int, assessmentID int64, authHeader string) {
+	if !consent.Granted(cfg.Services.User, userID, consent.ShareDoctor, authHeader) {
+		log.Printf("User %d has not consented to sharing results with doctors, alert not sent\n", userID)
+		return
+	}
 	log.Printf("Sending alert for high-risk user %d (Assessment ID: %d)\n", userID, assessmentID)
 
 	// Create JSON payload with type "HealthAssessment"
 	alertBody, _ := json.Marshal(map[string]interface{}{
 		"assessment_id": assessmentID,
+		"user_id":       userID,
 		"type":          "HealthAssessment", // Explicitly setting the type
 	})
 
-	// Send POST request to Notification Service
-	resp, err := http.Post("http://localhost:5002/api/postAlerts", "application/json", bytes.NewBuffer(alertBody))
+	// Send POST request to Notification Service on behalf of the user
+	req, err := http.NewRequest("POST", cfg.Services.Notifications+"/api/postAlerts", bytes.NewBuffer(alertBody))
+	if err != nil {
+		log.Println("Failed to create alert request:", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Println("Failed to send alert to doctors:", err)
 		return
@@ -211,12 +357,56 @@ func sendAlertToDoctors(userID int, assessmentID int64) {
 	log.Printf("Alert successfully sent for assessment %d\n", assessmentID)
 }
 
+// Look up the email addresses of the doctors currently assigned to the user
+func fetchCareTeamEmails(userID int, authHeader string) ([]string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/careTeam/%d", cfg.Services.Doctor, userID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doctor service returned %s", resp.Status)
+	}
+
+	var careTeam []struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&careTeam); err != nil {
+		return nil, err
+	}
+
+	emails := make([]string, 0, len(careTeam))
+	for _, doctor := range careTeam {
+		emails = append(emails, doctor.Email)
+	}
+	return emails, nil
+}
+
 // Email sender function
-func sendRiskAlertEmail(userID int, riskLevel string, assessmentID int64) {
+func sendRiskAlertEmail(userID int, riskLevel string, assessmentID int64, authHeader string) {
+	if !consent.Granted(cfg.Services.User, userID, consent.ShareDoctor, authHeader) {
+		log.Printf("User %d has not consented to sharing results with doctors, risk alert email not sent\n", userID)
+		return
+	}
+
 	// Set up email details
-	sender := "newuploadedvideo@gmail.com"
-	password := "agof rvwb lreo tups"          // Use an App Password if using Gmail
-	recipient := "s10247445@connect.np.edu.sg" // Change to the doctor's real email
+	sender := cfg.SMTP.Username
+
+	// Send to the user's doctors
+	recipients, err := fetchCareTeamEmails(userID, authHeader)
+	if err != nil {
+		log.Println("Failed to fetch care team:", err)
+	}
+	if len(recipients) == 0 {
+		recipients = []string{cfg.ClinicInbox}
+	}
 
 	// Email subject and body
 	subject := "Urgent: Risk Assessment Alert for User " + strconv.Itoa(userID)
@@ -225,17 +415,16 @@ func sendRiskAlertEmail(userID int, riskLevel string, assessmentID int64) {
 		<p><strong>User ID:</strong> %d</p>
 		<p><strong>Risk Level:</strong> %s</p>
 		<p>Please review the report immediately.</p>
-		<p><a href="http://localhost:5500/report.html?assessment_id=%d" style="color: #007bff; font-weight: bold;">View Report</a></p>
-	`, userID, riskLevel, assessmentID)
+		<p><a href="%s/report.html?assessment_id=%d" style="color: #007bff; font-weight: bold;">View Report</a></p>
+	`, userID, riskLevel, cfg.Services.FrontEnd, assessmentID)
 
 	// Configure SMTP
-	d := gomail.NewDialer("smtp.gmail.com", 587, sender, password)
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	d := gomail.NewDialer(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password.Value())
 
 	// Create email message
 	m := gomail.NewMessage()
 	m.SetHeader("From", sender)
-	m.SetHeader("To", recipient)
+	m.SetHeader("To", recipients...)
 	m.SetHeader("Subject", subject)
 	m.SetBody("text/html", body)
 
@@ -243,15 +432,18 @@ func sendRiskAlertEmail(userID int, riskLevel string, assessmentID int64) {
 	if err := d.DialAndSend(m); err != nil {
 		log.Println("Failed to send risk alert email:", err)
 	} else {
-		log.Println("Risk alert email sent successfully to", recipient)
+		log.Println("Risk alert email sent successfully to", recipients)
 	}
 }
 
-// Add Results from Risk Assessment into DB
-func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+// Add Results from Risk Assessment into DB, for a senior's own assessment or, with clinician
+// set, one a doctor scored for their patient
+func addAssessmentHandler(w http.ResponseWriter, r *http.Request, store *Store, clinician bool) {
 	type Request struct {
-		UserID  int         `json:"user_id"`
-		Answers map[int]int `json:"answers"`
+		UserID     int         `json:"user_id"`
+		Instrument string      `json:"instrument"` // The custom questionnaire if left out
+		Answers    map[int]int `json:"answers"`
+		Language   string      `json:"language"` // Questionnaire language, for the explanation
 	}
 
 	var req Request
@@ -262,6 +454,12 @@ func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
 		return
 	}
+	if req.Language == "" {
+		req.Language = "English"
+	}
+	if req.Instrument == "" {
+		req.Instrument = defaultInstrument
+	}
 
 	// Validate User ID
 	if req.UserID <= 0 {
@@ -270,6 +468,46 @@ func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Seniors submit their own assessments, and doctors score their patients with a clinician
+	// instrument. The Risk Assessment service refuses an instrument taken the wrong way.
+	if clinician {
+		if !auth.AuthorizeUser(w, r, req.UserID) {
+			return
+		}
+	} else if claims := auth.ClaimsFromRequest(r); claims == nil || claims.Role != rbac.RoleSenior || claims.Subject != req.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	authHeader := r.Header.Get("Authorization")
+
+	questions, err := store.Questions.List(req.Instrument, req.Language)
+	if err != nil {
+		if err == errUnsupportedLanguage {
+			http.Error(w, "Unsupported language", http.StatusBadRequest)
+		} else if err == errInstrumentNotOffered {
+			http.Error(w, "Instrument is not offered in this language", http.StatusBadRequest)
+		} else {
+			log.Println("Database query error:", err)
+			http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// A partial or malformed submission would score falsely low, so it is not scored at all
+	problems, err := validateAnswers(questions, req.Answers)
+	if err != nil {
+		log.Println("Questionnaire error:", err)
+		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
+		return
+	}
+	if len(problems) > 0 {
+		respond.JSONStatus(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"status":   assessmentIncomplete,
+			"problems": problems,
+		})
+		return
+	}
+
 	// Convert answers to JSON format
 	answersJSON, err := json.Marshal(req.Answers)
 	if err != nil {
@@ -286,8 +524,21 @@ func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Call Risk Assessment Service
-	riskResponse, err := http.Post("http://localhost:8080/api/analyzeRisk", "application/json", bytes.NewBuffer(riskRequestBody))
+	// Call Risk Assessment Service, forwarding the caller's token
+	riskURL := cfg.Services.RiskAssessment + "/api/analyzeRisk"
+	if clinician {
+		riskURL = cfg.Services.RiskAssessment + "/api/clinician/analyzeRisk"
+	}
+	riskRequest, err := http.NewRequest("POST", riskURL, bytes.NewBuffer(riskRequestBody))
+	if err != nil {
+		log.Println("Error creating risk assessment request:", err)
+		http.Error(w, "Failed to process risk assessment", http.StatusInternalServerError)
+		return
+	}
+	riskRequest.Header.Set("Content-Type", "application/json")
+	riskRequest.Header.Set("Authorization", authHeader)
+
+	riskResponse, err := http.DefaultClient.Do(riskRequest)
 	if err != nil {
 		log.Println("Error calling Risk Assessment Service:", err)
 		http.Error(w, "Failed to process risk assessment", http.StatusInternalServerError)
@@ -295,11 +546,41 @@ func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 	defer riskResponse.Body.Close()
 
+	// The Risk Assessment service checks the answers against its scoring model too
+	if riskResponse.StatusCode == http.StatusUnprocessableEntity {
+		var incomplete struct {
+			Problems []AnswerProblem `json:"problems"`
+		}
+		if err := json.NewDecoder(riskResponse.Body).Decode(&incomplete); err != nil {
+			log.Println("Error decoding risk assessment response:", err)
+			http.Error(w, "Failed to parse risk assessment response", http.StatusInternalServerError)
+			return
+		}
+		respond.JSONStatus(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"status":   assessmentIncomplete,
+			"problems": incomplete.Problems,
+		})
+		return
+	}
+	// Such as a clinician instrument submitted by a senior
+	if riskResponse.StatusCode == http.StatusBadRequest {
+		message, _ := io.ReadAll(io.LimitReader(riskResponse.Body, 1024))
+		http.Error(w, strings.TrimSpace(string(message)), http.StatusBadRequest)
+		return
+	}
+	if riskResponse.StatusCode != http.StatusOK {
+		log.Println("Risk Assessment Service returned status:", riskResponse.Status)
+		http.Error(w, "Failed to process risk assessment", http.StatusBadGateway)
+		return
+	}
+
 	// Parse Risk Assessment Response
 	var riskResult struct {
 		TotalScore     int    `json:"total_score"`
 		RiskLevel      string `json:"risk_level"`
 		Recommendation string `json:"recommendation"`
+		ModelVersion   int    `json:"model_version"`
+		Explanation
 	}
 
 	if err := json.NewDecoder(riskResponse.Body).Decode(&riskResult); err != nil {
@@ -307,53 +588,71 @@ func addAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		http.Error(w, "Failed to parse risk assessment response", http.StatusInternalServerError)
 		return
 	}
-
-	// Store results in the database
-	insertQuery := `INSERT INTO Assessments (UserID, QuestionResponses, TotalScore, RiskLevel, Recommendation, DateCreated) 
-                    VALUES (?, ?, ?, ?, ?, NOW())`
-	result, err := db.Exec(insertQuery, req.UserID, answersJSON, riskResult.TotalScore, riskResult.RiskLevel, riskResult.Recommendation)
+	explanation, err := json.Marshal(riskResult.Explanation)
 	if err != nil {
+		log.Println("Error encoding risk explanation:", err)
+		http.Error(w, "Failed to parse risk assessment response", http.StatusInternalServerError)
+		return
+	}
+
+	// Store results
+	record := AssessmentRecord{
+		UserID:         req.UserID,
+		Instrument:     req.Instrument,
+		Responses:      string(answersJSON),
+		TotalScore:     riskResult.TotalScore,
+		RiskLevel:      riskResult.RiskLevel,
+		Recommendation: riskResult.Recommendation,
+		ModelVersion:   riskResult.ModelVersion,
+		Explanation:    string(explanation),
+	}
+	if err := store.Assessments.Create(&record); err != nil {
 		log.Println("Database insert error:", err)
 		http.Error(w, "Failed to store assessment data", http.StatusInternalServerError)
 		return
 	}
 
-	assessmentID, _ := result.LastInsertId()
+	assessmentID := int64(record.AssessmentID)
+	store.Audit.Change(r, audit.Create, "assessment", strconv.FormatInt(assessmentID, 10), req.UserID)
 
 	// If risk is MODERATE or HIGH, send email to doctor
 	if riskResult.RiskLevel == "Moderate" || riskResult.RiskLevel == "High" {
-		go sendRiskAlertEmail(req.UserID, riskResult.RiskLevel, assessmentID)
+		go sendRiskAlertEmail(req.UserID, riskResult.RiskLevel, assessmentID, authHeader)
 	}
 
 	// If risk is MODERATE or HIGH, send a notification
 	if riskResult.RiskLevel == "Moderate" || riskResult.RiskLevel == "High" {
-		go sendNotification(req.UserID, riskResult.RiskLevel)
+		go sendNotification(req.UserID, riskResult.RiskLevel, authHeader, !clinician)
 	}
 
 	// **If risk is HIGH, send an alert to doctors**
 	if riskResult.RiskLevel == "High" {
-		go sendAlertToDoctors(req.UserID, assessmentID)
+		go sendAlertToDoctors(req.UserID, assessmentID, authHeader)
 	}
 
 	// Send response
 	response := map[string]interface{}{
+		"status":             assessmentComplete,
 		"assessment_id":      assessmentID,
 		"user_id":            req.UserID,
+		"instrument":         req.Instrument,
 		"total_score":        riskResult.TotalScore,
 		"risk_level":         riskResult.RiskLevel,
 		"recommendation":     riskResult.Recommendation,
+		"model_version":      riskResult.ModelVersion,
+		"explanation":        riskResult.Explanation,
 		"question_responses": req.Answers,
 	}
 
 	log.Println("Successfully stored assessment:", response)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	respond.JSON(w, response)
 }
 
 // Retrieve results of last assessment
-func getLastAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func getLastAssessmentHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	type Request struct {
-		UserID int `json:"user_id"`
+		UserID     int    `json:"user_id"`
+		Instrument string `json:"instrument"` // Any instrument if left out
 	}
 	var req Request
 
@@ -371,23 +670,15 @@ func getLastAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		return
 	}
 
-	// Query the database for the latest risk assessment for the user
-	query := `SELECT AssessmentID, TotalScore, RiskLevel, Recommendation 
-              FROM Assessments 
-              WHERE UserID = ? 
-              ORDER BY DateCreated DESC LIMIT 1`
-
-	assessment := Assessment{}
-
-	err := db.QueryRow(query, req.UserID).Scan(
-		&assessment.AssessmentID,
-		&assessment.TotalScore,
-		&assessment.RiskLevel,
-		&assessment.Recommendation,
-	)
+	// Seniors may only read their own assessments
+	if !auth.AuthorizeUser(w, r, req.UserID) {
+		return
+	}
 
+	// Look up the latest risk assessment for the user
+	latest, err := store.Assessments.Latest(req.UserID, req.Instrument)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == errAssessmentNotFound {
 			http.Error(w, "No risk assessments found for this user", http.StatusNotFound)
 		} else {
 			log.Println("Database query error:", err)
@@ -395,14 +686,26 @@ func getLastAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		}
 		return
 	}
+	assessment := Assessment{
+		AssessmentID:   latest.AssessmentID,
+		Instrument:     latest.Instrument,
+		TotalScore:     latest.TotalScore,
+		RiskLevel:      latest.RiskLevel,
+		Recommendation: latest.Recommendation,
+		ModelVersion:   latest.ModelVersion,
+		Explanation:    explanationJSON(latest.Explanation),
+	}
+
+	if !store.Audit.Access(w, r, "assessment", strconv.Itoa(assessment.AssessmentID), req.UserID) {
+		return
+	}
 
 	// Send JSON response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(assessment)
+	respond.JSON(w, assessment)
 }
 
 // Retrieve results of a specific assessment
-func getAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func getAssessmentHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	type Request struct {
 		AssessmentID int `json:"assessment_id"`
 	}
@@ -415,22 +718,10 @@ func getAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Query the database for the risk assessment for the user
-	query := `SELECT TotalScore, RiskLevel, Recommendation, UserID
-              FROM Assessments 
-              WHERE AssessmentID = ?`
-
-	assessment := Assessment{}
-
-	err := db.QueryRow(query, req.AssessmentID).Scan(
-		&assessment.TotalScore,
-		&assessment.RiskLevel,
-		&assessment.Recommendation,
-		&assessment.UserID,
-	)
-
+	// Look up the risk assessment
+	stored, err := store.Assessments.Get(req.AssessmentID)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == errAssessmentNotFound {
 			http.Error(w, "No risk assessments found for this user", http.StatusNotFound)
 		} else {
 			log.Println("Database query error:", err)
@@ -438,15 +729,33 @@ func getAssessmentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		}
 		return
 	}
+	assessment := Assessment{
+		Instrument:     stored.Instrument,
+		TotalScore:     stored.TotalScore,
+		RiskLevel:      stored.RiskLevel,
+		Recommendation: stored.Recommendation,
+		ModelVersion:   stored.ModelVersion,
+		Explanation:    explanationJSON(stored.Explanation),
+		UserID:         stored.UserID,
+	}
+
+	// Seniors may only read their own assessments
+	if !auth.AuthorizeUser(w, r, assessment.UserID) {
+		return
+	}
+
+	if !store.Audit.Access(w, r, "assessment", strconv.Itoa(req.AssessmentID), assessment.UserID) {
+		return
+	}
 
 	// Send JSON response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(assessment)
+	respond.JSON(w, assessment)
 }
 
-func assessmentHistoryHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func assessmentHistoryHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	type Request struct {
-		UserID int `json:"user_id"`
+		UserID     int    `json:"user_id"`
+		Instrument string `json:"instrument"` // Any instrument if left out
 	}
 	var req Request
 
@@ -464,41 +773,31 @@ func assessmentHistoryHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		return
 	}
 
-	// Query the database for all risk assessments for the user
-	query := `SELECT AssessmentID, TotalScore, RiskLevel, Recommendation, DateCreated
-              FROM Assessments 
-              WHERE UserID = ? 
-              ORDER BY DateCreated DESC`
+	// Seniors may only read their own assessments
+	if !auth.AuthorizeUser(w, r, req.UserID) {
+		return
+	}
 
-	rows, err := db.Query(query, req.UserID)
+	// Look up all risk assessments for the user, or only those with one instrument, whose scores compare
+	stored, err := store.Assessments.ListByUser(req.UserID, req.Instrument)
 	if err != nil {
 		log.Println("Database query error:", err)
 		http.Error(w, "Failed to fetch data", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
 	var assessments []Assessment
-
-	// Iterate over rows
-	for rows.Next() {
-		var assessment Assessment
-		var dateCreated time.Time
-
-		if err := rows.Scan(
-			&assessment.AssessmentID,
-			&assessment.TotalScore,
-			&assessment.RiskLevel,
-			&assessment.Recommendation,
-			&dateCreated,
-		); err != nil {
-			log.Println("Error scanning row:", err)
-			http.Error(w, "Failed to process data", http.StatusInternalServerError)
-			return
-		}
-
-		assessment.DateCreated = dateCreated.Format("2006-01-02 15:04:05")
-		assessments = append(assessments, assessment)
+	for _, a := range stored {
+		assessments = append(assessments, Assessment{
+			AssessmentID:   a.AssessmentID,
+			Instrument:     a.Instrument,
+			TotalScore:     a.TotalScore,
+			RiskLevel:      a.RiskLevel,
+			Recommendation: a.Recommendation,
+			ModelVersion:   a.ModelVersion,
+			Explanation:    explanationJSON(a.Explanation),
+			DateCreated:    a.DateCreated.Format("2006-01-02 15:04:05"),
+		})
 	}
 
 	// Check if no records were found
@@ -507,7 +806,10 @@ func assessmentHistoryHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		return
 	}
 
+	if !store.Audit.Access(w, r, "assessment", "", req.UserID) {
+		return
+	}
+
 	// Send JSON response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(assessments)
+	respond.JSON(w, assessments)
 }
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS AuditLog;
+DROP TABLE IF EXISTS QuestionsTa;
+DROP TABLE IF EXISTS QuestionsMy;
+DROP TABLE IF EXISTS QuestionsCn;
+DROP TABLE IF EXISTS QuestionsEn;
+DROP TABLE IF EXISTS Assessments;
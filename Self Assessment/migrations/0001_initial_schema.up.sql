@@ -1,13 +1,8 @@
--- Self-Assessment Service
-DROP database IF EXISTS self_assessment_db;
-CREATE database self_assessment_db;
-USE self_assessment_db;
-
 CREATE TABLE Assessments (
     AssessmentID INT AUTO_INCREMENT PRIMARY KEY,
     UserID INT NOT NULL,
     DateCreated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    QuestionResponses TEXT NOT NULL,
+    QuestionResponses TEXT NOT NULL, -- Encrypted, see fieldcrypt.go
     TotalScore INT,
     RiskLevel ENUM('Low', 'Moderate', 'High'),
     Recommendation TEXT
@@ -41,12 +36,6 @@ CREATE TABLE QuestionsTa (
     QuestionOptions VARCHAR(500) NOT NULL 
 );
 
-
-INSERT INTO Assessments (UserID, QuestionResponses, TotalScore, RiskLevel, Recommendation) VALUES
-(1, '{ 1: 2, 2: 3, 3: 2, 4: 1, 5: 3, 6: 2, 7: 2, 8: 3, 9: 2, 10: 1 }', 15, 'Moderate', 'Consider physical therapy, improve home safety, and monitor medications.'),
-(1, '{ 1: 2, 2: 2, 3: 1, 4: 2, 5: 1, 6: 2, 7: 2, 8: 2, 9: 2, 10: 1 }', 8, 'Low', 'Maintain a healthy lifestyle and exercise regularly.'),
-(2, '{ 1: 1, 2: 1, 3: 2, 4: 4, 5: 3, 6: 1, 7: 2, 8: 2, 9: 1, 10: 3 }', 18, 'High', 'Consult a healthcare provider for a fall risk assessment and use mobility aids.');
-
 INSERT INTO QuestionsEn (QuestionContent, QuestionOptions) 
 VALUES 
 ("Do you experience dizziness?", '["Yes", "No"]'),
@@ -94,4 +83,30 @@ VALUES
 ("நீங்கள் கைகளைப் பயன்படுத்தாமல் நாற்காலியில் இருந்து எழுந்திருக்க முடியுமா?", '["ஆம்", "இல்லை"]'),
 ("நீங்கள் மயக்கத்தை ஏற்படுத்தும் மருந்துகளை எடுத்துக்கொள்கிறீர்களா?", '["ஆம்", "இல்லை", "தெரியாது"]'),
 ("நீங்கள் முறையாக உடற்பயிற்சி செய்கிறீர்களா?", '["ஆம்", "இல்லை"]'),
-("உங்கள் கால்களில் உணர்விழப்பு இருக்கிறதா?", '["ஆம்", "இல்லை", "சில சமயங்களில்"]');
\ No newline at end of file
+("உங்கள் கால்களில் உணர்விழப்பு இருக்கிறதா?", '["ஆம்", "இல்லை", "சில சமயங்களில்"]');
+
+-- Append-only audit log of every read and change of health or profile data. Each row's Hash
+-- covers the previous row's Hash, so any edit or removal shows up when the chain is verified.
+CREATE TABLE AuditLog (
+    AuditID BIGINT AUTO_INCREMENT PRIMARY KEY,
+    Service VARCHAR(30) NOT NULL,
+    ActorID INT NOT NULL,
+    ActorRole VARCHAR(20) NOT NULL,
+    Action VARCHAR(10) NOT NULL,
+    ResourceType VARCHAR(30) NOT NULL,
+    ResourceID VARCHAR(64) NOT NULL DEFAULT '',
+    SubjectID INT NOT NULL DEFAULT 0,
+    SourceIP VARCHAR(45) NOT NULL DEFAULT '',
+    CreatedAt DATETIME(6) NOT NULL,
+    PrevHash CHAR(64) NOT NULL DEFAULT '',
+    Hash CHAR(64) NOT NULL,
+    INDEX (ActorID),
+    INDEX (SubjectID),
+    INDEX (CreatedAt)
+);
+
+CREATE TRIGGER AuditLogNoUpdate BEFORE UPDATE ON AuditLog
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only';
+
+CREATE TRIGGER AuditLogNoDelete BEFORE DELETE ON AuditLog
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only';
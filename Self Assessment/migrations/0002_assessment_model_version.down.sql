@@ -0,0 +1 @@
+ALTER TABLE Assessments DROP COLUMN ModelVersion;
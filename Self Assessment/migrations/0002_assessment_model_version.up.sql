@@ -0,0 +1,3 @@
+-- Version of the Risk Assessment scoring model each assessment was scored with. Assessments
+-- scored before models were versioned have none.
+ALTER TABLE Assessments ADD COLUMN ModelVersion INT NULL DEFAULT NULL AFTER Recommendation;
@@ -0,0 +1,3 @@
+-- Each answer's points and the factors that raised the score most, in the questionnaire
+-- language. Encrypted like QuestionResponses. Assessments scored before this have none.
+ALTER TABLE Assessments ADD COLUMN Explanation TEXT NULL DEFAULT NULL AFTER ModelVersion;
@@ -0,0 +1,24 @@
+-- Assessments taken with other instruments could no longer be told apart from the custom
+-- questionnaire, so refuse to go down while any exist rather than delete them. Export or
+-- remove them by hand first.
+DROP PROCEDURE IF EXISTS RequireOnlyFallRiskAssessments;
+
+DELIMITER //
+CREATE PROCEDURE RequireOnlyFallRiskAssessments()
+BEGIN
+    IF EXISTS (SELECT 1 FROM Assessments WHERE Instrument <> 'fall_risk') THEN
+        SIGNAL SQLSTATE '45000'
+            SET MESSAGE_TEXT = 'Assessments taken with instruments other than fall_risk exist; remove them before undoing this migration';
+    END IF;
+END//
+DELIMITER ;
+
+CALL RequireOnlyFallRiskAssessments();
+DROP PROCEDURE RequireOnlyFallRiskAssessments;
+
+DELETE FROM QuestionsEn WHERE Instrument <> 'fall_risk';
+ALTER TABLE Assessments DROP COLUMN Instrument;
+ALTER TABLE QuestionsTa DROP COLUMN Instrument;
+ALTER TABLE QuestionsMy DROP COLUMN Instrument;
+ALTER TABLE QuestionsCn DROP COLUMN Instrument;
+ALTER TABLE QuestionsEn DROP COLUMN Instrument;
@@ -0,0 +1,37 @@
+-- Validated fall-risk instruments offered alongside the custom questionnaire, which becomes the
+-- 'fall_risk' instrument. Question IDs stay unique across instruments, since answers and the
+-- Risk Assessment rule files refer to questions by ID alone.
+ALTER TABLE QuestionsEn ADD COLUMN Instrument VARCHAR(32) NOT NULL DEFAULT 'fall_risk' AFTER QuestionID;
+ALTER TABLE QuestionsCn ADD COLUMN Instrument VARCHAR(32) NOT NULL DEFAULT 'fall_risk' AFTER QuestionID;
+ALTER TABLE QuestionsMy ADD COLUMN Instrument VARCHAR(32) NOT NULL DEFAULT 'fall_risk' AFTER QuestionID;
+ALTER TABLE QuestionsTa ADD COLUMN Instrument VARCHAR(32) NOT NULL DEFAULT 'fall_risk' AFTER QuestionID;
+
+-- The instrument each assessment was taken with, so results are only compared like with like
+ALTER TABLE Assessments ADD COLUMN Instrument VARCHAR(32) NOT NULL DEFAULT 'fall_risk' AFTER UserID;
+
+-- Offered in English only until validated translations are added
+-- CDC STEADI "Stay Independent" brochure
+INSERT INTO QuestionsEn (QuestionID, Instrument, QuestionContent, QuestionOptions)
+VALUES
+(11, 'steadi', "I have fallen in the past year.", '["Yes", "No"]'),
+(12, 'steadi', "I use or have been advised to use a cane or walker to get around safely.", '["Yes", "No"]'),
+(13, 'steadi', "Sometimes I feel unsteady when I am walking.", '["Yes", "No"]'),
+(14, 'steadi', "I steady myself by holding onto furniture when walking at home.", '["Yes", "No"]'),
+(15, 'steadi', "I am worried about falling.", '["Yes", "No"]'),
+(16, 'steadi', "I need to push with my hands to stand up from a chair.", '["Yes", "No"]'),
+(17, 'steadi', "I have some trouble stepping up onto a curb.", '["Yes", "No"]'),
+(18, 'steadi', "I often have to rush to the toilet.", '["Yes", "No"]'),
+(19, 'steadi', "I have lost some feeling in my feet.", '["Yes", "No"]'),
+(20, 'steadi', "I take medicine that sometimes makes me feel light-headed or more tired than usual.", '["Yes", "No"]'),
+(21, 'steadi', "I take medicine to help me sleep or improve my mood.", '["Yes", "No"]'),
+(22, 'steadi', "I often feel sad or depressed.", '["Yes", "No"]');
+
+-- Morse Fall Scale
+INSERT INTO QuestionsEn (QuestionID, Instrument, QuestionContent, QuestionOptions)
+VALUES
+(23, 'morse', "History of falling, immediately or within the last 3 months", '["No", "Yes"]'),
+(24, 'morse', "Secondary diagnosis (more than one medical diagnosis)", '["No", "Yes"]'),
+(25, 'morse', "Ambulatory aid", '["None, bed rest or nurse assist", "Crutches, cane or walker", "Furniture"]'),
+(26, 'morse', "IV therapy or heparin lock", '["No", "Yes"]'),
+(27, 'morse', "Gait", '["Normal, bed rest or wheelchair", "Weak", "Impaired"]'),
+(28, 'morse', "Mental status", '["Oriented to own ability", "Overestimates or forgets limitations"]');
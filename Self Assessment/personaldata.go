@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"common/audit"
+	"common/auth"
+	"common/respond"
+)
+
+// Every assessment held about the user, collected by the User service's data export
+func exportPersonalDataHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	userID, ok := auth.DataSubjectFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	stored, err := store.Assessments.ListByUser(userID, "")
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Oldest first
+	assessments := []map[string]interface{}{}
+	for _, a := range slices.Backward(stored) {
+		assessments = append(assessments, map[string]interface{}{
+			"assessment_id":      a.AssessmentID,
+			"date_created":       a.DateCreated,
+			"instrument":         a.Instrument,
+			"question_responses": json.RawMessage(a.Responses),
+			"total_score":        a.TotalScore,
+			"risk_level":         a.RiskLevel,
+			"recommendation":     a.Recommendation,
+			"model_version":      a.ModelVersion,
+			"explanation":        explanationJSON(a.Explanation),
+		})
+	}
+
+	if !store.Audit.Access(w, r, "personal_data", strconv.Itoa(userID), userID) {
+		return
+	}
+
+	respond.JSON(w, map[string]interface{}{"assessments": assessments})
+}
+
+// Delete every assessment held about the user and report how many rows were erased
+func erasePersonalDataHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	userID, ok := auth.DataSubjectFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := store.Assessments.DeleteByUser(userID)
+	if err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	store.Audit.Change(r, audit.Delete, "personal_data", strconv.Itoa(userID), userID)
+
+	log.Printf("Erased %d assessments for user %d\n", deleted, userID)
+	respond.JSON(w, map[string]interface{}{
+		"deleted": map[string]int64{"Assessments": deleted},
+	})
+}
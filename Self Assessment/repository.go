@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"common/audit"
+	"common/fieldcrypt"
+)
+
+// Returned when no assessment matches
+var errAssessmentNotFound = errors.New("assessment not found")
+
+// Returned for a questionnaire language that is not offered
+var errUnsupportedLanguage = errors.New("unsupported language")
+
+// Returned for an instrument that has no questions in the language
+var errInstrumentNotOffered = errors.New("instrument not offered in this language")
+
+// The custom questionnaire, taken when no instrument is asked for
+const defaultInstrument = "fall_risk"
+
+// A questionnaire question, with its options as a JSON array
+type Question struct {
+	QuestionID int
+	Content    string
+	Options    string
+}
+
+// A stored assessment, with the answers decrypted
+type AssessmentRecord struct {
+	AssessmentID   int
+	UserID         int
+	Instrument     string // Questionnaire taken, see the Risk Assessment rule files
+	Responses      string // Answers as JSON, question ID to option
+	TotalScore     int
+	RiskLevel      string
+	Recommendation string
+	ModelVersion   int    // Risk model that scored it, 0 if scored before models were versioned
+	Explanation    string // Why it scored what it did as JSON, empty if scored before explanations
+	DateCreated    time.Time
+}
+
+type QuestionRepository interface {
+	// The instrument's questionnaire in English, Chinese, Malay or Tamil
+	List(instrument, language string) ([]Question, error)
+}
+
+type AssessmentRepository interface {
+	// Store a new assessment, filling in its ID and DateCreated
+	Create(assessment *AssessmentRecord) error
+	// The user's most recent assessment with the instrument, or with any if it is empty
+	Latest(userID int, instrument string) (AssessmentRecord, error)
+	Get(assessmentID int) (AssessmentRecord, error)
+	// Every assessment of the user with the instrument, or with any if it is empty, newest first
+	ListByUser(userID int, instrument string) ([]AssessmentRecord, error)
+	// Delete every assessment of the user, returning how many there were
+	DeleteByUser(userID int) (int64, error)
+}
+
+func newMySQLStore(db *sql.DB) *Store {
+	return &Store{
+		Questions:   mysqlQuestionRepository{db},
+		Assessments: mysqlAssessmentRepository{db},
+		Audit:       audit.New(auditServiceName, audit.MySQLRepository{DB: db}),
+		db:          db,
+	}
+}
+
+type mysqlQuestionRepository struct {
+	db *sql.DB
+}
+
+// Each language's questions are kept in their own table
+var questionTables = map[string]string{
+	"English": "QuestionsEn",
+	"Chinese": "QuestionsCn",
+	"Malay":   "QuestionsMy",
+	"Tamil":   "QuestionsTa",
+}
+
+func (m mysqlQuestionRepository) List(instrument, language string) ([]Question, error) {
+	table, ok := questionTables[language]
+	if !ok {
+		return nil, errUnsupportedLanguage
+	}
+
+	rows, err := m.db.Query(fmt.Sprintf("SELECT QuestionID, QuestionContent, QuestionOptions FROM %s WHERE Instrument = ? ORDER BY QuestionID", table), instrument)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := []Question{}
+	for rows.Next() {
+		var q Question
+		if err := rows.Scan(&q.QuestionID, &q.Content, &q.Options); err != nil {
+			return nil, err
+		}
+		questions = append(questions, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(questions) == 0 {
+		return nil, errInstrumentNotOffered
+	}
+	return questions, nil
+}
+
+// Assessments in the Assessments table, with answers encrypted
+type mysqlAssessmentRepository struct {
+	db *sql.DB
+}
+
+const assessmentColumns = `SELECT AssessmentID, UserID, Instrument, QuestionResponses, TotalScore, RiskLevel, Recommendation, ModelVersion, Explanation, DateCreated FROM Assessments`
+
+func (m mysqlAssessmentRepository) Create(assessment *AssessmentRecord) error {
+	// Answers are health data and are stored encrypted
+	storedResponses, err := fieldcrypt.Encrypt("Assessments.QuestionResponses", assessment.Responses)
+	if err != nil {
+		return err
+	}
+	storedExplanation, err := fieldcrypt.Encrypt("Assessments.Explanation", assessment.Explanation)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO Assessments (UserID, Instrument, QuestionResponses, TotalScore, RiskLevel, Recommendation, ModelVersion, Explanation, DateCreated) 
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, NOW())`
+	result, err := m.db.Exec(query, assessment.UserID, assessment.Instrument, storedResponses, assessment.TotalScore, assessment.RiskLevel, assessment.Recommendation,
+		assessment.ModelVersion, storedExplanation)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	assessment.AssessmentID = int(id)
+
+	// DateCreated is set by MySQL's clock, like every other row
+	return m.db.QueryRow("SELECT DateCreated FROM Assessments WHERE AssessmentID = ?", id).Scan(&assessment.DateCreated)
+}
+
+// Matches every instrument when the instrument is empty
+const instrumentFilter = ` AND (? = '' OR Instrument = ?)`
+
+func (m mysqlAssessmentRepository) Latest(userID int, instrument string) (AssessmentRecord, error) {
+	return m.queryOne(assessmentColumns+` WHERE UserID = ?`+instrumentFilter+` ORDER BY DateCreated DESC, AssessmentID DESC LIMIT 1`, userID, instrument, instrument)
+}
+
+func (m mysqlAssessmentRepository) Get(assessmentID int) (AssessmentRecord, error) {
+	return m.queryOne(assessmentColumns+` WHERE AssessmentID = ?`, assessmentID)
+}
+
+func (m mysqlAssessmentRepository) ListByUser(userID int, instrument string) ([]AssessmentRecord, error) {
+	rows, err := m.db.Query(assessmentColumns+` WHERE UserID = ?`+instrumentFilter+` ORDER BY DateCreated DESC, AssessmentID DESC`, userID, instrument, instrument)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assessments := []AssessmentRecord{}
+	for rows.Next() {
+		assessment, err := scanAssessment(rows)
+		if err != nil {
+			return nil, err
+		}
+		assessments = append(assessments, assessment)
+	}
+	return assessments, rows.Err()
+}
+
+func (m mysqlAssessmentRepository) DeleteByUser(userID int) (int64, error) {
+	result, err := m.db.Exec("DELETE FROM Assessments WHERE UserID = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (m mysqlAssessmentRepository) queryOne(query string, args ...interface{}) (AssessmentRecord, error) {
+	assessment, err := scanAssessment(m.db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return AssessmentRecord{}, errAssessmentNotFound
+	}
+	return assessment, err
+}
+
+// Scan a row selected with assessmentColumns, decrypting the answers and explanation
+func scanAssessment(row interface{ Scan(...interface{}) error }) (AssessmentRecord, error) {
+	var a AssessmentRecord
+	var totalScore, modelVersion sql.NullInt64
+	var riskLevel, recommendation, explanation sql.NullString
+	if err := row.Scan(&a.AssessmentID, &a.UserID, &a.Instrument, &a.Responses, &totalScore, &riskLevel, &recommendation, &modelVersion, &explanation, &a.DateCreated); err != nil {
+		return AssessmentRecord{}, err
+	}
+	a.TotalScore, a.RiskLevel, a.Recommendation = int(totalScore.Int64), riskLevel.String, recommendation.String
+	a.ModelVersion = int(modelVersion.Int64)
+
+	var err error
+	if a.Responses, err = fieldcrypt.Decrypt("Assessments.QuestionResponses", a.Responses); err != nil {
+		return AssessmentRecord{}, err
+	}
+	a.Explanation, err = fieldcrypt.Decrypt("Assessments.Explanation", explanation.String)
+	return a, err
+}
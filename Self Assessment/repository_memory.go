@@ -0,0 +1,172 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"common/audit"
+)
+
+func newMemoryStore() *Store {
+	return &Store{
+		Questions:   memoryQuestionRepository{},
+		Assessments: &memoryAssessmentRepository{nextID: 1},
+		Audit:       audit.New(auditServiceName, &audit.MemoryRepository{}),
+	}
+}
+
+// The questionnaires as seeded in MySQL, by instrument and language
+var memoryQuestions = map[string]map[string][]Question{
+	defaultInstrument: {
+		"English": {
+			{1, "Do you experience dizziness?", `["Yes", "No"]`},
+			{2, "How is your balance?", `["Good", "Moderate", "Poor"]`},
+			{3, "How many times have you fallen in the past year?", `["0", "1-2", "3 or more"]`},
+			{4, "Do you use any mobility aids?", `["None", "Cane", "Walker", "Wheelchair"]`},
+			{5, "Do you feel unsteady when walking?", `["Never", "Sometimes", "Often", "Always"]`},
+			{6, "Have you had a fall in the past 6 months?", `["Yes", "No"]`},
+			{7, "Are you able to stand up from a chair without using your hands?", `["Yes", "No"]`},
+			{8, "Do you take medications that cause dizziness?", `["Yes", "No", "Not sure"]`},
+			{9, "Do you exercise regularly?", `["Yes", "No"]`},
+			{10, "Do you experience numbness in your feet?", `["Yes", "No", "Sometimes"]`},
+		},
+		"Chinese": {
+			{1, "你是否感到头晕？", `["是", "否"]`},
+			{2, "你的平衡能力如何？", `["良好", "一般", "差"]`},
+			{3, "过去一年内你跌倒过几次？", `["0", "1-2", "3次或更多"]`},
+			{4, "你使用助行器具吗？", `["无", "手杖", "助行器", "轮椅"]`},
+			{5, "走路时你会感到不稳吗？", `["从不", "有时", "经常", "总是"]`},
+			{6, "过去6个月内你是否跌倒过？", `["是", "否"]`},
+			{7, "你能不用手站起来吗？", `["是", "否"]`},
+			{8, "你是否服用会导致头晕的药物？", `["是", "否", "不确定"]`},
+			{9, "你是否定期运动？", `["是", "否"]`},
+			{10, "你的脚是否会感到麻木？", `["是", "否", "有时"]`},
+		},
+		"Malay": {
+			{1, "Adakah anda mengalami pening?", `["Ya", "Tidak"]`},
+			{2, "Bagaimanakah keseimbangan anda?", `["Baik", "Sederhana", "Buruk"]`},
+			{3, "Berapa kali anda terjatuh dalam setahun yang lalu?", `["0", "1-2", "3 atau lebih"]`},
+			{4, "Adakah anda menggunakan alat bantuan pergerakan?", `["Tiada", "Tongkat", "Walker", "Kerusi roda"]`},
+			{5, "Adakah anda berasa tidak stabil semasa berjalan?", `["Tidak pernah", "Kadang-kadang", "Selalu", "Setiap masa"]`},
+			{6, "Adakah anda pernah jatuh dalam 6 bulan terakhir?", `["Ya", "Tidak"]`},
+			{7, "Bolehkah anda bangun dari kerusi tanpa menggunakan tangan?", `["Ya", "Tidak"]`},
+			{8, "Adakah anda mengambil ubat yang menyebabkan pening?", `["Ya", "Tidak", "Tidak pasti"]`},
+			{9, "Adakah anda bersenam secara berkala?", `["Ya", "Tidak"]`},
+			{10, "Adakah anda mengalami kebas di kaki anda?", `["Ya", "Tidak", "Kadang-kadang"]`},
+		},
+		"Tamil": {
+			{1, "நீங்கள் மயக்கம் உணர்கிறீர்களா?", `["ஆம்", "இல்லை"]`},
+			{2, "உங்கள் சமநிலை எப்படி உள்ளது?", `["நல்லது", "மிதமானது", "மோசமானது"]`},
+			{3, "கடந்த ஆண்டு நீங்கள் எத்தனை முறை கீழே விழுந்தீர்கள்?", `["0", "1-2", "3 அல்லது அதற்கு மேல்"]`},
+			{4, "நீங்கள் நகர்வதற்கு உதவிகள் பயன்படுத்துகிறீர்களா?", `["இல்லை", "சங்கில்", "நடக்க உதவும் கருவி", "சக்கர நாற்காலி"]`},
+			{5, "நடக்கும் போது நீங்கள் நிலை தடுமாறுகிறீர்களா?", `["ஒருபோதும் இல்லை", "சில சமயங்களில்", "அடிக்கடி", "எப்போதும்"]`},
+			{6, "கடந்த 6 மாதங்களில் நீங்கள் கீழே விழுந்தீர்களா?", `["ஆம்", "இல்லை"]`},
+			{7, "நீங்கள் கைகளைப் பயன்படுத்தாமல் நாற்காலியில் இருந்து எழுந்திருக்க முடியுமா?", `["ஆம்", "இல்லை"]`},
+			{8, "நீங்கள் மயக்கத்தை ஏற்படுத்தும் மருந்துகளை எடுத்துக்கொள்கிறீர்களா?", `["ஆம்", "இல்லை", "தெரியாது"]`},
+			{9, "நீங்கள் முறையாக உடற்பயிற்சி செய்கிறீர்களா?", `["ஆம்", "இல்லை"]`},
+			{10, "உங்கள் கால்களில் உணர்விழப்பு இருக்கிறதா?", `["ஆம்", "இல்லை", "சில சமயங்களில்"]`},
+		},
+	},
+	"steadi": {
+		"English": {
+			{11, "I have fallen in the past year.", `["Yes", "No"]`},
+			{12, "I use or have been advised to use a cane or walker to get around safely.", `["Yes", "No"]`},
+			{13, "Sometimes I feel unsteady when I am walking.", `["Yes", "No"]`},
+			{14, "I steady myself by holding onto furniture when walking at home.", `["Yes", "No"]`},
+			{15, "I am worried about falling.", `["Yes", "No"]`},
+			{16, "I need to push with my hands to stand up from a chair.", `["Yes", "No"]`},
+			{17, "I have some trouble stepping up onto a curb.", `["Yes", "No"]`},
+			{18, "I often have to rush to the toilet.", `["Yes", "No"]`},
+			{19, "I have lost some feeling in my feet.", `["Yes", "No"]`},
+			{20, "I take medicine that sometimes makes me feel light-headed or more tired than usual.", `["Yes", "No"]`},
+			{21, "I take medicine to help me sleep or improve my mood.", `["Yes", "No"]`},
+			{22, "I often feel sad or depressed.", `["Yes", "No"]`},
+		},
+	},
+	"morse": {
+		"English": {
+			{23, "History of falling, immediately or within the last 3 months", `["No", "Yes"]`},
+			{24, "Secondary diagnosis (more than one medical diagnosis)", `["No", "Yes"]`},
+			{25, "Ambulatory aid", `["None, bed rest or nurse assist", "Crutches, cane or walker", "Furniture"]`},
+			{26, "IV therapy or heparin lock", `["No", "Yes"]`},
+			{27, "Gait", `["Normal, bed rest or wheelchair", "Weak", "Impaired"]`},
+			{28, "Mental status", `["Oriented to own ability", "Overestimates or forgets limitations"]`},
+		},
+	},
+}
+
+type memoryQuestionRepository struct{}
+
+func (memoryQuestionRepository) List(instrument, language string) ([]Question, error) {
+	if _, ok := memoryQuestions[defaultInstrument][language]; !ok {
+		return nil, errUnsupportedLanguage
+	}
+	questions, ok := memoryQuestions[instrument][language]
+	if !ok {
+		return nil, errInstrumentNotOffered
+	}
+	return slices.Clone(questions), nil
+}
+
+// Assessments kept in memory. Answers are not encrypted.
+type memoryAssessmentRepository struct {
+	mu          sync.Mutex
+	assessments []AssessmentRecord
+	nextID      int
+}
+
+func (m *memoryAssessmentRepository) Create(assessment *AssessmentRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assessment.AssessmentID = m.nextID
+	assessment.DateCreated = time.Now()
+	m.nextID++
+	m.assessments = append(m.assessments, *assessment)
+	return nil
+}
+
+func (m *memoryAssessmentRepository) Latest(userID int, instrument string) (AssessmentRecord, error) {
+	assessments, _ := m.ListByUser(userID, instrument)
+	if len(assessments) == 0 {
+		return AssessmentRecord{}, errAssessmentNotFound
+	}
+	return assessments[0], nil
+}
+
+func (m *memoryAssessmentRepository) Get(assessmentID int) (AssessmentRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, a := range m.assessments {
+		if a.AssessmentID == assessmentID {
+			return a, nil
+		}
+	}
+	return AssessmentRecord{}, errAssessmentNotFound
+}
+
+func (m *memoryAssessmentRepository) ListByUser(userID int, instrument string) ([]AssessmentRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assessments := []AssessmentRecord{}
+	for _, a := range m.assessments {
+		if a.UserID == userID && (instrument == "" || a.Instrument == instrument) {
+			assessments = append(assessments, a)
+		}
+	}
+	sort.SliceStable(assessments, func(i, j int) bool { return assessments[i].AssessmentID > assessments[j].AssessmentID })
+	return assessments, nil
+}
+
+func (m *memoryAssessmentRepository) DeleteByUser(userID int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := len(m.assessments)
+	m.assessments = slices.DeleteFunc(m.assessments, func(a AssessmentRecord) bool { return a.UserID == userID })
+	return int64(before - len(m.assessments)), nil
+}
@@ -0,0 +1,21 @@
+//go:build mysql
+
+package main
+
+import (
+	"testing"
+
+	"common/dbtest"
+	"common/fieldcrypt"
+)
+
+func TestMySQLRepositories(t *testing.T) {
+	keys, err := fieldcrypt.GenerateKeyFile("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fieldcrypt.LoadKeys(keys); err != nil {
+		t.Fatal(err)
+	}
+	testRepositories(t, func(t *testing.T) *Store { return newMySQLStore(dbtest.Open(t, schemaFiles)) })
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+)
+
+// The behaviour every question and assessment repository must have, run against a fresh
+// store per case
+func testRepositories(t *testing.T, newStore func(t *testing.T) *Store) {
+	create := func(t *testing.T, store *Store, userID int, instrument string) AssessmentRecord {
+		t.Helper()
+		assessment := AssessmentRecord{
+			UserID:         userID,
+			Instrument:     instrument,
+			Responses:      `{"1":"Yes","2":"Poor"}`,
+			TotalScore:     12,
+			RiskLevel:      "High",
+			Recommendation: "See a doctor",
+			ModelVersion:   3,
+			Explanation:    `[{"question_id":1,"points":5}]`,
+		}
+		if err := store.Assessments.Create(&assessment); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		return assessment
+	}
+	ids := func(assessments []AssessmentRecord) []int {
+		ids := []int{}
+		for _, a := range assessments {
+			ids = append(ids, a.AssessmentID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store *Store)
+	}{
+		{"questions of an instrument in a language, in order", func(t *testing.T, store *Store) {
+			questions, err := store.Questions.List(defaultInstrument, "English")
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if len(questions) != 10 {
+				t.Fatalf("got %d questions, want 10", len(questions))
+			}
+			for i, q := range questions {
+				if q.QuestionID != i+1 || q.Content == "" || q.Options == "" {
+					t.Fatalf("question %d is %+v", i+1, q)
+				}
+			}
+		}},
+		{"questions in an unsupported language", func(t *testing.T, store *Store) {
+			if _, err := store.Questions.List(defaultInstrument, "Klingon"); err != errUnsupportedLanguage {
+				t.Fatalf("err = %v, want errUnsupportedLanguage", err)
+			}
+		}},
+		{"questions of an instrument not offered in the language", func(t *testing.T, store *Store) {
+			for _, instrument := range []string{"steadi", "unknown"} {
+				if _, err := store.Questions.List(instrument, "Chinese"); err != errInstrumentNotOffered {
+					t.Fatalf("%s: err = %v, want errInstrumentNotOffered", instrument, err)
+				}
+			}
+		}},
+		{"create fills in ID and DateCreated, and get returns it", func(t *testing.T, store *Store) {
+			created := create(t, store, 7, defaultInstrument)
+			if created.AssessmentID <= 0 || created.DateCreated.IsZero() {
+				t.Fatalf("ID %d, DateCreated %v not filled in", created.AssessmentID, created.DateCreated)
+			}
+			got, err := store.Assessments.Get(created.AssessmentID)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if !got.DateCreated.Equal(created.DateCreated) {
+				t.Fatalf("DateCreated %v, want %v", got.DateCreated, created.DateCreated)
+			}
+			got.DateCreated = created.DateCreated
+			if got != created {
+				t.Fatalf("got %+v, want %+v", got, created)
+			}
+		}},
+		{"get of an unknown ID", func(t *testing.T, store *Store) {
+			if _, err := store.Assessments.Get(999); err != errAssessmentNotFound {
+				t.Fatalf("err = %v, want errAssessmentNotFound", err)
+			}
+		}},
+		{"latest of any instrument", func(t *testing.T, store *Store) {
+			create(t, store, 7, defaultInstrument)
+			newest := create(t, store, 7, "steadi")
+			create(t, store, 8, defaultInstrument)
+			got, err := store.Assessments.Latest(7, "")
+			if err != nil {
+				t.Fatalf("Latest: %v", err)
+			}
+			if got.AssessmentID != newest.AssessmentID {
+				t.Fatalf("got assessment %d, want %d", got.AssessmentID, newest.AssessmentID)
+			}
+		}},
+		{"latest with an instrument", func(t *testing.T, store *Store) {
+			want := create(t, store, 7, defaultInstrument)
+			create(t, store, 7, "steadi")
+			got, err := store.Assessments.Latest(7, defaultInstrument)
+			if err != nil {
+				t.Fatalf("Latest: %v", err)
+			}
+			if got.AssessmentID != want.AssessmentID {
+				t.Fatalf("got assessment %d, want %d", got.AssessmentID, want.AssessmentID)
+			}
+			if _, err := store.Assessments.Latest(7, "morse"); err != errAssessmentNotFound {
+				t.Fatalf("morse: err = %v, want errAssessmentNotFound", err)
+			}
+		}},
+		{"list is the user's assessments, newest first", func(t *testing.T, store *Store) {
+			first := create(t, store, 7, defaultInstrument)
+			create(t, store, 8, defaultInstrument)
+			second := create(t, store, 7, "steadi")
+			third := create(t, store, 7, defaultInstrument)
+
+			all, err := store.Assessments.ListByUser(7, "")
+			if err != nil {
+				t.Fatalf("ListByUser: %v", err)
+			}
+			if got := ids(all); len(got) != 3 || got[0] != third.AssessmentID || got[1] != second.AssessmentID || got[2] != first.AssessmentID {
+				t.Fatalf("got %v, want %d, %d, %d", got, third.AssessmentID, second.AssessmentID, first.AssessmentID)
+			}
+			fallRisk, err := store.Assessments.ListByUser(7, defaultInstrument)
+			if err != nil {
+				t.Fatalf("ListByUser: %v", err)
+			}
+			if got := ids(fallRisk); len(got) != 2 || got[0] != third.AssessmentID || got[1] != first.AssessmentID {
+				t.Fatalf("got %v, want %d, %d", got, third.AssessmentID, first.AssessmentID)
+			}
+		}},
+		{"list without assessments is empty, not nil", func(t *testing.T, store *Store) {
+			got, err := store.Assessments.ListByUser(7, "")
+			if err != nil || got == nil || len(got) != 0 {
+				t.Fatalf("got %#v, %v, want an empty list", got, err)
+			}
+		}},
+		{"delete removes only the user's assessments", func(t *testing.T, store *Store) {
+			create(t, store, 7, defaultInstrument)
+			create(t, store, 7, "steadi")
+			other := create(t, store, 8, defaultInstrument)
+			deleted, err := store.Assessments.DeleteByUser(7)
+			if err != nil {
+				t.Fatalf("DeleteByUser: %v", err)
+			}
+			if deleted != 2 {
+				t.Fatalf("deleted %d, want 2", deleted)
+			}
+			if got, _ := store.Assessments.ListByUser(7, ""); len(got) != 0 {
+				t.Fatalf("%d assessments left", len(got))
+			}
+			if _, err := store.Assessments.Get(other.AssessmentID); err != nil {
+				t.Fatalf("other user's assessment: %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+}
+
+func TestMemoryRepositories(t *testing.T) {
+	testRepositories(t, func(t *testing.T) *Store { return newMemoryStore() })
+}
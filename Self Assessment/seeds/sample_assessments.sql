@@ -0,0 +1,8 @@
+-- Sample assessments for development, under fixed IDs so running the seed again changes nothing.
+-- They are stored in plaintext until the service is run with -reencrypt.
+INSERT IGNORE INTO Assessments (AssessmentID, UserID, QuestionResponses, TotalScore, RiskLevel, Recommendation) VALUES
+(1, 1, '{"1":2,"2":3,"3":2,"4":1,"5":3,"6":2,"7":2,"8":3,"9":2,"10":1}', 15, 'Moderate', 'Consider physical therapy, improve home safety, and monitor medications.'),
+(2, 1, '{"1":2,"2":2,"3":1,"4":2,"5":1,"6":2,"7":2,"8":2,"9":2,"10":1}', 8, 'Low', 'Maintain a healthy lifestyle and exercise regularly.'),
+(3, 2, '{"1":1,"2":1,"3":2,"4":4,"5":3,"6":1,"7":2,"8":2,"9":1,"10":3}', 18, 'High', 'Consult a healthcare provider for a fall risk assessment and use mobility aids.');
+INSERT IGNORE INTO Assessments (AssessmentID, UserID, Instrument, QuestionResponses, TotalScore, RiskLevel, Recommendation, ModelVersion) VALUES
+(4, 1, 'steadi', '{"11":1,"12":2,"13":1,"14":2,"15":1,"16":2,"17":2,"18":2,"19":2,"20":2,"21":2,"22":2}', 4, 'High', 'Talk to your healthcare provider about your risk of falling and what you can do to prevent a fall.', 1);
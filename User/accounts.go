@@ -0,0 +1,41 @@
+package main
+
+import (
+	"common/account"
+	"common/rbac"
+)
+
+// The shared login protection, session and password handling, run against this service's users
+func (s *Store) accounts() *account.Service {
+	return &account.Service{
+		Kind:          "user",
+		Accounts:      userAccounts{s.Users, s.Caregivers},
+		Sessions:      s.Sessions,
+		LoginFailures: s.LoginFailures,
+		Owns:          isUserAccount,
+	}
+}
+
+// Users as the shared handlers see them
+type userAccounts struct {
+	UserRepository
+	caregivers CaregiverLinkRepository
+}
+
+func (u userAccounts) Account(userID int) (account.Account, error) {
+	user, err := u.Get(userID)
+	if err == errUserNotFound {
+		return account.Account{}, account.ErrAccountNotFound
+	} else if err != nil {
+		return account.Account{}, err
+	}
+	return account.Account{ID: user.UserID, Role: user.Role, PasswordHash: user.PasswordHash, Active: true}, nil
+}
+
+// Caregiver tokens list the seniors who have approved them
+func (u userAccounts) TokenSeniors(userID int, role string) ([]int, error) {
+	if role != rbac.RoleCaregiver {
+		return nil, nil
+	}
+	return u.caregivers.ActiveSeniorIDs(userID)
+}
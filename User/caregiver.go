@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"common/audit"
+	"common/auth"
+	"common/rbac"
+	"common/respond"
+
+	"github.com/gorilla/mux"
+)
+
+// Caregiver link states. A link only grants access once the senior approves it.
+const (
+	linkPending = "Pending"
+	linkActive  = "Active"
+	linkRevoked = "Revoked"
+)
+
+// User service accounts are seniors and caregivers
+func isUserAccount(claims *auth.Claims) bool {
+	return claims != nil && (claims.Role == rbac.RoleSenior || claims.Role == rbac.RoleCaregiver)
+}
+
+// Parse the link_id path variable
+func linkIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	linkID, err := strconv.Atoi(mux.Vars(r)["link_id"])
+	if err != nil || linkID <= 0 {
+		http.Error(w, "Invalid link ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return linkID, true
+}
+
+// Let a caregiver ask a senior for access. The senior must approve before anything is shared.
+// The response is identical whether or not the email belongs to a senior.
+func requestCaregiverLinkHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+
+	var request struct {
+		SeniorEmail  string `json:"senior_email"`
+		Relationship string `json:"relationship"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	request.SeniorEmail = strings.TrimSpace(request.SeniorEmail)
+	request.Relationship = strings.TrimSpace(request.Relationship)
+	if request.SeniorEmail == "" {
+		http.Error(w, "Senior's email is required", http.StatusBadRequest)
+		return
+	}
+	if len(request.Relationship) > 50 {
+		http.Error(w, "Relationship must be at most 50 characters", http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]string{
+		"message": "If this email belongs to a Befrienders senior, they have been asked to approve your request.",
+	}
+
+	senior, err := store.Users.GetByEmail(request.SeniorEmail)
+	if err == errUserNotFound || (err == nil && senior.Role != rbac.RoleSenior) {
+		respond.JSON(w, response)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Only one open request or link per senior and caregiver
+	linkID, err := store.Caregivers.Request(senior.UserID, claims.Subject, request.Relationship)
+	if err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if linkID > 0 {
+		store.Audit.Change(r, audit.Create, "caregiver_link", strconv.Itoa(linkID), senior.UserID)
+	}
+
+	respond.JSON(w, response)
+}
+
+// List the logged in senior's caregivers, including requests waiting for approval
+func listCaregiversHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+
+	links, err := store.Caregivers.ListBySenior(claims.Subject)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !store.Audit.Access(w, r, "caregiver_link", "", claims.Subject) {
+		return
+	}
+
+	caregivers := []map[string]interface{}{}
+	for _, l := range links {
+		caregivers = append(caregivers, map[string]interface{}{
+			"link_id":      l.LinkID,
+			"caregiver_id": l.CaregiverID,
+			"name":         l.CaregiverName,
+			"email":        l.CaregiverEmail,
+			"relationship": l.Relationship,
+			"status":       l.Status,
+			"requested_at": l.RequestedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+
+	respond.JSON(w, caregivers)
+}
+
+// List the seniors the logged in caregiver is linked to or has asked to be linked to
+func listSeniorsHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+
+	links, err := store.Caregivers.ListByCaregiver(claims.Subject)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !store.Audit.Access(w, r, "caregiver_link", "", claims.Subject) {
+		return
+	}
+
+	seniors := []map[string]interface{}{}
+	for _, l := range links {
+		senior := map[string]interface{}{
+			"link_id":      l.LinkID,
+			"relationship": l.Relationship,
+			"status":       l.Status,
+		}
+		// Seniors' details are only shared once they approve the link
+		if l.Status == linkActive {
+			senior["user_id"] = l.SeniorID
+			senior["name"] = l.SeniorName
+		}
+		seniors = append(seniors, senior)
+	}
+
+	respond.JSON(w, seniors)
+}
+
+// Let the senior approve a caregiver's request. The caregiver gains access at their next token refresh.
+func approveCaregiverLinkHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+
+	linkID, ok := linkIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	approved, err := store.Caregivers.Approve(linkID, claims.Subject)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !approved {
+		http.Error(w, "Caregiver request not found", http.StatusNotFound)
+		return
+	}
+	store.Audit.Change(r, audit.Update, "caregiver_link", strconv.Itoa(linkID), claims.Subject)
+
+	respond.JSON(w, map[string]string{"message": "Caregiver approved successfully"})
+}
+
+// End a link. Seniors use this to decline a request or withdraw consent, caregivers to stop caring for a senior.
+func removeCaregiverLinkHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+
+	linkID, ok := linkIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	link, err := store.Caregivers.Get(linkID)
+	if err != nil && err != errLinkNotFound {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	owner := link.SeniorID
+	if claims.Role == rbac.RoleCaregiver {
+		owner = link.CaregiverID
+	}
+	if err == errLinkNotFound || owner != claims.Subject || link.Status == linkRevoked {
+		http.Error(w, "Caregiver link not found", http.StatusNotFound)
+		return
+	}
+
+	if err := store.Caregivers.Revoke(linkID); err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	store.Audit.Change(r, audit.Delete, "caregiver_link", strconv.Itoa(linkID), link.SeniorID)
+
+	// Log the caregiver out so access ends now rather than when their current token expires
+	if claims.Role == rbac.RoleSenior {
+		if err := store.Sessions.RevokeAll(link.CaregiverID); err != nil {
+			log.Println("Database update error:", err)
+		}
+	}
+
+	respond.JSON(w, map[string]string{"message": "Caregiver link removed successfully"})
+}
@@ -0,0 +1,16 @@
+package main
+
+import "common/config"
+
+// Settings of the User service, loaded by config.Load
+type Config struct {
+	Port           int    `env:"LOCAL_PORT" default:"5001" validate:"port"`
+	Storage        string `env:"STORAGE" default:"mysql" validate:"oneof=mysql memory"`
+	DB             config.Database
+	JWTSecret      config.Secret `env:"JWT_SECRET" required:"true"`
+	TrustedProxies string        `env:"TRUSTED_PROXIES" default:"127.0.0.1,::1"` // Proxies whose X-Real-IP header is believed
+	FieldKeys      config.Secret `env:"FIELD_KEYS" required:"true"`              // Master key file contents, usually given as FIELD_KEYS_FILE
+	Services       config.Services
+}
+
+var cfg Config
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"common/audit"
+	"common/auth"
+	"common/respond"
+
+	"github.com/gorilla/mux"
+)
+
+// Purposes a senior can consent to. Nothing is shared for a purpose until the senior grants it.
+const (
+	consentShareDoctor    = "share_with_doctor"    // Results emailed and alerted to their doctors
+	consentShareCaregiver = "share_with_caregiver" // Results emailed to their approved caregivers
+	consentResearch       = "research"             // Anonymised use of results for research
+)
+
+var consentPurposes = []string{consentShareDoctor, consentShareCaregiver, consentResearch}
+
+// Latest published wording of a consent purpose
+type consentText struct {
+	Purpose string `json:"purpose"`
+	Version int    `json:"version"`
+	Title   string `json:"title"`
+	Text    string `json:"text"`
+}
+
+// List the current consent wording so it can be shown before the senior decides
+func consentTextsHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	texts, err := store.Consents.CurrentTexts()
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := []consentText{}
+	for _, purpose := range consentPurposes {
+		if text, ok := texts[purpose]; ok {
+			response = append(response, text)
+		}
+	}
+
+	respond.JSON(w, response)
+}
+
+// Current consent state of a user. Other services check this before sending anything out.
+// A grant only counts while it is for the latest version of the text, so rewording a
+// purpose asks the senior to agree again.
+func getConsentsHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	userID, err := strconv.Atoi(mux.Vars(r)["user_id"])
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	// Seniors may only view their own consents, caregivers their linked seniors'
+	if !auth.AuthorizeUser(w, r, userID) {
+		return
+	}
+
+	texts, err := store.Consents.CurrentTexts()
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The newest decision for each purpose is the one in force
+	decisions, err := store.Consents.Decisions(userID)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if !store.Audit.Access(w, r, "consent", "", userID) {
+		return
+	}
+
+	consents := []map[string]interface{}{}
+	for _, purpose := range consentPurposes {
+		consent := map[string]interface{}{
+			"purpose":         purpose,
+			"current_version": texts[purpose].Version,
+			"granted":         false,
+		}
+		if d, ok := decisions[purpose]; ok {
+			consent["granted"] = d.Granted && d.Version == texts[purpose].Version
+			consent["version"] = d.Version
+			consent["recorded_at"] = d.RecordedAt.Format("2006-01-02 15:04:05")
+			// A grant of older wording has lapsed and needs renewing
+			consent["renewal_required"] = d.Granted && d.Version != texts[purpose].Version
+		}
+		consents = append(consents, consent)
+	}
+
+	respond.JSON(w, consents)
+}
+
+// Record a consent decision. Every decision is kept so the history can be shown later.
+func recordConsent(consents ConsentRepository, userID int, purpose string, version int, granted bool, userAgent string) error {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	return consents.Record(userID, purpose, version, granted, userAgent)
+}
+
+// Let the senior agree to a purpose. The version shown to them must still be the latest.
+func grantConsentHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+
+	var request struct {
+		Purpose string `json:"purpose"`
+		Version int    `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if !slices.Contains(consentPurposes, request.Purpose) {
+		http.Error(w, "Unknown consent purpose", http.StatusBadRequest)
+		return
+	}
+
+	texts, err := store.Consents.CurrentTexts()
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if request.Version != texts[request.Purpose].Version {
+		http.Error(w, "The consent text has changed, please review the latest version", http.StatusConflict)
+		return
+	}
+
+	if err := recordConsent(store.Consents, claims.Subject, request.Purpose, request.Version, true, r.UserAgent()); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	store.Audit.Change(r, audit.Create, "consent", request.Purpose, claims.Subject)
+
+	log.Printf("User %d granted consent %s (version %d)\n", claims.Subject, request.Purpose, request.Version)
+	respond.JSON(w, map[string]string{"message": "Consent granted"})
+}
+
+// Let the senior withdraw a purpose. Takes effect for everything sent from now on.
+func withdrawConsentHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	claims := auth.ClaimsFromRequest(r)
+
+	purpose := mux.Vars(r)["purpose"]
+	if !slices.Contains(consentPurposes, purpose) {
+		http.Error(w, "Unknown consent purpose", http.StatusBadRequest)
+		return
+	}
+
+	texts, err := store.Consents.CurrentTexts()
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := recordConsent(store.Consents, claims.Subject, purpose, texts[purpose].Version, false, r.UserAgent()); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	store.Audit.Change(r, audit.Update, "consent", purpose, claims.Subject)
+
+	log.Printf("User %d withdrew consent %s\n", claims.Subject, purpose)
+	respond.JSON(w, map[string]string{"message": "Consent withdrawn"})
+}
@@ -3,85 +3,172 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"common/account"
+	"common/audit"
+	"common/auth"
+	"common/config"
+	"common/fieldcrypt"
+	"common/migrate"
+	"common/rbac"
+	"common/respond"
+	"common/server"
+
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
-	"github.com/rs/cors"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Database connection function
-func connectDB(dbUser, dbPassword, dbHost, dbName string) (*sql.DB, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", dbUser, dbPassword, dbHost, dbName)
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
-	}
-	return db, db.Ping()
-}
-
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading .env file:", err)
+	reencrypt := flag.Bool("reencrypt", false, "Re-encrypt stored personal data under the current key, then exit")
+	flag.Parse()
+
+	// Load settings from the environment and .env files, listing everything missing at once
+	config.MustLoad(&cfg)
+
+	// `go run . migrate up|down|status|seed|baseline` manages the schema instead of serving
+	if flag.Arg(0) == "migrate" {
+		if err := migrate.Run(cfg.DB, schemaFiles, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
 	}
 
-	// Get database connection details from environment variables
-	dbUser, dbPassword, dbHost, dbName := os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_HOST"), os.Getenv("DB_NAME")
-	if dbUser == "" || dbPassword == "" || dbHost == "" || dbName == "" {
-		log.Fatal("Database credentials not fully set in environment variables")
+	auth.SetSecret(cfg.JWTSecret.Value())
+	if err := auth.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
 	}
 
-	// Get local port from environment variables
-	//localPort := os.Getenv("LOCAL_PORT")
-	localPort := "5001"
-	if localPort == "" {
-		log.Fatal("LOCAL_PORT environment variable is not set")
+	// Load the master keys personal data is encrypted with
+	if err := fieldcrypt.LoadKeys([]byte(cfg.FieldKeys.Value())); err != nil {
+		log.Fatalf("Failed to load field encryption keys: %v", err)
+	}
+	if !fieldcrypt.HasIndexKey() {
+		log.Fatal("The field key file has no index_key, which email lookups need")
 	}
 
-	// Connect to the database
-	db, err := connectDB(dbUser, dbPassword, dbHost, dbName)
+	// Connect to the database, or keep everything in memory with STORAGE=memory
+	store, err := openStore()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	defer store.Close()
+
+	if *reencrypt {
+		// Nothing is encrypted in memory
+		if store.db == nil {
+			log.Fatal("Re-encryption needs STORAGE=mysql")
+		}
+		if err := reencryptPersonalData(store.db); err != nil {
+			log.Fatalf("Re-encryption failed: %v", err)
+		}
+		log.Println("Re-encryption finished")
+		return
 	}
-	defer db.Close()
 
 	// Initialize the router
 	router := mux.NewRouter()
 
 	// API Routes
 	router.HandleFunc("/api/register", func(w http.ResponseWriter, r *http.Request) {
-		registrationHandler(w, r, db)
+		registrationHandler(w, r, store)
 	}).Methods("POST")
 	router.HandleFunc("/api/authenticate", func(w http.ResponseWriter, r *http.Request) {
-		authenticationHandler(w, r, db)
+		authenticationHandler(w, r, store)
+	}).Methods("POST")
+	router.HandleFunc("/api/verifyEmail", func(w http.ResponseWriter, r *http.Request) {
+		verifyEmailHandler(w, r, store)
+	}).Methods("POST")
+	router.HandleFunc("/api/resendVerification", func(w http.ResponseWriter, r *http.Request) {
+		resendVerificationHandler(w, r, store)
+	}).Methods("POST")
+	router.HandleFunc("/api/requestPasswordReset", func(w http.ResponseWriter, r *http.Request) {
+		requestPasswordResetHandler(w, r, store)
+	}).Methods("POST")
+	router.HandleFunc("/api/confirmPasswordReset", func(w http.ResponseWriter, r *http.Request) {
+		confirmPasswordResetHandler(w, r, store)
 	}).Methods("POST")
-	router.HandleFunc("/api/getUserDetails", func(w http.ResponseWriter, r *http.Request) {
-		getUserDetailsHandler(w, r, db)
+	router.HandleFunc("/api/refresh", func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().RefreshHandler(w, r)
 	}).Methods("POST")
-	router.HandleFunc("/api/updateUserDetails", func(w http.ResponseWriter, r *http.Request) {
-		updateUserDetailsHandler(w, r, db)
-	}).Methods("PUT")
-
-	// CORS Configuration
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT"},
-		AllowedHeaders:   []string{"Content-Type"},
-		AllowCredentials: true,
-	})
-	handler := c.Handler(router)
-
-	log.Printf("Starting server on :%s", localPort)
-	if err := http.ListenAndServe(":"+localPort, handler); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	router.HandleFunc("/api/consentTexts", func(w http.ResponseWriter, r *http.Request) {
+		consentTextsHandler(w, r, store)
+	}).Methods("GET")
+	router.HandleFunc("/api/logout", func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().LogoutHandler(w, r)
+	}).Methods("POST")
+
+	// Routes below require a valid session token
+	protected := router.NewRoute().Subrouter()
+	protected.Use(auth.Middleware)
+	protected.HandleFunc("/api/getUserDetails", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		getUserDetailsHandler(w, r, store)
+	}, rbac.PermProfileRead)).Methods("POST")
+	protected.HandleFunc("/api/updateUserDetails", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		updateUserDetailsHandler(w, r, store)
+	}, rbac.PermProfileWrite)).Methods("PUT")
+	protected.HandleFunc("/api/changePassword", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().ChangePasswordHandler(w, r)
+	}, rbac.PermAccountSelf)).Methods("PUT")
+	protected.HandleFunc("/api/admin/unlock/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().UnlockHandler(w, r)
+	}, rbac.PermAccountUnlock)).Methods("POST")
+	protected.HandleFunc("/api/logoutAll", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().LogoutAllHandler(w, r)
+	}, rbac.PermAccountSelf)).Methods("POST")
+	protected.HandleFunc("/api/sessions", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().ListSessionsHandler(w, r)
+	}, rbac.PermAccountSelf)).Methods("GET")
+	protected.HandleFunc("/api/sessions/{session_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.accounts().RevokeSessionHandler(w, r)
+	}, rbac.PermAccountSelf)).Methods("DELETE")
+	protected.HandleFunc("/api/caregivers", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		listCaregiversHandler(w, r, store)
+	}, rbac.PermCaregiverApprove)).Methods("GET")
+	protected.HandleFunc("/api/caregivers/requests", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		requestCaregiverLinkHandler(w, r, store)
+	}, rbac.PermCaregiverRequest)).Methods("POST")
+	protected.HandleFunc("/api/caregivers/{link_id}/approve", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		approveCaregiverLinkHandler(w, r, store)
+	}, rbac.PermCaregiverApprove)).Methods("POST")
+	protected.HandleFunc("/api/caregivers/{link_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		removeCaregiverLinkHandler(w, r, store)
+	}, rbac.PermCaregiverUnlink)).Methods("DELETE")
+	protected.HandleFunc("/api/seniors", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		listSeniorsHandler(w, r, store)
+	}, rbac.PermCaregiverRequest)).Methods("GET")
+	protected.HandleFunc("/api/consents/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		getConsentsHandler(w, r, store)
+	}, rbac.PermConsentRead)).Methods("GET")
+	protected.HandleFunc("/api/consents", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		grantConsentHandler(w, r, store)
+	}, rbac.PermConsentManage)).Methods("POST")
+	protected.HandleFunc("/api/consents/{purpose}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		withdrawConsentHandler(w, r, store)
+	}, rbac.PermConsentManage)).Methods("DELETE")
+	protected.HandleFunc("/api/audit", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.Audit.SearchHandler(w, r)
+	}, rbac.PermAuditRead)).Methods("GET")
+	protected.HandleFunc("/api/audit/verify", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		store.Audit.VerifyHandler(w, r)
+	}, rbac.PermAuditRead)).Methods("GET")
+	protected.HandleFunc("/api/personalData/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		exportPersonalDataHandler(w, r, store)
+	}, rbac.PermDataExport)).Methods("GET")
+	protected.HandleFunc("/api/personalData/{user_id}", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		erasePersonalDataHandler(w, r, store)
+	}, rbac.PermDataErase)).Methods("DELETE")
+
+	// Serve until stopped, letting requests in progress finish
+	if err := server.Run("User", cfg.Port, server.CORS(router, "GET", "POST", "PUT", "DELETE")); err != nil {
+		log.Fatalf("Server failed: %v", err)
 	}
 }
 
@@ -93,6 +180,7 @@ type User struct {
 	DateOfBirth time.Time `json:"dateOfBirth,omitempty"`
 	PhoneNumber string    `json:"phoneNumber,omitempty"`
 	Address     string    `json:"address,omitempty"`
+	Role        string    `json:"role,omitempty"`
 }
 
 // Custom JSON Unmarshaler for time.Time
@@ -109,6 +197,11 @@ func (u *User) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	// Caregiver accounts have no date of birth
+	if aux.DateOfBirth == "" {
+		return nil
+	}
+
 	// Parse date in YYYY-MM-DD format
 	parsedDOB, err := time.Parse("2006-01-02", aux.DateOfBirth)
 	if err != nil {
@@ -136,12 +229,17 @@ func validateUserInput(u User) map[string]string {
 	}
 
 	// Password validation
-	if u.Password == "" || len(u.Password) < 8 {
-		errors["password"] = "Password must be at least 8 characters long"
+	if msg := account.ValidatePassword(u.Password); msg != "" {
+		errors["password"] = msg
 	}
 
-	// Date of Birth validation
-	if u.DateOfBirth.Year() < 1900 || u.DateOfBirth.Year() > time.Now().Year() {
+	// Role validation
+	if u.Role != rbac.RoleSenior && u.Role != rbac.RoleCaregiver {
+		errors["role"] = "Role must be senior or caregiver"
+	}
+
+	// Date of Birth validation, seniors only
+	if u.Role != rbac.RoleCaregiver && (u.DateOfBirth.Year() < 1900 || u.DateOfBirth.Year() > time.Now().Year()) {
 		log.Println("Invalid Date of Birth:", u.DateOfBirth)
 		errors["dateOfBirth"] = "Invalid Date of Birth"
 	}
@@ -157,33 +255,134 @@ func validateUserInput(u User) map[string]string {
 		}
 	}
 
-	// Address validation
-	if u.Address == "" {
+	// Address validation, seniors only
+	if u.Role != rbac.RoleCaregiver && u.Address == "" {
 		errors["address"] = "Address is required"
 	}
 
 	return errors
 }
 
-func registrationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+// Columns of Users holding personal data, which are stored encrypted
+var userEncryptedColumns = []fieldcrypt.Column{
+	{Table: "Users", Key: "UserID", Column: "Email"},
+	{Table: "Users", Key: "UserID", Column: "DateOfBirth"},
+	{Table: "Users", Key: "UserID", Column: "PhoneNumber"},
+	{Table: "Users", Key: "UserID", Column: "Address"},
+}
+
+// Blind index of an email address. Users are always looked up by email through it.
+func emailIndex(email string) string {
+	return fieldcrypt.BlindIndex("Users.Email", email)
+}
+
+// A user's personal data as it is stored
+type encryptedUser struct {
+	Email       string
+	DateOfBirth interface{} // Caregivers have no date of birth, so NULL rather than the zero date
+	PhoneNumber string
+	Address     string
+}
+
+// Encrypt a user's personal data before it is stored
+func encryptUser(u User) (encryptedUser, error) {
+	var e encryptedUser
+	var err error
+	if e.Email, err = fieldcrypt.Encrypt("Users.Email", u.Email); err != nil {
+		return e, err
+	}
+	if !u.DateOfBirth.IsZero() {
+		if e.DateOfBirth, err = fieldcrypt.Encrypt("Users.DateOfBirth", u.DateOfBirth.Format("2006-01-02")); err != nil {
+			return e, err
+		}
+	}
+	if e.PhoneNumber, err = fieldcrypt.Encrypt("Users.PhoneNumber", u.PhoneNumber); err != nil {
+		return e, err
+	}
+	e.Address, err = fieldcrypt.Encrypt("Users.Address", u.Address)
+	return e, err
+}
+
+// Decrypt a stored date of birth, nil if there is none
+func decryptDateOfBirth(stored sql.NullString) (*time.Time, error) {
+	if !stored.Valid || stored.String == "" {
+		return nil, nil
+	}
+	value, err := fieldcrypt.Decrypt("Users.DateOfBirth", stored.String)
+	if err != nil {
+		return nil, err
+	}
+	dateOfBirth, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	return &dateOfBirth, nil
+}
+
+// Re-encrypt all personal data under the current master key and rebuild the email index,
+// e.g. after a key rotation or to encrypt rows stored before encryption was introduced
+func reencryptPersonalData(db *sql.DB) error {
+	if err := fieldcrypt.ReencryptColumns(db, userEncryptedColumns); err != nil {
+		return err
+	}
+
+	rows, err := db.Query("SELECT UserID, Email, COALESCE(EmailIndex, '') FROM Users")
+	if err != nil {
+		return err
+	}
+	indexes := map[int]string{}
+	for rows.Next() {
+		var userID int
+		var stored, index string
+		if err := rows.Scan(&userID, &stored, &index); err != nil {
+			rows.Close()
+			return err
+		}
+		email, err := fieldcrypt.Decrypt("Users.Email", stored)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("user %d: %v", userID, err)
+		}
+		if emailIndex(email) != index {
+			indexes[userID] = emailIndex(email)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for userID, index := range indexes {
+		if _, err := db.Exec("UPDATE Users SET EmailIndex = ? WHERE UserID = ?", index, userID); err != nil {
+			return err
+		}
+	}
+	log.Printf("Rebuilt the email index of %d users\n", len(indexes))
+	return nil
+}
+
+func registrationHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	// Decode the incoming JSON request body
 	var u User
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&u); err != nil {
 		log.Println("JSON decoding error:", err)
-		http.Error(w, `{"message":"Invalid input format"}`, http.StatusBadRequest)
+		respond.Message(w, http.StatusBadRequest, "Invalid input format")
 		return
 	}
 	defer r.Body.Close()
 
+	// Accounts are for seniors unless registering as a caregiver
+	if u.Role == "" {
+		u.Role = rbac.RoleSenior
+	}
+
 	// Validate input fields
 	validationErrors := validateUserInput(u)
 
 	// Check if email is already registered
-	query := "SELECT UserID FROM Users WHERE Email = ?"
-	var userID int
-	err := db.QueryRow(query, u.Email).Scan(&userID)
-	if err == sql.ErrNoRows {
+	_, err := store.Users.GetByEmail(u.Email)
+	if err == errUserNotFound {
 
 	} else if err != nil {
 		log.Println("Database query error:", err)
@@ -195,9 +394,7 @@ func registrationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 
 	if len(validationErrors) > 0 {
 		log.Println("Validation errors:", validationErrors)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(validationErrors)
+		respond.JSONStatus(w, http.StatusBadRequest, validationErrors)
 		return
 	}
 
@@ -205,29 +402,39 @@ func registrationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
 	if err != nil {
 		log.Println("Password hashing error:", err)
-		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		respond.Message(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
-	// Insert new user into the database
-	query2 := "INSERT INTO Users(Name, Email, PasswordHash, DateOfBirth, PhoneNumber, Address) VALUES(?, ?, ?, ?, ?, ?)"
-	// Insert User into DB
-	_, err = db.Exec(query2, u.Name, u.Email, hashedPassword, u.DateOfBirth, u.PhoneNumber, u.Address)
+	// Insert the new user, with their personal data encrypted
+	newUserID, err := store.Users.Create(u, hashedPassword)
 	if err != nil {
 		log.Println("Database insert error:", err)
-		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		respond.Message(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
+	// New users are not logged in yet, so they are recorded as creating their own profile
+	if err := store.Audit.Append(audit.Entry{
+		ActorID: newUserID, ActorRole: u.Role, Action: audit.Create, ResourceType: "user_profile",
+		ResourceID: strconv.Itoa(newUserID), SubjectID: newUserID, SourceIP: auth.ClientIP(r),
+	}); err != nil {
+		log.Println("Audit log error:", err)
+	}
+
+	// The account stays pending until the emailed link is opened
+	if err := createEmailVerification(store.Verifications, newUserID, u.Email, u.Name); err != nil {
+		log.Println("Email verification error:", err)
+	}
+
 	// Send success response
 	response := map[string]string{
-		"message": "Registration successful!.",
+		"message": "Registration successful! Please check your email to verify your account.",
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	respond.JSON(w, response)
 }
 
-func authenticationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func authenticationHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	// Define a struct for authentication request
 	var request struct {
 		Email    string `json:"email"`
@@ -249,17 +456,22 @@ func authenticationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Prepare SQL statement
-	var storedUserID int
-	var storedPassword string
-
-	query := "SELECT UserID, PasswordHash FROM Users WHERE Email = ?"
+	// Reject addresses with too many recent failures
+	ip := auth.ClientIP(r)
+	retryAfter, err := store.accounts().IPRetryAfter(ip)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	} else if retryAfter > 0 {
+		account.TooManyAttempts(w, retryAfter)
+		return
+	}
 
 	// Query the database for a user with the provided email
-	err := db.QueryRow(query, request.Email).Scan(&storedUserID, &storedPassword)
-	if err == sql.ErrNoRows {
-		// If no user is found
-		http.Error(w, "Invalid email", http.StatusUnauthorized)
+	user, err := store.Users.GetByEmail(request.Email)
+	if err == errUserNotFound {
+		store.accounts().RefuseLogin(w, ip, request.Password)
 		return
 	} else if err != nil {
 		log.Println("Database query error:", err)
@@ -267,31 +479,56 @@ func authenticationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Locked accounts are refused even with the right password, the same way as unknown emails
+	if user.LockedFor > 0 {
+		store.accounts().RefuseLogin(w, ip, request.Password)
+		return
+	}
+
 	// Compare entered password with stored password
-	err = bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(request.Password))
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(request.Password))
 	if err != nil {
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		store.accounts().RecordFailedLogin(ip, user.UserID)
+		http.Error(w, account.InvalidCredentialsMessage, http.StatusUnauthorized)
 		return
 	}
 
-	// Set response headers
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set("X-Frame-Options", "DENY")
-	w.Header().Set("X-XSS-Protection", "1; mode=block")
+	if user.FailedLoginCount > 0 {
+		if err := store.Users.ResetFailedLogins(user.UserID); err != nil {
+			log.Println("Database update error:", err)
+		}
+	}
 
-	// Respond with success message
-	response := map[string]interface{}{
-		"message": "Login successful",
-		"user_id": storedUserID, // Return User ID
+	// Unverified accounts cannot log in until the email address is confirmed
+	if user.Status != statusVerified {
+		respond.JSONStatus(w, http.StatusForbidden, map[string]string{
+			"message": "Please verify your email address before logging in.",
+			"status":  user.Status,
+		})
+		return
 	}
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("JSON encoding error:", err)
+
+	// Issue a signed access token and a refresh token for this device
+	token, refreshToken, err := store.accounts().CreateSession(user.UserID, user.Role, r.UserAgent())
+	if err != nil {
+		log.Println("Session creation error:", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
+
+	// Respond with success message
+	response := map[string]interface{}{
+		"message":       "Login successful",
+		"user_id":       user.UserID, // Return User ID
+		"role":          user.Role,
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(auth.TokenTTL.Seconds()),
+	}
+	respond.JSON(w, response)
 }
 
-func getUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func getUserDetailsHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	// Decode the incoming JSON request body
 	var request struct {
 		UserID int `json:"user_id"`
@@ -311,19 +548,24 @@ func getUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Seniors may only view their own profile, caregivers their linked seniors'
+	if !auth.AuthorizeUser(w, r, request.UserID) {
+		return
+	}
+
 	// Query user details from the database
 	var user struct {
-		Name        string    `json:"name"`
-		Email       string    `json:"email"`
-		DateOfBirth time.Time `json:"date_of_birth"`
-		PhoneNumber string    `json:"phone_number"`
-		Address     string    `json:"address"`
+		Name        string     `json:"name"`
+		Email       string     `json:"email"`
+		DateOfBirth *time.Time `json:"date_of_birth,omitempty"`
+		PhoneNumber string     `json:"phone_number"`
+		Address     string     `json:"address"`
+		Role        string     `json:"role"`
 	}
 
-	query := "SELECT Name, Email, DateOfBirth, PhoneNumber, Address FROM Users WHERE UserID = ?"
-	err := db.QueryRow(query, request.UserID).Scan(&user.Name, &user.Email, &user.DateOfBirth, &user.PhoneNumber, &user.Address)
+	account, err := store.Users.Get(request.UserID)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == errUserNotFound {
 			http.Error(w, "User not found", http.StatusNotFound)
 		} else {
 			log.Println("Database query error:", err)
@@ -331,28 +573,25 @@ func getUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		}
 		return
 	}
+	user.Name, user.Email, user.DateOfBirth = account.Name, account.Email, account.DateOfBirth
+	user.PhoneNumber, user.Address, user.Role = account.PhoneNumber, account.Address, account.Role
 
-	// Set secure response headers
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set("X-Frame-Options", "DENY")
-	w.Header().Set("X-XSS-Protection", "1; mode=block")
+	if !store.Audit.Access(w, r, "user_profile", strconv.Itoa(request.UserID), request.UserID) {
+		return
+	}
 
 	// Send response
-	if err := json.NewEncoder(w).Encode(user); err != nil {
-		log.Println("JSON encoding error:", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-	}
+	respond.JSON(w, user)
 }
 
 // receives user id and new email, dob, phone no. or address and updates record. returns update status
-func updateUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func updateUserDetailsHandler(w http.ResponseWriter, r *http.Request, store *Store) {
 	// Decode the incoming JSON request body
 	var u User
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&u); err != nil {
 		log.Println("JSON decoding error:", err)
-		http.Error(w, `{"message":"Invalid input"}`, http.StatusBadRequest)
+		respond.Message(w, http.StatusBadRequest, "Invalid input")
 		return
 	}
 	defer r.Body.Close()
@@ -360,58 +599,63 @@ func updateUserDetailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 	// Validate input
 	if u.UserID <= 0 {
 		log.Println("Error: Invalid user ID received")
-		http.Error(w, `{"message":"Invalid user ID"}`, http.StatusBadRequest)
+		respond.Message(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	// Only the account owner may update their profile
+	claims := auth.ClaimsFromRequest(r)
+	if !isUserAccount(claims) || claims.Subject != u.UserID {
+		respond.Message(w, http.StatusForbidden, "Forbidden")
 		return
 	}
+	u.Role = claims.Role
 	u.Password = "Placeholder"
 	validationErrors := validateUserInput(u)
 
 	// Query the database for a user with the provided email
-	query := "SELECT UserID FROM Users WHERE Email = ?"
-	var storedUserID int
-	err := db.QueryRow(query, u.Email).Scan(&storedUserID)
-	if err == sql.ErrNoRows {
+	existing, err := store.Users.GetByEmail(u.Email)
+	emailChanged := err == errUserNotFound
+	if err == errUserNotFound {
 
 	} else if err != nil {
 		log.Println("Database query error while checking email:", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
-	} else if storedUserID != u.UserID {
+	} else if existing.UserID != u.UserID {
 		validationErrors["email"] = "Email address already in use"
 	}
 
 	if len(validationErrors) > 0 {
 		log.Println("Validation errors:", validationErrors)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(validationErrors)
+		respond.JSONStatus(w, http.StatusBadRequest, validationErrors)
 		return
 	}
 
-	// Prepare the SQL statement
-	query2 := "UPDATE Users SET Name=?, Email=?, DateOfBirth=?, PhoneNumber=?, Address=? WHERE UserID=?"
-	result, err := db.Exec(query2, u.Name, u.Email, u.DateOfBirth, u.PhoneNumber, u.Address, u.UserID)
+	// A new address must be verified again before the next login
+	updated, err := store.Users.Update(u, emailChanged)
 	if err != nil {
 		log.Println("Database update error:", err)
-		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		respond.Message(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
-	// Check if any row was affected
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Println("Error checking rows affected:", err)
-		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+	if !updated {
+		log.Printf("No changes made or user with ID %d not found\n", u.UserID)
+		respond.Message(w, http.StatusNotFound, "User not found or no changes made")
 		return
 	}
 
-	if rowsAffected == 0 {
-		log.Printf("No changes made or user with ID %d not found\n", u.UserID)
-		http.Error(w, `{"message":"User not found or no changes made"}`, http.StatusNotFound)
-		return
+	store.Audit.Change(r, audit.Update, "user_profile", strconv.Itoa(u.UserID), u.UserID)
+
+	message := "Profile updated successfully"
+	if emailChanged {
+		if err := createEmailVerification(store.Verifications, u.UserID, u.Email, u.Name); err != nil {
+			log.Println("Email verification error:", err)
+		}
+		message = "Profile updated successfully. Please check your new email address to verify it."
 	}
 
 	// Send success response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Profile updated successfully"})
+	respond.JSON(w, map[string]string{"message": message})
 }
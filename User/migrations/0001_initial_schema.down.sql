@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS AuditLog;
+DROP TABLE IF EXISTS DataErasures;
+DROP TABLE IF EXISTS UserConsents;
+DROP TABLE IF EXISTS ConsentTexts;
+DROP TABLE IF EXISTS CaregiverLinks;
+DROP TABLE IF EXISTS LoginFailures;
+DROP TABLE IF EXISTS EmailVerifications;
+DROP TABLE IF EXISTS PasswordResets;
+DROP TABLE IF EXISTS RefreshTokens;
+DROP TABLE IF EXISTS Users;
@@ -0,0 +1,142 @@
+CREATE TABLE Users (
+    UserID INT AUTO_INCREMENT PRIMARY KEY,
+    Name VARCHAR(100) NOT NULL,
+    Email VARCHAR(512) NOT NULL, -- Encrypted, like DateOfBirth, PhoneNumber and Address
+    EmailIndex CHAR(64) UNIQUE NOT NULL, -- Blind index (keyed hash) of Email, used for lookups
+    PasswordHash VARCHAR(255) NOT NULL,
+    DateOfBirth VARCHAR(255),
+    PhoneNumber VARCHAR(255),
+    Address TEXT,
+    Role ENUM('senior', 'caregiver') NOT NULL DEFAULT 'senior', -- Caregivers have no date of birth or address
+    Status ENUM('Pending', 'Verified') NOT NULL DEFAULT 'Pending', -- Verified once the emailed link is opened
+    FailedLoginCount INT NOT NULL DEFAULT 0, -- Consecutive failed logins, reset on success
+    LockedUntil TIMESTAMP NULL DEFAULT NULL, -- Set once FailedLoginCount reaches the lockout threshold
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UpdatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- Refresh tokens, one row per device session (only the SHA-256 hash is stored)
+CREATE TABLE RefreshTokens (
+    TokenID INT AUTO_INCREMENT PRIMARY KEY,
+    UserID INT NOT NULL,
+    TokenHash CHAR(64) UNIQUE NOT NULL,
+    UserAgent VARCHAR(255) NOT NULL DEFAULT '',
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP NOT NULL,
+    RevokedAt TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (UserID) REFERENCES Users(UserID) ON DELETE CASCADE
+);
+
+-- Password reset requests (only the SHA-256 hash of the emailed token is stored)
+CREATE TABLE PasswordResets (
+    ResetID INT AUTO_INCREMENT PRIMARY KEY,
+    UserID INT NOT NULL,
+    TokenHash CHAR(64) UNIQUE NOT NULL,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP NOT NULL,
+    UsedAt TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (UserID) REFERENCES Users(UserID) ON DELETE CASCADE
+);
+
+-- Email verification requests (only the SHA-256 hash of the emailed token is stored)
+CREATE TABLE EmailVerifications (
+    VerificationID INT AUTO_INCREMENT PRIMARY KEY,
+    UserID INT NOT NULL,
+    TokenHash CHAR(64) UNIQUE NOT NULL,
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP NOT NULL,
+    UsedAt TIMESTAMP NULL DEFAULT NULL,
+    FOREIGN KEY (UserID) REFERENCES Users(UserID) ON DELETE CASCADE
+);
+
+-- Failed logins per client IP, used to throttle password guessing across accounts
+CREATE TABLE LoginFailures (
+    FailureID INT AUTO_INCREMENT PRIMARY KEY,
+    IPAddress VARCHAR(45) NOT NULL,
+    AttemptedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (IPAddress, AttemptedAt)
+);
+
+-- Caregivers and family members linked to a senior. Access is only granted once the senior approves.
+CREATE TABLE CaregiverLinks (
+    LinkID INT AUTO_INCREMENT PRIMARY KEY,
+    SeniorID INT NOT NULL,
+    CaregiverID INT NOT NULL,
+    Relationship VARCHAR(50) NOT NULL DEFAULT '',
+    Status ENUM('Pending', 'Active', 'Revoked') NOT NULL DEFAULT 'Pending',
+    RequestedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    ApprovedAt TIMESTAMP NULL DEFAULT NULL,
+    RevokedAt TIMESTAMP NULL DEFAULT NULL,
+    INDEX (CaregiverID, Status),
+    FOREIGN KEY (SeniorID) REFERENCES Users(UserID) ON DELETE CASCADE,
+    FOREIGN KEY (CaregiverID) REFERENCES Users(UserID) ON DELETE CASCADE
+);
+
+-- Published wording of each consent purpose. Rewording a purpose adds a new version,
+-- which seniors must agree to again before anything is shared for it.
+CREATE TABLE ConsentTexts (
+    Purpose ENUM('share_with_doctor', 'share_with_caregiver', 'research') NOT NULL,
+    Version INT NOT NULL,
+    Title VARCHAR(100) NOT NULL,
+    Body TEXT NOT NULL,
+    PublishedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (Purpose, Version)
+);
+
+INSERT INTO ConsentTexts (Purpose, Version, Title, Body) VALUES
+('share_with_doctor', 1, 'Share my results with my doctors',
+ 'When an assessment shows a moderate or high risk, or a vision test scores low, your results are emailed to the doctors assigned to you (or to the clinic if you have none) and shown on their alert dashboard so they can follow up.'),
+('share_with_caregiver', 1, 'Share my results with my caregivers',
+ 'When an assessment shows a moderate or high risk, the caregivers you have approved are emailed a notification so they can check on you.'),
+('research', 1, 'Use my results for research',
+ 'Your assessment and vision results may be used, with your name and contact details removed, to improve fall prevention for seniors.');
+
+-- Every consent decision a user makes. The newest row per purpose is the one in force.
+CREATE TABLE UserConsents (
+    ConsentID INT AUTO_INCREMENT PRIMARY KEY,
+    UserID INT NOT NULL,
+    Purpose ENUM('share_with_doctor', 'share_with_caregiver', 'research') NOT NULL,
+    Version INT NOT NULL,
+    Granted BOOLEAN NOT NULL,
+    UserAgent VARCHAR(255) NOT NULL DEFAULT '',
+    RecordedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX (UserID, Purpose),
+    FOREIGN KEY (UserID) REFERENCES Users(UserID) ON DELETE CASCADE
+);
+
+-- Record of completed personal data erasures. Holds no personal data, only the former user ID,
+-- who requested the erasure and how many rows each service deleted.
+CREATE TABLE DataErasures (
+    ErasureID INT AUTO_INCREMENT PRIMARY KEY,
+    UserID INT NOT NULL,
+    RequestedBy INT NOT NULL,
+    RequestedByRole VARCHAR(20) NOT NULL,
+    Report JSON NOT NULL,
+    CompletedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Append-only audit log of every read and change of health or profile data. Each row's Hash
+-- covers the previous row's Hash, so any edit or removal shows up when the chain is verified.
+CREATE TABLE AuditLog (
+    AuditID BIGINT AUTO_INCREMENT PRIMARY KEY,
+    Service VARCHAR(30) NOT NULL,
+    ActorID INT NOT NULL,
+    ActorRole VARCHAR(20) NOT NULL,
+    Action VARCHAR(10) NOT NULL,
+    ResourceType VARCHAR(30) NOT NULL,
+    ResourceID VARCHAR(64) NOT NULL DEFAULT '',
+    SubjectID INT NOT NULL DEFAULT 0,
+    SourceIP VARCHAR(45) NOT NULL DEFAULT '',
+    CreatedAt DATETIME(6) NOT NULL,
+    PrevHash CHAR(64) NOT NULL DEFAULT '',
+    Hash CHAR(64) NOT NULL,
+    INDEX (ActorID),
+    INDEX (SubjectID),
+    INDEX (CreatedAt)
+);
+
+CREATE TRIGGER AuditLogNoUpdate BEFORE UPDATE ON AuditLog
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only';
+
+CREATE TRIGGER AuditLogNoDelete BEFORE DELETE ON AuditLog
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only';
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"common/audit"
+	"common/auth"
+	"common/rbac"
+	"common/respond"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Services holding personal data keyed by UserID. Each one exports it with GET and erases it with DELETE.
+func personalDataServices() []struct{ Name, URL string } {
+	return []struct{ Name, URL string }{
+		{"self_assessment", cfg.Services.SelfAssessment + "/api/personalData/%d"},
+		{"vision_assessment", cfg.Services.VisionAssessment + "/personalData?userID=%d"},
+		{"notifications", cfg.Services.Notifications + "/api/personalData/%d"},
+		{"doctor", cfg.Services.Doctor + "/api/personalData/%d"},
+	}
+}
+
+// Call another service's personal data endpoint on behalf of the caller and return its JSON reply
+func callPersonalDataService(method, url, authHeader string) (json.RawMessage, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.RawMessage(body), nil
+}
+
+// Export everything held about a user across all services as one JSON document, or a ZIP with
+// one JSON file per service when called with ?format=zip
+func exportPersonalDataHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	userID, ok := auth.DataSubjectFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	// Password and token hashes are never exported
+	local, err := store.Users.PersonalData(userID)
+	if err == errUserNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	sections := map[string]interface{}{"user": local}
+	order := []string{"user"}
+	for _, service := range personalDataServices() {
+		data, err := callPersonalDataService("GET", fmt.Sprintf(service.URL, userID), r.Header.Get("Authorization"))
+		if err != nil {
+			// A partial export would misstate what is held, so fail the whole request
+			log.Println("Personal data export error:", err)
+			http.Error(w, "Failed to collect personal data from the "+service.Name+" service", http.StatusBadGateway)
+			return
+		}
+		sections[service.Name] = data
+		order = append(order, service.Name)
+	}
+
+	if !store.Audit.Access(w, r, "personal_data", strconv.Itoa(userID), userID) {
+		return
+	}
+
+	exportedAt := time.Now().UTC().Format(time.RFC3339)
+	filename := fmt.Sprintf("personal-data-%d", userID)
+	log.Printf("Personal data of user %d exported by user %d\n", userID, auth.ClaimsFromRequest(r).Subject)
+
+	if r.URL.Query().Get("format") != "zip" {
+		sections["user_id"] = userID
+		sections["exported_at"] = exportedAt
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		respond.JSON(w, sections)
+		return
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, name := range order {
+		file, err := archive.Create(name + ".json")
+		if err == nil {
+			err = json.NewEncoder(file).Encode(sections[name])
+		}
+		if err != nil {
+			log.Println("ZIP creation error:", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := archive.SetComment(fmt.Sprintf("Personal data of user %d exported at %s", userID, exportedAt)); err != nil {
+		log.Println("ZIP creation error:", err)
+	}
+	if err := archive.Close(); err != nil {
+		log.Println("ZIP creation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	w.Write(buffer.Bytes())
+}
+
+// Outcome of erasing a user's data in one service
+type erasureResult struct {
+	Service string           `json:"service"`
+	Status  string           `json:"status"`
+	Deleted map[string]int64 `json:"deleted,omitempty"`
+}
+
+// Erase a user's personal data in every service, then delete the account itself.
+// Seniors and caregivers confirm with their password. The account is only deleted once
+// every other service has succeeded, so a failed erasure can simply be retried.
+func erasePersonalDataHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	userID, ok := auth.DataSubjectFromPath(w, r)
+	if !ok {
+		return
+	}
+	claims := auth.ClaimsFromRequest(r)
+
+	var request struct {
+		Password string `json:"password"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+	}
+
+	user, err := store.Users.Get(userID)
+	if err == errUserNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// System admins act on a verified request, account owners confirm with their password
+	if claims.Role != rbac.RoleSystemAdmin {
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(request.Password)) != nil {
+			http.Error(w, "Incorrect password", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	report := []erasureResult{}
+	for _, service := range personalDataServices() {
+		data, err := callPersonalDataService("DELETE", fmt.Sprintf(service.URL, userID), r.Header.Get("Authorization"))
+		var reply struct {
+			Deleted map[string]int64 `json:"deleted"`
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &reply)
+		}
+		if err != nil {
+			log.Println("Personal data erasure error:", err)
+			report = append(report, erasureResult{Service: service.Name, Status: "failed"})
+			respond.JSONStatus(w, http.StatusBadGateway, map[string]interface{}{
+				"message": "Erasure could not be completed. No account data was deleted from the User service, please try again.",
+				"report":  report,
+			})
+			return
+		}
+		report = append(report, erasureResult{Service: service.Name, Status: "erased", Deleted: reply.Deleted})
+	}
+
+	// Delete the account and everything linked to it, keeping only a record of the erasure
+	// (user ID, who asked and row counts) as proof the request was carried out
+	local, err := store.Users.Erase(userID, claims, report)
+	if err != nil {
+		log.Println("Database delete error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	report = append(report, local)
+	store.Audit.Change(r, audit.Delete, "personal_data", strconv.Itoa(userID), userID)
+
+	log.Printf("Personal data of user %d erased by user %d (%s)\n", userID, claims.Subject, claims.Role)
+	respond.JSON(w, map[string]interface{}{
+		"message":      "Personal data erased successfully",
+		"user_id":      userID,
+		"completed_at": time.Now().UTC().Format(time.RFC3339),
+		"report":       report,
+	})
+}
@@ -0,0 +1,725 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"common/account"
+	"common/audit"
+	"common/auth"
+	"common/fieldcrypt"
+)
+
+// Returned when nothing matches the lookup
+var (
+	errUserNotFound  = errors.New("user not found")
+	errLinkNotFound  = errors.New("caregiver link not found")
+	errTokenNotFound = account.ErrTokenNotFound
+)
+
+// A user account with its personal data decrypted and the fields that never leave the service
+type UserAccount struct {
+	UserID           int
+	Name             string
+	Email            string
+	DateOfBirth      *time.Time // Caregivers have none
+	PhoneNumber      string
+	Address          string
+	Role             string
+	Status           string
+	PasswordHash     string
+	FailedLoginCount int
+	LockedFor        time.Duration // Time left on a lockout, 0 when not locked
+}
+
+type UserRepository interface {
+	// Store a new pending account, returning its ID
+	Create(u User, passwordHash []byte) (int, error)
+	Get(userID int) (UserAccount, error)
+	GetByEmail(email string) (UserAccount, error)
+	// Save the profile, putting the account back to Pending if the new email must be verified.
+	// Reports false if there is no such user or nothing changed.
+	Update(u User, reverify bool) (bool, error)
+	SetPassword(userID int, passwordHash []byte) error
+	// Add one to the consecutive failed logins in a single update and return the new count
+	IncrementFailedLogins(userID int) (int, error)
+	// Lock the account for the lockout, unless it is already locked for longer
+	LockFor(userID int, lockout time.Duration) error
+	// Clear the failed logins and any lockout
+	ResetFailedLogins(userID int) error
+	// Everything held about the user, by section. Password and token hashes are left out.
+	PersonalData(userID int) (map[string]interface{}, error)
+	// Delete the account and everything linked to it at once, keeping only a record of the
+	// erasure with the report of the other services
+	Erase(userID int, claims *auth.Claims, report []erasureResult) (erasureResult, error)
+}
+
+// An emailed password reset or verification link
+type OneTimeToken struct {
+	ID        int
+	UserID    int
+	ExpiresAt time.Time
+	Used      bool
+}
+
+type PasswordResetRepository interface {
+	// Store a new reset request, using up any earlier one so only the latest link works
+	Create(userID int, tokenHash string, expiresAt time.Time) error
+	GetByToken(tokenHash string) (OneTimeToken, error)
+	// Use up the request, set the new password and revoke every session at once,
+	// reporting false if the request was already used
+	Use(resetID, userID int, passwordHash []byte) (bool, error)
+	// How many requests were made within the window and how long ago the latest was
+	Recent(userID int, window time.Duration) (int, time.Duration, error)
+}
+
+type VerificationRepository interface {
+	// Store a new verification request, using up any earlier one so only the latest link works
+	Create(userID int, tokenHash string, expiresAt time.Time) error
+	GetByToken(tokenHash string) (OneTimeToken, error)
+	// Use up the request and mark the account verified at once, reporting false if it was already used
+	Use(verificationID, userID int) (bool, error)
+	// How many links the user was sent within the window, and how long ago the latest was
+	Recent(userID int, window time.Duration) (int, time.Duration, error)
+}
+
+// A caregiver's link to a senior
+type CaregiverLink struct {
+	LinkID         int
+	SeniorID       int
+	SeniorName     string
+	CaregiverID    int
+	CaregiverName  string
+	CaregiverEmail string
+	Relationship   string
+	Status         string
+	RequestedAt    time.Time
+}
+
+type CaregiverLinkRepository interface {
+	// Ask for a link, returning its ID, or 0 if the pair already has an open request or link
+	Request(seniorID, caregiverID int, relationship string) (int, error)
+	Get(linkID int) (CaregiverLink, error)
+	// The senior's or the caregiver's links that are not revoked, latest request first
+	ListBySenior(seniorID int) ([]CaregiverLink, error)
+	ListByCaregiver(caregiverID int) ([]CaregiverLink, error)
+	// IDs of the seniors who have approved the caregiver, in order
+	ActiveSeniorIDs(caregiverID int) ([]int, error)
+	// Approve one of the senior's pending requests, reporting false if there is none
+	Approve(linkID, seniorID int) (bool, error)
+	Revoke(linkID int) error
+}
+
+// A user's decision on a consent purpose
+type ConsentDecision struct {
+	Version    int
+	Granted    bool
+	RecordedAt time.Time
+}
+
+type ConsentRepository interface {
+	// Latest published text of every consent purpose, keyed by purpose
+	CurrentTexts() (map[string]consentText, error)
+	// The user's newest decision for each purpose, keyed by purpose
+	Decisions(userID int) (map[string]ConsentDecision, error)
+	Record(userID int, purpose string, version int, granted bool, userAgent string) error
+}
+
+func newMySQLStore(db *sql.DB) *Store {
+	return &Store{
+		Users:         mysqlUserRepository{db},
+		Sessions:      mysqlSessionRepository{db},
+		LoginFailures: mysqlLoginFailureRepository{db},
+		Resets:        mysqlPasswordResetRepository{db},
+		Verifications: mysqlVerificationRepository{db},
+		Caregivers:    mysqlCaregiverLinkRepository{db},
+		Consents:      mysqlConsentRepository{db},
+		Audit:         audit.New(auditServiceName, audit.MySQLRepository{DB: db}),
+		db:            db,
+	}
+}
+
+// Scans a single row or each of several
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Whether an UPDATE or DELETE touched any row
+func affected(result sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+type mysqlUserRepository struct {
+	db *sql.DB
+}
+
+// Lockouts are measured with MySQL's clock, which LockedUntil is set by
+const userColumns = `SELECT UserID, Name, Email, DateOfBirth, COALESCE(PhoneNumber, ''), COALESCE(Address, ''), Role, Status,
+              PasswordHash, FailedLoginCount, GREATEST(COALESCE(TIMESTAMPDIFF(SECOND, NOW(), LockedUntil), 0), 0) FROM Users`
+
+// Scan a user row, decrypting the personal data
+func scanUser(row scanner) (UserAccount, error) {
+	var u UserAccount
+	var storedEmail, storedPhone, storedAddress string
+	var storedDateOfBirth sql.NullString
+	var lockedFor int64
+	err := row.Scan(&u.UserID, &u.Name, &storedEmail, &storedDateOfBirth, &storedPhone, &storedAddress, &u.Role, &u.Status,
+		&u.PasswordHash, &u.FailedLoginCount, &lockedFor)
+	if err != nil {
+		return u, err
+	}
+	u.LockedFor = time.Duration(lockedFor) * time.Second
+
+	u.Email, err = fieldcrypt.Decrypt("Users.Email", storedEmail)
+	if err == nil {
+		u.DateOfBirth, err = decryptDateOfBirth(storedDateOfBirth)
+	}
+	if err == nil {
+		u.PhoneNumber, err = fieldcrypt.Decrypt("Users.PhoneNumber", storedPhone)
+	}
+	if err == nil {
+		u.Address, err = fieldcrypt.Decrypt("Users.Address", storedAddress)
+	}
+	return u, err
+}
+
+func (m mysqlUserRepository) Create(u User, passwordHash []byte) (int, error) {
+	stored, err := encryptUser(u)
+	if err != nil {
+		return 0, err
+	}
+	query := "INSERT INTO Users(Name, Email, EmailIndex, PasswordHash, DateOfBirth, PhoneNumber, Address, Role) VALUES(?, ?, ?, ?, ?, ?, ?, ?)"
+	result, err := m.db.Exec(query, u.Name, stored.Email, emailIndex(u.Email), passwordHash, stored.DateOfBirth, stored.PhoneNumber, stored.Address, u.Role)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (m mysqlUserRepository) Get(userID int) (UserAccount, error) {
+	return m.queryOne(userColumns+" WHERE UserID = ?", userID)
+}
+
+// Emails are encrypted, so lookups go through the blind index
+func (m mysqlUserRepository) GetByEmail(email string) (UserAccount, error) {
+	return m.queryOne(userColumns+" WHERE EmailIndex = ?", emailIndex(email))
+}
+
+func (m mysqlUserRepository) queryOne(query string, args ...interface{}) (UserAccount, error) {
+	u, err := scanUser(m.db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return UserAccount{}, errUserNotFound
+	}
+	return u, err
+}
+
+func (m mysqlUserRepository) Update(u User, reverify bool) (bool, error) {
+	stored, err := encryptUser(u)
+	if err != nil {
+		return false, err
+	}
+	query := "UPDATE Users SET Name=?, Email=?, EmailIndex=?, DateOfBirth=?, PhoneNumber=?, Address=? WHERE UserID=?"
+	if reverify {
+		query = "UPDATE Users SET Name=?, Email=?, EmailIndex=?, DateOfBirth=?, PhoneNumber=?, Address=?, Status='Pending' WHERE UserID=?"
+	}
+	return affected(m.db.Exec(query, u.Name, stored.Email, emailIndex(u.Email), stored.DateOfBirth, stored.PhoneNumber, stored.Address, u.UserID))
+}
+
+func (m mysqlUserRepository) SetPassword(userID int, passwordHash []byte) error {
+	_, err := m.db.Exec("UPDATE Users SET PasswordHash = ? WHERE UserID = ?", passwordHash, userID)
+	return err
+}
+
+// LAST_INSERT_ID(expr) hands back the incremented count from the same statement, so
+// concurrent failures each see their own count
+func (m mysqlUserRepository) IncrementFailedLogins(userID int) (int, error) {
+	result, err := m.db.Exec("UPDATE Users SET FailedLoginCount = LAST_INSERT_ID(FailedLoginCount + 1) WHERE UserID = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return 0, err
+	}
+	failedLogins, err := result.LastInsertId()
+	return int(failedLogins), err
+}
+
+func (m mysqlUserRepository) LockFor(userID int, lockout time.Duration) error {
+	_, err := m.db.Exec("UPDATE Users SET LockedUntil = GREATEST(COALESCE(LockedUntil, NOW()), NOW() + INTERVAL ? SECOND) WHERE UserID = ?", int(lockout.Seconds()), userID)
+	return err
+}
+
+func (m mysqlUserRepository) ResetFailedLogins(userID int) error {
+	_, err := m.db.Exec("UPDATE Users SET FailedLoginCount = 0, LockedUntil = NULL WHERE UserID = ?", userID)
+	return err
+}
+
+// Collect rows of a query as a list of column name to value maps
+func (m mysqlUserRepository) queryRows(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			// Text columns are returned as bytes
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func (m mysqlUserRepository) PersonalData(userID int) (map[string]interface{}, error) {
+	profile, err := m.queryRows(`SELECT UserID, Name, Email, DateOfBirth, PhoneNumber, Address, Role, Status, CreatedAt, UpdatedAt
+                                 FROM Users WHERE UserID = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(profile) == 0 {
+		return nil, errUserNotFound
+	}
+	// Personal data is stored encrypted
+	for _, column := range []string{"Email", "DateOfBirth", "PhoneNumber", "Address"} {
+		if stored, ok := profile[0][column].(string); ok {
+			if profile[0][column], err = fieldcrypt.Decrypt("Users."+column, stored); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	caregivers, err := m.queryRows(`SELECT l.LinkID, s.Name AS Senior, c.Name AS Caregiver, l.Relationship, l.Status, l.RequestedAt, l.ApprovedAt, l.RevokedAt
+                                    FROM CaregiverLinks l
+                                    JOIN Users s ON s.UserID = l.SeniorID
+                                    JOIN Users c ON c.UserID = l.CaregiverID
+                                    WHERE l.SeniorID = ? OR l.CaregiverID = ?`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := m.queryRows("SELECT UserAgent, CreatedAt, ExpiresAt, RevokedAt FROM RefreshTokens WHERE UserID = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	verifications, err := m.queryRows("SELECT CreatedAt, ExpiresAt, UsedAt FROM EmailVerifications WHERE UserID = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	resets, err := m.queryRows("SELECT CreatedAt, ExpiresAt, UsedAt FROM PasswordResets WHERE UserID = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	consents, err := m.queryRows("SELECT Purpose, Version, Granted, UserAgent, RecordedAt FROM UserConsents WHERE UserID = ? ORDER BY ConsentID", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"profile":             profile[0],
+		"caregiver_links":     caregivers,
+		"sessions":            sessions,
+		"email_verifications": verifications,
+		"password_resets":     resets,
+		"consents":            consents,
+	}, nil
+}
+
+func (m mysqlUserRepository) Erase(userID int, claims *auth.Claims, report []erasureResult) (erasureResult, error) {
+	result := erasureResult{Service: "user", Status: "erased", Deleted: map[string]int64{}}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		Table string
+		Query string
+	}{
+		{"CaregiverLinks", "DELETE FROM CaregiverLinks WHERE SeniorID = ? OR CaregiverID = ?"},
+		{"RefreshTokens", "DELETE FROM RefreshTokens WHERE UserID = ?"},
+		{"PasswordResets", "DELETE FROM PasswordResets WHERE UserID = ?"},
+		{"EmailVerifications", "DELETE FROM EmailVerifications WHERE UserID = ?"},
+		{"UserConsents", "DELETE FROM UserConsents WHERE UserID = ?"},
+		{"Users", "DELETE FROM Users WHERE UserID = ?"},
+	}
+	for _, statement := range statements {
+		args := []interface{}{userID}
+		if statement.Table == "CaregiverLinks" {
+			args = append(args, userID)
+		}
+		res, err := tx.Exec(statement.Query, args...)
+		if err != nil {
+			return result, err
+		}
+		result.Deleted[statement.Table], _ = res.RowsAffected()
+	}
+
+	reportJSON, err := json.Marshal(append(report, result))
+	if err != nil {
+		return result, err
+	}
+	query := "INSERT INTO DataErasures (UserID, RequestedBy, RequestedByRole, Report) VALUES (?, ?, ?, ?)"
+	if _, err := tx.Exec(query, userID, claims.Subject, claims.Role, reportJSON); err != nil {
+		return result, err
+	}
+
+	return result, tx.Commit()
+}
+
+type mysqlSessionRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlSessionRepository) Create(userID int, tokenHash, userAgent string, expiresAt time.Time) error {
+	query := "INSERT INTO RefreshTokens (UserID, TokenHash, UserAgent, ExpiresAt) VALUES (?, ?, ?, ?)"
+	_, err := m.db.Exec(query, userID, tokenHash, userAgent, expiresAt)
+	return err
+}
+
+const sessionColumns = "SELECT TokenID, UserID, UserAgent, CreatedAt, ExpiresAt, RevokedAt IS NOT NULL FROM RefreshTokens"
+
+func scanSession(row scanner) (account.Session, error) {
+	var s account.Session
+	err := row.Scan(&s.SessionID, &s.AccountID, &s.UserAgent, &s.CreatedAt, &s.ExpiresAt, &s.Revoked)
+	return s, err
+}
+
+func (m mysqlSessionRepository) GetByToken(tokenHash string) (account.Session, error) {
+	s, err := scanSession(m.db.QueryRow(sessionColumns+" WHERE TokenHash = ?", tokenHash))
+	if err == sql.ErrNoRows {
+		return account.Session{}, errTokenNotFound
+	}
+	return s, err
+}
+
+func (m mysqlSessionRepository) Revoke(sessionID, userID int) (bool, error) {
+	return affected(m.db.Exec("UPDATE RefreshTokens SET RevokedAt = NOW() WHERE TokenID = ? AND UserID = ? AND RevokedAt IS NULL", sessionID, userID))
+}
+
+func (m mysqlSessionRepository) RevokeByToken(tokenHash string) error {
+	_, err := m.db.Exec("UPDATE RefreshTokens SET RevokedAt = NOW() WHERE TokenHash = ? AND RevokedAt IS NULL", tokenHash)
+	return err
+}
+
+func (m mysqlSessionRepository) RevokeAll(userID int) error {
+	_, err := m.db.Exec("UPDATE RefreshTokens SET RevokedAt = NOW() WHERE UserID = ? AND RevokedAt IS NULL", userID)
+	return err
+}
+
+func (m mysqlSessionRepository) ListActive(userID int) ([]account.Session, error) {
+	rows, err := m.db.Query(sessionColumns+` WHERE UserID = ? AND RevokedAt IS NULL AND ExpiresAt > ? ORDER BY CreatedAt DESC, TokenID DESC`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []account.Session{}
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+type mysqlLoginFailureRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlLoginFailureRepository) Record(ip string) error {
+	_, err := m.db.Exec("INSERT INTO LoginFailures (IPAddress) VALUES (?)", ip)
+	return err
+}
+
+func (m mysqlLoginFailureRepository) Recent(ip string, window time.Duration) (int, time.Duration, error) {
+	var failures int
+	var secondsSinceOldest sql.NullInt64
+	query := `SELECT COUNT(*), TIMESTAMPDIFF(SECOND, MIN(AttemptedAt), NOW())
+              FROM LoginFailures WHERE IPAddress = ? AND AttemptedAt >= NOW() - INTERVAL ? SECOND`
+	err := m.db.QueryRow(query, ip, int(window.Seconds())).Scan(&failures, &secondsSinceOldest)
+	return failures, time.Duration(secondsSinceOldest.Int64) * time.Second, err
+}
+
+// Look up a password reset or verification link in its table
+func getOneTimeToken(db *sql.DB, query, tokenHash string) (OneTimeToken, error) {
+	var t OneTimeToken
+	err := db.QueryRow(query, tokenHash).Scan(&t.ID, &t.UserID, &t.ExpiresAt, &t.Used)
+	if err == sql.ErrNoRows {
+		return OneTimeToken{}, errTokenNotFound
+	}
+	return t, err
+}
+
+type mysqlPasswordResetRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlPasswordResetRepository) Create(userID int, tokenHash string, expiresAt time.Time) error {
+	if _, err := m.db.Exec("UPDATE PasswordResets SET UsedAt = NOW() WHERE UserID = ? AND UsedAt IS NULL", userID); err != nil {
+		return err
+	}
+	_, err := m.db.Exec("INSERT INTO PasswordResets (UserID, TokenHash, ExpiresAt) VALUES (?, ?, ?)", userID, tokenHash, expiresAt)
+	return err
+}
+
+func (m mysqlPasswordResetRepository) GetByToken(tokenHash string) (OneTimeToken, error) {
+	return getOneTimeToken(m.db, "SELECT ResetID, UserID, ExpiresAt, UsedAt IS NOT NULL FROM PasswordResets WHERE TokenHash = ?", tokenHash)
+}
+
+func (m mysqlPasswordResetRepository) Use(resetID, userID int, passwordHash []byte) (bool, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Guard against the link being used twice at once
+	used, err := affected(tx.Exec("UPDATE PasswordResets SET UsedAt = NOW() WHERE ResetID = ? AND UsedAt IS NULL", resetID))
+	if err != nil || !used {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE Users SET PasswordHash = ? WHERE UserID = ?", passwordHash, userID); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE RefreshTokens SET RevokedAt = NOW() WHERE UserID = ? AND RevokedAt IS NULL", userID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Computed in SQL to avoid clock and time zone skew
+func (m mysqlPasswordResetRepository) Recent(userID int, window time.Duration) (int, time.Duration, error) {
+	var sent int
+	var secondsSinceLast sql.NullInt64
+	query := `SELECT COUNT(*), TIMESTAMPDIFF(SECOND, MAX(CreatedAt), NOW())
+              FROM PasswordResets WHERE UserID = ? AND CreatedAt >= NOW() - INTERVAL ? SECOND`
+	err := m.db.QueryRow(query, userID, int(window.Seconds())).Scan(&sent, &secondsSinceLast)
+	return sent, time.Duration(secondsSinceLast.Int64) * time.Second, err
+}
+
+type mysqlVerificationRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlVerificationRepository) Create(userID int, tokenHash string, expiresAt time.Time) error {
+	if _, err := m.db.Exec("UPDATE EmailVerifications SET UsedAt = NOW() WHERE UserID = ? AND UsedAt IS NULL", userID); err != nil {
+		return err
+	}
+	_, err := m.db.Exec("INSERT INTO EmailVerifications (UserID, TokenHash, ExpiresAt) VALUES (?, ?, ?)", userID, tokenHash, expiresAt)
+	return err
+}
+
+func (m mysqlVerificationRepository) GetByToken(tokenHash string) (OneTimeToken, error) {
+	return getOneTimeToken(m.db, "SELECT VerificationID, UserID, ExpiresAt, UsedAt IS NOT NULL FROM EmailVerifications WHERE TokenHash = ?", tokenHash)
+}
+
+func (m mysqlVerificationRepository) Use(verificationID, userID int) (bool, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Guard against the link being used twice at once
+	used, err := affected(tx.Exec("UPDATE EmailVerifications SET UsedAt = NOW() WHERE VerificationID = ? AND UsedAt IS NULL", verificationID))
+	if err != nil || !used {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE Users SET Status = ? WHERE UserID = ?", statusVerified, userID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Computed in SQL to avoid clock and time zone skew
+func (m mysqlVerificationRepository) Recent(userID int, window time.Duration) (int, time.Duration, error) {
+	var sent int
+	var secondsSinceLast sql.NullInt64
+	query := `SELECT COUNT(*), TIMESTAMPDIFF(SECOND, MAX(CreatedAt), NOW())
+              FROM EmailVerifications WHERE UserID = ? AND CreatedAt >= NOW() - INTERVAL ? SECOND`
+	err := m.db.QueryRow(query, userID, int(window.Seconds())).Scan(&sent, &secondsSinceLast)
+	return sent, time.Duration(secondsSinceLast.Int64) * time.Second, err
+}
+
+type mysqlCaregiverLinkRepository struct {
+	db *sql.DB
+}
+
+const linkColumns = `SELECT l.LinkID, l.SeniorID, s.Name, l.CaregiverID, c.Name, c.Email, l.Relationship, l.Status, l.RequestedAt
+              FROM CaregiverLinks l JOIN Users s ON s.UserID = l.SeniorID JOIN Users c ON c.UserID = l.CaregiverID`
+
+func scanLink(row scanner) (CaregiverLink, error) {
+	var l CaregiverLink
+	var storedEmail string
+	err := row.Scan(&l.LinkID, &l.SeniorID, &l.SeniorName, &l.CaregiverID, &l.CaregiverName, &storedEmail, &l.Relationship, &l.Status, &l.RequestedAt)
+	if err != nil {
+		return l, err
+	}
+	l.CaregiverEmail, err = fieldcrypt.Decrypt("Users.Email", storedEmail)
+	return l, err
+}
+
+func (m mysqlCaregiverLinkRepository) Request(seniorID, caregiverID int, relationship string) (int, error) {
+	query := `INSERT INTO CaregiverLinks (SeniorID, CaregiverID, Relationship, Status)
+              SELECT ?, ?, ?, ? FROM DUAL WHERE NOT EXISTS (
+                  SELECT 1 FROM CaregiverLinks WHERE SeniorID = ? AND CaregiverID = ? AND Status <> ?)`
+	result, err := m.db.Exec(query, seniorID, caregiverID, relationship, linkPending, seniorID, caregiverID, linkRevoked)
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return 0, nil
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (m mysqlCaregiverLinkRepository) Get(linkID int) (CaregiverLink, error) {
+	l, err := scanLink(m.db.QueryRow(linkColumns+" WHERE l.LinkID = ?", linkID))
+	if err == sql.ErrNoRows {
+		return CaregiverLink{}, errLinkNotFound
+	}
+	return l, err
+}
+
+func (m mysqlCaregiverLinkRepository) ListBySenior(seniorID int) ([]CaregiverLink, error) {
+	return m.list(linkColumns+" WHERE l.SeniorID = ? AND l.Status <> ? ORDER BY l.RequestedAt DESC, l.LinkID DESC", seniorID, linkRevoked)
+}
+
+func (m mysqlCaregiverLinkRepository) ListByCaregiver(caregiverID int) ([]CaregiverLink, error) {
+	return m.list(linkColumns+" WHERE l.CaregiverID = ? AND l.Status <> ? ORDER BY l.RequestedAt DESC, l.LinkID DESC", caregiverID, linkRevoked)
+}
+
+func (m mysqlCaregiverLinkRepository) list(query string, args ...interface{}) ([]CaregiverLink, error) {
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []CaregiverLink{}
+	for rows.Next() {
+		l, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+func (m mysqlCaregiverLinkRepository) ActiveSeniorIDs(caregiverID int) ([]int, error) {
+	rows, err := m.db.Query("SELECT SeniorID FROM CaregiverLinks WHERE CaregiverID = ? AND Status = ? ORDER BY SeniorID", caregiverID, linkActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seniors := []int{}
+	for rows.Next() {
+		var seniorID int
+		if err := rows.Scan(&seniorID); err != nil {
+			return nil, err
+		}
+		seniors = append(seniors, seniorID)
+	}
+	return seniors, rows.Err()
+}
+
+func (m mysqlCaregiverLinkRepository) Approve(linkID, seniorID int) (bool, error) {
+	query := "UPDATE CaregiverLinks SET Status = ?, ApprovedAt = NOW() WHERE LinkID = ? AND SeniorID = ? AND Status = ?"
+	return affected(m.db.Exec(query, linkActive, linkID, seniorID, linkPending))
+}
+
+func (m mysqlCaregiverLinkRepository) Revoke(linkID int) error {
+	_, err := m.db.Exec("UPDATE CaregiverLinks SET Status = ?, RevokedAt = NOW() WHERE LinkID = ?", linkRevoked, linkID)
+	return err
+}
+
+type mysqlConsentRepository struct {
+	db *sql.DB
+}
+
+func (m mysqlConsentRepository) CurrentTexts() (map[string]consentText, error) {
+	query := `SELECT t.Purpose, t.Version, t.Title, t.Body FROM ConsentTexts t
+              WHERE t.Version = (SELECT MAX(Version) FROM ConsentTexts WHERE Purpose = t.Purpose)`
+	rows, err := m.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	texts := map[string]consentText{}
+	for rows.Next() {
+		var text consentText
+		if err := rows.Scan(&text.Purpose, &text.Version, &text.Title, &text.Text); err != nil {
+			return nil, err
+		}
+		texts[text.Purpose] = text
+	}
+	return texts, rows.Err()
+}
+
+func (m mysqlConsentRepository) Decisions(userID int) (map[string]ConsentDecision, error) {
+	query := `SELECT c.Purpose, c.Version, c.Granted, c.RecordedAt FROM UserConsents c
+              WHERE c.UserID = ? AND c.ConsentID = (
+                  SELECT MAX(ConsentID) FROM UserConsents WHERE UserID = c.UserID AND Purpose = c.Purpose)`
+	rows, err := m.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	decisions := map[string]ConsentDecision{}
+	for rows.Next() {
+		var purpose string
+		var d ConsentDecision
+		if err := rows.Scan(&purpose, &d.Version, &d.Granted, &d.RecordedAt); err != nil {
+			return nil, err
+		}
+		decisions[purpose] = d
+	}
+	return decisions, rows.Err()
+}
+
+func (m mysqlConsentRepository) Record(userID int, purpose string, version int, granted bool, userAgent string) error {
+	query := "INSERT INTO UserConsents (UserID, Purpose, Version, Granted, UserAgent) VALUES (?, ?, ?, ?, ?)"
+	_, err := m.db.Exec(query, userID, purpose, version, granted, userAgent)
+	return err
+}
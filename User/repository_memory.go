@@ -0,0 +1,754 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"common/account"
+	"common/audit"
+	"common/auth"
+)
+
+// Consent wording published by migrations/0001_initial_schema.up.sql
+var memoryConsentTexts = []consentText{
+	{Purpose: consentShareDoctor, Version: 1, Title: "Share my results with my doctors",
+		Text: "When an assessment shows a moderate or high risk, or a vision test scores low, your results are emailed to the doctors assigned to you (or to the clinic if you have none) and shown on their alert dashboard so they can follow up."},
+	{Purpose: consentShareCaregiver, Version: 1, Title: "Share my results with my caregivers",
+		Text: "When an assessment shows a moderate or high risk, the caregivers you have approved are emailed a notification so they can check on you."},
+	{Purpose: consentResearch, Version: 1, Title: "Use my results for research",
+		Text: "Your assessment and vision results may be used, with your name and contact details removed, to improve fall prevention for seniors."},
+}
+
+// Every table kept in memory, behind one lock so changes spanning tables are all or nothing.
+// Nothing is encrypted, as it never leaves the process.
+type memoryData struct {
+	mu            sync.Mutex
+	users         []memoryUser
+	sessions      []memorySession
+	loginFailures []memoryLoginFailure
+	resets        []memoryToken
+	verifications []memoryToken
+	links         []memoryLink
+	consents      []memoryConsent
+	erasures      []memoryErasure
+	lastID        map[string]int // Last ID handed out per table
+}
+
+type memoryUser struct {
+	UserAccount
+	lockedUntil time.Time
+	createdAt   time.Time
+	updatedAt   time.Time
+}
+
+type memorySession struct {
+	account.Session
+	tokenHash string
+	revokedAt time.Time
+}
+
+type memoryLoginFailure struct {
+	ip          string
+	attemptedAt time.Time
+}
+
+type memoryToken struct {
+	OneTimeToken
+	tokenHash string
+	createdAt time.Time
+	usedAt    time.Time
+}
+
+type memoryLink struct {
+	linkID       int
+	seniorID     int
+	caregiverID  int
+	relationship string
+	status       string
+	requestedAt  time.Time
+	approvedAt   time.Time
+	revokedAt    time.Time
+}
+
+type memoryConsent struct {
+	userID     int
+	purpose    string
+	version    int
+	granted    bool
+	userAgent  string
+	recordedAt time.Time
+}
+
+type memoryErasure struct {
+	userID          int
+	requestedBy     int
+	requestedByRole string
+	report          []byte
+	completedAt     time.Time
+}
+
+func newMemoryStore() *Store {
+	data := &memoryData{lastID: map[string]int{}}
+	return &Store{
+		Users:         memoryUserRepository{data},
+		Sessions:      memorySessionRepository{data},
+		LoginFailures: memoryLoginFailureRepository{data},
+		Resets:        memoryPasswordResetRepository{data},
+		Verifications: memoryVerificationRepository{data},
+		Caregivers:    memoryCaregiverLinkRepository{data},
+		Consents:      memoryConsentRepository{data},
+		Audit:         audit.New(auditServiceName, &audit.MemoryRepository{}),
+	}
+}
+
+// Auto-increment ID for a new row. Called with the lock held.
+func (d *memoryData) nextID(table string) int {
+	d.lastID[table]++
+	return d.lastID[table]
+}
+
+// The user's row, or nil. Called with the lock held.
+func (d *memoryData) user(userID int) *memoryUser {
+	for i := range d.users {
+		if d.users[i].UserID == userID {
+			return &d.users[i]
+		}
+	}
+	return nil
+}
+
+// The row as MySQL would return it, with the time left on its lockout
+func (m memoryUser) account() UserAccount {
+	account := m.UserAccount
+	if lockedFor := time.Until(m.lockedUntil).Truncate(time.Second); lockedFor > 0 {
+		account.LockedFor = lockedFor
+	}
+	return account
+}
+
+// A nullable timestamp as MySQL returns it, nil when unset
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// Caregivers have no date of birth
+func dateOfBirth(u User) *time.Time {
+	if u.DateOfBirth.IsZero() {
+		return nil
+	}
+	dob := u.DateOfBirth
+	return &dob
+}
+
+type memoryUserRepository struct {
+	data *memoryData
+}
+
+func (m memoryUserRepository) Create(u User, passwordHash []byte) (int, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	account := UserAccount{
+		UserID: m.data.nextID("users"), Name: u.Name, Email: u.Email, DateOfBirth: dateOfBirth(u),
+		PhoneNumber: u.PhoneNumber, Address: u.Address, Role: u.Role, Status: statusPending, PasswordHash: string(passwordHash),
+	}
+	now := time.Now()
+	m.data.users = append(m.data.users, memoryUser{UserAccount: account, createdAt: now, updatedAt: now})
+	return account.UserID, nil
+}
+
+func (m memoryUserRepository) Get(userID int) (UserAccount, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	if u := m.data.user(userID); u != nil {
+		return u.account(), nil
+	}
+	return UserAccount{}, errUserNotFound
+}
+
+// Matches the same addresses as the blind index
+func (m memoryUserRepository) GetByEmail(email string) (UserAccount, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for _, u := range m.data.users {
+		if strings.EqualFold(strings.TrimSpace(u.Email), strings.TrimSpace(email)) {
+			return u.account(), nil
+		}
+	}
+	return UserAccount{}, errUserNotFound
+}
+
+func (m memoryUserRepository) Update(u User, reverify bool) (bool, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	stored := m.data.user(u.UserID)
+	if stored == nil {
+		return false, nil
+	}
+	before := *stored
+	stored.Name, stored.Email, stored.DateOfBirth = u.Name, u.Email, dateOfBirth(u)
+	stored.PhoneNumber, stored.Address = u.PhoneNumber, u.Address
+	if reverify {
+		stored.Status = statusPending
+	}
+
+	// Like MySQL, only rows that really changed count
+	sameDateOfBirth := (before.DateOfBirth == nil) == (stored.DateOfBirth == nil) &&
+		(before.DateOfBirth == nil || before.DateOfBirth.Equal(*stored.DateOfBirth))
+	if sameDateOfBirth && before.Name == stored.Name && before.Email == stored.Email && before.PhoneNumber == stored.PhoneNumber &&
+		before.Address == stored.Address && before.Status == stored.Status {
+		return false, nil
+	}
+	stored.updatedAt = time.Now()
+	return true, nil
+}
+
+// Change the user's row under the lock, doing nothing if there is none
+func (m memoryUserRepository) update(userID int, change func(u *memoryUser)) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	if u := m.data.user(userID); u != nil {
+		change(u)
+	}
+	return nil
+}
+
+func (m memoryUserRepository) SetPassword(userID int, passwordHash []byte) error {
+	return m.update(userID, func(u *memoryUser) { u.PasswordHash = string(passwordHash) })
+}
+
+func (m memoryUserRepository) IncrementFailedLogins(userID int) (int, error) {
+	failedLogins := 0
+	err := m.update(userID, func(u *memoryUser) {
+		u.FailedLoginCount++
+		failedLogins = u.FailedLoginCount
+	})
+	return failedLogins, err
+}
+
+func (m memoryUserRepository) LockFor(userID int, lockout time.Duration) error {
+	return m.update(userID, func(u *memoryUser) {
+		if until := time.Now().Add(lockout); until.After(u.lockedUntil) {
+			u.lockedUntil = until
+		}
+	})
+}
+
+func (m memoryUserRepository) ResetFailedLogins(userID int) error {
+	return m.update(userID, func(u *memoryUser) {
+		u.FailedLoginCount = 0
+		u.lockedUntil = time.Time{}
+	})
+}
+
+// Laid out like the MySQL export, one map of column name to value per row
+func (m memoryUserRepository) PersonalData(userID int) (map[string]interface{}, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	u := m.data.user(userID)
+	if u == nil {
+		return nil, errUserNotFound
+	}
+	var dob interface{}
+	if u.DateOfBirth != nil {
+		dob = u.DateOfBirth.Format("2006-01-02")
+	}
+	profile := map[string]interface{}{
+		"UserID": u.UserID, "Name": u.Name, "Email": u.Email, "DateOfBirth": dob, "PhoneNumber": u.PhoneNumber,
+		"Address": u.Address, "Role": u.Role, "Status": u.Status, "CreatedAt": u.createdAt, "UpdatedAt": u.updatedAt,
+	}
+
+	caregivers := []map[string]interface{}{}
+	for _, l := range m.data.links {
+		if l.seniorID == userID || l.caregiverID == userID {
+			caregivers = append(caregivers, map[string]interface{}{
+				"LinkID": l.linkID, "Senior": m.data.user(l.seniorID).Name, "Caregiver": m.data.user(l.caregiverID).Name,
+				"Relationship": l.relationship, "Status": l.status, "RequestedAt": l.requestedAt,
+				"ApprovedAt": nullTime(l.approvedAt), "RevokedAt": nullTime(l.revokedAt),
+			})
+		}
+	}
+
+	sessions := []map[string]interface{}{}
+	for _, s := range m.data.sessions {
+		if s.AccountID == userID {
+			sessions = append(sessions, map[string]interface{}{
+				"UserAgent": s.UserAgent, "CreatedAt": s.CreatedAt, "ExpiresAt": s.ExpiresAt, "RevokedAt": nullTime(s.revokedAt),
+			})
+		}
+	}
+
+	tokens := func(all []memoryToken) []map[string]interface{} {
+		rows := []map[string]interface{}{}
+		for _, t := range all {
+			if t.UserID == userID {
+				rows = append(rows, map[string]interface{}{"CreatedAt": t.createdAt, "ExpiresAt": t.ExpiresAt, "UsedAt": nullTime(t.usedAt)})
+			}
+		}
+		return rows
+	}
+
+	consents := []map[string]interface{}{}
+	for _, c := range m.data.consents {
+		if c.userID == userID {
+			consents = append(consents, map[string]interface{}{
+				"Purpose": c.purpose, "Version": c.version, "Granted": c.granted, "UserAgent": c.userAgent, "RecordedAt": c.recordedAt,
+			})
+		}
+	}
+
+	return map[string]interface{}{
+		"profile":             profile,
+		"caregiver_links":     caregivers,
+		"sessions":            sessions,
+		"email_verifications": tokens(m.data.verifications),
+		"password_resets":     tokens(m.data.resets),
+		"consents":            consents,
+	}, nil
+}
+
+func (m memoryUserRepository) Erase(userID int, claims *auth.Claims, report []erasureResult) (erasureResult, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	result := erasureResult{Service: "user", Status: "erased", Deleted: map[string]int64{}}
+	deleted := func(table string, before, after int) {
+		result.Deleted[table] = int64(before - after)
+	}
+
+	n := len(m.data.links)
+	m.data.links = slices.DeleteFunc(m.data.links, func(l memoryLink) bool { return l.seniorID == userID || l.caregiverID == userID })
+	deleted("CaregiverLinks", n, len(m.data.links))
+
+	n = len(m.data.sessions)
+	m.data.sessions = slices.DeleteFunc(m.data.sessions, func(s memorySession) bool { return s.AccountID == userID })
+	deleted("RefreshTokens", n, len(m.data.sessions))
+
+	ofUser := func(t memoryToken) bool { return t.UserID == userID }
+	n = len(m.data.resets)
+	m.data.resets = slices.DeleteFunc(m.data.resets, ofUser)
+	deleted("PasswordResets", n, len(m.data.resets))
+
+	n = len(m.data.verifications)
+	m.data.verifications = slices.DeleteFunc(m.data.verifications, ofUser)
+	deleted("EmailVerifications", n, len(m.data.verifications))
+
+	n = len(m.data.consents)
+	m.data.consents = slices.DeleteFunc(m.data.consents, func(c memoryConsent) bool { return c.userID == userID })
+	deleted("UserConsents", n, len(m.data.consents))
+
+	n = len(m.data.users)
+	m.data.users = slices.DeleteFunc(m.data.users, func(u memoryUser) bool { return u.UserID == userID })
+	deleted("Users", n, len(m.data.users))
+
+	reportJSON, err := json.Marshal(append(report, result))
+	if err != nil {
+		return result, err
+	}
+	m.data.erasures = append(m.data.erasures, memoryErasure{
+		userID: userID, requestedBy: claims.Subject, requestedByRole: claims.Role, report: reportJSON, completedAt: time.Now(),
+	})
+	return result, nil
+}
+
+type memorySessionRepository struct {
+	data *memoryData
+}
+
+func (m memorySessionRepository) Create(userID int, tokenHash, userAgent string, expiresAt time.Time) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	s := account.Session{SessionID: m.data.nextID("sessions"), AccountID: userID, UserAgent: userAgent, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	m.data.sessions = append(m.data.sessions, memorySession{Session: s, tokenHash: tokenHash})
+	return nil
+}
+
+func (m memorySessionRepository) GetByToken(tokenHash string) (account.Session, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for _, s := range m.data.sessions {
+		if s.tokenHash == tokenHash {
+			return s.Session, nil
+		}
+	}
+	return account.Session{}, errTokenNotFound
+}
+
+// Revoke the active sessions matching, returning how many there were. Called with the lock held.
+func (d *memoryData) revokeSessions(match func(s memorySession) bool) int {
+	revoked := 0
+	for i, s := range d.sessions {
+		if !s.Revoked && match(s) {
+			d.sessions[i].Revoked = true
+			d.sessions[i].revokedAt = time.Now()
+			revoked++
+		}
+	}
+	return revoked
+}
+
+func (m memorySessionRepository) revoke(match func(s memorySession) bool) int {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	return m.data.revokeSessions(match)
+}
+
+func (m memorySessionRepository) Revoke(sessionID, userID int) (bool, error) {
+	return m.revoke(func(s memorySession) bool { return s.SessionID == sessionID && s.AccountID == userID }) > 0, nil
+}
+
+func (m memorySessionRepository) RevokeByToken(tokenHash string) error {
+	m.revoke(func(s memorySession) bool { return s.tokenHash == tokenHash })
+	return nil
+}
+
+func (m memorySessionRepository) RevokeAll(userID int) error {
+	m.revoke(func(s memorySession) bool { return s.AccountID == userID })
+	return nil
+}
+
+func (m memorySessionRepository) ListActive(userID int) ([]account.Session, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	sessions := []account.Session{}
+	for i := len(m.data.sessions) - 1; i >= 0; i-- {
+		s := m.data.sessions[i].Session
+		if s.AccountID == userID && !s.Revoked && time.Now().Before(s.ExpiresAt) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+type memoryLoginFailureRepository struct {
+	data *memoryData
+}
+
+func (m memoryLoginFailureRepository) Record(ip string) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	m.data.loginFailures = append(m.data.loginFailures, memoryLoginFailure{ip: ip, attemptedAt: time.Now()})
+	return nil
+}
+
+func (m memoryLoginFailureRepository) Recent(ip string, window time.Duration) (int, time.Duration, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	// Failures from before the window are no longer needed
+	since := time.Now().Add(-window)
+	m.data.loginFailures = slices.DeleteFunc(m.data.loginFailures, func(f memoryLoginFailure) bool {
+		return f.attemptedAt.Before(since)
+	})
+
+	failures := 0
+	var oldest time.Time
+	for _, f := range m.data.loginFailures {
+		if f.ip == ip {
+			if failures == 0 {
+				oldest = f.attemptedAt
+			}
+			failures++
+		}
+	}
+	if failures == 0 {
+		return 0, 0, nil
+	}
+	return failures, time.Since(oldest).Truncate(time.Second), nil
+}
+
+// Use up the user's outstanding tokens and add a new one. Called with the lock held.
+func (d *memoryData) replaceToken(tokens []memoryToken, table string, userID int, tokenHash string, expiresAt time.Time) []memoryToken {
+	now := time.Now()
+	for i, t := range tokens {
+		if t.UserID == userID && !t.Used {
+			tokens[i].Used = true
+			tokens[i].usedAt = now
+		}
+	}
+	t := OneTimeToken{ID: d.nextID(table), UserID: userID, ExpiresAt: expiresAt}
+	return append(tokens, memoryToken{OneTimeToken: t, tokenHash: tokenHash, createdAt: now})
+}
+
+// Find a token by its hash. Called with the lock held.
+func findToken(tokens []memoryToken, tokenHash string) (OneTimeToken, error) {
+	for _, t := range tokens {
+		if t.tokenHash == tokenHash {
+			return t.OneTimeToken, nil
+		}
+	}
+	return OneTimeToken{}, errTokenNotFound
+}
+
+// Use up a token by its ID, reporting false if it already was. Called with the lock held.
+func useToken(tokens []memoryToken, id int) bool {
+	for i, t := range tokens {
+		if t.ID == id && !t.Used {
+			tokens[i].Used = true
+			tokens[i].usedAt = time.Now()
+			return true
+		}
+	}
+	return false
+}
+
+type memoryPasswordResetRepository struct {
+	data *memoryData
+}
+
+func (m memoryPasswordResetRepository) Create(userID int, tokenHash string, expiresAt time.Time) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	m.data.resets = m.data.replaceToken(m.data.resets, "resets", userID, tokenHash, expiresAt)
+	return nil
+}
+
+func (m memoryPasswordResetRepository) GetByToken(tokenHash string) (OneTimeToken, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	return findToken(m.data.resets, tokenHash)
+}
+
+func (m memoryPasswordResetRepository) Use(resetID, userID int, passwordHash []byte) (bool, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	if !useToken(m.data.resets, resetID) {
+		return false, nil
+	}
+	if u := m.data.user(userID); u != nil {
+		u.PasswordHash = string(passwordHash)
+	}
+	m.data.revokeSessions(func(s memorySession) bool { return s.AccountID == userID })
+	return true, nil
+}
+
+func (m memoryPasswordResetRepository) Recent(userID int, window time.Duration) (int, time.Duration, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	return recentTokens(m.data.resets, userID, window)
+}
+
+type memoryVerificationRepository struct {
+	data *memoryData
+}
+
+func (m memoryVerificationRepository) Create(userID int, tokenHash string, expiresAt time.Time) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	m.data.verifications = m.data.replaceToken(m.data.verifications, "verifications", userID, tokenHash, expiresAt)
+	return nil
+}
+
+func (m memoryVerificationRepository) GetByToken(tokenHash string) (OneTimeToken, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	return findToken(m.data.verifications, tokenHash)
+}
+
+func (m memoryVerificationRepository) Use(verificationID, userID int) (bool, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	if !useToken(m.data.verifications, verificationID) {
+		return false, nil
+	}
+	if u := m.data.user(userID); u != nil {
+		u.Status = statusVerified
+	}
+	return true, nil
+}
+
+func (m memoryVerificationRepository) Recent(userID int, window time.Duration) (int, time.Duration, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	return recentTokens(m.data.verifications, userID, window)
+}
+
+// Count a user's tokens created within the window. Called with the lock held.
+func recentTokens(tokens []memoryToken, userID int, window time.Duration) (int, time.Duration, error) {
+	since := time.Now().Add(-window)
+	sent := 0
+	var latest time.Time
+	for _, t := range tokens {
+		if t.UserID == userID && !t.createdAt.Before(since) {
+			sent++
+			latest = t.createdAt
+		}
+	}
+	if sent == 0 {
+		return 0, 0, nil
+	}
+	return sent, time.Since(latest).Truncate(time.Second), nil
+}
+
+type memoryCaregiverLinkRepository struct {
+	data *memoryData
+}
+
+// The link with both users' details. Called with the lock held.
+func (d *memoryData) caregiverLink(l memoryLink) CaregiverLink {
+	link := CaregiverLink{
+		LinkID: l.linkID, SeniorID: l.seniorID, CaregiverID: l.caregiverID,
+		Relationship: l.relationship, Status: l.status, RequestedAt: l.requestedAt,
+	}
+	if senior := d.user(l.seniorID); senior != nil {
+		link.SeniorName = senior.Name
+	}
+	if caregiver := d.user(l.caregiverID); caregiver != nil {
+		link.CaregiverName, link.CaregiverEmail = caregiver.Name, caregiver.Email
+	}
+	return link
+}
+
+func (m memoryCaregiverLinkRepository) Request(seniorID, caregiverID int, relationship string) (int, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for _, l := range m.data.links {
+		if l.seniorID == seniorID && l.caregiverID == caregiverID && l.status != linkRevoked {
+			return 0, nil
+		}
+	}
+	l := memoryLink{
+		linkID: m.data.nextID("links"), seniorID: seniorID, caregiverID: caregiverID,
+		relationship: relationship, status: linkPending, requestedAt: time.Now(),
+	}
+	m.data.links = append(m.data.links, l)
+	return l.linkID, nil
+}
+
+func (m memoryCaregiverLinkRepository) Get(linkID int) (CaregiverLink, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for _, l := range m.data.links {
+		if l.linkID == linkID {
+			return m.data.caregiverLink(l), nil
+		}
+	}
+	return CaregiverLink{}, errLinkNotFound
+}
+
+func (m memoryCaregiverLinkRepository) ListBySenior(seniorID int) ([]CaregiverLink, error) {
+	return m.list(func(l memoryLink) bool { return l.seniorID == seniorID }), nil
+}
+
+func (m memoryCaregiverLinkRepository) ListByCaregiver(caregiverID int) ([]CaregiverLink, error) {
+	return m.list(func(l memoryLink) bool { return l.caregiverID == caregiverID }), nil
+}
+
+func (m memoryCaregiverLinkRepository) list(match func(l memoryLink) bool) []CaregiverLink {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	links := []CaregiverLink{}
+	for i := len(m.data.links) - 1; i >= 0; i-- {
+		if l := m.data.links[i]; l.status != linkRevoked && match(l) {
+			links = append(links, m.data.caregiverLink(l))
+		}
+	}
+	return links
+}
+
+func (m memoryCaregiverLinkRepository) ActiveSeniorIDs(caregiverID int) ([]int, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	seniors := []int{}
+	for _, l := range m.data.links {
+		if l.caregiverID == caregiverID && l.status == linkActive {
+			seniors = append(seniors, l.seniorID)
+		}
+	}
+	sort.Ints(seniors)
+	return seniors, nil
+}
+
+func (m memoryCaregiverLinkRepository) Approve(linkID, seniorID int) (bool, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for i, l := range m.data.links {
+		if l.linkID == linkID && l.seniorID == seniorID && l.status == linkPending {
+			m.data.links[i].status = linkActive
+			m.data.links[i].approvedAt = time.Now()
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m memoryCaregiverLinkRepository) Revoke(linkID int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for i, l := range m.data.links {
+		if l.linkID == linkID {
+			m.data.links[i].status = linkRevoked
+			m.data.links[i].revokedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+type memoryConsentRepository struct {
+	data *memoryData
+}
+
+func (m memoryConsentRepository) CurrentTexts() (map[string]consentText, error) {
+	texts := map[string]consentText{}
+	for _, text := range memoryConsentTexts {
+		if text.Version > texts[text.Purpose].Version {
+			texts[text.Purpose] = text
+		}
+	}
+	return texts, nil
+}
+
+func (m memoryConsentRepository) Decisions(userID int) (map[string]ConsentDecision, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	// Later decisions replace earlier ones
+	decisions := map[string]ConsentDecision{}
+	for _, c := range m.data.consents {
+		if c.userID == userID {
+			decisions[c.purpose] = ConsentDecision{Version: c.version, Granted: c.granted, RecordedAt: c.recordedAt}
+		}
+	}
+	return decisions, nil
+}
+
+func (m memoryConsentRepository) Record(userID int, purpose string, version int, granted bool, userAgent string) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	m.data.consents = append(m.data.consents, memoryConsent{
+		userID: userID, purpose: purpose, version: version, granted: granted, userAgent: userAgent, recordedAt: time.Now(),
+	})
+	return nil
+}
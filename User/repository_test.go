@@ -0,0 +1,380 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"common/account"
+	"common/account/accounttest"
+	"common/auth"
+	"common/rbac"
+)
+
+// Store a new pending account directly in the repository
+func createUser(t *testing.T, store *Store, name, email, role string) int {
+	t.Helper()
+	u := User{Name: name, Email: email, PhoneNumber: "91234567", Role: role}
+	if role == rbac.RoleSenior {
+		u.DateOfBirth, u.Address = time.Date(1950, 3, 14, 0, 0, 0, 0, time.UTC), "1 Test Street"
+	}
+	id, err := store.Users.Create(u, []byte("hash"))
+	if err != nil {
+		t.Fatalf("Users.Create: %v", err)
+	}
+	return id
+}
+
+// The behaviour every repository of the service must have, run against a fresh store per case
+func testRepositories(t *testing.T, newStore func(t *testing.T) *Store) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store *Store)
+	}{
+		{"a created user is pending and found by ID", func(t *testing.T, store *Store) {
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleSenior)
+			u, err := store.Users.Get(id)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if u.UserID != id || u.Name != "Tan Ah Kow" || u.Email != "ahkow@example.com" || u.Role != rbac.RoleSenior ||
+				u.Status != statusPending || u.PasswordHash != "hash" || u.Address != "1 Test Street" || u.PhoneNumber != "91234567" {
+				t.Fatalf("got %+v", u)
+			}
+			if u.DateOfBirth == nil || u.DateOfBirth.Format("2006-01-02") != "1950-03-14" {
+				t.Fatalf("DateOfBirth %v, want 1950-03-14", u.DateOfBirth)
+			}
+		}},
+		{"caregivers have no date of birth", func(t *testing.T, store *Store) {
+			u, err := store.Users.Get(createUser(t, store, "Carer", "carer@example.com", rbac.RoleCaregiver))
+			if err != nil || u.DateOfBirth != nil {
+				t.Fatalf("got %v, %v, want no date of birth", u.DateOfBirth, err)
+			}
+		}},
+		{"unknown users", func(t *testing.T, store *Store) {
+			if _, err := store.Users.Get(999); err != errUserNotFound {
+				t.Fatalf("Get: err = %v, want errUserNotFound", err)
+			}
+			if _, err := store.Users.GetByEmail("nobody@example.com"); err != errUserNotFound {
+				t.Fatalf("GetByEmail: err = %v, want errUserNotFound", err)
+			}
+		}},
+		{"email lookups ignore case and surrounding spaces", func(t *testing.T, store *Store) {
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleSenior)
+			u, err := store.Users.GetByEmail("  AhKow@Example.com ")
+			if err != nil || u.UserID != id {
+				t.Fatalf("got user %d, %v, want %d", u.UserID, err, id)
+			}
+		}},
+		{"update saves the profile and reports changes", func(t *testing.T, store *Store) {
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleCaregiver)
+			if err := store.Verifications.Create(id, "verify-hash", time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			token, _ := store.Verifications.GetByToken("verify-hash")
+			if _, err := store.Verifications.Use(token.ID, id); err != nil {
+				t.Fatal(err)
+			}
+
+			changed := User{UserID: id, Name: "Tan Ah Kow", Email: "new@example.com", PhoneNumber: "98765432"}
+			if updated, err := store.Users.Update(changed, true); err != nil || !updated {
+				t.Fatalf("Update: %v, %v, want updated", updated, err)
+			}
+			u, _ := store.Users.Get(id)
+			if u.Email != "new@example.com" || u.PhoneNumber != "98765432" || u.Status != statusPending {
+				t.Fatalf("got %+v, want the new email, pending verification", u)
+			}
+			if updated, err := store.Users.Update(changed, false); err != nil || updated {
+				t.Fatalf("Update with nothing changed: %v, %v", updated, err)
+			}
+			if updated, err := store.Users.Update(User{UserID: 999, Name: "Nobody"}, false); err != nil || updated {
+				t.Fatalf("Update of an unknown user: %v, %v", updated, err)
+			}
+		}},
+		{"set password", func(t *testing.T, store *Store) {
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleSenior)
+			if err := store.Users.SetPassword(id, []byte("new-hash")); err != nil {
+				t.Fatalf("SetPassword: %v", err)
+			}
+			if u, _ := store.Users.Get(id); u.PasswordHash != "new-hash" {
+				t.Fatalf("PasswordHash %q, want new-hash", u.PasswordHash)
+			}
+		}},
+		{"failed logins count up, lock and reset", func(t *testing.T, store *Store) {
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleSenior)
+			for want := 1; want <= 3; want++ {
+				if got, err := store.Users.IncrementFailedLogins(id); err != nil || got != want {
+					t.Fatalf("IncrementFailedLogins: %d, %v, want %d", got, err, want)
+				}
+			}
+			if err := store.Users.LockFor(id, 10*time.Minute); err != nil {
+				t.Fatalf("LockFor: %v", err)
+			}
+			// A shorter lockout does not cut the current one short
+			if err := store.Users.LockFor(id, time.Minute); err != nil {
+				t.Fatalf("LockFor: %v", err)
+			}
+			u, _ := store.Users.Get(id)
+			if u.FailedLoginCount != 3 || u.LockedFor < 9*time.Minute || u.LockedFor > 10*time.Minute {
+				t.Fatalf("%d failed logins, locked for %v, want 3 and about 10m", u.FailedLoginCount, u.LockedFor)
+			}
+
+			if err := store.Users.ResetFailedLogins(id); err != nil {
+				t.Fatalf("ResetFailedLogins: %v", err)
+			}
+			if u, _ := store.Users.Get(id); u.FailedLoginCount != 0 || u.LockedFor != 0 {
+				t.Fatalf("%d failed logins, locked for %v after reset", u.FailedLoginCount, u.LockedFor)
+			}
+			if got, err := store.Users.IncrementFailedLogins(999); err != nil || got != 0 {
+				t.Fatalf("IncrementFailedLogins of an unknown user: %d, %v", got, err)
+			}
+		}},
+		{"a password reset uses up earlier links, and works once", func(t *testing.T, store *Store) {
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleSenior)
+			if err := store.Sessions.Create(id, "session-hash", "test-agent", time.Now().Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			for _, hash := range []string{"reset-1", "reset-2"} {
+				if err := store.Resets.Create(id, hash, time.Now().Add(time.Hour)); err != nil {
+					t.Fatalf("Create: %v", err)
+				}
+			}
+			if first, err := store.Resets.GetByToken("reset-1"); err != nil || !first.Used {
+				t.Fatalf("earlier link: %+v, %v, want used up", first, err)
+			}
+			latest, err := store.Resets.GetByToken("reset-2")
+			if err != nil || latest.UserID != id || latest.Used {
+				t.Fatalf("latest link: %+v, %v", latest, err)
+			}
+
+			if used, err := store.Resets.Use(latest.ID, id, []byte("new-hash")); err != nil || !used {
+				t.Fatalf("Use: %v, %v", used, err)
+			}
+			if used, err := store.Resets.Use(latest.ID, id, []byte("other-hash")); err != nil || used {
+				t.Fatalf("Use again: %v, %v", used, err)
+			}
+			if u, _ := store.Users.Get(id); u.PasswordHash != "new-hash" {
+				t.Fatalf("PasswordHash %q, want new-hash", u.PasswordHash)
+			}
+			if sessions, _ := store.Sessions.ListActive(id); len(sessions) != 0 {
+				t.Fatalf("%d sessions still active after the reset", len(sessions))
+			}
+			if _, err := store.Resets.GetByToken("unknown"); err != errTokenNotFound {
+				t.Fatalf("unknown link: err = %v, want errTokenNotFound", err)
+			}
+		}},
+		{"password reset emails sent recently", func(t *testing.T, store *Store) {
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleSenior)
+			if sent, _, err := store.Resets.Recent(id, time.Hour); err != nil || sent != 0 {
+				t.Fatalf("Recent before sending: %d, %v", sent, err)
+			}
+			for _, hash := range []string{"reset-1", "reset-2"} {
+				if err := store.Resets.Create(id, hash, time.Now().Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sent, sinceLatest, err := store.Resets.Recent(id, time.Hour)
+			if err != nil || sent != 2 || sinceLatest < 0 || sinceLatest > time.Minute {
+				t.Fatalf("Recent: %d sent, latest %v ago, %v, want 2 just now", sent, sinceLatest, err)
+			}
+		}},
+		{"verification marks the account verified, once", func(t *testing.T, store *Store) {
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleSenior)
+			if err := store.Verifications.Create(id, "verify-hash", time.Now().Add(time.Hour)); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			token, err := store.Verifications.GetByToken("verify-hash")
+			if err != nil {
+				t.Fatalf("GetByToken: %v", err)
+			}
+			if used, err := store.Verifications.Use(token.ID, id); err != nil || !used {
+				t.Fatalf("Use: %v, %v", used, err)
+			}
+			if used, err := store.Verifications.Use(token.ID, id); err != nil || used {
+				t.Fatalf("Use again: %v, %v", used, err)
+			}
+			if u, _ := store.Users.Get(id); u.Status != statusVerified {
+				t.Fatalf("Status %q, want %q", u.Status, statusVerified)
+			}
+		}},
+		{"verification emails sent recently", func(t *testing.T, store *Store) {
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleSenior)
+			if sent, _, err := store.Verifications.Recent(id, time.Hour); err != nil || sent != 0 {
+				t.Fatalf("Recent before sending: %d, %v", sent, err)
+			}
+			for _, hash := range []string{"verify-1", "verify-2"} {
+				if err := store.Verifications.Create(id, hash, time.Now().Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sent, sinceLatest, err := store.Verifications.Recent(id, time.Hour)
+			if err != nil || sent != 2 || sinceLatest < 0 || sinceLatest > time.Minute {
+				t.Fatalf("Recent: %d sent, latest %v ago, %v, want 2 just now", sent, sinceLatest, err)
+			}
+		}},
+		{"caregiver links are requested once, approved and revoked", func(t *testing.T, store *Store) {
+			senior := createUser(t, store, "Senior", "senior@example.com", rbac.RoleSenior)
+			caregiver := createUser(t, store, "Carer", "carer@example.com", rbac.RoleCaregiver)
+
+			linkID, err := store.Caregivers.Request(senior, caregiver, "Daughter")
+			if err != nil || linkID <= 0 {
+				t.Fatalf("Request: %d, %v", linkID, err)
+			}
+			if again, err := store.Caregivers.Request(senior, caregiver, "Daughter"); err != nil || again != 0 {
+				t.Fatalf("Request again: %d, %v, want 0", again, err)
+			}
+			link, err := store.Caregivers.Get(linkID)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if link.SeniorID != senior || link.SeniorName != "Senior" || link.CaregiverID != caregiver || link.CaregiverName != "Carer" ||
+				link.CaregiverEmail != "carer@example.com" || link.Relationship != "Daughter" || link.Status != linkPending {
+				t.Fatalf("got %+v", link)
+			}
+			if seniors, _ := store.Caregivers.ActiveSeniorIDs(caregiver); len(seniors) != 0 {
+				t.Fatalf("pending link counts as active: %v", seniors)
+			}
+
+			if approved, err := store.Caregivers.Approve(linkID, caregiver); err != nil || approved {
+				t.Fatalf("Approve by the caregiver: %v, %v", approved, err)
+			}
+			if approved, err := store.Caregivers.Approve(linkID, senior); err != nil || !approved {
+				t.Fatalf("Approve: %v, %v", approved, err)
+			}
+			if seniors, err := store.Caregivers.ActiveSeniorIDs(caregiver); err != nil || len(seniors) != 1 || seniors[0] != senior {
+				t.Fatalf("ActiveSeniorIDs: %v, %v, want [%d]", seniors, err, senior)
+			}
+
+			if err := store.Caregivers.Revoke(linkID); err != nil {
+				t.Fatalf("Revoke: %v", err)
+			}
+			if links, _ := store.Caregivers.ListBySenior(senior); len(links) != 0 {
+				t.Fatalf("revoked link still listed: %+v", links)
+			}
+			if again, err := store.Caregivers.Request(senior, caregiver, "Son"); err != nil || again <= 0 {
+				t.Fatalf("Request after revoking: %d, %v", again, err)
+			}
+			if _, err := store.Caregivers.Get(999); err != errLinkNotFound {
+				t.Fatalf("unknown link: err = %v, want errLinkNotFound", err)
+			}
+		}},
+		{"caregiver links are listed for both sides, newest first", func(t *testing.T, store *Store) {
+			senior := createUser(t, store, "Senior", "senior@example.com", rbac.RoleSenior)
+			first := createUser(t, store, "First", "first@example.com", rbac.RoleCaregiver)
+			second := createUser(t, store, "Second", "second@example.com", rbac.RoleCaregiver)
+			firstLink, _ := store.Caregivers.Request(senior, first, "Son")
+			secondLink, _ := store.Caregivers.Request(senior, second, "Daughter")
+
+			bySenior, err := store.Caregivers.ListBySenior(senior)
+			if err != nil || len(bySenior) != 2 || bySenior[0].LinkID != secondLink || bySenior[1].LinkID != firstLink {
+				t.Fatalf("ListBySenior: %+v, %v, want links %d then %d", bySenior, err, secondLink, firstLink)
+			}
+			byCaregiver, err := store.Caregivers.ListByCaregiver(first)
+			if err != nil || len(byCaregiver) != 1 || byCaregiver[0].LinkID != firstLink {
+				t.Fatalf("ListByCaregiver: %+v, %v, want link %d", byCaregiver, err, firstLink)
+			}
+			if empty, err := store.Caregivers.ListByCaregiver(senior); err != nil || empty == nil || len(empty) != 0 {
+				t.Fatalf("ListByCaregiver without links: %#v, %v, want an empty list", empty, err)
+			}
+		}},
+		{"consent texts and the latest decisions", func(t *testing.T, store *Store) {
+			texts, err := store.Consents.CurrentTexts()
+			if err != nil {
+				t.Fatalf("CurrentTexts: %v", err)
+			}
+			for _, purpose := range []string{consentShareDoctor, consentShareCaregiver, consentResearch} {
+				if text := texts[purpose]; text.Version < 1 || text.Title == "" || text.Text == "" {
+					t.Fatalf("%s: got %+v", purpose, text)
+				}
+			}
+
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleSenior)
+			if decisions, err := store.Consents.Decisions(id); err != nil || len(decisions) != 0 {
+				t.Fatalf("Decisions before any: %v, %v", decisions, err)
+			}
+			for _, granted := range []bool{true, false} {
+				if err := store.Consents.Record(id, consentResearch, 1, granted, "test-agent"); err != nil {
+					t.Fatalf("Record: %v", err)
+				}
+			}
+			if err := store.Consents.Record(id, consentShareDoctor, 1, true, "test-agent"); err != nil {
+				t.Fatalf("Record: %v", err)
+			}
+			decisions, err := store.Consents.Decisions(id)
+			if err != nil {
+				t.Fatalf("Decisions: %v", err)
+			}
+			if len(decisions) != 2 || decisions[consentResearch].Granted || !decisions[consentShareDoctor].Granted ||
+				decisions[consentResearch].Version != 1 || decisions[consentResearch].RecordedAt.IsZero() {
+				t.Fatalf("got %+v, want research withdrawn and sharing with doctors granted", decisions)
+			}
+		}},
+		{"personal data has every section", func(t *testing.T, store *Store) {
+			id := createUser(t, store, "Tan Ah Kow", "ahkow@example.com", rbac.RoleSenior)
+			data, err := store.Users.PersonalData(id)
+			if err != nil {
+				t.Fatalf("PersonalData: %v", err)
+			}
+			for _, section := range []string{"profile", "caregiver_links", "sessions", "email_verifications", "password_resets", "consents"} {
+				if _, ok := data[section]; !ok {
+					t.Fatalf("no %s section", section)
+				}
+			}
+			if profile, ok := data["profile"].(map[string]interface{}); !ok || profile["Email"] != "ahkow@example.com" {
+				t.Fatalf("profile %#v", data["profile"])
+			}
+			if _, err := store.Users.PersonalData(999); err != errUserNotFound {
+				t.Fatalf("unknown user: err = %v, want errUserNotFound", err)
+			}
+		}},
+		{"erase deletes the user and everything linked to them only", func(t *testing.T, store *Store) {
+			senior := createUser(t, store, "Senior", "senior@example.com", rbac.RoleSenior)
+			caregiver := createUser(t, store, "Carer", "carer@example.com", rbac.RoleCaregiver)
+			store.Caregivers.Request(senior, caregiver, "Son")
+			store.Sessions.Create(senior, "senior-session", "test-agent", time.Now().Add(time.Hour))
+			store.Sessions.Create(caregiver, "carer-session", "test-agent", time.Now().Add(time.Hour))
+			store.Resets.Create(senior, "reset-hash", time.Now().Add(time.Hour))
+			store.Verifications.Create(senior, "verify-hash", time.Now().Add(time.Hour))
+			store.Consents.Record(senior, consentResearch, 1, true, "test-agent")
+
+			claims := &auth.Claims{Subject: senior, Role: rbac.RoleSenior}
+			result, err := store.Users.Erase(senior, claims, nil)
+			if err != nil {
+				t.Fatalf("Erase: %v", err)
+			}
+			for _, table := range []string{"CaregiverLinks", "RefreshTokens", "PasswordResets", "EmailVerifications", "UserConsents", "Users"} {
+				if result.Deleted[table] != 1 {
+					t.Fatalf("deleted %v, want one row of each", result.Deleted)
+				}
+			}
+			if _, err := store.Users.Get(senior); err != errUserNotFound {
+				t.Fatalf("erased user: err = %v, want errUserNotFound", err)
+			}
+			if _, err := store.Users.Get(caregiver); err != nil {
+				t.Fatalf("caregiver: %v", err)
+			}
+			if sessions, _ := store.Sessions.ListActive(caregiver); len(sessions) != 1 {
+				t.Fatalf("caregiver has %d sessions, want 1", len(sessions))
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+
+	t.Run("sessions", func(t *testing.T) {
+		accounttest.Sessions(t, func(t *testing.T) (account.SessionRepository, int, int) {
+			store := newStore(t)
+			return store.Sessions, createUser(t, store, "First", "first@example.com", rbac.RoleSenior),
+				createUser(t, store, "Second", "second@example.com", rbac.RoleSenior)
+		})
+	})
+	t.Run("login failures", func(t *testing.T) {
+		accounttest.LoginFailures(t, func(t *testing.T) account.LoginFailureRepository { return newStore(t).LoginFailures })
+	})
+}
+
+func TestMemoryRepositories(t *testing.T) {
+	testRepositories(t, func(t *testing.T) *Store { return newMemoryStore() })
+}
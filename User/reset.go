@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/account"
+	"common/respond"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// How long an emailed password reset link stays valid
+const passwordResetTTL = time.Hour
+
+// Request throttling: minimum gap between emails and maximum emails per day for one address
+const (
+	passwordResetInterval   = time.Minute
+	passwordResetDailyLimit = 5
+)
+
+// Ask the Email service to send the reset link to the user
+func sendPasswordResetEmail(email, name, token string) {
+	requestBody, _ := json.Marshal(map[string]string{
+		"email": email,
+		"name":  name,
+		"token": token,
+	})
+
+	resp, err := http.Post(cfg.Services.Email+"/sendPasswordReset", "application/json", bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Println("Error sending password reset to email microservice:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	log.Println("Password reset email requested for user", email)
+}
+
+// Create a single-use reset token and email it to the user.
+// The response is identical whether or not the email is registered, until the address is throttled.
+func requestPasswordResetHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	var request struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	request.Email = strings.TrimSpace(request.Email)
+	if request.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]string{
+		"message": "If an account exists for this email, a password reset link has been sent.",
+	}
+
+	user, err := store.Users.GetByEmail(request.Email)
+	if err == errUserNotFound {
+		respond.JSON(w, response)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Throttle based on the links already sent to this address
+	sentToday, sinceLast, err := store.Resets.Recent(user.UserID, 24*time.Hour)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if sentToday >= passwordResetDailyLimit {
+		w.Header().Set("Retry-After", strconv.Itoa(int(24*time.Hour/time.Second)))
+		http.Error(w, "Too many password reset emails requested. Please try again tomorrow.", http.StatusTooManyRequests)
+		return
+	}
+	if sentToday > 0 {
+		if wait := int((passwordResetInterval - sinceLast).Seconds()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(wait))
+			http.Error(w, "Please wait before requesting another password reset email.", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	token, err := account.GenerateOpaqueToken()
+	if err != nil {
+		log.Println("Token generation error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Only the most recent link may be used
+	if err := store.Resets.Create(user.UserID, account.HashToken(token), time.Now().Add(passwordResetTTL)); err != nil {
+		log.Println("Database insert error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	go sendPasswordResetEmail(request.Email, user.Name, token)
+
+	respond.JSON(w, response)
+}
+
+// Set a new password using a reset token, then log out every device
+func confirmPasswordResetHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	var request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if request.Token == "" {
+		http.Error(w, "Reset token is required", http.StatusBadRequest)
+		return
+	}
+
+	if msg := account.ValidatePassword(request.Password); msg != "" {
+		respond.JSONStatus(w, http.StatusBadRequest, map[string]string{"password": msg})
+		return
+	}
+
+	// Look up the reset request
+	reset, err := store.Resets.GetByToken(account.HashToken(request.Token))
+	if err == errTokenNotFound || (err == nil && (reset.Used || time.Now().After(reset.ExpiresAt))) {
+		http.Error(w, "Invalid or expired reset link", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Hash the password before storing it
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Password hashing error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Consume the token and set the password, logging out anyone holding an old session
+	used, err := store.Resets.Use(reset.ID, reset.UserID, hashedPassword)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !used {
+		http.Error(w, "Invalid or expired reset link", http.StatusBadRequest)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Password has been reset. Please log in with your new password."})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"common/account"
+	"common/respond"
+)
+
+// Account states stored in Users.Status
+const (
+	statusPending  = "Pending"
+	statusVerified = "Verified"
+)
+
+// How long an emailed verification link stays valid
+const verificationTTL = 24 * time.Hour
+
+// Resend throttling: minimum gap between emails and maximum emails per day for one address
+const (
+	verificationResendInterval = time.Minute
+	verificationDailyLimit     = 5
+)
+
+// Ask the Email service to send the verification link to the user
+func sendVerificationEmail(email, name, token string) {
+	requestBody, _ := json.Marshal(map[string]string{
+		"email": email,
+		"name":  name,
+		"token": token,
+	})
+
+	resp, err := http.Post(cfg.Services.Email+"/sendVerificationEmail", "application/json", bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Println("Error sending verification to email microservice:", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	log.Println("Verification email requested for user", email)
+}
+
+// Create a new verification token for the user, replacing any outstanding one, and email it
+func createEmailVerification(verifications VerificationRepository, userID int, email, name string) error {
+	token, err := account.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	if err := verifications.Create(userID, account.HashToken(token), time.Now().Add(verificationTTL)); err != nil {
+		return err
+	}
+
+	go sendVerificationEmail(email, name, token)
+	return nil
+}
+
+// Mark the account as verified using the emailed token
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	var request struct {
+		Token string `json:"token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Look up the verification request
+	verification, err := store.Verifications.GetByToken(account.HashToken(request.Token))
+	if err == errTokenNotFound || (err == nil && (verification.Used || time.Now().After(verification.ExpiresAt))) {
+		http.Error(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Consume the token and verify the account
+	used, err := store.Verifications.Use(verification.ID, verification.UserID)
+	if err != nil {
+		log.Println("Database update error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !used {
+		http.Error(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+
+	respond.JSON(w, map[string]string{"message": "Email verified successfully. You can now log in."})
+}
+
+// Send a fresh verification link, throttled per address
+func resendVerificationHandler(w http.ResponseWriter, r *http.Request, store *Store) {
+	var request struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Println("JSON decoding error:", err)
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	request.Email = strings.TrimSpace(request.Email)
+	if request.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	response := map[string]string{
+		"message": "If this email belongs to an unverified account, a new verification link has been sent.",
+	}
+
+	user, err := store.Users.GetByEmail(request.Email)
+	if err == errUserNotFound || (err == nil && user.Status != statusPending) {
+		respond.JSON(w, response)
+		return
+	} else if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Throttle based on the links already sent to this address
+	sentToday, sinceLast, err := store.Verifications.Recent(user.UserID, 24*time.Hour)
+	if err != nil {
+		log.Println("Database query error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if sentToday >= verificationDailyLimit {
+		w.Header().Set("Retry-After", strconv.Itoa(int(24*time.Hour/time.Second)))
+		http.Error(w, "Too many verification emails requested. Please try again tomorrow.", http.StatusTooManyRequests)
+		return
+	}
+	if sentToday > 0 {
+		if wait := int((verificationResendInterval - sinceLast).Seconds()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(wait))
+			http.Error(w, "Please wait before requesting another verification email.", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	if err := createEmailVerification(store.Verifications, user.UserID, request.Email, user.Name); err != nil {
+		log.Println("Email verification error:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respond.JSON(w, response)
+}
@@ -2,6 +2,14 @@ module vision_assessment_service
 
 go 1.23.2
 
-require github.com/go-sql-driver/mysql v1.8.1
+require common v0.0.0
 
-require filippo.io/edwards25519 v1.1.0 // indirect
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/go-sql-driver/mysql v1.8.1 // indirect
+	github.com/gorilla/mux v1.8.1 // indirect
+	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/rs/cors v1.11.1 // indirect
+)
+
+replace common => ../Common
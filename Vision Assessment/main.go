@@ -2,12 +2,22 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
 	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
-
-	_ "github.com/go-sql-driver/mysql"
+	"os"
+	"strconv"
+
+	"common/audit"
+	"common/auth"
+	"common/config"
+	"common/consent"
+	"common/fieldcrypt"
+	"common/migrate"
+	"common/rbac"
+	"common/respond"
+	"common/server"
 )
 
 type VisionResult struct {
@@ -19,20 +29,94 @@ type VisionResult struct {
 	CreatedAt     string `json:"CreatedAt"` // Keeps CreatedAt field
 }
 
+// Columns holding health data, which are stored encrypted
+var visionEncryptedColumns = []fieldcrypt.Column{
+	{Table: "visionResults", Key: "ID", Column: "Comments"},
+}
+
 func main() {
-	http.HandleFunc("/postVisionResult", handlePostRequest)
-	http.HandleFunc("/getLatestResult", getLatestResult)
-	http.HandleFunc("/getAllVisionResults", getAllVisionResults)
-	http.HandleFunc("/getVisionResult", getVisionResult)
+	reencrypt := flag.Bool("reencrypt", false, "Re-encrypt stored comments under the current key, then exit")
+	flag.Parse()
+
+	// Load settings from the environment and .env files, listing everything missing at once
+	config.MustLoad(&cfg)
+
+	// `go run . migrate up|down|status|seed|baseline` manages the schema instead of serving
+	if flag.Arg(0) == "migrate" {
+		if err := migrate.Run(cfg.DB, schemaFiles, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	auth.SetSecret(cfg.JWTSecret.Value())
+	if err := auth.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
-	log.Println("Vision service running on port 8088")
-	log.Fatal(http.ListenAndServe(":8088", nil))
+	// Load the master keys vision comments are encrypted with
+	if err := fieldcrypt.LoadKeys([]byte(cfg.FieldKeys.Value())); err != nil {
+		log.Fatalf("Failed to load field encryption keys: %v", err)
+	}
+
+	// One pool shared by every request, or everything in memory with STORAGE=memory
+	store, err := openStore()
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+	defer store.Close()
+
+	if *reencrypt {
+		// Nothing is encrypted in memory
+		if store.db == nil {
+			log.Fatal("Re-encryption needs STORAGE=mysql")
+		}
+		if err := fieldcrypt.ReencryptColumns(store.db, visionEncryptedColumns); err != nil {
+			log.Fatalf("Re-encryption failed: %v", err)
+		}
+		log.Println("Re-encryption finished")
+		return
+	}
+
+	// Serve until stopped, letting requests in progress finish
+	if err := server.Run("Vision", cfg.Port, routes(store)); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
 }
 
-func handlePostRequest(w http.ResponseWriter, r *http.Request) {
+// Every endpoint, behind the token check
+func routes(store *Store) http.Handler {
+	mux := http.NewServeMux()
+	results, auditLog := store.Results, store.Audit
+
+	mux.HandleFunc("/postVisionResult", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		handlePostRequest(w, r, results, auditLog)
+	}, rbac.PermVisionSubmit))
+	mux.HandleFunc("/getLatestResult", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		getLatestResult(w, r, results, auditLog)
+	}, rbac.PermVisionRead))
+	mux.HandleFunc("/getAllVisionResults", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		getAllVisionResults(w, r, results, auditLog)
+	}, rbac.PermVisionRead))
+	mux.HandleFunc("/getVisionResult", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		getVisionResult(w, r, results, auditLog)
+	}, rbac.PermVisionRead))
+	mux.HandleFunc("/personalData", func(w http.ResponseWriter, r *http.Request) {
+		personalDataHandler(w, r, results, auditLog)
+	})
+	mux.HandleFunc("/audit", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		auditLog.SearchHandler(w, r)
+	}, rbac.PermAuditRead))
+	mux.HandleFunc("/audit/verify", auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+		auditLog.VerifyHandler(w, r)
+	}, rbac.PermAuditRead))
+	return auth.Middleware(mux)
+}
+
+func handlePostRequest(w http.ResponseWriter, r *http.Request, results VisionResultRepository, auditLog *audit.Log) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -51,37 +135,31 @@ func handlePostRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := sql.Open("mysql", "root:04D685362v98@tcp(127.0.0.1:3306)/vision_assessment_db")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Only the senior themselves may store their results
+	if claims := auth.ClaimsFromRequest(r); claims == nil || claims.Role != rbac.RoleSenior || claims.Subject != result.UserID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
-	defer db.Close()
 
-	query := `INSERT INTO visionResults (UserID, LeftEyeScore, RightEyeScore, Comments) VALUES (?, ?, ?, ?)`
-	res, err := db.Exec(query, result.UserID, result.LeftEyeScore, result.RightEyeScore, result.Comments)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := results.Create(&result); err != nil {
+		respond.Fail(w, err)
 		return
 	}
-
-	// Get the inserted ID
-	insertedID, _ := res.LastInsertId()
-	result.ID = int(insertedID) // Assign ID to result struct
+	auditLog.Change(r, audit.Create, "vision_result", strconv.Itoa(result.ID), result.UserID)
 
 	// Call Email Microservice if vision score is low
 	if result.LeftEyeScore <= 2 || result.RightEyeScore <= 2 {
-		go callEmailMicroservice(result) // Now includes correct ID
+		go callEmailMicroservice(result, r.Header.Get("Authorization")) // Now includes correct ID
 	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Results stored successfully"))
 }
 
-func getLatestResult(w http.ResponseWriter, r *http.Request) {
+func getLatestResult(w http.ResponseWriter, r *http.Request, results VisionResultRepository, auditLog *audit.Log) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -99,37 +177,38 @@ func getLatestResult(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := sql.Open("mysql", "root:04D685362v98@tcp(127.0.0.1:3306)/vision_assessment_db")
+	// Seniors may only read their own results
+	id, err := strconv.Atoi(userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid UserID", http.StatusBadRequest)
+		return
+	}
+	if !auth.AuthorizeUser(w, r, id) {
 		return
 	}
-	defer db.Close()
 
-	var result VisionResult
-	query := `SELECT * FROM visionResults 
-			  WHERE UserID = ? 
-			  ORDER BY CreatedAt DESC 
-			  LIMIT 1`
-	err = db.QueryRow(query, userID).Scan(&result.ID, &result.UserID, &result.LeftEyeScore, &result.RightEyeScore, &result.Comments, &result.CreatedAt) // Fetching latest ID
+	result, err := results.Latest(id)
+	if err == errVisionResultNotFound {
+		http.Error(w, "No results found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "No results found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		respond.Fail(w, err)
+		return
+	}
+
+	if !auditLog.Access(w, r, "vision_result", strconv.Itoa(result.ID), id) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	respond.JSON(w, result)
 }
 
 // All vision test results for the given userID
-func getAllVisionResults(w http.ResponseWriter, r *http.Request) {
+func getAllVisionResults(w http.ResponseWriter, r *http.Request, results VisionResultRepository, auditLog *audit.Log) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -147,47 +226,35 @@ func getAllVisionResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := sql.Open("mysql", "root:04D685362v98@tcp(127.0.0.1:3306)/vision_assessment_db")
+	// Seniors may only read their own results
+	id, err := strconv.Atoi(userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Invalid UserID", http.StatusBadRequest)
 		return
 	}
-	defer db.Close()
-
-	query := `SELECT UserID, LeftEyeScore, RightEyeScore, Comments, CreatedAt FROM visionResults WHERE UserID = ? ORDER BY CreatedAt DESC`
-	rows, err := db.Query(query, userID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !auth.AuthorizeUser(w, r, id) {
 		return
 	}
-	defer rows.Close()
 
-	var results []VisionResult
-	for rows.Next() {
-		var result VisionResult
-		err := rows.Scan(&result.UserID, &result.LeftEyeScore, &result.RightEyeScore, &result.Comments, &result.CreatedAt) // Now scanning CreatedAt directly
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		results = append(results, result)
+	history, err := results.ListByUser(id)
+	if err != nil {
+		respond.Fail(w, err)
+		return
 	}
 
-	if err = rows.Err(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !auditLog.Access(w, r, "vision_result", "", id) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	respond.JSON(w, history)
 }
 
 // get visionResult for a sepcific user
 // Get a Vision Result by visionAssessment_id
-func getVisionResult(w http.ResponseWriter, r *http.Request) {
+func getVisionResult(w http.ResponseWriter, r *http.Request, results VisionResultRepository, auditLog *audit.Log) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
@@ -216,40 +283,50 @@ func getVisionResult(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := sql.Open("mysql", "root:04D685362v98@tcp(127.0.0.1:3306)/vision_assessment_db")
+	result, err := results.Get(requestData.VisionAssessmentID)
+	if err == errVisionResultNotFound {
+		http.Error(w, "No vision result found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respond.Fail(w, err)
 		return
 	}
-	defer db.Close()
 
-	var result VisionResult
-	query := `SELECT ID, UserID, LeftEyeScore, RightEyeScore, Comments, CreatedAt FROM visionResults WHERE ID = ?`
-	err = db.QueryRow(query, requestData.VisionAssessmentID).Scan(
-		&result.ID, &result.UserID, &result.LeftEyeScore, &result.RightEyeScore, &result.Comments, &result.CreatedAt)
+	// Seniors may only read their own results
+	if !auth.AuthorizeUser(w, r, result.UserID) {
+		return
+	}
 
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "No vision result found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	if !auditLog.Access(w, r, "vision_result", strconv.Itoa(result.ID), result.UserID) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	respond.JSON(w, result)
 }
 
-// Call Email Microservice
-func callEmailMicroservice(result VisionResult) {
-	emailServiceURL := "http://localhost:8090/sendReportToDoctor"
+// Call Email Microservice, forwarding the user's token so it can look up their doctors
+func callEmailMicroservice(result VisionResult, authHeader string) {
+	if !consent.Granted(cfg.Services.User, result.UserID, consent.ShareDoctor, authHeader) {
+		log.Printf("User %d has not consented to sharing results with doctors, report not sent\n", result.UserID)
+		return
+	}
+
+	emailServiceURL := cfg.Services.Email + "/sendReportToDoctor"
 
 	// Convert result to JSON
 	requestBody, _ := json.Marshal(result)
 
 	// Send POST request to email microservice
-	resp, err := http.Post(emailServiceURL, "application/json", bytes.NewBuffer(requestBody))
+	req, err := http.NewRequest("POST", emailServiceURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Println("Error creating email request:", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authHeader)
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.Println("Error sending report to email microservice:", err)
 		return
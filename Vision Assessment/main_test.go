@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"common/auth"
+	"common/rbac"
+)
+
+func init() {
+	auth.SetSecret("vision-handler-tests")
+}
+
+// The endpoints over an empty in-memory store
+func newTestServer(t *testing.T) (http.Handler, *Store) {
+	t.Helper()
+	store := newMemoryStore()
+	return routes(store), store
+}
+
+// Send a request as the given account, or without a token if role is empty
+func do(t *testing.T, handler http.Handler, method, target string, body interface{}, subject int, role string, seniors ...int) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, target, &reader)
+	if role != "" {
+		token, err := auth.IssueToken(subject, role, seniors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// Store a result for the senior directly, bypassing the handlers
+func seedResult(t *testing.T, store *Store, userID, left, right int) VisionResult {
+	t.Helper()
+	result := VisionResult{UserID: userID, LeftEyeScore: left, RightEyeScore: right, Comments: "Seeded"}
+	if err := store.Results.Create(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestPostVisionResult(t *testing.T) {
+	// Scores above 2 so no report is sent to the Email service
+	result := VisionResult{UserID: 7, LeftEyeScore: 4, RightEyeScore: 5, Comments: "Clear"}
+
+	tests := []struct {
+		name    string
+		subject int
+		role    string
+		want    int
+	}{
+		{"senior for themselves", 7, rbac.RoleSenior, http.StatusOK},
+		{"senior for another senior", 8, rbac.RoleSenior, http.StatusForbidden},
+		{"caregiver", 7, rbac.RoleCaregiver, http.StatusForbidden},
+		{"doctor", 1, rbac.RoleDoctor, http.StatusForbidden},
+		{"no token", 0, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, store := newTestServer(t)
+			rec := do(t, handler, http.MethodPost, "/postVisionResult", result, tt.subject, tt.role)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+
+			want := 0
+			if tt.want == http.StatusOK {
+				want = 1
+			}
+			if stored, _ := store.Results.ListByUser(7); len(stored) != want {
+				t.Fatalf("stored %d results, want %d", len(stored), want)
+			}
+		})
+	}
+
+	t.Run("invalid body", func(t *testing.T) {
+		handler, _ := newTestServer(t)
+		rec := do(t, handler, http.MethodPost, "/postVisionResult", "not a result", 7, rbac.RoleSenior)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("wrong method", func(t *testing.T) {
+		handler, _ := newTestServer(t)
+		rec := do(t, handler, http.MethodGet, "/postVisionResult", nil, 7, rbac.RoleSenior)
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestGetLatestResult(t *testing.T) {
+	handler, store := newTestServer(t)
+	seedResult(t, store, 7, 3, 4)
+	latest := seedResult(t, store, 7, 5, 6)
+	seedResult(t, store, 8, 1, 1)
+
+	tests := []struct {
+		name    string
+		query   string
+		subject int
+		role    string
+		seniors []int
+		want    int
+	}{
+		{"senior reads own", "userID=7", 7, rbac.RoleSenior, nil, http.StatusOK},
+		{"senior reads another", "userID=8", 7, rbac.RoleSenior, nil, http.StatusForbidden},
+		{"linked caregiver", "userID=7", 20, rbac.RoleCaregiver, []int{7}, http.StatusOK},
+		{"unlinked caregiver", "userID=7", 20, rbac.RoleCaregiver, []int{8}, http.StatusForbidden},
+		{"assigned doctor", "userID=7", 1, rbac.RoleDoctor, []int{7}, http.StatusOK},
+		{"unassigned doctor", "userID=7", 1, rbac.RoleDoctor, []int{8}, http.StatusForbidden},
+		{"admin without vision access", "userID=7", 2, rbac.RoleAdmin, nil, http.StatusForbidden},
+		{"no results yet", "userID=9", 9, rbac.RoleSenior, nil, http.StatusNotFound},
+		{"missing userID", "", 7, rbac.RoleSenior, nil, http.StatusBadRequest},
+		{"invalid userID", "userID=seven", 7, rbac.RoleSenior, nil, http.StatusBadRequest},
+		{"no token", "userID=7", 0, "", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, handler, http.MethodGet, "/getLatestResult?"+tt.query, nil, tt.subject, tt.role, tt.seniors...)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got VisionResult
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != latest.ID {
+				t.Fatalf("got result %d, want the latest %d", got.ID, latest.ID)
+			}
+		})
+	}
+}
+
+func TestGetAllVisionResults(t *testing.T) {
+	handler, store := newTestServer(t)
+	first := seedResult(t, store, 7, 3, 4)
+	second := seedResult(t, store, 7, 5, 6)
+	other := seedResult(t, store, 8, 1, 1)
+
+	tests := []struct {
+		name    string
+		userID  int
+		subject int
+		role    string
+		seniors []int
+		want    int
+		ids     []int
+	}{
+		{"senior lists own, newest first", 7, 7, rbac.RoleSenior, nil, http.StatusOK, []int{second.ID, first.ID}},
+		{"senior without results", 9, 9, rbac.RoleSenior, nil, http.StatusOK, []int{}},
+		{"senior lists another", 8, 7, rbac.RoleSenior, nil, http.StatusForbidden, nil},
+		{"assigned doctor", 8, 1, rbac.RoleDoctor, []int{8}, http.StatusOK, []int{other.ID}},
+		{"unassigned doctor", 8, 1, rbac.RoleDoctor, nil, http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, handler, http.MethodGet, fmt.Sprintf("/getAllVisionResults?userID=%d", tt.userID), nil, tt.subject, tt.role, tt.seniors...)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got []VisionResult
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.ids) {
+				t.Fatalf("got %d results, want %d", len(got), len(tt.ids))
+			}
+			for i, result := range got {
+				if result.ID != tt.ids[i] {
+					t.Fatalf("result %d has ID %d, want %d", i, result.ID, tt.ids[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetVisionResult(t *testing.T) {
+	handler, store := newTestServer(t)
+	result := seedResult(t, store, 7, 3, 4)
+
+	tests := []struct {
+		name    string
+		id      int
+		subject int
+		role    string
+		seniors []int
+		want    int
+	}{
+		{"senior reads own", result.ID, 7, rbac.RoleSenior, nil, http.StatusOK},
+		{"senior reads another's", result.ID, 8, rbac.RoleSenior, nil, http.StatusForbidden},
+		{"linked caregiver", result.ID, 20, rbac.RoleCaregiver, []int{7}, http.StatusOK},
+		{"unlinked caregiver", result.ID, 20, rbac.RoleCaregiver, nil, http.StatusForbidden},
+		{"assigned doctor", result.ID, 1, rbac.RoleDoctor, []int{7}, http.StatusOK},
+		{"unassigned doctor", result.ID, 1, rbac.RoleDoctor, nil, http.StatusForbidden},
+		{"unknown result", result.ID + 100, 7, rbac.RoleSenior, nil, http.StatusNotFound},
+		{"missing ID", 0, 7, rbac.RoleSenior, nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]int{"visionAssessment_id": tt.id}
+			rec := do(t, handler, http.MethodPost, "/getVisionResult", body, tt.subject, tt.role, tt.seniors...)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got VisionResult
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != result {
+				t.Fatalf("got %+v, want %+v", got, result)
+			}
+		})
+	}
+}
+
+// Every read that returns results is recorded in the audit log, and refused reads are not
+func TestReadsAreAudited(t *testing.T) {
+	handler, store := newTestServer(t)
+	result := seedResult(t, store, 7, 3, 4)
+
+	do(t, handler, http.MethodGet, "/getLatestResult?userID=7", nil, 1, rbac.RoleDoctor, 7)
+	do(t, handler, http.MethodGet, "/getLatestResult?userID=7", nil, 8, rbac.RoleSenior)
+
+	entries, err := store.Audit.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d audit entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.ActorID != 1 || entry.SubjectID != 7 || entry.ResourceID != fmt.Sprint(result.ID) {
+		t.Fatalf("unexpected audit entry %+v", entry)
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS AuditLog;
+DROP TABLE IF EXISTS VisionResults;
@@ -0,0 +1,35 @@
+-- Creating the VisionResults Table
+CREATE TABLE VisionResults (
+    ID INT AUTO_INCREMENT PRIMARY KEY,
+    UserID INT NOT NULL,
+    LeftEyeScore INT NOT NULL,
+    RightEyeScore INT NOT NULL,
+    Comments TEXT NOT NULL, -- Encrypted, see fieldcrypt.go
+    CreatedAt TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Append-only audit log of every read and change of health or profile data. Each row's Hash
+-- covers the previous row's Hash, so any edit or removal shows up when the chain is verified.
+CREATE TABLE AuditLog (
+    AuditID BIGINT AUTO_INCREMENT PRIMARY KEY,
+    Service VARCHAR(30) NOT NULL,
+    ActorID INT NOT NULL,
+    ActorRole VARCHAR(20) NOT NULL,
+    Action VARCHAR(10) NOT NULL,
+    ResourceType VARCHAR(30) NOT NULL,
+    ResourceID VARCHAR(64) NOT NULL DEFAULT '',
+    SubjectID INT NOT NULL DEFAULT 0,
+    SourceIP VARCHAR(45) NOT NULL DEFAULT '',
+    CreatedAt DATETIME(6) NOT NULL,
+    PrevHash CHAR(64) NOT NULL DEFAULT '',
+    Hash CHAR(64) NOT NULL,
+    INDEX (ActorID),
+    INDEX (SubjectID),
+    INDEX (CreatedAt)
+);
+
+CREATE TRIGGER AuditLogNoUpdate BEFORE UPDATE ON AuditLog
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only';
+
+CREATE TRIGGER AuditLogNoDelete BEFORE DELETE ON AuditLog
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'AuditLog is append-only';
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"common/audit"
+	"common/auth"
+	"common/rbac"
+	"common/respond"
+)
+
+// Export (GET) or erase (DELETE) every vision result held about a user. Called by the User service.
+func personalDataHandler(w http.ResponseWriter, r *http.Request, results VisionResultRepository, auditLog *audit.Log) {
+	switch r.Method {
+	case http.MethodGet:
+		auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+			exportPersonalData(w, r, results, auditLog)
+		}, rbac.PermDataExport)(w, r)
+	case http.MethodDelete:
+		auth.RequirePermission(func(w http.ResponseWriter, r *http.Request) {
+			erasePersonalData(w, r, results, auditLog)
+		}, rbac.PermDataErase)(w, r)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// Parse the userID query parameter and check the caller may act on that user's personal data
+func dataSubjectFromQuery(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("userID"))
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid UserID", http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, auth.AuthorizeDataSubject(w, r, userID)
+}
+
+func exportPersonalData(w http.ResponseWriter, r *http.Request, results VisionResultRepository, auditLog *audit.Log) {
+	userID, ok := dataSubjectFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := results.ListByUser(userID)
+	if err != nil {
+		respond.Fail(w, err)
+		return
+	}
+	// Exported oldest first
+	slices.Reverse(history)
+
+	if !auditLog.Access(w, r, "personal_data", strconv.Itoa(userID), userID) {
+		return
+	}
+
+	respond.JSON(w, map[string]interface{}{"vision_results": history})
+}
+
+func erasePersonalData(w http.ResponseWriter, r *http.Request, results VisionResultRepository, auditLog *audit.Log) {
+	userID, ok := dataSubjectFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := results.DeleteByUser(userID)
+	if err != nil {
+		respond.Fail(w, err)
+		return
+	}
+	auditLog.Change(r, audit.Delete, "personal_data", strconv.Itoa(userID), userID)
+
+	log.Printf("Erased %d vision results for user %d\n", deleted, userID)
+	respond.JSON(w, map[string]interface{}{
+		"deleted": map[string]int64{"visionResults": deleted},
+	})
+}
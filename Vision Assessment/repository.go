@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"common/audit"
+	"common/fieldcrypt"
+)
+
+// Returned when no vision result matches
+var errVisionResultNotFound = errors.New("vision result not found")
+
+// How CreatedAt is sent to the front end, as MySQL returns it
+const createdAtLayout = "2006-01-02 15:04:05"
+
+// Storage of vision results. Handlers only depend on this, so they can run against memory.
+type VisionResultRepository interface {
+	// Store a new result, filling in its ID and CreatedAt
+	Create(result *VisionResult) error
+	// The user's most recent result
+	Latest(userID int) (VisionResult, error)
+	// Every result of the user, newest first
+	ListByUser(userID int) ([]VisionResult, error)
+	Get(id int) (VisionResult, error)
+	// Delete every result of the user, returning how many there were
+	DeleteByUser(userID int) (int64, error)
+}
+
+// Vision results in the visionResults table, with comments encrypted
+type mysqlVisionResultRepository struct {
+	db *sql.DB
+}
+
+func newMySQLStore(db *sql.DB) *Store {
+	return &Store{
+		Results: &mysqlVisionResultRepository{db: db},
+		Audit:   audit.New(auditServiceName, audit.MySQLRepository{DB: db}),
+		db:      db,
+	}
+}
+
+const visionResultColumns = `SELECT ID, UserID, LeftEyeScore, RightEyeScore, Comments, CreatedAt FROM visionResults`
+
+func (m *mysqlVisionResultRepository) Create(result *VisionResult) error {
+	// Comments describe the senior's eyesight and are stored encrypted
+	comments, err := fieldcrypt.Encrypt("visionResults.Comments", result.Comments)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO visionResults (UserID, LeftEyeScore, RightEyeScore, Comments) VALUES (?, ?, ?, ?)`
+	res, err := m.db.Exec(query, result.UserID, result.LeftEyeScore, result.RightEyeScore, comments)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	result.ID = int(id)
+
+	// CreatedAt is set by MySQL in its own time zone, like every other row
+	var createdAt time.Time
+	if err := m.db.QueryRow("SELECT CreatedAt FROM visionResults WHERE ID = ?", id).Scan(&createdAt); err != nil {
+		return err
+	}
+	result.CreatedAt = createdAt.Format(createdAtLayout)
+	return nil
+}
+
+func (m *mysqlVisionResultRepository) Latest(userID int) (VisionResult, error) {
+	return m.queryOne(visionResultColumns+` WHERE UserID = ? ORDER BY CreatedAt DESC, ID DESC LIMIT 1`, userID)
+}
+
+func (m *mysqlVisionResultRepository) Get(id int) (VisionResult, error) {
+	return m.queryOne(visionResultColumns+` WHERE ID = ?`, id)
+}
+
+func (m *mysqlVisionResultRepository) ListByUser(userID int) ([]VisionResult, error) {
+	rows, err := m.db.Query(visionResultColumns+` WHERE UserID = ? ORDER BY CreatedAt DESC, ID DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []VisionResult{}
+	for rows.Next() {
+		result, err := scanVisionResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (m *mysqlVisionResultRepository) DeleteByUser(userID int) (int64, error) {
+	res, err := m.db.Exec("DELETE FROM visionResults WHERE UserID = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (m *mysqlVisionResultRepository) queryOne(query string, args ...interface{}) (VisionResult, error) {
+	result, err := scanVisionResult(m.db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return VisionResult{}, errVisionResultNotFound
+	}
+	return result, err
+}
+
+// Scan a row selected with visionResultColumns, decrypting the comments
+func scanVisionResult(row interface{ Scan(...interface{}) error }) (VisionResult, error) {
+	var result VisionResult
+	var createdAt time.Time
+	if err := row.Scan(&result.ID, &result.UserID, &result.LeftEyeScore, &result.RightEyeScore, &result.Comments, &createdAt); err != nil {
+		return VisionResult{}, err
+	}
+	result.CreatedAt = createdAt.Format(createdAtLayout)
+
+	var err error
+	result.Comments, err = fieldcrypt.Decrypt("visionResults.Comments", result.Comments)
+	return result, err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"common/audit"
+)
+
+func newMemoryStore() *Store {
+	return &Store{
+		Results: &memoryVisionResultRepository{nextID: 1},
+		Audit:   audit.New(auditServiceName, &audit.MemoryRepository{}),
+	}
+}
+
+// Vision results kept in memory. Nothing is encrypted.
+type memoryVisionResultRepository struct {
+	mu      sync.Mutex
+	results []VisionResult // In the order they were created
+	nextID  int
+}
+
+func (m *memoryVisionResultRepository) Create(result *VisionResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result.ID = m.nextID
+	result.CreatedAt = time.Now().Format(createdAtLayout)
+	m.nextID++
+	m.results = append(m.results, *result)
+	return nil
+}
+
+func (m *memoryVisionResultRepository) Latest(userID int) (VisionResult, error) {
+	results, _ := m.ListByUser(userID)
+	if len(results) == 0 {
+		return VisionResult{}, errVisionResultNotFound
+	}
+	return results[0], nil
+}
+
+func (m *memoryVisionResultRepository) ListByUser(userID int) ([]VisionResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := []VisionResult{}
+	for _, result := range m.results {
+		if result.UserID == userID {
+			results = append(results, result)
+		}
+	}
+	// Newest first, by creation then by ID as MySQL orders them
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].CreatedAt != results[j].CreatedAt {
+			return results[i].CreatedAt > results[j].CreatedAt
+		}
+		return results[i].ID > results[j].ID
+	})
+	return results, nil
+}
+
+func (m *memoryVisionResultRepository) Get(id int) (VisionResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, result := range m.results {
+		if result.ID == id {
+			return result, nil
+		}
+	}
+	return VisionResult{}, errVisionResultNotFound
+}
+
+func (m *memoryVisionResultRepository) DeleteByUser(userID int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.results[:0]
+	for _, result := range m.results {
+		if result.UserID != userID {
+			kept = append(kept, result)
+		}
+	}
+	deleted := int64(len(m.results) - len(kept))
+	m.results = kept
+	return deleted, nil
+}
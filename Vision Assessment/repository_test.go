@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+)
+
+// The behaviour every VisionResultRepository must have, run against a fresh store per case
+func testVisionResultRepository(t *testing.T, newStore func(t *testing.T) *Store) {
+	create := func(t *testing.T, repo VisionResultRepository, userID, left, right int) VisionResult {
+		t.Helper()
+		result := VisionResult{UserID: userID, LeftEyeScore: left, RightEyeScore: right, Comments: "Blurred at distance"}
+		if err := repo.Create(&result); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo VisionResultRepository)
+	}{
+		{"create fills in ID and CreatedAt", func(t *testing.T, repo VisionResultRepository) {
+			first, second := create(t, repo, 7, 3, 4), create(t, repo, 7, 5, 6)
+			if first.ID <= 0 || second.ID <= first.ID {
+				t.Fatalf("IDs %d then %d, want increasing positive IDs", first.ID, second.ID)
+			}
+			if first.CreatedAt == "" {
+				t.Fatal("CreatedAt not filled in")
+			}
+		}},
+		{"get returns the stored result", func(t *testing.T, repo VisionResultRepository) {
+			created := create(t, repo, 7, 3, 4)
+			got, err := repo.Get(created.ID)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if got != created {
+				t.Fatalf("got %+v, want %+v", got, created)
+			}
+		}},
+		{"get of an unknown ID", func(t *testing.T, repo VisionResultRepository) {
+			if _, err := repo.Get(999); err != errVisionResultNotFound {
+				t.Fatalf("err = %v, want errVisionResultNotFound", err)
+			}
+		}},
+		{"latest is the newest of the user's", func(t *testing.T, repo VisionResultRepository) {
+			create(t, repo, 7, 3, 4)
+			newest := create(t, repo, 7, 5, 6)
+			create(t, repo, 8, 1, 1)
+			got, err := repo.Latest(7)
+			if err != nil {
+				t.Fatalf("Latest: %v", err)
+			}
+			if got.ID != newest.ID {
+				t.Fatalf("got result %d, want %d", got.ID, newest.ID)
+			}
+		}},
+		{"latest without results", func(t *testing.T, repo VisionResultRepository) {
+			create(t, repo, 8, 1, 1)
+			if _, err := repo.Latest(7); err != errVisionResultNotFound {
+				t.Fatalf("err = %v, want errVisionResultNotFound", err)
+			}
+		}},
+		{"list is the user's results, newest first", func(t *testing.T, repo VisionResultRepository) {
+			first := create(t, repo, 7, 3, 4)
+			create(t, repo, 8, 1, 1)
+			second := create(t, repo, 7, 5, 6)
+			got, err := repo.ListByUser(7)
+			if err != nil {
+				t.Fatalf("ListByUser: %v", err)
+			}
+			if len(got) != 2 || got[0].ID != second.ID || got[1].ID != first.ID {
+				t.Fatalf("got %+v, want results %d then %d", got, second.ID, first.ID)
+			}
+		}},
+		{"list without results is empty, not nil", func(t *testing.T, repo VisionResultRepository) {
+			got, err := repo.ListByUser(7)
+			if err != nil {
+				t.Fatalf("ListByUser: %v", err)
+			}
+			if got == nil || len(got) != 0 {
+				t.Fatalf("got %#v, want an empty list", got)
+			}
+		}},
+		{"delete removes only the user's results", func(t *testing.T, repo VisionResultRepository) {
+			create(t, repo, 7, 3, 4)
+			create(t, repo, 7, 5, 6)
+			other := create(t, repo, 8, 1, 1)
+			deleted, err := repo.DeleteByUser(7)
+			if err != nil {
+				t.Fatalf("DeleteByUser: %v", err)
+			}
+			if deleted != 2 {
+				t.Fatalf("deleted %d, want 2", deleted)
+			}
+			if got, _ := repo.ListByUser(7); len(got) != 0 {
+				t.Fatalf("%d results left", len(got))
+			}
+			if _, err := repo.Get(other.ID); err != nil {
+				t.Fatalf("other user's result: %v", err)
+			}
+			if deleted, _ := repo.DeleteByUser(7); deleted != 0 {
+				t.Fatalf("deleted %d on the second run, want 0", deleted)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t).Results)
+		})
+	}
+}
+
+func TestMemoryVisionResultRepository(t *testing.T) {
+	testVisionResultRepository(t, func(t *testing.T) *Store { return newMemoryStore() })
+}
@@ -0,0 +1,5 @@
+-- Sample vision test records for development, under fixed IDs so running the seed again changes
+-- nothing. They are stored in plaintext until the service is run with -reencrypt.
+INSERT IGNORE INTO VisionResults (ID, UserID, LeftEyeScore, RightEyeScore, Comments, CreatedAt) VALUES
+(1, 5, 5, 5, 'Your vision in both eyes seems to be slightly reduced.', '2025-02-12 15:32:10'),
+(2, 5, 1, 1, 'Your vision in both eyes is significantly reduced.', '2025-02-12 15:32:48');
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"log"
+
+	"common/audit"
+	"common/config"
+	"common/database"
+	"common/migrate"
+)
+
+// Numbered schema migrations and development seed data, run with `go run . migrate`
+//
+//go:embed migrations seeds
+var schemaFiles embed.FS
+
+// Service name recorded on every audit log entry written here
+const auditServiceName = "vision_assessment"
+
+// Everything the service keeps. Handlers only go through these, so the service runs against
+// MySQL or, with STORAGE=memory, without a database server.
+type Store struct {
+	Results VisionResultRepository
+	Audit   *audit.Log
+
+	db *sql.DB // Nil when kept in memory
+}
+
+// Open the storage chosen by STORAGE
+func openStore() (*Store, error) {
+	if cfg.Storage == config.StorageMemory {
+		log.Println("Keeping data in memory, it is lost when the service stops")
+		return newMemoryStore(), nil
+	}
+	db, err := database.Connect(cfg.DB)
+	if err != nil {
+		return nil, err
+	}
+	// Refuse to run against a schema older or newer than this build
+	if err := migrate.Check(db, schemaFiles); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return newMySQLStore(db), nil
+}
+
+func (s *Store) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}